@@ -14,6 +14,7 @@ type Capsule struct {
 	SinkId    uuid.UUID // only needed when sending to SinkNode, otherwise 0
 	Filename  string    // only needed when sending to SinkFlushNode, otherwise empty string
 	Prefix    string    // only needed when sending to SinkFlushNode, otherwise empty string
+	Input     int       // index of the union input that the events came from, otherwise 0
 	EventList []string
 }
 
@@ -0,0 +1,670 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vaerohq/vaero/execute"
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+)
+
+// apiPrefix is the path prefix of every management API route
+const apiPrefix = "/api/v1"
+
+// apiMaxBodyBytes limits the size of a request body sent to the management API
+const apiMaxBodyBytes = 10 << 20
+
+// pipelineJSON is the representation of a pipeline in the management API
+type pipelineJSON struct {
+	Id        int             `json:"id"`
+	Interval  int             `json:"interval"`
+	TaskGraph json.RawMessage `json:"task_graph"`
+	Spec      string          `json:"spec"`
+	Status    string          `json:"status"`
+	Alive     int             `json:"alive"`
+}
+
+// pipelineRequest is the body of a request to add or update a pipeline. If TaskGraph is absent, the task graph is
+// generated by running the specification file at Spec on the server.
+type pipelineRequest struct {
+	TaskGraph  json.RawMessage `json:"task_graph,omitempty"`
+	Spec       string          `json:"spec,omitempty"`
+	SpecSource string          `json:"spec_source,omitempty"` // contents of the spec, recorded in the version history
+	Author     string          `json:"author,omitempty"`      // recorded in the version history
+}
+
+// rollbackRequest is the body of a request to roll back a pipeline. Version 0 rolls back to the version before the
+// latest.
+type rollbackRequest struct {
+	Version int    `json:"version,omitempty"`
+	Author  string `json:"author,omitempty"`
+}
+
+// versionJSON is the representation of a version of a pipeline in the management API
+type versionJSON struct {
+	Version    int             `json:"version"`
+	Time       time.Time       `json:"time"`
+	Author     string          `json:"author"`
+	Spec       string          `json:"spec"`
+	SpecSource string          `json:"spec_source,omitempty"`
+	TaskGraph  json.RawMessage `json:"task_graph"`
+}
+
+// rollbackJSON is the body of a rollback response
+type rollbackJSON struct {
+	Version  int          `json:"version"` // the version rolled back to
+	Time     time.Time    `json:"time"`    // when that version was recorded
+	Pipeline pipelineJSON `json:"pipeline"`
+}
+
+// statusJSON is the body of a status response
+type statusJSON struct {
+	Status    string         `json:"status"`
+	StartTime time.Time      `json:"start_time"`
+	Pipelines map[string]int `json:"pipelines"` // number of pipelines by status
+}
+
+// errorJSON is the body of an error response
+type errorJSON struct {
+	Error   string   `json:"error"`
+	Details []string `json:"details,omitempty"`
+}
+
+// apiServer serves the management API
+type apiServer struct {
+	c         *ControlDB
+	token     string
+	startTime time.Time
+}
+
+// serveAPI serves the management API and pipeline metrics on settings.Config.ApiAddress. It runs for the life of
+// the start command.
+func serveAPI(c *ControlDB) {
+	if settings.Config.ApiAddress == "" {
+		log.Logger.Info("Management API and metrics are disabled")
+		return
+	}
+
+	token, err := apiToken(true)
+	if err != nil {
+		log.Logger.Error("Could not set up API token", zap.String("Error", err.Error()))
+		return
+	}
+
+	server := &apiServer{c: c, token: token, startTime: time.Now()}
+
+	mux := http.NewServeMux()
+	mux.Handle(apiPrefix+"/", server)
+
+	// Metrics are served without the API token, so that Prometheus can scrape them
+	mux.Handle("/metrics", execute.MetricsHandler())
+
+	// Probes are also served without the API token, for use by orchestrators such as Kubernetes
+	mux.HandleFunc("/healthz", server.handleHealthz)
+	mux.HandleFunc("/readyz", server.handleReadyz)
+
+	log.Logger.Info("Serving management API", zap.String("Address", settings.Config.ApiAddress))
+
+	if err := http.ListenAndServe(settings.Config.ApiAddress, mux); err != nil {
+		log.Logger.Error("Management API stopped", zap.String("Error", err.Error()))
+	}
+}
+
+// apiToken returns the API token from the config. If none is set, it reads the token file, and if create is true
+// and the file does not exist, it generates a new token and writes it to the file.
+func apiToken(create bool) (string, error) {
+	if settings.Config.ApiToken != "" {
+		return settings.Config.ApiToken, nil
+	}
+
+	tokenFile := settings.Config.ApiTokenFile
+	data, err := os.ReadFile(tokenFile)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	} else if !os.IsNotExist(err) || !create {
+		return "", err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := os.MkdirAll(filepath.Dir(tokenFile), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+
+	log.Logger.Info("Generated API token", zap.String("Filename", tokenFile))
+
+	return token, nil
+}
+
+// ServeHTTP authenticates the request and routes it to its handler
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeAPIError(w, http.StatusUnauthorized, "invalid or missing API token", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodyBytes)
+
+	// Routes are /status, /pipelines, /pipelines/{id}, and /pipelines/{id}/{action}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "status":
+		s.handleStatus(w, r)
+	case len(parts) == 1 && parts[0] == "pipelines":
+		s.handlePipelines(w, r)
+	case len(parts) >= 2 && len(parts) <= 4 && parts[0] == "pipelines":
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "pipeline id must be an integer", nil)
+			return
+		}
+
+		if len(parts) == 2 {
+			s.handlePipeline(w, r, id)
+		} else if len(parts) == 3 {
+			s.handlePipelineAction(w, r, id, parts[2])
+		} else if parts[2] == "versions" {
+			version, err := strconv.Atoi(parts[3])
+			if err != nil || version < 1 {
+				writeAPIError(w, http.StatusBadRequest, "version must be a positive integer", nil)
+				return
+			}
+			s.handleVersion(w, r, id, version)
+		} else {
+			writeAPIError(w, http.StatusNotFound, "not found", nil)
+		}
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found", nil)
+	}
+}
+
+// authorized checks the bearer token of the request in constant time
+func (s *apiServer) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(s.token)) == 1
+}
+
+// handleStatus returns the status of Vaero and a count of pipelines by status
+func (s *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	status := statusJSON{Status: "running", StartTime: s.startTime, Pipelines: map[string]int{}}
+	for _, entry := range s.c.selectAllFromJobsDB() {
+		status.Pipelines[entry.Status]++
+	}
+
+	writeAPIJSON(w, http.StatusOK, status)
+}
+
+// handlePipelines lists pipelines or adds a pipeline
+func (s *apiServer) handlePipelines(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		entries := s.c.selectAllFromJobsDB()
+		pipelines := make([]pipelineJSON, len(entries))
+		for idx, entry := range entries {
+			pipelines[idx] = toPipelineJSON(entry)
+		}
+
+		writeAPIJSON(w, http.StatusOK, pipelines)
+		return
+	}
+
+	taskGraphStr, spec, info, ok := readPipelineRequest(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := s.c.addPipeline(taskGraphStr, spec, info)
+	if err != nil {
+		writePipelineError(w, err)
+		return
+	}
+
+	log.Logger.Info("Added pipeline through API", zap.Int("Id", entry.Id), zap.String("Spec", spec))
+
+	writeAPIJSON(w, http.StatusCreated, toPipelineJSON(entry))
+}
+
+// handlePipeline gets, updates, or deletes the pipeline with id
+func (s *apiServer) handlePipeline(w http.ResponseWriter, r *http.Request, id int) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entry, ok := s.c.selectFromJobsDB(id)
+		if !ok {
+			writePipelineError(w, errPipelineNotFound)
+			return
+		}
+
+		writeAPIJSON(w, http.StatusOK, toPipelineJSON(entry))
+
+	case http.MethodPut:
+		taskGraphStr, spec, info, ok := readPipelineRequest(w, r)
+		if !ok {
+			return
+		}
+
+		entry, err := s.c.updatePipeline(id, taskGraphStr, spec, info)
+		if err != nil {
+			writePipelineError(w, err)
+			return
+		}
+
+		log.Logger.Info("Updated pipeline through API", zap.Int("Id", id), zap.String("Spec", spec))
+
+		writeAPIJSON(w, http.StatusOK, toPipelineJSON(entry))
+
+	case http.MethodDelete:
+		if err := s.c.deletePipeline(id); err != nil {
+			writePipelineError(w, err)
+			return
+		}
+
+		log.Logger.Info("Deleted pipeline through API", zap.Int("Id", id))
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handlePipelineAction stops, starts, or rolls back the pipeline with id, lists its failures, versions, or
+// schedules, or shows and resets its checkpoints
+func (s *apiServer) handlePipelineAction(w http.ResponseWriter, r *http.Request, id int, action string) {
+	var entry PipelineEntry
+	var err error
+
+	switch action {
+	case "stop":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		entry, err = s.c.stopPipeline(id)
+	case "start":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		entry, err = s.c.startPipeline(id)
+	case "failures":
+		s.handleFailures(w, r, id)
+		return
+	case "rollback":
+		s.handleRollback(w, r, id)
+		return
+	case "versions":
+		s.handleVersions(w, r, id)
+		return
+	case "schedules":
+		s.handleSchedules(w, r, id)
+		return
+	case "checkpoints":
+		s.handleCheckpoints(w, r, id)
+		return
+	default:
+		writeAPIError(w, http.StatusNotFound, "not found", nil)
+		return
+	}
+
+	if err != nil {
+		writePipelineError(w, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, toPipelineJSON(entry))
+}
+
+// handleFailures lists the most recent failures of the pipeline with id. The limit query parameter sets the number
+// of failures returned.
+func (s *apiServer) handleFailures(w http.ResponseWriter, r *http.Request, id int) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	if _, ok := s.c.selectFromJobsDB(id); !ok {
+		writePipelineError(w, errPipelineNotFound)
+		return
+	}
+
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 1 {
+			writeAPIError(w, http.StatusBadRequest, "limit must be a positive integer", nil)
+			return
+		}
+	}
+
+	failures, err := s.c.selectFailures(id, limit)
+	if err != nil {
+		writePipelineError(w, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, failures)
+}
+
+// handleSchedules lists the schedules of the sources of the pipeline with id, with their last and next reads
+func (s *apiServer) handleSchedules(w http.ResponseWriter, r *http.Request, id int) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	schedules, err := s.c.selectSchedules(id)
+	if err != nil {
+		writePipelineError(w, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, schedules)
+}
+
+// handleCheckpoints lists the cursors of the sources of the pipeline with id. PUT sets the cursor of the source in
+// the request body, and DELETE deletes the cursor of the source query parameter, or of every source.
+func (s *apiServer) handleCheckpoints(w http.ResponseWriter, r *http.Request, id int) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut, http.MethodDelete) {
+		return
+	}
+
+	var checkpoints []SourceCheckpoint
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		checkpoints, err = s.c.selectCheckpoints(id)
+	case http.MethodPut:
+		var req checkpointRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid request body", []string{err.Error()})
+			return
+		}
+		if len(req.Cursor) == 0 || string(req.Cursor) == "null" {
+			writeAPIError(w, http.StatusBadRequest, "request must include a cursor", nil)
+			return
+		}
+		checkpoints, err = s.c.resetCheckpoints(id, req.Source, string(req.Cursor))
+	case http.MethodDelete:
+		checkpoints, err = s.c.resetCheckpoints(id, r.URL.Query().Get("source"), "")
+	}
+
+	if err != nil {
+		writePipelineError(w, err)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		log.Logger.Info("Reset pipeline checkpoints through API", zap.Int("Id", id))
+	}
+
+	writeAPIJSON(w, http.StatusOK, checkpoints)
+}
+
+// handleRollback rolls back the pipeline with id to the version in the request body, or to the version before the
+// latest if the body is empty
+func (s *apiServer) handleRollback(w http.ResponseWriter, r *http.Request, id int) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var req rollbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body", []string{err.Error()})
+		return
+	}
+
+	if req.Author == "" {
+		req.Author = requestAuthor(r)
+	}
+
+	entry, v, err := s.c.rollbackPipeline(id, req.Version, req.Author)
+	if err != nil {
+		writePipelineError(w, err)
+		return
+	}
+
+	log.Logger.Info("Rolled back pipeline through API", zap.Int("Id", id), zap.Int("Version", v.Version))
+
+	writeAPIJSON(w, http.StatusOK, rollbackJSON{Version: v.Version, Time: v.Time, Pipeline: toPipelineJSON(entry)})
+}
+
+// handleVersions lists the versions of the pipeline with id, oldest first
+func (s *apiServer) handleVersions(w http.ResponseWriter, r *http.Request, id int) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	versions, err := s.c.selectVersions(id)
+	if err != nil {
+		writePipelineError(w, err)
+		return
+	}
+
+	list := make([]versionJSON, len(versions))
+	for idx, v := range versions {
+		list[idx] = toVersionJSON(v)
+	}
+
+	writeAPIJSON(w, http.StatusOK, list)
+}
+
+// handleVersion gets a version of the pipeline with id
+func (s *apiServer) handleVersion(w http.ResponseWriter, r *http.Request, id int, version int) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	if _, ok := s.c.selectFromJobsDB(id); !ok {
+		writePipelineError(w, errPipelineNotFound)
+		return
+	}
+
+	v, err := s.c.selectVersion(id, version)
+	if err != nil {
+		writePipelineError(w, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusOK, toVersionJSON(v))
+}
+
+// readPipelineRequest decodes the body of a request to add or update a pipeline and returns its task graph json,
+// spec, and the version info to record. It writes an error response and returns false if the request is invalid.
+func readPipelineRequest(w http.ResponseWriter, r *http.Request) (string, string, versionInfo, bool) {
+	var req pipelineRequest
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid request body", []string{err.Error()})
+		return "", "", versionInfo{}, false
+	}
+
+	info := versionInfo{SpecSource: req.SpecSource, Author: req.Author}
+	if info.Author == "" {
+		info.Author = requestAuthor(r)
+	}
+
+	if len(req.TaskGraph) > 0 {
+		return string(req.TaskGraph), req.Spec, info, true
+	}
+
+	if req.Spec == "" {
+		writeAPIError(w, http.StatusBadRequest, "request must include a task_graph or a spec", nil)
+		return "", "", versionInfo{}, false
+	}
+
+	taskGraphStr, err := runSpecFile(req.Spec)
+	if err != nil {
+		var details []string
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			details = splitLines(string(exitErr.Stderr))
+		}
+		writeAPIError(w, http.StatusBadRequest, err.Error(), details)
+		return "", "", versionInfo{}, false
+	}
+
+	if info.SpecSource == "" {
+		if source, err := os.ReadFile(req.Spec); err == nil {
+			info.SpecSource = string(source)
+		}
+	}
+
+	return taskGraphStr, req.Spec, info, true
+}
+
+// requestAuthor is the author recorded for a request that does not name one
+func requestAuthor(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "api@" + host
+}
+
+// writePipelineError writes the error response for an error returned by a pipeline operation
+func writePipelineError(w http.ResponseWriter, err error) {
+	var validationErrs execute.ValidationErrors
+
+	switch {
+	case errors.Is(err, errPipelineNotFound), errors.Is(err, errVersionNotFound):
+		writeAPIError(w, http.StatusNotFound, err.Error(), nil)
+	case errors.As(err, &validationErrs):
+		details := make([]string, len(validationErrs))
+		for idx, e := range validationErrs {
+			details[idx] = e.Error()
+		}
+		writeAPIError(w, http.StatusBadRequest, "invalid task graph", details)
+	case errors.Is(err, errInvalidTaskGraph), errors.Is(err, errInvalidCheckpoint):
+		writeAPIError(w, http.StatusBadRequest, err.Error(), nil)
+	case errors.Is(err, errPipelineStopping), errors.Is(err, errPipelineRunning):
+		writeAPIError(w, http.StatusConflict, err.Error(), nil)
+	default:
+		log.Logger.Error("Management API request failed", zap.String("Error", err.Error()))
+		writeAPIError(w, http.StatusInternalServerError, err.Error(), nil)
+	}
+}
+
+// allowMethods writes a method not allowed response and returns false if the request method is not in methods
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method), nil)
+
+	return false
+}
+
+// toPipelineJSON converts a pipeline entry to its API representation, with the values of secret args redacted
+func toPipelineJSON(entry PipelineEntry) pipelineJSON {
+	taskGraph := json.RawMessage(execute.RedactTaskGraph(entry.TaskGraphStr))
+	if !json.Valid(taskGraph) {
+		taskGraph, _ = json.Marshal(entry.TaskGraphStr)
+	}
+
+	return pipelineJSON{
+		Id:        entry.Id,
+		Interval:  entry.Interval,
+		TaskGraph: taskGraph,
+		Spec:      entry.Spec,
+		Status:    entry.Status,
+		Alive:     entry.Alive,
+	}
+}
+
+// fromPipelineJSON converts the API representation of a pipeline to a pipeline entry
+func fromPipelineJSON(p pipelineJSON) PipelineEntry {
+	return PipelineEntry{
+		Id:           p.Id,
+		Interval:     p.Interval,
+		TaskGraphStr: string(p.TaskGraph),
+		Spec:         p.Spec,
+		Status:       p.Status,
+		Alive:        p.Alive,
+	}
+}
+
+// toVersionJSON converts a pipeline version to its API representation, with the values of secret args redacted from
+// the task graph and the spec source
+func toVersionJSON(v PipelineVersion) versionJSON {
+	taskGraph := json.RawMessage(execute.RedactTaskGraph(v.TaskGraphStr))
+	if !json.Valid(taskGraph) {
+		taskGraph, _ = json.Marshal(v.TaskGraphStr)
+	}
+
+	return versionJSON{
+		Version:    v.Version,
+		Time:       v.Time,
+		Author:     v.Author,
+		Spec:       v.Spec,
+		SpecSource: execute.RedactSpecSource(v.SpecSource, v.TaskGraphStr),
+		TaskGraph:  taskGraph,
+	}
+}
+
+// fromVersionJSON converts the API representation of a version of the pipeline with id to a pipeline version
+func fromVersionJSON(id int, v versionJSON) PipelineVersion {
+	return PipelineVersion{
+		JobId:        id,
+		Version:      v.Version,
+		TaskGraphStr: string(v.TaskGraph),
+		Spec:         v.Spec,
+		SpecSource:   v.SpecSource,
+		Author:       v.Author,
+		Time:         v.Time,
+	}
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Logger.Error("Could not write API response", zap.String("Error", err.Error()))
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string, details []string) {
+	writeAPIJSON(w, status, errorJSON{Error: msg, Details: details})
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+var checkpointSource string
+var checkpointCursor string
+
+// checkpointCmd represents the checkpoint command
+var checkpointCmd = &cobra.Command{
+	Use:   "checkpoint",
+	Short: "Show or reset the checkpoints of the sources of a pipeline",
+	Long: `Sources such as s3 and okta commit a checkpoint, a cursor of what they have read, after every read. A restarted
+pipeline resumes from its checkpoints rather than reading everything again. Use the show and reset subcommands to
+see the checkpoints of a pipeline, or to replay its sources from the start or from a point in time.`,
+}
+
+// checkpointShowCmd represents the checkpoint show command
+var checkpointShowCmd = &cobra.Command{
+	Use:   "show [pipeline ID]",
+	Short: "Show the checkpoints of the sources of the specified pipeline",
+	Long: `Show each source of the specified pipeline, by its location in the task graph, with the cursor it resumes from
+and when the cursor was committed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+
+		if err != nil {
+			log.Logger.Fatal("Argument must be an integer", zap.String("Error", err.Error()))
+		}
+
+		c.CheckpointShowHandler(id)
+	},
+}
+
+// checkpointResetCmd represents the checkpoint reset command
+var checkpointResetCmd = &cobra.Command{
+	Use:   "reset [pipeline ID]",
+	Short: "Reset the checkpoints of the sources of the specified pipeline",
+	Long: `Delete the checkpoints of the sources of the specified pipeline, so that they read from the start when the
+pipeline is next started. Select one source with --source. To replay from a point in time, set the cursor of the
+source with --cursor, for example: vaero checkpoint reset 1 --source 0 --cursor '{"since": "2023-01-02T00:00:00Z"}'
+The pipeline must be stopped, as its sources would overwrite the checkpoints.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+
+		if err != nil {
+			log.Logger.Fatal("Argument must be an integer", zap.String("Error", err.Error()))
+		}
+
+		if checkpointCursor != "" && checkpointSource == "" {
+			log.Logger.Fatal("Select the source to set the cursor of with --source")
+		}
+
+		c.CheckpointResetHandler(id, checkpointSource, checkpointCursor)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkpointCmd)
+	checkpointCmd.AddCommand(checkpointShowCmd)
+	checkpointCmd.AddCommand(checkpointResetCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// checkpointCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	checkpointResetCmd.Flags().StringVarP(&checkpointSource, "source", "s", "", "Location of the source in the task graph, such as 0 or 0.1.0 (all sources if not set)")
+	checkpointResetCmd.Flags().StringVarP(&checkpointCursor, "cursor", "c", "", "Cursor to resume from, as json (read from the start if not set)")
+}
@@ -0,0 +1,167 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vaerohq/vaero/execute"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// SourceCheckpoint is the cursor of a source of a pipeline, which the source resumes from when it restarts
+type SourceCheckpoint struct {
+	Source string          `json:"source"` // path of the source in the task graph
+	Op     string          `json:"op"`
+	Cursor json.RawMessage `json:"cursor,omitempty"` // empty if the source reads from the start
+	Time   *time.Time      `json:"time,omitempty"`   // when the cursor was committed
+}
+
+// checkpointRequest is the body of a request to set the cursor of a source
+type checkpointRequest struct {
+	Source string          `json:"source"`
+	Cursor json.RawMessage `json:"cursor"`
+}
+
+// errPipelineRunning is returned when the checkpoints of a pipeline are changed while it is running, as its
+// sources would overwrite them
+var errPipelineRunning = errors.New("pipeline is running. Stop it before resetting its checkpoints")
+
+// errInvalidCheckpoint is returned when a checkpoint names a node that is not a source, or its cursor is not json
+var errInvalidCheckpoint = errors.New("invalid checkpoint")
+
+// LoadCheckpoint returns the cursor committed by the source at path of the job with id, if it was committed by op
+func (c *ControlDB) LoadCheckpoint(id int, path string, op string) (string, bool) {
+	sqlStmt := fmt.Sprintf(`SELECT cursor FROM %s WHERE job_id = ? AND source = ? AND op = ?`, checkpointsTable)
+
+	var cursor string
+	if err := c.db.QueryRow(sqlStmt, id, path, op).Scan(&cursor); err != nil {
+		if err != sql.ErrNoRows {
+			log.Logger.Error("Could not load checkpoint", zap.Int("Id", id), zap.String("Error", err.Error()))
+		}
+		return "", false
+	}
+
+	return cursor, true
+}
+
+// CommitCheckpoint stores the cursor of the source at path of the job with id
+func (c *ControlDB) CommitCheckpoint(id int, path string, op string, cursor string) {
+	if err := c.storeCheckpoint(id, path, op, cursor); err != nil {
+		log.Logger.Error("Could not commit checkpoint", zap.Int("Id", id), zap.String("Source", path),
+			zap.String("Error", err.Error()))
+	}
+}
+
+// storeCheckpoint inserts or replaces the cursor of the source at path of the job with id
+func (c *ControlDB) storeCheckpoint(id int, path string, op string, cursor string) error {
+	sqlStmt := fmt.Sprintf(`
+		INSERT INTO %s (job_id, source, op, cursor, time) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (job_id, source) DO UPDATE SET op = excluded.op, cursor = excluded.cursor, time = excluded.time
+		`, checkpointsTable)
+
+	_, err := c.db.Exec(sqlStmt, id, path, op, cursor, time.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// selectCheckpoints returns the sources of the job with id, with their cursors
+func (c *ControlDB) selectCheckpoints(id int) ([]SourceCheckpoint, error) {
+	entry, ok := c.selectFromJobsDB(id)
+	if !ok {
+		return nil, errPipelineNotFound
+	}
+
+	taskGraph, err := parseTaskGraph(entry.TaskGraphStr)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoints := []SourceCheckpoint{}
+	execute.WalkTaskGraph(taskGraph, func(path string, task *execute.OpTask) {
+		if task.Type == "source" {
+			checkpoints = append(checkpoints, SourceCheckpoint{Source: path, Op: task.Op})
+		}
+	})
+
+	// A cursor committed by a different op, such as before the source was replaced, is not used and not shown
+	sqlStmt := fmt.Sprintf(`SELECT cursor, time FROM %s WHERE job_id = ? AND source = ? AND op = ?`, checkpointsTable)
+	for idx := range checkpoints {
+		var cursor, timeStr string
+		err := c.db.QueryRow(sqlStmt, id, checkpoints[idx].Source, checkpoints[idx].Op).Scan(&cursor, &timeStr)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		checkpoints[idx].Cursor = json.RawMessage(cursor)
+		checkpoints[idx].Time = parseScheduleTime(timeStr)
+	}
+
+	return checkpoints, nil
+}
+
+// resetCheckpoints sets the cursor of the source at path of the job with id, so that it resumes from there when it
+// next starts. An empty cursor deletes the checkpoint, so the source reads from the start, and an empty path resets
+// every source. The job must not be running.
+func (c *ControlDB) resetCheckpoints(id int, path string, cursor string) ([]SourceCheckpoint, error) {
+	entry, ok := c.selectFromJobsDB(id)
+	if !ok {
+		return nil, errPipelineNotFound
+	}
+
+	switch entry.Status {
+	case "running", "staged":
+		return nil, errPipelineRunning
+	case "stopping":
+		return nil, errPipelineStopping
+	}
+
+	checkpoints, err := c.selectCheckpoints(id)
+	if err != nil {
+		return nil, err
+	}
+
+	op := ""
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Source == path {
+			op = checkpoint.Op
+		}
+	}
+
+	if path != "" && op == "" {
+		return nil, fmt.Errorf("%w: %s is not a source of the pipeline", errInvalidCheckpoint, path)
+	}
+
+	if cursor == "" {
+		err = c.deleteCheckpoints(id, path)
+	} else if path == "" {
+		err = fmt.Errorf("%w: a cursor can only be set for one source", errInvalidCheckpoint)
+	} else if !json.Valid([]byte(cursor)) {
+		err = fmt.Errorf("%w: cursor must be json, such as {\"since\": \"2023-01-02T00:00:00Z\"}", errInvalidCheckpoint)
+	} else {
+		err = c.storeCheckpoint(id, path, op, cursor)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return c.selectCheckpoints(id)
+}
+
+// deleteCheckpoints deletes the cursor of the source at path of the job with id, or of every source if path is empty
+func (c *ControlDB) deleteCheckpoints(id int, path string) error {
+	if path == "" {
+		_, err := c.db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE job_id = ?`, checkpointsTable), id)
+		return err
+	}
+
+	_, err := c.db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE job_id = ? AND source = ?`, checkpointsTable), id, path)
+	return err
+}
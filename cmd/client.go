@@ -0,0 +1,252 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+)
+
+// apiProbeTimeout is how long the CLI waits for a running Vaero to answer before falling back to the jobs table
+const apiProbeTimeout = 500 * time.Millisecond
+
+// apiRequestTimeout bounds every other API request. Deleting a running pipeline waits for it to stop, so this is
+// generous.
+const apiRequestTimeout = 5 * time.Minute
+
+// apiClient is a client of the management API of a running Vaero
+type apiClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// apiError is an error response from the management API
+type apiError struct {
+	StatusCode int
+	Message    string
+	Details    []string
+}
+
+func (e *apiError) Error() string {
+	if len(e.Details) == 0 {
+		return e.Message
+	}
+	return e.Message + ": " + strings.Join(e.Details, "; ")
+}
+
+// Is matches the errors of the local pipeline operations, so that callers handle both the same way
+func (e *apiError) Is(target error) bool {
+	if e.StatusCode != http.StatusNotFound {
+		return false
+	}
+
+	isVersion := strings.HasPrefix(e.Message, errVersionNotFound.Error())
+	return (target == errVersionNotFound && isVersion) || (target == errPipelineNotFound && !isVersion)
+}
+
+// newAPIClient returns a client of the management API if a Vaero is running and answering on
+// settings.Config.ApiAddress. Otherwise, it returns nil and the CLI works on the jobs table directly.
+func newAPIClient() *apiClient {
+	if settings.Config.ApiAddress == "" {
+		return nil
+	}
+
+	token, err := apiToken(false)
+	if err != nil {
+		return nil
+	}
+
+	client := &apiClient{
+		baseURL: "http://" + settings.Config.ApiAddress + apiPrefix,
+		token:   token,
+		http:    &http.Client{Timeout: apiProbeTimeout},
+	}
+
+	var status statusJSON
+	if err := client.do(http.MethodGet, "/status", nil, &status); err != nil {
+		if apiErr, ok := err.(*apiError); ok && apiErr.StatusCode == http.StatusUnauthorized {
+			log.Logger.Fatal("Management API rejected the API token", zap.String("Address", settings.Config.ApiAddress))
+		}
+		log.Logger.Info("Management API not available, using jobs table", zap.String("Error", err.Error()))
+		return nil
+	}
+
+	client.http.Timeout = apiRequestTimeout
+
+	return client
+}
+
+// addPipeline adds a pipeline from the task graph json
+func (client *apiClient) addPipeline(taskGraphStr string, spec string, info versionInfo) (PipelineEntry, error) {
+	var p pipelineJSON
+	err := client.do(http.MethodPost, "/pipelines", pipelineRequest{TaskGraph: json.RawMessage(taskGraphStr), Spec: spec,
+		SpecSource: info.SpecSource, Author: info.Author}, &p)
+	return fromPipelineJSON(p), err
+}
+
+// updatePipeline replaces the task graph of the pipeline with id
+func (client *apiClient) updatePipeline(id int, taskGraphStr string, spec string, info versionInfo) (PipelineEntry, error) {
+	var p pipelineJSON
+	err := client.do(http.MethodPut, fmt.Sprintf("/pipelines/%d", id), pipelineRequest{TaskGraph: json.RawMessage(taskGraphStr),
+		Spec: spec, SpecSource: info.SpecSource, Author: info.Author}, &p)
+	return fromPipelineJSON(p), err
+}
+
+// deletePipeline deletes the pipeline with id
+func (client *apiClient) deletePipeline(id int) error {
+	return client.do(http.MethodDelete, fmt.Sprintf("/pipelines/%d", id), nil, nil)
+}
+
+// getPipeline returns the pipeline with id
+func (client *apiClient) getPipeline(id int) (PipelineEntry, error) {
+	var p pipelineJSON
+	err := client.do(http.MethodGet, fmt.Sprintf("/pipelines/%d", id), nil, &p)
+	return fromPipelineJSON(p), err
+}
+
+// listPipelines returns all pipelines
+func (client *apiClient) listPipelines() ([]PipelineEntry, error) {
+	var pipelines []pipelineJSON
+	if err := client.do(http.MethodGet, "/pipelines", nil, &pipelines); err != nil {
+		return nil, err
+	}
+
+	entries := make([]PipelineEntry, len(pipelines))
+	for idx, p := range pipelines {
+		entries[idx] = fromPipelineJSON(p)
+	}
+
+	return entries, nil
+}
+
+// startPipeline stages the stopped pipeline with id
+func (client *apiClient) startPipeline(id int) (PipelineEntry, error) {
+	var p pipelineJSON
+	err := client.do(http.MethodPost, fmt.Sprintf("/pipelines/%d/start", id), nil, &p)
+	return fromPipelineJSON(p), err
+}
+
+// stopPipeline stops the pipeline with id
+func (client *apiClient) stopPipeline(id int) (PipelineEntry, error) {
+	var p pipelineJSON
+	err := client.do(http.MethodPost, fmt.Sprintf("/pipelines/%d/stop", id), nil, &p)
+	return fromPipelineJSON(p), err
+}
+
+// rollbackPipeline rolls back the pipeline with id to version, and returns the version rolled back to
+func (client *apiClient) rollbackPipeline(id int, version int, author string) (PipelineEntry, PipelineVersion, error) {
+	var rb rollbackJSON
+	err := client.do(http.MethodPost, fmt.Sprintf("/pipelines/%d/rollback", id), rollbackRequest{Version: version, Author: author}, &rb)
+	return fromPipelineJSON(rb.Pipeline), PipelineVersion{JobId: id, Version: rb.Version, Spec: rb.Pipeline.Spec, Time: rb.Time}, err
+}
+
+// listVersions returns the versions of the pipeline with id, oldest first
+func (client *apiClient) listVersions(id int) ([]PipelineVersion, error) {
+	var list []versionJSON
+	if err := client.do(http.MethodGet, fmt.Sprintf("/pipelines/%d/versions", id), nil, &list); err != nil {
+		return nil, err
+	}
+
+	versions := make([]PipelineVersion, len(list))
+	for idx, v := range list {
+		versions[idx] = fromVersionJSON(id, v)
+	}
+
+	return versions, nil
+}
+
+// getVersion returns a version of the pipeline with id
+func (client *apiClient) getVersion(id int, version int) (PipelineVersion, error) {
+	var v versionJSON
+	err := client.do(http.MethodGet, fmt.Sprintf("/pipelines/%d/versions/%d", id, version), nil, &v)
+	return fromVersionJSON(id, v), err
+}
+
+// getSchedules returns the schedules of the sources of the pipeline with id
+func (client *apiClient) getSchedules(id int) ([]SourceSchedule, error) {
+	var schedules []SourceSchedule
+	err := client.do(http.MethodGet, fmt.Sprintf("/pipelines/%d/schedules", id), nil, &schedules)
+	return schedules, err
+}
+
+// getCheckpoints returns the cursors of the sources of the pipeline with id
+func (client *apiClient) getCheckpoints(id int) ([]SourceCheckpoint, error) {
+	var checkpoints []SourceCheckpoint
+	err := client.do(http.MethodGet, fmt.Sprintf("/pipelines/%d/checkpoints", id), nil, &checkpoints)
+	return checkpoints, err
+}
+
+// resetCheckpoints sets the cursor of the source at path of the pipeline with id, or deletes it if cursor is empty
+func (client *apiClient) resetCheckpoints(id int, path string, cursor string) ([]SourceCheckpoint, error) {
+	var checkpoints []SourceCheckpoint
+	var err error
+	if cursor == "" {
+		err = client.do(http.MethodDelete, fmt.Sprintf("/pipelines/%d/checkpoints?source=%s", id, url.QueryEscape(path)),
+			nil, &checkpoints)
+	} else {
+		err = client.do(http.MethodPut, fmt.Sprintf("/pipelines/%d/checkpoints", id),
+			checkpointRequest{Source: path, Cursor: json.RawMessage(cursor)}, &checkpoints)
+	}
+	return checkpoints, err
+}
+
+// getFailures returns the most recent failures of the pipeline with id
+func (client *apiClient) getFailures(id int, limit int) ([]PipelineFailure, error) {
+	var failures []PipelineFailure
+	err := client.do(http.MethodGet, fmt.Sprintf("/pipelines/%d/failures?limit=%d", id, limit), nil, &failures)
+	return failures, err
+}
+
+// do sends a request with body encoded as json and decodes the response into out. An error response is returned
+// as an *apiError.
+func (client *apiClient) do(method string, path string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, client.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+client.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var errBody errorJSON
+		if err := json.NewDecoder(resp.Body).Decode(&errBody); err != nil || errBody.Error == "" {
+			errBody.Error = resp.Status
+		}
+		return &apiError{StatusCode: resp.StatusCode, Message: errBody.Error, Details: errBody.Details}
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
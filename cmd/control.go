@@ -0,0 +1,177 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+)
+
+// controlRequest is the first line sent by the CLI to the control socket of a running Vaero
+type controlRequest struct {
+	Command  string  `json:"command"`
+	Pipeline int     `json:"pipeline"`
+	Node     string  `json:"node,omitempty"`
+	Sample   float64 `json:"sample,omitempty"`
+	Limit    int     `json:"limit,omitempty"`
+}
+
+// controlResponse is the first line sent back by the control socket. An empty Error means the request was accepted.
+type controlResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// serveControl listens on the control socket and handles requests from the CLI. It runs for the life of the
+// start command.
+func serveControl() {
+	socket := settings.Config.ControlSocket
+
+	// Remove a socket left behind by a previous run, unless another Vaero is still listening on it
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		log.Logger.Fatal("Another Vaero is already listening on the control socket", zap.String("Socket", socket))
+	}
+	os.Remove(socket)
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		log.Logger.Error("Could not listen on control socket", zap.String("Socket", socket), zap.String("Error", err.Error()))
+		return
+	}
+	defer listener.Close()
+
+	// Only the owner may control Vaero
+	if err := os.Chmod(socket, 0600); err != nil {
+		log.Logger.Error("Could not set control socket permissions", zap.String("Error", err.Error()))
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Logger.Error("Control socket accept failed", zap.String("Error", err.Error()))
+			continue
+		}
+
+		go handleControlConn(conn)
+	}
+}
+
+// handleControlConn reads a request from a control connection and dispatches it
+func handleControlConn(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+
+	var req controlRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		writeControlResponse(conn, "invalid request: "+err.Error())
+		return
+	}
+
+	switch req.Command {
+	case "tap":
+		handleTapConn(conn, reader, req)
+	default:
+		writeControlResponse(conn, fmt.Sprintf("unknown command %q", req.Command))
+	}
+}
+
+// handleTapConn streams the events of a tap to the connection, one json string per line, until the tap is done or
+// the client disconnects
+func handleTapConn(conn net.Conn, reader *bufio.Reader, req controlRequest) {
+	tap, err := executor.AddTap(req.Pipeline, req.Node, req.Sample, req.Limit)
+	if err != nil {
+		writeControlResponse(conn, err.Error())
+		return
+	}
+	defer executor.RemoveTap(tap)
+
+	log.Logger.Info("Tap attached", zap.Int("Id", req.Pipeline), zap.String("Node", req.Node))
+	defer log.Logger.Info("Tap detached", zap.Int("Id", req.Pipeline), zap.String("Node", req.Node))
+
+	if err := writeControlResponse(conn, ""); err != nil {
+		return
+	}
+
+	// The client sends nothing more, so a completed read means it disconnected
+	disconnected := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, reader)
+		close(disconnected)
+	}()
+
+	enc := json.NewEncoder(conn)
+	for {
+		select {
+		case event := <-tap.Events:
+			if err := enc.Encode(event); err != nil {
+				return
+			}
+		case <-tap.Done:
+			// Send any events still buffered
+			for {
+				select {
+				case event := <-tap.Events:
+					if err := enc.Encode(event); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case <-disconnected:
+			return
+		}
+	}
+}
+
+func writeControlResponse(conn net.Conn, errMsg string) error {
+	return json.NewEncoder(conn).Encode(controlResponse{Error: errMsg})
+}
+
+// dialControl connects to the control socket of a running Vaero, sends the request, and returns a reader positioned
+// after the response line
+func dialControl(req controlRequest) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.Dial("unix", settings.Config.ControlSocket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to Vaero on %s. Is vaero start running? (%s)",
+			settings.Config.ControlSocket, err.Error())
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	var resp controlResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.Error != "" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%s", resp.Error)
+	}
+
+	return conn, reader, nil
+}
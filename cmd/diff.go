@@ -0,0 +1,52 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// diffSpec sets whether the diff command also compares the spec sources
+var diffSpec bool
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [pipeline ID] [version] [version]",
+	Short: "Show the differences between two versions of the specified pipeline",
+	Long: `Show a structural diff of the task graphs of two versions of the specified pipeline. Nodes are identified by
+their path in the task graph, such as 3.1.0. Added nodes are marked +, removed nodes -, and changed nodes ~ with the
+args, secret, and restart settings that changed. Find versions using the history command.`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		nums := make([]int, len(args))
+		for idx, arg := range args {
+			num, err := strconv.Atoi(arg)
+
+			if err != nil {
+				log.Logger.Fatal("Argument must be an integer", zap.String("Error", err.Error()))
+			}
+			nums[idx] = num
+		}
+
+		c.DiffHandler(nums[0], nums[1], nums[2], diffSpec)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// diffCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	diffCmd.Flags().BoolVar(&diffSpec, "spec", false, "Also show a line diff of the spec sources")
+}
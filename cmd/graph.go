@@ -0,0 +1,36 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var graphFormat string
+
+// graphCmd represents the graph command
+var graphCmd = &cobra.Command{
+	Use:   "graph [pipeline ID or file path]",
+	Short: "Display the task graph of a pipeline",
+	Long: `Display the task graph of a pipeline, including its branches. The pipeline is either the ID of a pipeline
+that has been added, or a pipeline file. Tasks are labeled with their location in the task graph.
+Formats are an ASCII tree (tree), Graphviz DOT (dot), or a Mermaid flowchart (mermaid). For example:
+vaero graph 1 --format dot | dot -Tpng -o pipeline.png`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c.GraphHandler(args[0], graphFormat)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// graphCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	graphCmd.Flags().StringVarP(&graphFormat, "format", "f", "tree", "Output format: tree, dot, or mermaid")
+}
@@ -0,0 +1,318 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vaerohq/vaero/execute"
+)
+
+// graphChange is a difference between two task graphs at a single node
+type graphChange struct {
+	Kind    string   // "+" for an added node, "-" for a removed node, or "~" for a changed node
+	Path    string   // path of the node in the new task graph, or in the old task graph if it was removed
+	OldPath string   // path of a changed node in the old task graph
+	Node    string   // type and op of the node
+	Details []string // the args and blocks that were added, removed, or changed
+}
+
+// diffTaskGraphs compares two task graph json strings structurally. Nodes are matched by type and op in the order
+// they appear, so inserting or removing a node does not show the nodes after it as changed.
+func diffTaskGraphs(oldStr string, newStr string) ([]graphChange, error) {
+	var oldGraph, newGraph []interface{}
+	if err := json.Unmarshal([]byte(oldStr), &oldGraph); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidTaskGraph, err.Error())
+	}
+	if err := json.Unmarshal([]byte(newStr), &newGraph); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidTaskGraph, err.Error())
+	}
+
+	var changes []graphChange
+	diffTaskLists(oldGraph, newGraph, "", "", &changes)
+
+	return changes, nil
+}
+
+// diffTaskLists compares the tasks of a task graph or branch route
+func diffTaskLists(oldTasks []interface{}, newTasks []interface{}, oldPrefix string, newPrefix string, changes *[]graphChange) {
+	oldKeys := make([]string, len(oldTasks))
+	for idx, task := range oldTasks {
+		oldKeys[idx] = taskKey(task)
+	}
+	newKeys := make([]string, len(newTasks))
+	for idx, task := range newTasks {
+		newKeys[idx] = taskKey(task)
+	}
+
+	oldIdx, newIdx := 0, 0
+	for _, pair := range lcsPairs(oldKeys, newKeys) {
+		for ; oldIdx < pair[0]; oldIdx++ {
+			addTaskChanges("-", oldTasks[oldIdx], nodePath(oldPrefix, oldIdx), changes)
+		}
+		for ; newIdx < pair[1]; newIdx++ {
+			addTaskChanges("+", newTasks[newIdx], nodePath(newPrefix, newIdx), changes)
+		}
+
+		diffTask(oldTasks[oldIdx], newTasks[newIdx], nodePath(oldPrefix, oldIdx), nodePath(newPrefix, newIdx), changes)
+		oldIdx++
+		newIdx++
+	}
+
+	for ; oldIdx < len(oldTasks); oldIdx++ {
+		addTaskChanges("-", oldTasks[oldIdx], nodePath(oldPrefix, oldIdx), changes)
+	}
+	for ; newIdx < len(newTasks); newIdx++ {
+		addTaskChanges("+", newTasks[newIdx], nodePath(newPrefix, newIdx), changes)
+	}
+}
+
+// diffTask compares two tasks with the same type and op
+func diffTask(oldTask interface{}, newTask interface{}, oldPath string, newPath string, changes *[]graphChange) {
+	// A branch is a list of routes, each of which is a list of tasks
+	if oldRoutes, ok := oldTask.([]interface{}); ok {
+		newRoutes := newTask.([]interface{})
+		for idx := 0; idx < len(oldRoutes) || idx < len(newRoutes); idx++ {
+			var oldRoute, newRoute []interface{}
+			if idx < len(oldRoutes) {
+				oldRoute, _ = oldRoutes[idx].([]interface{})
+			}
+			if idx < len(newRoutes) {
+				newRoute, _ = newRoutes[idx].([]interface{})
+			}
+			diffTaskLists(oldRoute, newRoute, nodePath(oldPath, idx), nodePath(newPath, idx), changes)
+		}
+		return
+	}
+
+	oldMap, _ := oldTask.(map[string]interface{})
+	newMap, _ := newTask.(map[string]interface{})
+
+	// The values of secret args are not shown, only that they changed
+	taskType, _ := newMap["type"].(string)
+	op, _ := newMap["op"].(string)
+	oldArgs, _ := oldMap["args"].(map[string]interface{})
+	newArgs, _ := newMap["args"].(map[string]interface{})
+	oldSecret, _ := oldMap["secret"].(map[string]interface{})
+	newSecret, _ := newMap["secret"].(map[string]interface{})
+	secretArgs := execute.SecretArgs(taskType, op, oldArgs, oldSecret)
+	for arg := range execute.SecretArgs(taskType, op, newArgs, newSecret) {
+		secretArgs[arg] = true
+	}
+
+	var details []string
+	for _, block := range []string{"args", "secret", "restart", "schedule"} {
+		oldBlock, _ := oldMap[block].(map[string]interface{})
+		newBlock, _ := newMap[block].(map[string]interface{})
+
+		var secrets map[string]bool
+		if block == "args" {
+			secrets = secretArgs
+		}
+		details = append(details, diffBlock(block, oldBlock, newBlock, secrets)...)
+	}
+
+	if len(details) > 0 {
+		change := graphChange{Kind: "~", Path: newPath, Node: taskKey(newTask), Details: details}
+		if oldPath != newPath {
+			change.OldPath = oldPath
+		}
+		*changes = append(*changes, change)
+	}
+
+	// A union has a list of inputs, each of which is a list of tasks
+	oldInputs, _ := oldMap["inputs"].([]interface{})
+	newInputs, _ := newMap["inputs"].([]interface{})
+	for idx := 0; idx < len(oldInputs) || idx < len(newInputs); idx++ {
+		var oldInput, newInput []interface{}
+		if idx < len(oldInputs) {
+			oldInput, _ = oldInputs[idx].([]interface{})
+		}
+		if idx < len(newInputs) {
+			newInput, _ = newInputs[idx].([]interface{})
+		}
+		diffTaskLists(oldInput, newInput, nodePath(oldPath, idx), nodePath(newPath, idx), changes)
+	}
+}
+
+// diffBlock compares the keys of a block of a task, such as its args. The values of keys in secrets are redacted.
+func diffBlock(block string, oldBlock map[string]interface{}, newBlock map[string]interface{}, secrets map[string]bool) []string {
+	keys := map[string]bool{}
+	for k := range oldBlock {
+		keys[k] = true
+	}
+	for k := range newBlock {
+		keys[k] = true
+	}
+
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	var details []string
+	for _, k := range sortedKeys {
+		oldVal, inOld := oldBlock[k]
+		newVal, inNew := newBlock[k]
+		oldStr, newStr := jsonValue(oldVal), jsonValue(newVal)
+		if secrets[k] {
+			oldStr, newStr = execute.Redacted, execute.Redacted
+		}
+
+		switch {
+		case !inOld:
+			details = append(details, fmt.Sprintf("+ %s.%s: %s", block, k, newStr))
+		case !inNew:
+			details = append(details, fmt.Sprintf("- %s.%s: %s", block, k, oldStr))
+		case !reflect.DeepEqual(oldVal, newVal) && secrets[k]:
+			details = append(details, fmt.Sprintf("~ %s.%s: changed", block, k))
+		case !reflect.DeepEqual(oldVal, newVal):
+			details = append(details, fmt.Sprintf("~ %s.%s: %s -> %s", block, k, oldStr, newStr))
+		}
+	}
+
+	return details
+}
+
+// addTaskChanges records an added or removed task, and every task in it if it is a branch or union
+func addTaskChanges(kind string, task interface{}, path string, changes *[]graphChange) {
+	if routes, ok := task.([]interface{}); ok {
+		*changes = append(*changes, graphChange{Kind: kind, Path: path, Node: "branch"})
+		for idx, route := range routes {
+			tasks, _ := route.([]interface{})
+			for taskIdx, routeTask := range tasks {
+				addTaskChanges(kind, routeTask, nodePath(nodePath(path, idx), taskIdx), changes)
+			}
+		}
+		return
+	}
+
+	var details []string
+	taskMap, _ := task.(map[string]interface{})
+	if args, ok := taskMap["args"].(map[string]interface{}); ok && len(args) > 0 {
+		// Redact a copy, as the task is still to be compared
+		redacted := map[string]interface{}{"type": taskMap["type"], "op": taskMap["op"], "secret": taskMap["secret"],
+			"args": copyArgs(args)}
+		execute.RedactArgs(redacted)
+		details = append(details, "args: "+jsonValue(redacted["args"]))
+	}
+
+	*changes = append(*changes, graphChange{Kind: kind, Path: path, Node: taskKey(task), Details: details})
+
+	inputs, _ := taskMap["inputs"].([]interface{})
+	for idx, input := range inputs {
+		tasks, _ := input.([]interface{})
+		for taskIdx, inputTask := range tasks {
+			addTaskChanges(kind, inputTask, nodePath(nodePath(path, idx), taskIdx), changes)
+		}
+	}
+}
+
+// copyArgs returns a shallow copy of the args of a task
+func copyArgs(args map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		copied[k] = v
+	}
+	return copied
+}
+
+// nodePath returns the path of task idx of the task graph or branch route at prefix, such as 3.1.0
+func nodePath(prefix string, idx int) string {
+	if prefix == "" {
+		return strconv.Itoa(idx)
+	}
+	return prefix + "." + strconv.Itoa(idx)
+}
+
+// taskKey identifies the kind of node a task is, to match tasks between task graphs
+func taskKey(task interface{}) string {
+	if _, ok := task.([]interface{}); ok {
+		return "branch"
+	}
+
+	taskMap, _ := task.(map[string]interface{})
+	taskType, _ := taskMap["type"].(string)
+	op, _ := taskMap["op"].(string)
+
+	return taskType + " " + op
+}
+
+// jsonValue formats a value of a task graph as json
+func jsonValue(val interface{}) string {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+	return string(data)
+}
+
+// lcsPairs returns the index pairs of a longest common subsequence of a and b, in order
+func lcsPairs(a []string, b []string) [][2]int {
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	var pairs [][2]int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if a[i] == b[j] {
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		} else if lengths[i+1][j] >= lengths[i][j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+
+	return pairs
+}
+
+// diffLines returns a line diff of two texts, with each line prefixed by "+ ", "- ", or "  " if unchanged
+func diffLines(oldText string, newText string) []string {
+	oldLines := strings.Split(strings.TrimSuffix(oldText, "\n"), "\n")
+	newLines := strings.Split(strings.TrimSuffix(newText, "\n"), "\n")
+
+	var lines []string
+	oldIdx, newIdx := 0, 0
+	for _, pair := range lcsPairs(oldLines, newLines) {
+		for ; oldIdx < pair[0]; oldIdx++ {
+			lines = append(lines, "- "+oldLines[oldIdx])
+		}
+		for ; newIdx < pair[1]; newIdx++ {
+			lines = append(lines, "+ "+newLines[newIdx])
+		}
+		lines = append(lines, "  "+oldLines[oldIdx])
+		oldIdx++
+		newIdx++
+	}
+	for ; oldIdx < len(oldLines); oldIdx++ {
+		lines = append(lines, "- "+oldLines[oldIdx])
+	}
+	for ; newIdx < len(newLines); newIdx++ {
+		lines = append(lines, "+ "+newLines[newIdx])
+	}
+
+	return lines
+}
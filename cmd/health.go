@@ -0,0 +1,139 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/vaerohq/vaero/execute"
+	"github.com/vaerohq/vaero/settings"
+)
+
+// minAdminPollStall is the shortest time without an admin routine poll before Vaero is reported unhealthy
+const minAdminPollStall = 30 * time.Second
+
+// probeJSON is the body of a health or readiness response
+type probeJSON struct {
+	Status        string              `json:"status"` // ok or fail
+	Reasons       []string            `json:"reasons,omitempty"`
+	LastAdminPoll *time.Time          `json:"last_admin_poll,omitempty"`
+	Pipelines     []pipelineProbeJSON `json:"pipelines"`
+}
+
+// pipelineProbeJSON describes the health of a single pipeline
+type pipelineProbeJSON struct {
+	Id      int                     `json:"id"`
+	Status  string                  `json:"status"`
+	Ready   bool                    `json:"ready"`
+	Reasons []string                `json:"reasons,omitempty"`
+	Health  *execute.PipelineHealth `json:"health,omitempty"` // only set for running pipelines
+}
+
+// handleHealthz reports whether Vaero is alive. It fails if the admin routine has stopped polling the jobs table,
+// because pipelines can then no longer be started or stopped.
+func (s *apiServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+
+	probe := probeJSON{Status: "ok"}
+
+	last := atomic.LoadInt64(&lastAdminPoll)
+	stall := time.Duration(3*settings.Config.PollPipelineChangesFreq) * time.Second
+	if stall < minAdminPollStall {
+		stall = minAdminPollStall
+	}
+
+	if last == 0 {
+		// The admin routine has not polled yet, which is only a problem if Vaero has been up for a while
+		if time.Since(s.startTime) > stall {
+			probe.Reasons = append(probe.Reasons, "admin routine has not started")
+		}
+	} else {
+		lastTime := time.Unix(0, last)
+		probe.LastAdminPoll = &lastTime
+		if since := time.Since(lastTime); since > stall {
+			probe.Reasons = append(probe.Reasons, fmt.Sprintf("admin routine has not polled the jobs table for %s", since.Round(time.Second)))
+		}
+	}
+
+	probe.Pipelines = s.probePipelines()
+
+	writeProbe(w, probe)
+}
+
+// handleReadyz reports whether every pipeline is running as expected. It fails while a staged pipeline has not
+// started, while a sink has been failing for longer than settings.Config.SinkFailureThreshold, or if a source has
+// stopped unexpectedly.
+func (s *apiServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodHead) {
+		return
+	}
+
+	probe := probeJSON{Status: "ok", Pipelines: s.probePipelines()}
+
+	for _, p := range probe.Pipelines {
+		if !p.Ready {
+			probe.Reasons = append(probe.Reasons, fmt.Sprintf("pipeline %d is not ready", p.Id))
+		}
+	}
+
+	writeProbe(w, probe)
+}
+
+// probePipelines checks the health of every pipeline in the jobs table
+func (s *apiServer) probePipelines() []pipelineProbeJSON {
+	threshold := time.Duration(settings.Config.SinkFailureThreshold) * time.Second
+
+	entries := s.c.selectAllFromJobsDB()
+	pipelines := make([]pipelineProbeJSON, 0, len(entries))
+
+	for _, entry := range entries {
+		p := pipelineProbeJSON{Id: entry.Id, Status: entry.Status, Ready: true}
+
+		switch entry.Status {
+		case "staged":
+			p.Reasons = append(p.Reasons, "pipeline is staged but has not started")
+		case "failed":
+			p.Reasons = append(p.Reasons, "pipeline failed and its restart policy gave up")
+		case "running":
+			health, ok := executor.Health(entry.Id)
+			if !ok {
+				p.Reasons = append(p.Reasons, "pipeline is marked running but is not running")
+				break
+			}
+			p.Health = &health
+
+			if !health.SourceRunning {
+				p.Reasons = append(p.Reasons, "source stopped: "+health.SourceError)
+			}
+
+			for _, sink := range health.Sinks {
+				if sink.FailingSince != nil && time.Since(*sink.FailingSince) > threshold {
+					p.Reasons = append(p.Reasons, fmt.Sprintf("sink %s (%s) has been failing for %s: %s", sink.Node, sink.Op,
+						time.Since(*sink.FailingSince).Round(time.Second), sink.LastError))
+				}
+			}
+		}
+
+		p.Ready = len(p.Reasons) == 0
+		pipelines = append(pipelines, p)
+	}
+
+	return pipelines
+}
+
+// writeProbe writes the probe with status 200 if it passed, or 503 if it failed
+func writeProbe(w http.ResponseWriter, probe probeJSON) {
+	status := http.StatusOK
+	if len(probe.Reasons) > 0 {
+		probe.Status = "fail"
+		status = http.StatusServiceUnavailable
+	}
+
+	writeAPIJSON(w, status, probe)
+}
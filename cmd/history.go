@@ -0,0 +1,45 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history [pipeline ID]",
+	Short: "List the versions of the specified pipeline",
+	Long: `List every version of the specified pipeline, with when it was submitted, who submitted it, the spec file,
+and a summary of the nodes added, removed, and changed since the previous version. A version is recorded whenever a
+pipeline is added, updated, or rolled back. The author is VAERO_AUTHOR if set, otherwise user@host.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+
+		if err != nil {
+			log.Logger.Fatal("Argument must be an integer", zap.String("Error", err.Error()))
+		}
+
+		c.HistoryHandler(id)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// historyCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// historyCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// failuresTable is the name of the sql table for pipeline failures
+const failuresTable = "job_failures"
+
+// versionsTable is the name of the sql table for the task graph versions of pipelines
+const versionsTable = "job_versions"
+
+// schedulesTable is the name of the sql table for the reads of sources with a schedule
+const schedulesTable = "source_schedules"
+
+// checkpointsTable is the name of the sql table for the cursors of sources
+const checkpointsTable = "source_checkpoints"
+
+// migrations upgrade the control DB from one version to the next. The version of a DB is stored in its
+// user_version pragma, which is the number of migrations applied. Append new migrations; never edit old ones.
+var migrations = []string{
+	// 1: add the failed status, and record pipeline failures
+	fmt.Sprintf(`
+		CREATE TABLE %[1]s_new (id INTEGER NOT NULL PRIMARY KEY, interval INTEGER,
+			task_graph TEXT, spec TEXT, status TEXT CHECK( status IN ("running", "staged", "stopped", "stopping", "failed") ), alive INTEGER);
+		INSERT INTO %[1]s_new (id, interval, task_graph, spec, status, alive)
+			SELECT id, interval, task_graph, spec, status, alive FROM %[1]s;
+		DROP TABLE %[1]s;
+		ALTER TABLE %[1]s_new RENAME TO %[1]s;
+
+		CREATE TABLE %[2]s (id INTEGER NOT NULL PRIMARY KEY, job_id INTEGER, time TEXT, node TEXT,
+			error TEXT, stack TEXT, restarts INTEGER, restarting INTEGER);
+		CREATE INDEX %[2]s_job_id ON %[2]s (job_id);
+		`, jobsTable, failuresTable),
+
+	// 2: keep a history of the task graph of each pipeline, starting with the current task graph as version 1
+	fmt.Sprintf(`
+		CREATE TABLE %[2]s (id INTEGER NOT NULL PRIMARY KEY, job_id INTEGER, version INTEGER, task_graph TEXT,
+			spec TEXT, time TEXT, UNIQUE (job_id, version));
+		INSERT INTO %[2]s (job_id, version, task_graph, spec, time)
+			SELECT id, 1, task_graph, spec, strftime('%%Y-%%m-%%dT%%H:%%M:%%SZ', 'now') FROM %[1]s;
+		`, jobsTable, versionsTable),
+
+	// 3: record the source of the spec and the author of each version
+	fmt.Sprintf(`
+		ALTER TABLE %[1]s ADD COLUMN spec_source TEXT NOT NULL DEFAULT '';
+		ALTER TABLE %[1]s ADD COLUMN author TEXT NOT NULL DEFAULT '';
+		`, versionsTable),
+
+	// 4: record the last and next reads of sources with a schedule, keyed by the path of the source in the task graph
+	fmt.Sprintf(`
+		CREATE TABLE %[1]s (job_id INTEGER NOT NULL, source TEXT NOT NULL, last_read TEXT NOT NULL DEFAULT '',
+			next_read TEXT NOT NULL DEFAULT '', PRIMARY KEY (job_id, source));
+		`, schedulesTable),
+
+	// 5: store the cursors of sources, keyed by the path of the source in the task graph
+	fmt.Sprintf(`
+		CREATE TABLE %[1]s (job_id INTEGER NOT NULL, source TEXT NOT NULL, op TEXT NOT NULL, cursor TEXT NOT NULL,
+			time TEXT NOT NULL, PRIMARY KEY (job_id, source));
+		`, checkpointsTable),
+}
+
+// migrateTables applies any migrations that the control DB has not had yet
+func (c *ControlDB) migrateTables() {
+	var version int
+	if err := c.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		log.Logger.Fatal("Could not read database version", zap.String("Error", err.Error()))
+	}
+
+	for ; version < len(migrations); version++ {
+		log.Logger.Info("Migrating database", zap.Int("Version", version+1))
+
+		tx, err := c.db.Begin()
+		if err != nil {
+			log.Logger.Fatal("Could not start database migration", zap.String("Error", err.Error()))
+		}
+
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			log.Logger.Fatal("Database migration failed", zap.Int("Version", version+1), zap.String("Error", err.Error()))
+		}
+
+		// PRAGMA does not accept parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			log.Logger.Fatal("Database migration failed", zap.Int("Version", version+1), zap.String("Error", err.Error()))
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Logger.Fatal("Database migration failed", zap.Int("Version", version+1), zap.String("Error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/vaerohq/vaero/execute"
+	"github.com/vaerohq/vaero/integrations/wasm"
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/schema"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+)
+
+// pluginsCmd represents the plugins command
+var pluginsCmd = &cobra.Command{
+	Use:   "plugins [op]",
+	Short: "List the sources, transforms, and sinks that pipelines can use",
+	Long: `List the sources, transforms, and sinks that pipelines can use, with their capabilities and required options,
+and the WebAssembly plugins in the plugin folder. If an op is given, such as s3, all the options of each source,
+transform, and sink with that op are shown.
+
+Capabilities:
+  pull             reads on an interval or schedule, rather than receiving events pushed to it
+  push             receives events pushed to it, such as by HTTP
+  secrets refresh  takes new values of its args when its secrets are refreshed
+  acks             a source commits a checkpoint of what it has read, and a sink reports events it could not write`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 {
+			if !printPluginOptions(args[0]) {
+				log.Logger.Fatal("Unknown op", zap.String("Op", args[0]))
+			}
+			return
+		}
+
+		printPlugins()
+	},
+}
+
+// pluginEntry is a registered source, transform, or sink, as listed by the plugins command
+type pluginEntry struct {
+	schema       *schema.OpSchema
+	capabilities []string
+}
+
+// pluginEntries returns every registered component, sources first, then transforms, then sinks
+func pluginEntries() []pluginEntry {
+	entries := []pluginEntry{}
+
+	for _, p := range execute.SourcePlugins() {
+		capabilities := []string{"push"}
+		if p.Pull {
+			capabilities[0] = "pull"
+		}
+		if p.SecretsRefresh {
+			capabilities = append(capabilities, "secrets refresh")
+		}
+		if p.Acks {
+			capabilities = append(capabilities, "acks")
+		}
+		entries = append(entries, pluginEntry{schema: p.Schema, capabilities: capabilities})
+	}
+
+	for _, p := range execute.TransformPlugins() {
+		entries = append(entries, pluginEntry{schema: p.Schema})
+	}
+
+	for _, p := range execute.SinkPlugins() {
+		capabilities := []string{}
+		if p.SecretsRefresh {
+			capabilities = append(capabilities, "secrets refresh")
+		}
+		if p.Acks {
+			capabilities = append(capabilities, "acks")
+		}
+		entries = append(entries, pluginEntry{schema: p.Schema, capabilities: capabilities})
+	}
+
+	return entries
+}
+
+// printPlugins prints every registered component, and the WebAssembly plugins in the plugin folder
+func printPlugins() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintf(w, "Type\tOp\tCapabilities\tRequired Options\n")
+	for _, entry := range pluginEntries() {
+		required := []string{}
+		for _, arg := range entry.schema.Args {
+			if arg.Required {
+				required = append(required, arg.Name)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.schema.Type, entry.schema.Op, orNone(entry.capabilities),
+			orNone(required))
+	}
+	w.Flush()
+
+	fmt.Printf("\nRun vaero plugins <op> to see all the options of an op.\n")
+
+	infos, err := wasm.Available()
+	if err != nil {
+		log.Logger.Fatal("Could not list WebAssembly plugins", zap.String("Error", err.Error()))
+	}
+
+	fmt.Printf("\nWebAssembly plugins in %s:\n", settings.Config.PluginDir)
+	if len(infos) == 0 {
+		fmt.Printf("none\n")
+		return
+	}
+
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintf(w, "Plugin\tImplements\tHTTP Hosts\tDescription\n")
+	for _, info := range infos {
+		description := info.Manifest.Description
+		if info.Err != nil {
+			description = "invalid: " + info.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", info.Name, orNone(info.Kinds), orNone(info.Manifest.HTTPHosts), description)
+	}
+	w.Flush()
+}
+
+// printPluginOptions prints all the options of each component with op, and returns false if there is none
+func printPluginOptions(op string) bool {
+	found := false
+
+	for _, entry := range pluginEntries() {
+		if entry.schema.Op != op {
+			continue
+		}
+		if found {
+			fmt.Println()
+		}
+		found = true
+
+		fmt.Printf("%s %s (capabilities: %s)\n", entry.schema.Type, entry.schema.Op, orNone(entry.capabilities))
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+		fmt.Fprintf(w, "Option\tType\tDefault\tDescription\n")
+		for _, arg := range entry.schema.Args {
+			def, _ := json.Marshal(arg.Default)
+			if str, ok := arg.Default.(string); ok && str != "" {
+				def = []byte(str)
+			} else if arg.Required {
+				def = []byte("required")
+			}
+			description := arg.Description
+			if len(arg.Allowed) > 0 {
+				description += fmt.Sprintf(". One of %v", arg.Allowed)
+			}
+			if arg.Secret {
+				description += " (secret)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", arg.Name, arg.Type, string(def), description)
+		}
+		w.Flush()
+	}
+
+	return found
+}
+
+// orNone joins values with commas, or returns "none" if there are none
+func orNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+func init() {
+	rootCmd.AddCommand(pluginsCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// pluginsCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// pluginsCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
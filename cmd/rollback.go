@@ -0,0 +1,52 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [pipeline ID] [version]",
+	Short: "Roll back the specified pipeline to an earlier version",
+	Long: `Replace the task graph of the specified pipeline with the task graph of an earlier version. Without a version,
+the pipeline is rolled back to the version before the latest. The rollback is recorded as a new version, and a
+running pipeline switches to it in the same way as with the update command.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+
+		if err != nil {
+			log.Logger.Fatal("Argument must be an integer", zap.String("Error", err.Error()))
+		}
+
+		version := 0
+		if len(args) > 1 {
+			if version, err = strconv.Atoi(args[1]); err != nil || version < 1 {
+				log.Logger.Fatal("Version must be a positive integer", zap.String("Version", args[1]))
+			}
+		}
+
+		c.RollbackHandler(id, version)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// rollbackCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// rollbackCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
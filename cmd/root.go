@@ -14,7 +14,7 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "vaero",
 	Short: "Vaero collects, transforms, and routes your log data",
-	Long: `Vaero is a modern log shipper that lets you specify your log pipelines in Python. Pipelines are executed in Go. This application is a command line interface for Vaero.
+	Long: `Vaero is a modern log shipper that lets you specify your log pipelines in Python, YAML, TOML, or JSON. Pipelines are executed in Go. This application is a command line interface for Vaero.
 To get started, add a pipeline file and then start:
 vaero add pipelines/pipe.py
 vaero start
@@ -23,7 +23,6 @@ vaero start
 		log.InitLogger()
 		InitSettings()
 		c.InitTables()
-		CheckPython()
 	},
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {
 		log.SyncLogger()
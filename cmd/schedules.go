@@ -0,0 +1,116 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/vaerohq/vaero/execute"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// SourceSchedule is the schedule of a source of a pipeline, with its last and next reads
+type SourceSchedule struct {
+	Source   string     `json:"source"`   // path of the source in the task graph
+	Schedule string     `json:"schedule"` // description of the schedule block of the source
+	LastRead *time.Time `json:"last_read,omitempty"`
+	NextRead *time.Time `json:"next_read,omitempty"` // empty if the schedule allows no more reads
+}
+
+// LastScheduledRead returns the time of the last read of the source at path of the job with id
+func (c *ControlDB) LastScheduledRead(id int, path string) (time.Time, bool) {
+	sqlStmt := fmt.Sprintf(`SELECT last_read FROM %s WHERE job_id = ? AND source = ?`, schedulesTable)
+
+	var lastStr string
+	if err := c.db.QueryRow(sqlStmt, id, path).Scan(&lastStr); err != nil {
+		if err != sql.ErrNoRows {
+			log.Logger.Error("Could not read last scheduled read", zap.Int("Id", id), zap.String("Error", err.Error()))
+		}
+		return time.Time{}, false
+	}
+
+	last, err := time.Parse(time.RFC3339Nano, lastStr)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return last, true
+}
+
+// RecordScheduledRead records the last and next reads of the source at path of the job with id. A zero last keeps
+// the recorded last read.
+func (c *ControlDB) RecordScheduledRead(id int, path string, last time.Time, next time.Time) {
+	sqlStmt := fmt.Sprintf(`
+		INSERT INTO %s (job_id, source, last_read, next_read) VALUES (?, ?, ?, ?)
+			ON CONFLICT (job_id, source) DO UPDATE SET
+				last_read = CASE WHEN excluded.last_read = '' THEN last_read ELSE excluded.last_read END,
+				next_read = excluded.next_read
+		`, schedulesTable)
+
+	if _, err := c.db.Exec(sqlStmt, id, path, formatScheduleTime(last), formatScheduleTime(next)); err != nil {
+		log.Logger.Error("Could not record scheduled read", zap.Int("Id", id), zap.String("Error", err.Error()))
+	}
+}
+
+// formatScheduleTime formats a time for the schedules table, where the zero time is empty
+func formatScheduleTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseScheduleTime parses a time of the schedules table, where empty is nil
+func parseScheduleTime(str string) *time.Time {
+	t, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// selectSchedules returns the schedules of the sources of the job with id, with their last and next reads
+func (c *ControlDB) selectSchedules(id int) ([]SourceSchedule, error) {
+	entry, ok := c.selectFromJobsDB(id)
+	if !ok {
+		return nil, errPipelineNotFound
+	}
+
+	taskGraph, err := parseTaskGraph(entry.TaskGraphStr)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []SourceSchedule{}
+	execute.WalkTaskGraph(taskGraph, func(path string, task *execute.OpTask) {
+		if len(task.Schedule) != 0 {
+			schedules = append(schedules, SourceSchedule{Source: path, Schedule: execute.DescribeSchedule(task.Schedule)})
+		}
+	})
+
+	sqlStmt := fmt.Sprintf(`SELECT last_read, next_read FROM %s WHERE job_id = ? AND source = ?`, schedulesTable)
+	for idx := range schedules {
+		var lastStr, nextStr string
+		err := c.db.QueryRow(sqlStmt, id, schedules[idx].Source).Scan(&lastStr, &nextStr)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		schedules[idx].LastRead = parseScheduleTime(lastStr)
+		schedules[idx].NextRead = parseScheduleTime(nextStr)
+	}
+
+	return schedules, nil
+}
+
+// deleteSchedules deletes the recorded reads of the sources of the job with id
+func (c *ControlDB) deleteSchedules(id int) error {
+	_, err := c.db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE job_id = ?`, schedulesTable), id)
+	return err
+}
@@ -0,0 +1,47 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/schema"
+	"go.uber.org/zap"
+)
+
+// schemaCmd represents the schema command
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Display the argument schemas of all sources, transforms, and sinks as JSON",
+	Long: `Display the argument schemas of all sources, transforms, and sinks as JSON. The Python Vaero builder reads
+this file from vaero/schema.json to validate pipelines before they are added. Regenerate it from the registered
+components with:
+go generate ./execute`,
+	Args: cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		out, err := schema.JSON()
+
+		if err != nil {
+			log.Logger.Fatal("Could not generate schema", zap.String("Error", err.Error()))
+		}
+
+		fmt.Println(string(out))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// schemaCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// schemaCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
@@ -4,16 +4,34 @@ Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
 package cmd
 
 import (
+	"strconv"
+
 	"github.com/spf13/cobra"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
 )
 
 // startCmd represents the start command
 var startCmd = &cobra.Command{
-	Use:   "start",
-	Short: "Start the log shipper",
-	Long:  `Start the log shipper. All pipelines that are currently staged are run. Pipelines that are added after the start command are automatically run.`,
-	Args:  cobra.ExactArgs(0),
+	Use:   "start [pipeline ID]",
+	Short: "Start the log shipper, or restart a stopped pipeline",
+	Long: `Start the log shipper. All pipelines that are currently staged are run. Pipelines that are added after the start command are automatically run.
+The start command also serves the management API, which the other commands use when the log shipper is running.
+
+If a pipeline ID is given, the stopped pipeline is staged again so that the running log shipper restarts it.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 {
+			id, err := strconv.Atoi(args[0])
+
+			if err != nil {
+				log.Logger.Fatal("Argument must be an integer", zap.String("Error", err.Error()))
+			}
+
+			c.ResumeHandler(id)
+			return
+		}
+
 		c.StartHandler()
 	},
 }
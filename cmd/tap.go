@@ -0,0 +1,49 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+var tapNode string
+var tapSample float64
+var tapLimit int
+
+// tapCmd represents the tap command
+var tapCmd = &cobra.Command{
+	Use:   "tap [pipeline ID]",
+	Short: "Stream the events flowing through a running pipeline",
+	Long: `Stream the events leaving a source, transform, or sink of a running pipeline. Vaero must be running with the
+start command. Select the node by its location in the task graph, as shown by the graph command. By default,
+events leaving the source are shown. Sink events are shown when they are flushed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+
+		if err != nil {
+			log.Logger.Fatal("Argument must be an integer", zap.String("Error", err.Error()))
+		}
+
+		c.TapHandler(id, tapNode, tapSample, tapLimit)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tapCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// tapCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	tapCmd.Flags().StringVarP(&tapNode, "node", "n", "0", "Location of the node in the task graph, such as 2.1.0")
+	tapCmd.Flags().Float64VarP(&tapSample, "sample", "s", 1, "Fraction of events to show, greater than 0 and at most 1")
+	tapCmd.Flags().IntVarP(&tapLimit, "limit", "l", 0, "Stop after this many events (0 for no limit)")
+}
@@ -0,0 +1,39 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var testGoldenDir string
+var testUpdate bool
+
+// testCmd represents the test command
+var testCmd = &cobra.Command{
+	Use:   "test [file path] [input file]",
+	Short: "Test a pipeline file against sample input and golden output",
+	Long: `Test a pipeline file by running the events in the input file through its transforms and routes. Sinks are
+replaced by in-memory captures, and the events that reach each sink are compared to a golden file for that sink.
+The input file contains a json array of events or one json event per line. Golden files are named by the location
+of the sink in the task graph, such as 2.1.0_stdout.jsonl, and are stored in the directory given by --golden
+(by default, the input file name with a .golden extension). Use --update to write the golden files.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		c.TestHandler(args[0], args[1], testGoldenDir, testUpdate)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// testCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	testCmd.Flags().StringVarP(&testGoldenDir, "golden", "g", "", "Directory of golden files")
+	testCmd.Flags().BoolVarP(&testUpdate, "update", "u", false, "Write the captured output to the golden files")
+}
@@ -0,0 +1,47 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// updateCmd represents the update command
+var updateCmd = &cobra.Command{
+	Use:   "update [pipeline ID] [pipeline spec]",
+	Short: "Replace the specified pipeline with a new pipeline specification",
+	Long: `Replace the task graph of the specified pipeline with the one generated by a pipeline specification file.
+A running pipeline switches to the new task graph without stopping: if its source is unchanged, the source keeps
+running and sends events to the new transforms and sinks, while the old sinks flush their buffers. A pipeline whose
+source changed is restarted. Each update is recorded as a version, which the rollback command can return to. Find
+pipeline IDs using the list command.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id, err := strconv.Atoi(args[0])
+
+		if err != nil {
+			log.Logger.Fatal("Argument must be an integer", zap.String("Error", err.Error()))
+		}
+
+		c.UpdateHandler(id, args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+
+	// Here you will define your flags and configuration settings.
+
+	// Cobra supports Persistent Flags which will work for this command
+	// and all subcommands, e.g.:
+	// updateCmd.PersistentFlags().String("foo", "", "A help for foo")
+
+	// Cobra supports local flags which will only run when this command
+	// is called directly, e.g.:
+	// updateCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+}
@@ -5,12 +5,18 @@ package cmd
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/tabwriter"
 	"time"
 
@@ -22,6 +28,7 @@ import (
 	"github.com/vaerohq/vaero/execute"
 	"github.com/vaerohq/vaero/log"
 	"github.com/vaerohq/vaero/settings"
+	"github.com/vaerohq/vaero/spec"
 	"go.uber.org/zap"
 )
 
@@ -36,6 +43,9 @@ var c ControlDB
 
 var executor execute.Executor
 
+// lastAdminPoll is the time in unix nanoseconds that the admin routine last polled the jobs table
+var lastAdminPoll int64
+
 type PipelineEntry struct {
 	Id           int
 	Interval     int
@@ -65,10 +75,26 @@ func InitSettings() {
 
 	// Set defaults
 	settings.Config = settings.GlobalConfig{
+		AWSRegion:               "",
+		ApiAddress:              "127.0.0.1:8090",
+		ApiToken:                "",
+		ApiTokenFile:            "./data/api_token",
+		ControlSocket:           "./data/vaero.sock",
 		DefaultChanBufferLen:    1000,
 		LogLevel:                "Info",
+		PluginDir:               "./plugins",
 		PollPipelineChangesFreq: 1,
 		PythonPath:              "",
+		PythonTimeout:           600,
+		SecretCacheSeconds:      300,
+		SecretTimeoutSeconds:    10,
+		SinkFailureThreshold:    300,
+		VaultAddress:            "",
+		VaultAuthMount:          "approle",
+		VaultNamespace:          "",
+		VaultRoleId:             "",
+		VaultSecretId:           "",
+		VaultToken:              "",
 	}
 
 	// Read config file into global settings
@@ -78,6 +104,8 @@ func InitSettings() {
 
 	// Set log level
 	switch strings.ToLower(settings.Config.LogLevel) {
+	case "debug":
+		log.LogLevel.SetLevel(zap.DebugLevel)
 	case "error":
 		log.LogLevel.SetLevel(zap.ErrorLevel)
 	case "info":
@@ -87,39 +115,48 @@ func InitSettings() {
 	}
 }
 
-// CheckPython checks if Python3 is installed
-func CheckPython() {
+// pythonOnce checks for Python only once per process, and only when a Python spec is run
+var pythonOnce sync.Once
+var pythonErr error
 
-	// Run python
-	cmd := exec.Command("python", "-V")
+// checkPython checks if Python3 and the required Python packages are installed
+func checkPython() error {
+	pythonOnce.Do(func() {
+		// Run python
+		cmd := exec.Command("python", "-V")
 
-	// Activate virtual environment if selected
-	if settings.Config.PythonPath != "" {
-		cmd.Path = filepath.Join(settings.Config.PythonPath, "python")
-	}
+		// Activate virtual environment if selected
+		if settings.Config.PythonPath != "" {
+			cmd.Path = filepath.Join(settings.Config.PythonPath, "python")
+		}
 
-	// Run command
-	output, err := cmd.Output()
+		// Run command
+		output, err := cmd.Output()
 
-	if err != nil {
-		log.Logger.Fatal("Python not found. Python 3.X must be installed and accessible from command 'python'.")
-	}
+		if err != nil {
+			pythonErr = errors.New("Python not found. Python 3.X must be installed and accessible from command 'python' to run Python pipeline specifications")
+			return
+		}
 
-	// Check for Python 3
-	r := regexp.MustCompile(`Python 3\..*`)
+		// Check for Python 3
+		r := regexp.MustCompile(`Python 3\..*`)
 
-	if !r.MatchString(string(output)) {
-		log.Logger.Fatal("Python 3.X must be installed and accessible from command 'python'", zap.String("Version", string(output)))
-	}
+		if !r.MatchString(string(output)) {
+			pythonErr = fmt.Errorf("Python 3.X must be installed and accessible from command 'python', found %s", strings.TrimSpace(string(output)))
+			return
+		}
+
+		log.Logger.Info("Python found", zap.String("Version", string(output)))
 
-	log.Logger.Info("Python found", zap.String("Version", string(output)))
+		// Check for all required Python packages
+		pythonErr = checkPythonPackage("tomli")
+	})
 
-	// Check for all required Python packages
-	CheckPythonPackage("tomli")
+	return pythonErr
 }
 
-// CheckPythonPackage checks if the specified Python package is installed
-func CheckPythonPackage(pkg string) bool {
+// checkPythonPackage checks if the specified Python package is installed
+func checkPythonPackage(pkg string) error {
 	// Run python
 	importString := fmt.Sprintf("import %s", pkg)
 	cmd := exec.Command("python", "-c", importString)
@@ -130,15 +167,11 @@ func CheckPythonPackage(pkg string) bool {
 	}
 
 	// Run command
-	_, err := cmd.Output()
-
-	if err != nil {
-		errorString := fmt.Sprintf("Required Python package %s not found. Please install with pip install.", pkg)
-		log.Logger.Fatal(errorString, zap.String("Package", pkg))
-		return false
+	if _, err := cmd.Output(); err != nil {
+		return fmt.Errorf("required Python package %s not found, please install it with pip install", pkg)
 	}
 
-	return true
+	return nil
 }
 
 // InitTables creates Vaero's DB tables if they do not exist
@@ -174,18 +207,308 @@ func (c *ControlDB) InitTables() {
 	if err != nil {
 		log.Logger.Fatal("Create table failed", zap.String("Error", err.Error()))
 	}
+
+	c.migrateTables()
 }
 
 // AddHandler adds the job as staged
 func (c *ControlDB) AddHandler(specName string) {
 
+	taskGraphStr := runSpec(specName)
+
+	// Validate the task graph to catch invalid args before the pipeline is staged
+	if _, err := checkTaskGraph(taskGraphStr); err != nil {
+		reportTaskGraphErrors(specName, err)
+	}
+
+	var entry PipelineEntry
+	var err error
+	if client := newAPIClient(); client != nil {
+		entry, err = client.addPipeline(taskGraphStr, specName, specVersionInfo(specName))
+	} else {
+		entry, err = c.addPipeline(taskGraphStr, specName, specVersionInfo(specName))
+	}
+
+	if err != nil {
+		reportTaskGraphErrors(specName, err)
+	}
+
+	// output
+	fmt.Printf("Added pipeline from %s \n", specName)
+	printPipelineEntriesTable([]PipelineEntry{entry})
+}
+
+// ValidateHandler runs the pipeline specification file and validates its task graph without staging the pipeline
+func (c *ControlDB) ValidateHandler(specName string) {
+
+	taskGraphStr := runSpec(specName)
+
+	if _, err := checkTaskGraph(taskGraphStr); err != nil {
+		reportTaskGraphErrors(specName, err)
+	}
+
+	fmt.Printf("Pipeline specification %s is valid\n", specName)
+}
+
+// GraphHandler renders the task graph of a pipeline in the specified format (tree, dot, or mermaid). The target is
+// either the ID of a pipeline that has been added or the path of a pipeline specification file.
+func (c *ControlDB) GraphHandler(target string, format string) {
+	var render func([]execute.OpTask) string
+
+	switch strings.ToLower(format) {
+	case "tree":
+		render = execute.RenderTree
+	case "dot":
+		render = execute.RenderDOT
+	case "mermaid":
+		render = execute.RenderMermaid
+	default:
+		log.Logger.Fatal("Unknown graph format. Use tree, dot, or mermaid.", zap.String("Format", format))
+	}
+
+	var taskGraphStr string
+	if id, err := strconv.Atoi(target); err == nil {
+		var entry PipelineEntry
+		if client := newAPIClient(); client != nil {
+			entry, err = client.getPipeline(id)
+		} else {
+			var ok bool
+			if entry, ok = c.selectFromJobsDB(id); !ok {
+				err = errPipelineNotFound
+			}
+		}
+
+		if errors.Is(err, errPipelineNotFound) {
+			fmt.Printf("Pipeline %d not found\n", id)
+			return
+		} else if err != nil {
+			log.Logger.Fatal("Could not get pipeline", zap.Int("Id", id), zap.String("Error", err.Error()))
+		}
+		taskGraphStr = entry.TaskGraphStr
+	} else {
+		taskGraphStr = runSpec(target)
+	}
+
+	taskGraph, err := parseTaskGraph(taskGraphStr)
+	if err != nil {
+		reportTaskGraphErrors(target, err)
+	}
+
+	fmt.Print(render(taskGraph))
+}
+
+// TestHandler runs the events in inputFile through the transforms and routes of the pipeline specification, capturing
+// the events that reach each sink instead of sending them. The captured events are compared to golden files in
+// goldenDir, one file per sink, and a diff is displayed for each mismatch. If update is true, the golden files are
+// written instead. Exits with a non-zero status if any sink does not match.
+func (c *ControlDB) TestHandler(specName string, inputFile string, goldenDir string, update bool) {
+
+	taskGraphStr := runSpec(specName)
+
+	taskGraph, err := genTaskGraph(taskGraphStr)
+	if err != nil {
+		reportTaskGraphErrors(specName, err)
+	}
+
+	eventList, err := readEventsFile(inputFile)
+	if err != nil {
+		log.Logger.Fatal("Could not read input file", zap.String("Filename", inputFile), zap.String("Error", err.Error()))
+	}
+
+	if goldenDir == "" {
+		goldenDir = strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + ".golden"
+	}
+
+	captured := execute.RunTransforms(eventList, taskGraph)
+
+	// Compare the output of each sink to its golden file
+	failed := 0
+	expectedFiles := map[string]bool{}
+	execute.WalkTaskGraph(taskGraph, func(path string, task *execute.OpTask) {
+		if task.Type != "sink" {
+			return
+		}
+
+		goldenFile := filepath.Join(goldenDir, fmt.Sprintf("%s_%s.jsonl", path, task.Op))
+		expectedFiles[filepath.Base(goldenFile)] = true
+		actual := captured[task.Id]
+
+		if update {
+			if err := os.MkdirAll(goldenDir, 0755); err != nil {
+				log.Logger.Fatal("Could not create golden directory", zap.String("Error", err.Error()))
+			}
+			if err := os.WriteFile(goldenFile, []byte(joinLines(actual)), 0644); err != nil {
+				log.Logger.Fatal("Could not write golden file", zap.String("Filename", goldenFile), zap.String("Error", err.Error()))
+			}
+			fmt.Printf("UPDATED %s (%d events)\n", goldenFile, len(actual))
+			return
+		}
+
+		content, err := os.ReadFile(goldenFile)
+		if err != nil {
+			fmt.Printf("FAIL %s %s: could not read golden file: %s\n", path, task.Op, err.Error())
+			failed++
+			return
+		}
+
+		expected := splitLines(string(content))
+		if diff := lineDiff(expected, actual); diff != "" {
+			fmt.Printf("FAIL %s %s: output does not match %s\n%s", path, task.Op, goldenFile, diff)
+			failed++
+			return
+		}
+
+		fmt.Printf("ok   %s %s (%d events)\n", path, task.Op, len(actual))
+	})
+
+	// Golden files left over from sinks that no longer exist are reported, so they are not silently ignored
+	if entries, err := os.ReadDir(goldenDir); err == nil && !update {
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".jsonl") && !expectedFiles[entry.Name()] {
+				fmt.Printf("FAIL %s: golden file does not match any sink\n", filepath.Join(goldenDir, entry.Name()))
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d sink(s) failed. Run with --update to accept the new output.\n", failed)
+		log.SyncLogger()
+		os.Exit(1)
+	}
+}
+
+// readEventsFile reads events from a file containing either a json array of events or one json event per line
+func readEventsFile(fileName string) ([]string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := strings.TrimSpace(string(content))
+	eventList := []string{}
+
+	if strings.HasPrefix(trimmed, "[") {
+		if !gjson.Valid(trimmed) {
+			return nil, errors.New("input is not a valid json array")
+		}
+		gjson.Parse(trimmed).ForEach(func(_, value gjson.Result) bool {
+			eventList = append(eventList, value.Raw)
+			return true
+		})
+		return eventList, nil
+	}
+
+	for idx, line := range splitLines(trimmed) {
+		if !gjson.Valid(line) {
+			return nil, fmt.Errorf("line %d is not valid json", idx+1)
+		}
+		eventList = append(eventList, line)
+	}
+
+	return eventList, nil
+}
+
+// splitLines splits text into lines, dropping empty lines
+func splitLines(text string) []string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// joinLines joins lines with a trailing newline
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// lineDiff returns a diff of the expected and actual lines, with removed lines prefixed by - and added lines by +.
+// It returns an empty string if the lines are equal.
+func lineDiff(expected []string, actual []string) string {
+	// Longest common subsequence table
+	lcs := make([][]int, len(expected)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(actual)+1)
+	}
+	for i := len(expected) - 1; i >= 0; i-- {
+		for j := len(actual) - 1; j >= 0; j-- {
+			if expected[i] == actual[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var b strings.Builder
+	changed := false
+	i, j := 0, 0
+	for i < len(expected) || j < len(actual) {
+		switch {
+		case i < len(expected) && j < len(actual) && expected[i] == actual[j]:
+			fmt.Fprintf(&b, "    %s\n", expected[i])
+			i++
+			j++
+		case i < len(expected) && (j == len(actual) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&b, "  - %s\n", expected[i])
+			changed = true
+			i++
+		default:
+			fmt.Fprintf(&b, "  + %s\n", actual[j])
+			changed = true
+			j++
+		}
+	}
+
+	if !changed {
+		return ""
+	}
+	return b.String()
+}
+
+// runSpec runs the pipeline specification file and returns the task graph json that it generates. It exits if the
+// specification cannot be run.
+func runSpec(specName string) string {
+	taskGraphStr, err := runSpecFile(specName)
+
+	if err != nil {
+		// Show the Python error output, which includes any problems found by the Vaero builder
+		if exitErr, ok := errors.Unwrap(err).(*exec.ExitError); ok {
+			os.Stderr.Write(exitErr.Stderr)
+		}
+		log.Logger.Fatal("Could not run pipeline specification", zap.String("Filename", specName), zap.String("Error", err.Error()))
+	}
+
+	return taskGraphStr
+}
+
+// runSpecFile runs the pipeline specification file and returns the task graph json that it generates. YAML, TOML,
+// and JSON specs are read natively; any other spec is run as a Python module.
+func runSpecFile(specName string) (string, error) {
+
 	// Check if spec file exists
 	if _, err := os.Stat(specName); err != nil {
 		if os.IsNotExist(err) {
-			log.Logger.Fatal("File not found", zap.String("Filename", specName))
-		} else {
-			log.Logger.Fatal("Could not open file", zap.String("Error", err.Error()))
+			return "", fmt.Errorf("file not found: %w", err)
 		}
+		return "", fmt.Errorf("could not open file: %w", err)
+	}
+
+	if spec.IsDeclarative(specName) {
+		return spec.Build(specName)
+	}
+
+	if err := checkPython(); err != nil {
+		return "", err
 	}
 
 	// Run the Python spec file and read stdout
@@ -205,35 +528,30 @@ func (c *ControlDB) AddHandler(specName string) {
 	output, err := cmd.Output()
 
 	if err != nil {
-		log.Logger.Fatal("Could not run Python pipeline specification", zap.String("Error", err.Error()))
+		return "", fmt.Errorf("could not run Python pipeline specification: %w", err)
 	}
-	taskGraphStr := string(output)
-
-	// Add to pipelines database
-	sqlStmt := fmt.Sprintf(`
-		INSERT INTO %s (interval, task_graph, spec, status, alive)
-		values(?, ?, ?, ?, ?)
-		`, jobsTable)
 
-	stmt, err := c.db.Prepare(sqlStmt)
-	defer stmt.Close()
+	return string(output), nil
+}
 
-	// Read interval
-	interval := gjson.Get(taskGraphStr, "0.args.interval").Int() // Interval is stored in the first task, which is the source
-	status := "staged"
-	alive := 1
+// reportTaskGraphErrors prints every problem found in a task graph, then exits
+func reportTaskGraphErrors(specName string, err error) {
+	fmt.Fprintf(os.Stderr, "Invalid pipeline specification %s:\n", specName)
 
-	_, err = stmt.Exec(interval, taskGraphStr, specName, status, alive)
-	if err != nil {
-		log.Logger.Fatal("Could not add pipeline to database", zap.String("Error", err.Error()))
+	var apiErr *apiError
+	if errs, ok := err.(execute.ValidationErrors); ok {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "  %s\n", e.Error())
+		}
+	} else if errors.As(err, &apiErr) && len(apiErr.Details) > 0 {
+		for _, detail := range apiErr.Details {
+			fmt.Fprintf(os.Stderr, "  %s\n", detail)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "  %s\n", err.Error())
 	}
 
-	// output
-	fmt.Printf("Added pipeline from %s \n", specName)
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
-	fmt.Fprintf(w, "Interval\tTask Graph\tFile\tStatus\tAlive\n")
-	fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\n", interval, taskGraphStr, specName, status, alive)
-	w.Flush()
+	log.Logger.Fatal("Invalid pipeline specification", zap.String("Filename", specName))
 }
 
 // convertToModuleName converts a file path to be usable with python -m flag
@@ -253,74 +571,399 @@ func convertToModuleName(specName string) string {
 // DeleteHandler deletes the job with id. If not found, do nothing.
 func (c *ControlDB) DeleteHandler(id int) {
 
-	entry, ok := c.selectFromJobsDB(id)
+	var err error
+	if client := newAPIClient(); client != nil {
+		err = client.deletePipeline(id)
+	} else {
+		err = c.deletePipeline(id)
+	}
 
-	if !ok {
+	if errors.Is(err, errPipelineNotFound) {
 		fmt.Printf("Pipeline %d not found\n", id)
 		return
+	} else if err != nil {
+		log.Logger.Fatal("Could not delete pipeline", zap.Int("Id", id), zap.String("Error", err.Error()))
 	}
 
-	// Stop a pipeline if running, otherwise it will be orphaned when deleted
-	if entry.Status == "running" {
-		c.StopHandler(id)
+	// output
+	fmt.Printf("Deleted pipeline %d\n", id)
+}
+
+// DetailHandler displays the details of the job with id. If not found, it displays a not found message.
+func (c *ControlDB) DetailHandler(id int) {
+
+	var entry PipelineEntry
+	var err error
+	if client := newAPIClient(); client != nil {
+		entry, err = client.getPipeline(id)
+	} else {
+		var ok bool
+		if entry, ok = c.selectFromJobsDB(id); !ok {
+			err = errPipelineNotFound
+		}
 	}
 
-	for {
-		entry, ok = c.selectFromJobsDB(id)
+	if errors.Is(err, errPipelineNotFound) {
+		fmt.Printf("Pipeline %d not found\n", id)
+		return
+	} else if err != nil {
+		log.Logger.Fatal("Could not get pipeline", zap.Int("Id", id), zap.String("Error", err.Error()))
+	}
 
-		// Check if stopped
-		if entry.Status == "stopped" || entry.Status == "staged" {
+	singleEntryArr := []PipelineEntry{entry}
 
-			// Delete
-			sqlStmt := fmt.Sprintf(`
-			DELETE FROM %s WHERE id = ?
-			`, jobsTable)
+	printPipelineEntriesTable(singleEntryArr)
 
-			stmt, err := c.db.Prepare(sqlStmt)
-			defer stmt.Close()
+	// Show recent failures, with the stack trace of the latest
+	var failures []PipelineFailure
+	if client := newAPIClient(); client != nil {
+		failures, err = client.getFailures(id, detailFailures)
+	} else {
+		failures, err = c.selectFailures(id, detailFailures)
+	}
 
-			_, err = stmt.Exec(id)
-			if err != nil {
-				log.Logger.Error("Could not delete pipeline from database", zap.String("Error", err.Error()))
-			}
+	if err != nil {
+		log.Logger.Error("Could not get pipeline failures", zap.Int("Id", id), zap.String("Error", err.Error()))
+	} else if len(failures) > 0 {
+		printFailures(failures)
+	}
+
+	// Show the schedules of sources that read on a schedule
+	var schedules []SourceSchedule
+	if client := newAPIClient(); client != nil {
+		schedules, err = client.getSchedules(id)
+	} else {
+		schedules, err = c.selectSchedules(id)
+	}
 
-			// output
-			fmt.Printf("Deleted pipeline %d\n", id)
-			break
-		} else if !ok { // Error if job is not found
-			break
+	if err != nil {
+		log.Logger.Error("Could not get pipeline schedules", zap.Int("Id", id), zap.String("Error", err.Error()))
+	} else if len(schedules) > 0 {
+		printSchedules(schedules)
+	}
+}
+
+// printSchedules prints the schedules of the sources of a pipeline, with their last and next reads
+func printSchedules(schedules []SourceSchedule) {
+	fmt.Printf("\nSchedules:\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintf(w, "Source\tSchedule\tLast Read\tNext Read\n")
+	for _, s := range schedules {
+		lastRead, nextRead := "never", "none"
+		if s.LastRead != nil {
+			lastRead = s.LastRead.Local().Format(time.RFC3339)
 		}
+		if s.NextRead != nil {
+			nextRead = s.NextRead.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Source, s.Schedule, lastRead, nextRead)
+	}
+	w.Flush()
+}
 
-		time.Sleep(time.Second)
+// detailFailures is the number of recent failures shown by the detail command
+const detailFailures = 5
+
+// printFailures prints pipeline failures, newest first, with the stack trace of the newest
+func printFailures(failures []PipelineFailure) {
+	fmt.Printf("\nRecent failures:\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintf(w, "Time\tNode\tRestarts\tRestarting\tError\n")
+	for _, failure := range failures {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%t\t%s\n", failure.Time.Format(time.RFC3339), failure.Node, failure.Restarts,
+			failure.Restarting, failure.Error)
+	}
+	w.Flush()
+
+	if failures[0].Stack != "" {
+		fmt.Printf("\nStack trace of the latest failure:\n%s", failures[0].Stack)
 	}
 }
 
-// DetailHandler displays the details of the job with id. If not found, it displays a not found message.
-func (c *ControlDB) DetailHandler(id int) {
+// ListHandler lists all jobs
+func (c *ControlDB) ListHandler() {
 
-	entry, ok := c.selectFromJobsDB(id)
+	var pipelineEntries []PipelineEntry
+	if client := newAPIClient(); client != nil {
+		var err error
+		if pipelineEntries, err = client.listPipelines(); err != nil {
+			log.Logger.Fatal("Could not list pipelines", zap.String("Error", err.Error()))
+		}
+	} else {
+		pipelineEntries = c.selectAllFromJobsDB()
+	}
 
-	if !ok {
+	printPipelineEntriesTable(pipelineEntries)
+}
+
+// UpdateHandler replaces the task graph of the job with id with the one generated by the specification file. A
+// running job switches to the new task graph without stopping.
+func (c *ControlDB) UpdateHandler(id int, specName string) {
+
+	taskGraphStr := runSpec(specName)
+
+	// Validate the task graph to catch invalid args before the pipeline is changed
+	if _, err := checkTaskGraph(taskGraphStr); err != nil {
+		reportTaskGraphErrors(specName, err)
+	}
+
+	var entry PipelineEntry
+	var err error
+	if client := newAPIClient(); client != nil {
+		entry, err = client.updatePipeline(id, taskGraphStr, specName, specVersionInfo(specName))
+	} else {
+		entry, err = c.updatePipeline(id, taskGraphStr, specName, specVersionInfo(specName))
+	}
+
+	if errors.Is(err, errPipelineNotFound) {
 		fmt.Printf("Pipeline %d not found\n", id)
 		return
+	} else if err != nil {
+		reportTaskGraphErrors(specName, err)
 	}
 
-	singleEntryArr := []PipelineEntry{entry}
+	// output
+	fmt.Printf("Updated pipeline %d from %s \n", id, specName)
+	printPipelineEntriesTable([]PipelineEntry{entry})
+}
 
-	printPipelineEntriesTable(singleEntryArr)
+// RollbackHandler replaces the task graph of the job with id with the task graph of an earlier version. Version 0
+// rolls back to the version before the latest.
+func (c *ControlDB) RollbackHandler(id int, version int) {
+
+	var entry PipelineEntry
+	var v PipelineVersion
+	var err error
+	if client := newAPIClient(); client != nil {
+		entry, v, err = client.rollbackPipeline(id, version, currentAuthor())
+	} else {
+		entry, v, err = c.rollbackPipeline(id, version, currentAuthor())
+	}
+
+	if errors.Is(err, errPipelineNotFound) {
+		fmt.Printf("Pipeline %d not found\n", id)
+		return
+	} else if err != nil {
+		log.Logger.Fatal("Could not roll back pipeline", zap.Int("Id", id), zap.String("Error", err.Error()))
+	}
+
+	// output
+	fmt.Printf("Rolled back pipeline %d to version %d from %s\n", id, v.Version, v.Time.Format(time.RFC3339))
+	printPipelineEntriesTable([]PipelineEntry{entry})
 }
 
-// ListHandler lists all jobs
-func (c *ControlDB) ListHandler() {
+// CheckpointShowHandler lists the sources of the job with id, with the cursors they resume from
+func (c *ControlDB) CheckpointShowHandler(id int) {
 
-	pipelineEntries := c.selectAllFromJobsDB()
+	var checkpoints []SourceCheckpoint
+	var err error
+	if client := newAPIClient(); client != nil {
+		checkpoints, err = client.getCheckpoints(id)
+	} else {
+		checkpoints, err = c.selectCheckpoints(id)
+	}
 
-	printPipelineEntriesTable(pipelineEntries)
+	if errors.Is(err, errPipelineNotFound) {
+		fmt.Printf("Pipeline %d not found\n", id)
+		return
+	} else if err != nil {
+		log.Logger.Fatal("Could not get pipeline checkpoints", zap.Int("Id", id), zap.String("Error", err.Error()))
+	}
+
+	printCheckpoints(checkpoints)
+}
+
+// CheckpointResetHandler sets the cursor of the source at path of the stopped job with id, or deletes it if cursor
+// is empty so that the source reads from the start. An empty path deletes the cursors of every source.
+func (c *ControlDB) CheckpointResetHandler(id int, path string, cursor string) {
+	if cursor != "" && !json.Valid([]byte(cursor)) {
+		log.Logger.Fatal("Cursor must be json", zap.String("Cursor", cursor))
+	}
+
+	var checkpoints []SourceCheckpoint
+	var err error
+	if client := newAPIClient(); client != nil {
+		checkpoints, err = client.resetCheckpoints(id, path, cursor)
+	} else {
+		checkpoints, err = c.resetCheckpoints(id, path, cursor)
+	}
+
+	if errors.Is(err, errPipelineNotFound) {
+		fmt.Printf("Pipeline %d not found\n", id)
+		return
+	} else if err != nil {
+		log.Logger.Fatal("Could not reset pipeline checkpoints", zap.Int("Id", id), zap.String("Error", err.Error()))
+	}
+
+	// output
+	fmt.Printf("Reset checkpoints of pipeline %d\n", id)
+	printCheckpoints(checkpoints)
+}
+
+// printCheckpoints prints the sources of a pipeline, with the cursors they resume from
+func printCheckpoints(checkpoints []SourceCheckpoint) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintf(w, "Source\tOp\tCommitted\tCursor\n")
+	for _, checkpoint := range checkpoints {
+		committed, cursor := "never", "start"
+		if checkpoint.Time != nil {
+			committed = checkpoint.Time.Local().Format(time.RFC3339)
+		}
+		if len(checkpoint.Cursor) > 0 {
+			cursor = string(checkpoint.Cursor)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", checkpoint.Source, checkpoint.Op, committed, cursor)
+	}
+	w.Flush()
+}
+
+// HistoryHandler lists the versions of the job with id, with a summary of the changes in each
+func (c *ControlDB) HistoryHandler(id int) {
+
+	var versions []PipelineVersion
+	var err error
+	if client := newAPIClient(); client != nil {
+		versions, err = client.listVersions(id)
+	} else {
+		versions, err = c.selectVersions(id)
+	}
+
+	if errors.Is(err, errPipelineNotFound) {
+		fmt.Printf("Pipeline %d not found\n", id)
+		return
+	} else if err != nil {
+		log.Logger.Fatal("Could not get pipeline history", zap.Int("Id", id), zap.String("Error", err.Error()))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintf(w, "Version\tTime\tAuthor\tFile\tChanges\n")
+	for idx, v := range versions {
+		changes := "created"
+		if idx > 0 {
+			changes = summarizeChanges(versions[idx-1].TaskGraphStr, v.TaskGraphStr)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", v.Version, v.Time.Format(time.RFC3339), v.Author, v.Spec, changes)
+	}
+	w.Flush()
+}
+
+// summarizeChanges counts the nodes added, removed, and changed between two task graphs
+func summarizeChanges(oldStr string, newStr string) string {
+	changes, err := diffTaskGraphs(oldStr, newStr)
+	if err != nil {
+		return "invalid task graph"
+	} else if len(changes) == 0 {
+		return "no changes"
+	}
+
+	counts := map[string]int{}
+	for _, change := range changes {
+		counts[change.Kind]++
+	}
+
+	return fmt.Sprintf("%d added, %d removed, %d changed", counts["+"], counts["-"], counts["~"])
+}
+
+// DiffHandler shows a structural diff of the task graphs of two versions of the job with id. If showSpec is set, it
+// also shows a line diff of the spec sources.
+func (c *ControlDB) DiffHandler(id int, v1 int, v2 int, showSpec bool) {
+
+	getVersion := c.selectVersion
+	if client := newAPIClient(); client != nil {
+		getVersion = client.getVersion
+	} else if _, ok := c.selectFromJobsDB(id); !ok {
+		fmt.Printf("Pipeline %d not found\n", id)
+		return
+	}
+
+	versions := make([]PipelineVersion, 2)
+	for idx, version := range []int{v1, v2} {
+		v, err := getVersion(id, version)
+		if errors.Is(err, errPipelineNotFound) {
+			fmt.Printf("Pipeline %d not found\n", id)
+			return
+		} else if err != nil {
+			log.Logger.Fatal("Could not get pipeline version", zap.Int("Id", id), zap.Int("Version", version),
+				zap.String("Error", err.Error()))
+		}
+		versions[idx] = v
+	}
+
+	changes, err := diffTaskGraphs(versions[0].TaskGraphStr, versions[1].TaskGraphStr)
+	if err != nil {
+		log.Logger.Fatal("Could not compare task graphs", zap.Int("Id", id), zap.String("Error", err.Error()))
+	}
+
+	for _, v := range versions {
+		fmt.Printf("Version %d: %s by %s from %s\n", v.Version, v.Time.Format(time.RFC3339), v.Author, v.Spec)
+	}
+	fmt.Println()
+
+	if len(changes) == 0 {
+		fmt.Println("Task graphs are identical")
+	}
+	for _, change := range changes {
+		if change.OldPath != "" {
+			fmt.Printf("%s %s %s (was %s)\n", change.Kind, change.Path, change.Node, change.OldPath)
+		} else {
+			fmt.Printf("%s %s %s\n", change.Kind, change.Path, change.Node)
+		}
+		for _, detail := range change.Details {
+			fmt.Printf("    %s\n", detail)
+		}
+	}
+
+	if showSpec {
+		fmt.Printf("\nSpec source:\n")
+		if versions[0].SpecSource == "" || versions[1].SpecSource == "" {
+			fmt.Println("Spec source was not recorded for both versions")
+			return
+		}
+		for _, line := range diffLines(versions[0].SpecSource, versions[1].SpecSource) {
+			fmt.Println(line)
+		}
+	}
+}
+
+// ResumeHandler stages the stopped job with id, so that it is started again. If not found, do nothing.
+func (c *ControlDB) ResumeHandler(id int) {
+
+	var err error
+	if client := newAPIClient(); client != nil {
+		_, err = client.startPipeline(id)
+	} else {
+		_, err = c.startPipeline(id)
+	}
+
+	if errors.Is(err, errPipelineNotFound) {
+		fmt.Printf("Pipeline %d not found\n", id)
+		return
+	} else if err != nil {
+		log.Logger.Fatal("Could not start pipeline", zap.Int("Id", id), zap.String("Error", err.Error()))
+	}
+
+	// output
+	fmt.Printf("Staged pipeline %d\n", id)
 }
 
 // StartHandler starts all jobs that are staged
 func (c *ControlDB) StartHandler() {
 
+	// Listen for local control commands, such as tap
+	go serveControl()
+
+	// Record pipeline failures, and mark pipelines failed when their restart policy gives up
+	executor.FailureHandler = c.recordFailure
+
+	// Record the reads of sources with a schedule, so that reads missed while Vaero was down can be caught up
+	executor.ScheduleStore = c
+
+	// Store the cursors of sources, so that restarted pipelines resume where they left off
+	executor.CheckpointStore = c
+
+	// Serve the management API
+	go serveAPI(c)
+
 	// Run admin routine to poll jobs table and update running pipelines
 	go adminRoutine(c)
 
@@ -331,37 +974,59 @@ func (c *ControlDB) StartHandler() {
 	<-exit
 }
 
-// StopHandler stops the job with id by setting alive to 0. If not found, do nothing.
-func (c *ControlDB) StopHandler(id int) {
-	// Check if job exists
-	_, ok := c.selectFromJobsDB(id)
+// TapHandler prints the events leaving the node at nodePath of the running pipeline with id, as they flow. It
+// connects to the control socket of the running start command.
+func (c *ControlDB) TapHandler(id int, nodePath string, sample float64, limit int) {
+	conn, reader, err := dialControl(controlRequest{Command: "tap", Pipeline: id, Node: nodePath, Sample: sample, Limit: limit})
+	if err != nil {
+		log.Logger.Fatal("Could not tap pipeline", zap.Int("Id", id), zap.String("Node", nodePath), zap.String("Error", err.Error()))
+	}
+	defer conn.Close()
 
-	if !ok {
-		fmt.Printf("Pipeline %d not found\n", id)
-		return
+	dec := json.NewDecoder(reader)
+	for {
+		var event string
+		if err := dec.Decode(&event); err != nil {
+			if err != io.EOF {
+				log.Logger.Error("Tap ended", zap.String("Error", err.Error()))
+			}
+			return
+		}
+
+		fmt.Println(event)
 	}
+}
 
-	// Stop job
-	sqlStmt := fmt.Sprintf(`
-		UPDATE %s SET status = ?, alive = ? WHERE id = ?
-		`, jobsTable)
+// StopHandler stops the job with id by setting alive to 0. If not found, do nothing.
+func (c *ControlDB) StopHandler(id int) {
 
-	stmt, err := c.db.Prepare(sqlStmt)
-	defer stmt.Close()
+	var err error
+	if client := newAPIClient(); client != nil {
+		_, err = client.stopPipeline(id)
+	} else {
+		_, err = c.stopPipeline(id)
+	}
 
-	_, err = stmt.Exec("stopping", 0, id)
-	if err != nil {
-		log.Logger.Fatal("Update row failed", zap.String("Error", err.Error()))
+	if errors.Is(err, errPipelineNotFound) {
+		fmt.Printf("Pipeline %d not found\n", id)
+		return
+	} else if err != nil {
+		log.Logger.Fatal("Could not stop pipeline", zap.Int("Id", id), zap.String("Error", err.Error()))
 	}
 
 	// output
 	fmt.Printf("Stopping pipeline %d\n", id)
 }
 
-// adminRoutine is a long running goroutine that regularly checks the jobs table for new, stopped, or deleted jobs
-// and applies those changes
+// adminRoutine is a long running goroutine that regularly checks the jobs table for new, stopped, updated, or
+// deleted jobs and applies those changes
 func adminRoutine(c *ControlDB) {
+	// The task graph json that each job was started or last updated with, to detect updates
+	runningTaskGraphs := map[int]string{}
+
 	for {
+		atomic.StoreInt64(&lastAdminPoll, time.Now().UnixNano())
+
 		entries := c.selectAllFromJobsDB()
 
 		for _, entry := range entries {
@@ -377,17 +1042,44 @@ func adminRoutine(c *ControlDB) {
 					)
 				*/
 				fmt.Printf("Starting pipeline %d %d %s %s %s %d\n", entry.Id,
-					entry.Interval, entry.TaskGraphStr, entry.Spec, entry.Status, entry.Alive)
+					entry.Interval, execute.RedactTaskGraph(entry.TaskGraphStr), entry.Spec, entry.Status, entry.Alive)
+
+				taskGraph, err := genTaskGraph(entry.TaskGraphStr)
 
-				taskGraph := genTaskGraph(entry.TaskGraphStr)
+				if err != nil {
+					log.Logger.Error("Invalid pipeline specification", zap.Int("Id", entry.Id), zap.String("Error", err.Error()))
+					c.updateJobStatus(entry.Id, "stopped")
+					continue
+				}
 
 				// Initiate run here
 				executor.RunJob(entry.Id, entry.Interval, taskGraph)
+				runningTaskGraphs[entry.Id] = entry.TaskGraphStr
 
 				c.updateJobStatus(entry.Id, "running")
+			} else if entry.Status == "running" {
+				runningStr, ok := runningTaskGraphs[entry.Id]
+				if !ok || runningStr == entry.TaskGraphStr {
+					continue
+				}
+				runningTaskGraphs[entry.Id] = entry.TaskGraphStr
+
+				taskGraph, err := genTaskGraph(entry.TaskGraphStr)
+				if err != nil {
+					log.Logger.Error("Invalid pipeline specification", zap.Int("Id", entry.Id), zap.String("Error", err.Error()))
+					continue
+				}
+
+				// Switch the running job to the updated task graph
+				if err := executor.UpdateJob(entry.Id, taskGraph); err != nil {
+					log.Logger.Error("Could not update pipeline", zap.Int("Id", entry.Id), zap.String("Error", err.Error()))
+					continue
+				}
+				fmt.Printf("Updated pipeline %d\n", entry.Id)
 			} else if entry.Status == "stopping" {
 				// Stop job here
 				executor.StopJob(entry.Id)
+				delete(runningTaskGraphs, entry.Id)
 
 				c.updateJobStatus(entry.Id, "stopped")
 				fmt.Printf("Stopped pipeline %d\n", entry.Id)
@@ -404,7 +1096,8 @@ func printPipelineEntriesTable(entries []PipelineEntry) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
 	fmt.Fprintf(w, "Id\tInterval\tTask Graph\tFile\tStatus\tAlive\n")
 	for _, entry := range entries {
-		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%d\n", entry.Id, entry.Interval, entry.TaskGraphStr, entry.Spec, entry.Status, entry.Alive)
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%d\n", entry.Id, entry.Interval, execute.RedactTaskGraph(entry.TaskGraphStr),
+			entry.Spec, entry.Status, entry.Alive)
 	}
 	w.Flush()
 }
@@ -504,33 +1197,418 @@ func (c *ControlDB) updateJobStatus(id int, status string) {
 	}
 }
 
-// genTaskGraph generates a task graph of OpTasks from a taskGraphStr
-func genTaskGraph(taskGraphStr string) []execute.OpTask {
-	jsonGraph := gjson.Parse(taskGraphStr).Value().([]interface{})
+// errPipelineNotFound is returned when no pipeline has the requested id
+var errPipelineNotFound = errors.New("pipeline not found")
 
-	taskGraph := genTaskGraphHelper(jsonGraph)
+// errPipelineStopping is returned when a pipeline cannot be changed until it has finished stopping
+var errPipelineStopping = errors.New("pipeline is stopping. Try again once it has stopped")
+
+// errInvalidTaskGraph is returned when a task graph is not a json array of tasks
+var errInvalidTaskGraph = errors.New("invalid task graph")
+
+// addPipeline validates the task graph and adds it to the jobs table as staged. It is recorded as the first version
+// of the job, with info.
+func (c *ControlDB) addPipeline(taskGraphStr string, specName string, info versionInfo) (PipelineEntry, error) {
+	taskGraph, err := checkTaskGraph(taskGraphStr)
+	if err != nil {
+		return PipelineEntry{}, err
+	}
+
+	// Add to pipelines database
+	sqlStmt := fmt.Sprintf(`
+		INSERT INTO %s (interval, task_graph, spec, status, alive)
+		values(?, ?, ?, ?, ?)
+		`, jobsTable)
+
+	stmt, err := c.db.Prepare(sqlStmt)
+	if err != nil {
+		return PipelineEntry{}, err
+	}
+	defer stmt.Close()
+
+	entry := PipelineEntry{
+		Interval:     taskGraph[0].IntArg("interval"), // Interval is stored in the first task, which is the source
+		TaskGraphStr: taskGraphStr,
+		Spec:         specName,
+		Status:       "staged",
+		Alive:        1,
+	}
+
+	result, err := stmt.Exec(entry.Interval, entry.TaskGraphStr, entry.Spec, entry.Status, entry.Alive)
+	if err != nil {
+		return PipelineEntry{}, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return PipelineEntry{}, err
+	}
+	entry.Id = int(id)
+
+	if err := c.recordVersion(entry.Id, taskGraphStr, specName, info); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// stopPipeline marks the job with id as stopping, so that the admin routine stops it. A staged job that has not
+// started yet is stopped immediately.
+func (c *ControlDB) stopPipeline(id int) (PipelineEntry, error) {
+	entry, ok := c.selectFromJobsDB(id)
+	if !ok {
+		return entry, errPipelineNotFound
+	}
+
+	switch entry.Status {
+	case "stopped", "stopping":
+		return entry, nil
+	case "staged", "failed":
+		entry.Status = "stopped"
+	default:
+		entry.Status = "stopping"
+	}
+	entry.Alive = 0
+
+	sqlStmt := fmt.Sprintf(`
+		UPDATE %s SET status = ?, alive = ? WHERE id = ?
+		`, jobsTable)
+
+	if _, err := c.db.Exec(sqlStmt, entry.Status, entry.Alive, id); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// startPipeline stages the stopped job with id, so that the admin routine starts it again
+func (c *ControlDB) startPipeline(id int) (PipelineEntry, error) {
+	entry, ok := c.selectFromJobsDB(id)
+	if !ok {
+		return entry, errPipelineNotFound
+	}
+
+	switch entry.Status {
+	case "staged", "running":
+		return entry, nil
+	case "stopping":
+		return entry, errPipelineStopping
+	}
+
+	entry.Status = "staged"
+	entry.Alive = 1
+
+	sqlStmt := fmt.Sprintf(`
+		UPDATE %s SET status = ?, alive = ? WHERE id = ?
+		`, jobsTable)
+
+	if _, err := c.db.Exec(sqlStmt, entry.Status, entry.Alive, id); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// deletePipeline deletes the job with id, stopping it first if it is running
+func (c *ControlDB) deletePipeline(id int) error {
+	// Stop a pipeline if running, otherwise it will be orphaned when deleted
+	if _, err := c.stopPipeline(id); err != nil {
+		return err
+	}
+
+	if err := c.waitForStopped(id); err != nil {
+		return err
+	}
+
+	sqlStmt := fmt.Sprintf(`
+		DELETE FROM %s WHERE id = ?
+		`, jobsTable)
+
+	if _, err := c.db.Exec(sqlStmt, id); err != nil {
+		return err
+	}
+
+	if err := c.deleteSchedules(id); err != nil {
+		return err
+	}
+
+	if err := c.deleteCheckpoints(id, ""); err != nil {
+		return err
+	}
+
+	return c.deleteVersions(id)
+}
+
+// updatePipeline validates the new task graph, replaces the task graph of the job with id, and records it as a new
+// version. The admin routine switches a running job to the new task graph without stopping it. A stopping job is
+// staged again once it has stopped, and a failed job is staged.
+func (c *ControlDB) updatePipeline(id int, taskGraphStr string, specName string, info versionInfo) (PipelineEntry, error) {
+	taskGraph, err := checkTaskGraph(taskGraphStr)
+	if err != nil {
+		return PipelineEntry{}, err
+	}
+
+	entry, ok := c.selectFromJobsDB(id)
+	if !ok {
+		return entry, errPipelineNotFound
+	}
+
+	if entry.Status == "stopping" {
+		if err := c.waitForStopped(id); err != nil {
+			return entry, err
+		}
+		entry.Status = "staged"
+		entry.Alive = 1
+	} else if entry.Status == "failed" {
+		entry.Status = "staged"
+		entry.Alive = 1
+	}
+
+	entry.Interval = taskGraph[0].IntArg("interval")
+	entry.TaskGraphStr = taskGraphStr
+	entry.Spec = specName
+
+	sqlStmt := fmt.Sprintf(`
+		UPDATE %s SET interval = ?, task_graph = ?, spec = ?, status = ?, alive = ? WHERE id = ?
+		`, jobsTable)
+
+	if _, err := c.db.Exec(sqlStmt, entry.Interval, entry.TaskGraphStr, entry.Spec, entry.Status, entry.Alive, id); err != nil {
+		return entry, err
+	}
+
+	if err := c.recordVersion(id, taskGraphStr, specName, info); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// stopWaitTimeout is how long to wait for the admin routine to stop a job. It allows for the admin routine to see
+// the stop, and for the pipeline to take its full stop timeout to finish.
+const stopWaitTimeout = 2 * time.Minute
+
+// waitForStopped waits until the admin routine has stopped the job with id. It returns errPipelineStopping if the job
+// has not stopped within stopWaitTimeout.
+func (c *ControlDB) waitForStopped(id int) error {
+	deadline := time.Now().Add(stopWaitTimeout)
+
+	for {
+		entry, ok := c.selectFromJobsDB(id)
+
+		if !ok { // Error if job is not found
+			return errPipelineNotFound
+		} else if entry.Status == "stopped" || entry.Status == "staged" {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("pipeline did not stop within %s: %w", stopWaitTimeout, errPipelineStopping)
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// PipelineFailure is a failure of a pipeline recorded in the failures table
+type PipelineFailure struct {
+	Id         int       `json:"id"`
+	JobId      int       `json:"pipeline"`
+	Time       time.Time `json:"time"`
+	Node       string    `json:"node"`
+	Error      string    `json:"error"`
+	Stack      string    `json:"stack,omitempty"`
+	Restarts   int       `json:"restarts"`
+	Restarting bool      `json:"restarting"`
+}
+
+// recordFailure adds a pipeline failure to the failures table. If the pipeline will not be restarted, it is marked
+// failed.
+func (c *ControlDB) recordFailure(failure execute.PipelineFailure) {
+	sqlStmt := fmt.Sprintf(`
+		INSERT INTO %s (job_id, time, node, error, stack, restarts, restarting)
+		values(?, ?, ?, ?, ?, ?, ?)
+		`, failuresTable)
+
+	restarting := 0
+	if failure.Restarting {
+		restarting = 1
+	}
+
+	_, err := c.db.Exec(sqlStmt, failure.PipelineId, failure.Time.Format(time.RFC3339Nano), failure.Node, failure.Error,
+		failure.Stack, failure.Restarts, restarting)
+	if err != nil {
+		log.Logger.Error("Could not record pipeline failure", zap.Int("Id", failure.PipelineId), zap.String("Error", err.Error()))
+	}
+
+	if failure.Restarting {
+		return
+	}
+
+	// Only a running pipeline is marked failed. A pipeline that is being stopped is left for the admin routine.
+	sqlStmt = fmt.Sprintf(`
+		UPDATE %s SET status = "failed", alive = 0 WHERE id = ? AND status = "running"
+		`, jobsTable)
+
+	if _, err := c.db.Exec(sqlStmt, failure.PipelineId); err != nil {
+		log.Logger.Error("Could not mark pipeline failed", zap.Int("Id", failure.PipelineId), zap.String("Error", err.Error()))
+	}
+
+	fmt.Printf("Pipeline %d failed\n", failure.PipelineId)
+}
+
+// selectFailures returns the most recent failures of the job with id, newest first
+func (c *ControlDB) selectFailures(id int, limit int) ([]PipelineFailure, error) {
+	sqlStmt := fmt.Sprintf(`
+		SELECT id, job_id, time, node, error, stack, restarts, restarting FROM %s WHERE job_id = ? ORDER BY id DESC LIMIT ?
+		`, failuresTable)
+
+	rows, err := c.db.Query(sqlStmt, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []PipelineFailure{}
+	for rows.Next() {
+		var failure PipelineFailure
+		var timeStr string
+		var restarting int
+
+		if err := rows.Scan(&failure.Id, &failure.JobId, &timeStr, &failure.Node, &failure.Error, &failure.Stack,
+			&failure.Restarts, &restarting); err != nil {
+			return nil, err
+		}
+
+		failure.Time, _ = time.Parse(time.RFC3339Nano, timeStr)
+		failure.Restarting = restarting == 1
+		failures = append(failures, failure)
+	}
+
+	return failures, rows.Err()
+}
+
+// genTaskGraph generates a task graph of OpTasks from a taskGraphStr. The task graph is validated against the op
+// schemas and its transforms are compiled, so it is ready to run. Every problem found is returned in the error. The
+// transforms must be closed with execute.CloseTransforms once the task graph is no longer used, so it is only for
+// task graphs that are run. Use checkTaskGraph or parseTaskGraph otherwise.
+func genTaskGraph(taskGraphStr string) ([]execute.OpTask, error) {
+	return loadTaskGraph(taskGraphStr, execute.CompileTaskGraph)
+}
+
+// checkTaskGraph generates and validates a task graph like genTaskGraph, and checks that its transforms compile,
+// without keeping the compiled transforms
+func checkTaskGraph(taskGraphStr string) ([]execute.OpTask, error) {
+	return loadTaskGraph(taskGraphStr, execute.CheckTaskGraph)
+}
+
+// parseTaskGraph generates and validates a task graph without compiling its transforms, for when only the structure
+// of the task graph is needed
+func parseTaskGraph(taskGraphStr string) ([]execute.OpTask, error) {
+	return loadTaskGraph(taskGraphStr, nil)
+}
+
+// loadTaskGraph generates a task graph of OpTasks from a taskGraphStr, validates it against the op schemas, and
+// compiles its transforms with compile, if it is not nil. Every problem found is returned in the error.
+func loadTaskGraph(taskGraphStr string, compile func([]execute.OpTask) error) ([]execute.OpTask, error) {
+	if !gjson.Valid(taskGraphStr) {
+		return nil, fmt.Errorf("%w: not valid json", errInvalidTaskGraph)
+	}
+
+	jsonGraph, ok := gjson.Parse(taskGraphStr).Value().([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: must be a json array", errInvalidTaskGraph)
+	}
+
+	var errs execute.ValidationErrors
+	taskGraph := genTaskGraphHelper(jsonGraph, "", &errs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// Compile even if validation fails, so that all problems are reported at once. Compile errors for tasks that
+	// already failed validation are dropped, because they only repeat the validation error.
+	if err := execute.ValidateTaskGraph(taskGraph); err != nil {
+		errs = err.(execute.ValidationErrors)
+	}
+
+	if compile != nil {
+		if err := compile(taskGraph); err != nil {
+			invalid := map[string]bool{}
+			for _, e := range errs {
+				invalid[e.Path] = true
+			}
+			for _, e := range err.(execute.ValidationErrors) {
+				if !invalid[e.Path] {
+					errs = append(errs, e)
+				}
+			}
+		} else if len(errs) > 0 {
+			execute.CloseTransforms(taskGraph) // compiled, but not returned
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
 	//fmt.Printf("Task graph %v", taskGraph)
 
-	return taskGraph
+	return taskGraph, nil
 }
 
-func genTaskGraphHelper(jsonGraph []interface{}) []execute.OpTask {
+func genTaskGraphHelper(jsonGraph []interface{}, prefix string, errs *execute.ValidationErrors) []execute.OpTask {
 	taskGraph := []execute.OpTask{}
-	for _, v := range jsonGraph {
+	for idx, v := range jsonGraph {
+		path := strconv.Itoa(idx)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
 		switch tk := v.(type) {
 		case map[string]interface{}: // handle regular ops
 			var op execute.OpTask
-			mapstructure.Decode(tk, &op)
+			if err := mapstructure.Decode(tk, &op); err != nil {
+				*errs = append(*errs, execute.ValidationError{Path: path, Msg: err.Error()})
+				continue
+			}
 			op.Id = uuid.New()
+
+			// A union has a list of inputs, each of which is a list of tasks
+			if inputs, ok := tk["inputs"]; ok {
+				inputList, ok := inputs.([]interface{})
+				if !ok {
+					*errs = append(*errs, execute.ValidationError{Path: path, Msg: "inputs must be a json array"})
+					continue
+				}
+
+				op.Inputs = make([][]execute.OpTask, 0, len(inputList))
+				for inputIdx, sub := range inputList {
+					inputPath := path + "." + strconv.Itoa(inputIdx)
+					subGraph, ok := sub.([]interface{})
+					if !ok {
+						*errs = append(*errs, execute.ValidationError{Path: inputPath, Msg: "each input of a union must be a json array"})
+						continue
+					}
+					op.Inputs = append(op.Inputs, genTaskGraphHelper(subGraph, inputPath, errs))
+				}
+			}
+
 			taskGraph = append(taskGraph, op)
 		case []interface{}: // handle arrays, which represent branching
 			var op execute.OpTask = execute.OpTask{Type: "branch", Branches: make([][]execute.OpTask, 0), Id: uuid.New()}
 
-			for _, sub := range tk {
-				op.Branches = append(op.Branches, genTaskGraphHelper(sub.([]interface{})))
+			for branchIdx, sub := range tk {
+				branchPath := path + "." + strconv.Itoa(branchIdx)
+				subGraph, ok := sub.([]interface{})
+				if !ok {
+					*errs = append(*errs, execute.ValidationError{Path: branchPath, Msg: "each route of a branch must be a json array"})
+					continue
+				}
+				op.Branches = append(op.Branches, genTaskGraphHelper(subGraph, branchPath, errs))
 			}
 			taskGraph = append(taskGraph, op)
+		default:
+			*errs = append(*errs, execute.ValidationError{Path: path, Msg: "task must be a json object or array"})
 		}
 	}
 
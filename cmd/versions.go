@@ -0,0 +1,155 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+// errVersionNotFound is returned when a pipeline has no version with the requested number
+var errVersionNotFound = errors.New("pipeline version not found")
+
+// PipelineVersion is a task graph of a pipeline, recorded whenever the pipeline is added, updated, or rolled back
+type PipelineVersion struct {
+	JobId        int
+	Version      int
+	TaskGraphStr string
+	Spec         string
+	SpecSource   string // contents of the spec file when the version was submitted
+	Author       string
+	Time         time.Time
+}
+
+// versionInfo describes who submitted a task graph, and the spec it was generated from
+type versionInfo struct {
+	SpecSource string
+	Author     string
+}
+
+// specVersionInfo returns the version info for a spec file submitted by the current user
+func specVersionInfo(specName string) versionInfo {
+	source, _ := os.ReadFile(specName) // already run to generate the task graph, so a read error is unlikely
+
+	return versionInfo{SpecSource: string(source), Author: currentAuthor()}
+}
+
+// currentAuthor returns the author recorded for changes made by this process, which is the VAERO_AUTHOR environment
+// variable if set, and otherwise user@host of the current user
+func currentAuthor() string {
+	if author := os.Getenv("VAERO_AUTHOR"); author != "" {
+		return author
+	}
+
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+
+	return name
+}
+
+// recordVersion adds the task graph to the versions of the job with id, as the next version number
+func (c *ControlDB) recordVersion(id int, taskGraphStr string, specName string, info versionInfo) error {
+	sqlStmt := fmt.Sprintf(`
+		INSERT INTO %[1]s (job_id, version, task_graph, spec, spec_source, author, time)
+			SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ?, ? FROM %[1]s WHERE job_id = ?
+		`, versionsTable)
+
+	_, err := c.db.Exec(sqlStmt, id, taskGraphStr, specName, info.SpecSource, info.Author,
+		time.Now().UTC().Format(time.RFC3339Nano), id)
+	return err
+}
+
+// selectVersion returns the version of the job with id. Version 0 selects the version before the latest.
+func (c *ControlDB) selectVersion(id int, version int) (PipelineVersion, error) {
+	if version == 0 {
+		sqlStmt := fmt.Sprintf(`SELECT COALESCE(MAX(version), 0) - 1 FROM %s WHERE job_id = ?`, versionsTable)
+		if err := c.db.QueryRow(sqlStmt, id).Scan(&version); err != nil {
+			return PipelineVersion{}, err
+		}
+	}
+
+	sqlStmt := fmt.Sprintf(`
+		SELECT job_id, version, task_graph, spec, spec_source, author, time FROM %s WHERE job_id = ? AND version = ?
+		`, versionsTable)
+
+	v, err := scanVersion(c.db.QueryRow(sqlStmt, id, version))
+	if err == sql.ErrNoRows {
+		return v, fmt.Errorf("%w: pipeline %d has no version %d", errVersionNotFound, id, version)
+	}
+
+	return v, err
+}
+
+// selectVersions returns every version of the job with id, oldest first
+func (c *ControlDB) selectVersions(id int) ([]PipelineVersion, error) {
+	if _, ok := c.selectFromJobsDB(id); !ok {
+		return nil, errPipelineNotFound
+	}
+
+	sqlStmt := fmt.Sprintf(`
+		SELECT job_id, version, task_graph, spec, spec_source, author, time FROM %s WHERE job_id = ? ORDER BY version
+		`, versionsTable)
+
+	rows, err := c.db.Query(sqlStmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []PipelineVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
+}
+
+// scanVersion scans a row of the versions table
+func scanVersion(row interface{ Scan(...interface{}) error }) (PipelineVersion, error) {
+	var v PipelineVersion
+	var timeStr string
+
+	if err := row.Scan(&v.JobId, &v.Version, &v.TaskGraphStr, &v.Spec, &v.SpecSource, &v.Author, &timeStr); err != nil {
+		return v, err
+	}
+	v.Time, _ = time.Parse(time.RFC3339Nano, timeStr)
+
+	return v, nil
+}
+
+// deleteVersions deletes the versions of the job with id
+func (c *ControlDB) deleteVersions(id int) error {
+	_, err := c.db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE job_id = ?`, versionsTable), id)
+	return err
+}
+
+// rollbackPipeline replaces the task graph of the job with id with the task graph of an earlier version. Version 0
+// rolls back to the version before the latest. The rollback is recorded as a new version by author.
+func (c *ControlDB) rollbackPipeline(id int, version int, author string) (PipelineEntry, PipelineVersion, error) {
+	if _, ok := c.selectFromJobsDB(id); !ok {
+		return PipelineEntry{}, PipelineVersion{}, errPipelineNotFound
+	}
+
+	v, err := c.selectVersion(id, version)
+	if err != nil {
+		return PipelineEntry{}, v, err
+	}
+
+	entry, err := c.updatePipeline(id, v.TaskGraphStr, v.Spec, versionInfo{SpecSource: v.SpecSource, Author: author})
+	return entry, v, err
+}
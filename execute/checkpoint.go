@@ -0,0 +1,72 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"github.com/vaerohq/vaero/integrations/sources"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// CheckpointStore stores the cursors of sources that keep track of what they have read, so that a restarted
+// pipeline resumes where it left off
+type CheckpointStore interface {
+	// LoadCheckpoint returns the cursor committed by the source at path of the pipeline with id. A cursor committed
+	// by a different op is not returned, as its format is not understood by the source.
+	LoadCheckpoint(id int, path string, op string) (string, bool)
+	// CommitCheckpoint stores the cursor of the source at path of the pipeline with id
+	CommitCheckpoint(id int, path string, op string, cursor string)
+}
+
+// checkpointer loads and commits the cursor of a source that implements sources.Checkpointer
+type checkpointer struct {
+	store  CheckpointStore
+	id     int
+	path   string // path of the source in the task graph
+	op     string
+	cursor string // last cursor loaded or committed
+}
+
+// newCheckpointer loads the committed cursor of the source at path of the pipeline with id into source. It returns
+// nil if the source keeps no cursor or there is no store.
+func newCheckpointer(source sources.Source, store CheckpointStore, id int, path string, op string) *checkpointer {
+	if _, ok := source.(sources.Checkpointer); !ok || store == nil {
+		return nil
+	}
+
+	c := &checkpointer{store: store, id: id, path: path, op: op}
+
+	if cursor, ok := store.LoadCheckpoint(id, path, op); ok {
+		log.Logger.Info("Resuming source from checkpoint", zap.Int("Id", id), zap.String("Source", path),
+			zap.String("Cursor", cursor))
+		c.cursor = cursor
+	}
+	c.restore(source)
+
+	return c
+}
+
+// restore sets the last cursor on source, such as on a source that was recreated to apply new secrets
+func (c *checkpointer) restore(source sources.Source) {
+	if s, ok := source.(sources.Checkpointer); ok {
+		s.SetCheckpoint(c.cursor)
+	}
+}
+
+// commit stores the cursor of source if it has moved. It is called once the events of a read have been sent on to
+// the pipeline.
+func (c *checkpointer) commit(source sources.Source) {
+	s, ok := source.(sources.Checkpointer)
+	if !ok {
+		return
+	}
+
+	cursor := s.Checkpoint()
+	if cursor == "" || cursor == c.cursor {
+		return
+	}
+
+	c.store.CommitCheckpoint(c.id, c.path, c.op, cursor)
+	c.cursor = cursor
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/vaerohq/vaero/transform"
+)
+
+// CompileTaskGraph prepares every transform in the task graph, so that regular expressions are compiled, paths are
+// checked, and args are validated once per task instead of once per event. The task graph must already have been
+// checked by ValidateTaskGraph. If any transform cannot be prepared, the prepared transforms are closed, and it
+// returns ValidationErrors listing all of them. The transforms of a compiled task graph must be closed with
+// CloseTransforms once it is no longer used.
+func CompileTaskGraph(taskGraph []OpTask) error {
+	var errs ValidationErrors
+
+	compileTaskGraphHelper(taskGraph, "", &errs, true)
+
+	if len(errs) > 0 {
+		CloseTransforms(taskGraph)
+		return errs
+	}
+	return nil
+}
+
+// CheckTaskGraph checks that every transform in the task graph can be prepared, like CompileTaskGraph, but closes
+// each prepared transform right away, so that a task graph that is only validated or displayed holds no resources
+// such as WebAssembly runtimes or Python workers. Secrets are not looked up, so transforms whose args reference
+// secrets are only checked by ValidateTaskGraph, and prepared when the task graph is compiled to run.
+func CheckTaskGraph(taskGraph []OpTask) error {
+	var errs ValidationErrors
+
+	compileTaskGraphHelper(taskGraph, "", &errs, false)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// compileTaskGraphHelper prepares the transforms of the task graph, and keeps them in the tasks if keep is true, or
+// closes them otherwise
+func compileTaskGraphHelper(taskGraph []OpTask, prefix string, errs *ValidationErrors, keep bool) {
+	for idx := range taskGraph {
+		task := &taskGraph[idx]
+		path := taskPath(prefix, idx)
+
+		switch task.Type {
+		case "tn":
+			if !keep && len(secretRefArgs(task.Args)) != 0 {
+				continue
+			}
+			tn, err := compileTransform(task)
+			if err != nil {
+				*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Msg: err.Error()})
+				continue
+			}
+			if keep {
+				task.Transform = tn
+			} else if closer, ok := tn.(transform.Closer); ok {
+				closer.Close()
+			}
+		case "branch":
+			for branchIdx, branch := range task.Branches {
+				compileTaskGraphHelper(branch, taskPath(path, branchIdx), errs, keep)
+			}
+		case "union":
+			for inputIdx, input := range task.Inputs {
+				compileTaskGraphHelper(input, taskPath(path, inputIdx), errs, keep)
+			}
+		}
+	}
+}
+
+// compileTransform creates the prepared transform for a tn task with the registered transform of its op. Secrets
+// referenced by the args are resolved once, when the transform is compiled to run.
+func compileTransform(task *OpTask) (transform.Transform, error) {
+	p, ok := transformPlugins[task.Op]
+	if !ok {
+		return nil, fmt.Errorf("unknown transform")
+	}
+
+	task, err := withResolvedRefs(task)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.New(task)
+}
+
+// CloseTransforms releases the resources of the transforms in the task graph, such as the worker processes of python
+// transforms
+func CloseTransforms(taskGraph []OpTask) {
+	WalkTaskGraph(taskGraph, func(path string, task *OpTask) {
+		if closer, ok := task.Transform.(transform.Closer); ok {
+			closer.Close()
+		}
+	})
+}
+
+// taskPath returns the location of a task in the task graph json, such as 3.1.0 for the first task of the second
+// branch of the fourth task
+func taskPath(prefix string, idx int) string {
+	if prefix == "" {
+		return strconv.Itoa(idx)
+	}
+
+	return prefix + "." + strconv.Itoa(idx)
+}
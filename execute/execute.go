@@ -4,6 +4,9 @@ Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
 package execute
 
 import (
+	"reflect"
+	"strconv"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -11,59 +14,141 @@ import (
 	"github.com/google/uuid"
 	"github.com/vaerohq/vaero/capsule"
 	"github.com/vaerohq/vaero/integrations/sinks"
+	"github.com/vaerohq/vaero/integrations/sources"
 	"github.com/vaerohq/vaero/log"
 	"github.com/vaerohq/vaero/settings"
 )
 
 type Executor struct {
+	// FailureHandler, if set, is called whenever a pipeline fails, such as when a node panics
+	FailureHandler func(PipelineFailure)
+	// ScheduleStore, if set, records the reads of sources with a schedule, so missed reads can be caught up
+	ScheduleStore ScheduleStore
+	// CheckpointStore, if set, stores the cursors of sources, so restarted pipelines resume where they left off
+	CheckpointStore CheckpointStore
 }
 
 type ControlChannels struct {
-	Done chan int
+	Done    chan int      // receives the signal to stop the pipeline
+	Stopped chan struct{} // closed when the pipeline has stopped
+	update  chan updateRequest
 }
 
 var pipeControls map[int]ControlChannels = map[int]ControlChannels{}
+var pipeControlsMutex sync.Mutex
 
-// StopJob stops a job by sending the done signal
+// runningGraphs stores the task graph of each running job, so other goroutines can look up its nodes
+var runningGraphs map[int][]OpTask = map[int][]OpTask{}
+var runningGraphsMutex sync.Mutex
+
+// StopJob stops a job by sending the done signal, and waits for it to stop
 func (executor *Executor) StopJob(id int) {
 	log.Logger.Info("Stop Job", zap.Int("Id", id))
 
-	pipeControls[id].Done <- 1
+	pipeControlsMutex.Lock()
+	controls, ok := pipeControls[id]
+	delete(pipeControls, id)
+	pipeControlsMutex.Unlock()
+
+	if !ok {
+		log.Logger.Info("Job is not running", zap.Int("Id", id))
+		return
+	}
+
+	controls.Done <- 1
+
+	select {
+	case <-controls.Stopped:
+	case <-time.After(stopTimeout):
+		log.Logger.Error("Timed out waiting for job to stop", zap.Int("Id", id))
+	}
+}
+
+// runningTaskGraph returns the task graph of the running job with id
+func runningTaskGraph(id int) ([]OpTask, bool) {
+	runningGraphsMutex.Lock()
+	defer runningGraphsMutex.Unlock()
+
+	taskGraph, ok := runningGraphs[id]
+	return taskGraph, ok
 }
 
-// RunJob runs a job for the taskGraph. The job runs as a set of forever-running goroutines until stopped.
+// RunJob runs a job for the taskGraph. The job runs as a set of forever-running goroutines until stopped. A
+// supervisor restarts the job if it fails, according to the restart policy of the task graph.
 func (executor *Executor) RunJob(id int, interval int, taskGraph []OpTask) {
 	log.Logger.Info("Run Job", zap.Int("Id", id), zap.Int("Interval", interval))
 
-	var done chan int = make(chan int)
-	var srcOut chan capsule.Capsule = make(chan capsule.Capsule, settings.Config.DefaultChanBufferLen)
-	var tnOut chan capsule.Capsule = make(chan capsule.Capsule, settings.Config.DefaultChanBufferLen)
+	controls := ControlChannels{Done: make(chan int, 1), Stopped: make(chan struct{}), update: make(chan updateRequest)}
+
+	pipeControlsMutex.Lock()
+	pipeControls[id] = controls
+	pipeControlsMutex.Unlock()
 
-	go sourceNode(done, srcOut, taskGraph)
-	go transformNode(srcOut, tnOut, taskGraph)
-	go sinkNode(tnOut, taskGraph)
+	setRunningTaskGraph(id, taskGraph)
 
-	pipeControls[id] = ControlChannels{Done: done}
+	go executor.supervise(id, taskGraph, controls)
 }
 
-func sourceNode(done chan int, srcOut chan capsule.Capsule, taskGraph []OpTask) {
+// cleanUpJob removes the state kept for a job that has stopped
+func cleanUpJob(id int, controls ControlChannels) {
+	runningGraphsMutex.Lock()
+	delete(runningGraphs, id)
+	runningGraphsMutex.Unlock()
+
+	removePipelineTaps(id)
+	channels.unwatch(strconv.Itoa(id))
+	removePipelineHealth(id)
+
+	// Only remove the controls if they still belong to this run of the job
+	pipeControlsMutex.Lock()
+	if current, ok := pipeControls[id]; ok && current.Stopped == controls.Stopped {
+		delete(pipeControls, id)
+	}
+	pipeControlsMutex.Unlock()
+}
+
+// sourceNode runs the source at the start of the taskGraph, which is at path in the task graph of the pipeline
+func sourceNode(run *pipelineRun, srcOut chan capsule.Capsule, taskGraph []OpTask, path string) {
+	defer run.wg.Done()
+	defer run.recoverNode("sourceNode", nil)
+
+	h := run.health
 
 	// check the first task of the task graph to identify the source
 	if len(taskGraph) <= 0 {
 		log.Logger.Error("Task graph is empty")
+		close(srcOut)
+		h.sourceExited("task graph is empty")
 		return
 	}
 
 	if taskGraph[0].Type != "source" {
 		log.Logger.Error("Task graph does not start with a source")
+		close(srcOut)
+		h.sourceExited("task graph does not start with a source")
 		return
 	}
 
 	sourceConfig := initSourceConfig(&taskGraph[0])
+
+	// Resolve the secrets referenced by the args, into a copy of the task so that the task graph keeps the references
+	resolved, err := withResolvedRefs(sourceConfig.SourceTask)
+	if err != nil {
+		log.Logger.Error("Could not resolve secrets of source", zap.String("Source", taskGraph[0].Op),
+			zap.String("Error", err.Error()))
+		close(srcOut)
+		h.sourceExited(err.Error())
+		return
+	}
+	sourceConfig.SourceTask, sourceConfig.LastRefsRefresh = resolved, time.Now()
+
 	source, err := createSource(sourceConfig.SourceTask, srcOut)
 
 	if err != nil {
 		log.Logger.Error("Failed to identify source", zap.String("Error", err.Error()))
+		close(srcOut)
+		h.sourceExited(err.Error())
+		return
 	}
 
 	defer func() {
@@ -73,34 +158,80 @@ func sourceNode(done chan int, srcOut chan capsule.Capsule, taskGraph []OpTask)
 	}()
 
 	if source.Type() == "pull" {
+		var sched *scheduler
+		if sourceConfig.Schedule != nil {
+			sched = newScheduler(sourceConfig.Schedule, run.schedules, run.id, path)
+		}
+
+		checkpoints := newCheckpointer(source, run.checkpoints, run.id, path, sourceConfig.SourceTask.Op)
+
 		// main loop
 		//count := 0 // temp
 		for {
 			select {
-			case _ = <-done:
+			case _ = <-run.done:
 				return
 
 			default:
+				// Wait for the next scheduled read
+				if sched != nil && !sched.wait(run.done) {
+					return
+				}
+
 				// Refresh secrets if needed
+				refreshed := false
 				if len(sourceConfig.SourceTask.Secret) != 0 &&
 					time.Now().Sub(sourceConfig.LastSecretsRefresh) > sourceConfig.SecretsCacheTime {
-					newSecrets, err := getSecrets(sourceConfig.SourceTask.Secret)
+					newSecrets, err := getSecrets(sourceConfig.SourceTask.Secret, sourceConfig.SecretsTimeout)
 					if err != nil {
 						log.Logger.Error("Error refreshing secrets", zap.String("Error", err.Error()))
 					} else {
 						log.Logger.Info("Refreshed secrets")
 						applySecrets(sourceConfig.SourceTask, newSecrets)
-						source = updateSource(source, sourceConfig.SourceTask)
+						refreshed = true
 					}
 					sourceConfig.LastSecretsRefresh = time.Now()
 				}
 
+				// Resolve referenced secrets again once their cached values expire, and take their new values
+				if len(sourceConfig.SourceTask.SecretRefs) != 0 && secretRefsDue(sourceConfig.LastRefsRefresh) {
+					args, err := resolveSecretRefs(sourceConfig.SourceTask.Args, sourceConfig.SourceTask.SecretRefs)
+					if err != nil {
+						log.Logger.Error("Error refreshing secrets", zap.String("Error", err.Error()))
+					} else if !reflect.DeepEqual(args, sourceConfig.SourceTask.Args) {
+						log.Logger.Info("Refreshed secrets")
+						sourceConfig.SourceTask.Args = args
+						refreshed = true
+					}
+					sourceConfig.LastRefsRefresh = time.Now()
+				}
+
+				if refreshed {
+					previous := source
+					source = updateSource(source, sourceConfig.SourceTask)
+					if source != previous {
+						previous.CleanUp() // such as to stop the Python process of the replaced source
+					}
+					if checkpoints != nil {
+						checkpoints.restore(source)
+					}
+				}
+
 				// Read from source
 				sourceConfig.LastExecution = time.Now()
 				capsule := capsule.Capsule{EventList: source.Read()} // read from source, and create capsule
 				srcOut <- capsule                                    // send capsule to transformNode
 				// capsule and eventList unsafe to access after sending
 
+				if checkpoints != nil {
+					checkpoints.commit(source)
+				}
+
+				if sched != nil {
+					sched.read()
+					continue
+				}
+
 				// Delay for interval
 				delta := sourceConfig.Interval - time.Now().Sub(sourceConfig.LastExecution)
 				if delta > 0 {
@@ -124,12 +255,28 @@ func sourceNode(done chan int, srcOut chan capsule.Capsule, taskGraph []OpTask)
 	} else if source.Type() == "push" {
 		source.Read()
 
-		<-done // wait for done signal
+		// wait for done signal, unless the source fails first
+		var failed <-chan error
+		if failer, ok := source.(sources.Failer); ok {
+			failed = failer.Failed()
+		}
+
+		select {
+		case <-run.done:
+		case err := <-failed:
+			log.Logger.Error("Source failed", zap.String("Source", sourceConfig.SourceTask.Op), zap.String("Error", err.Error()))
+			h.sourceExited(err.Error())
+		}
 	}
 }
 
-func transformNode(srcOut chan capsule.Capsule, tnOut chan capsule.Capsule, taskGraph []OpTask) {
+func transformNode(g *graphRun, srcOut chan capsule.Capsule, tnOut chan capsule.Capsule, taskGraph []OpTask) {
+	defer g.nodeDone()
+	defer g.run.recoverNode("transformNode", func() { go drainCapsules(srcOut) })
+
+	m := g.metrics
 	defer func() {
+		CloseTransforms(taskGraph)
 		close(tnOut)
 		log.Logger.Info("Closing transformNode")
 	}()
@@ -143,12 +290,27 @@ func transformNode(srcOut chan capsule.Capsule, tnOut chan capsule.Capsule, task
 			return
 		}
 
+		eventList := event.EventList
+		if taskGraph[0].Type == "union" {
+			eventList = unionProcess(eventList, event.Input, &taskGraph[0], tnOut, m)
+		} else {
+			// Events arriving here are the events leaving the source
+			source := m.node(taskGraph[0].Id)
+			source.in(eventList)
+			source.out(eventList)
+			tapEvents(taskGraph[0].Id, eventList)
+		}
+
 		// Perform transformations
-		transformProcess(event.EventList, taskGraph, tnOut)
+		transformProcess(eventList, taskGraph, tnOut, m)
 	}
 }
 
-func sinkNode(tnOut chan capsule.Capsule, taskGraph []OpTask) {
+func sinkNode(g *graphRun, tnOut chan capsule.Capsule, taskGraph []OpTask) {
+	defer g.nodeDone()
+	defer g.run.recoverNode("sinkNode", func() { go drainCapsules(tnOut) })
+
+	m := g.metrics
 	// sinks map stores all sinks
 	var snks = make(map[uuid.UUID]*sinks.SinkConfig)
 
@@ -156,11 +318,11 @@ func sinkNode(tnOut chan capsule.Capsule, taskGraph []OpTask) {
 	timeChan := make(chan capsule.SinkTimerCapsule, settings.Config.DefaultChanBufferLen)
 
 	defer func() {
-		closeSinks(snks)
+		closeSinks(snks, m)
 		log.Logger.Info("Closing sinkNode")
 	}()
 
-	initSinkNode(snks, taskGraph, timeChan)
+	initSinkNode(snks, taskGraph, timeChan, g)
 
 	// main loop
 	for {
@@ -169,7 +331,7 @@ func sinkNode(tnOut chan capsule.Capsule, taskGraph []OpTask) {
 		// Receive timer event
 		case tc, _ := <-timeChan:
 
-			handleSinkTimer(tc, snks)
+			handleSinkTimer(tc, snks, m)
 
 		// Receive new events from transformNode
 		case event, ok := <-tnOut:
@@ -179,7 +341,7 @@ func sinkNode(tnOut chan capsule.Capsule, taskGraph []OpTask) {
 				return
 			}
 
-			sinkBatch(&event, snks)
+			sinkBatch(&event, snks, m)
 		}
 	}
 }
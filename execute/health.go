@@ -0,0 +1,154 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PipelineHealth describes the health of a running pipeline
+type PipelineHealth struct {
+	Id            int          `json:"id"`
+	StartTime     time.Time    `json:"start_time"`
+	SourceRunning bool         `json:"source_running"`
+	SourceError   string       `json:"source_error,omitempty"` // why the source stopped, if it stopped unexpectedly
+	Restarts      int          `json:"restarts"`               // restarts since the pipeline last ran successfully
+	Sinks         []SinkHealth `json:"sinks"`
+}
+
+// SinkHealth describes the health of a sink of a running pipeline
+type SinkHealth struct {
+	Node         string     `json:"node"`
+	Op           string     `json:"op"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"`
+	FailingSince *time.Time `json:"failing_since,omitempty"` // time of the first failure since the last success
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// pipelineHealth records the health of a running pipeline. A nil *pipelineHealth records nothing.
+type pipelineHealth struct {
+	mutex  sync.Mutex
+	health PipelineHealth
+	sinks  map[uuid.UUID]*SinkHealth
+	order  []uuid.UUID // sink ids in task graph order
+}
+
+var healthMutex sync.Mutex
+var pipelineHealths = map[int]*pipelineHealth{}
+
+// newPipelineHealth starts recording the health of the pipeline with id
+func newPipelineHealth(id int, taskGraph []OpTask) *pipelineHealth {
+	h := &pipelineHealth{
+		health: PipelineHealth{Id: id, StartTime: time.Now(), SourceRunning: true},
+	}
+	h.setSinks(taskGraph)
+
+	healthMutex.Lock()
+	pipelineHealths[id] = h
+	healthMutex.Unlock()
+
+	return h
+}
+
+// removePipelineHealth stops recording the health of the pipeline with id
+func removePipelineHealth(id int) {
+	healthMutex.Lock()
+	delete(pipelineHealths, id)
+	healthMutex.Unlock()
+}
+
+// Health returns the health of the running pipeline with id
+func (executor *Executor) Health(id int) (PipelineHealth, bool) {
+	healthMutex.Lock()
+	h, ok := pipelineHealths[id]
+	healthMutex.Unlock()
+
+	if !ok {
+		return PipelineHealth{}, false
+	}
+
+	return h.snapshot(), true
+}
+
+// snapshot returns a copy of the health that is safe to use after the lock is released
+func (h *pipelineHealth) snapshot() PipelineHealth {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	snapshot := h.health
+	snapshot.Sinks = make([]SinkHealth, len(h.order))
+	for idx, sinkId := range h.order {
+		snapshot.Sinks[idx] = *h.sinks[sinkId]
+	}
+
+	return snapshot
+}
+
+// setSinks records the health of the sinks of the task graph, replacing any sinks of a previous task graph
+func (h *pipelineHealth) setSinks(taskGraph []OpTask) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.sinks = map[uuid.UUID]*SinkHealth{}
+	h.order = nil
+
+	WalkTaskGraph(taskGraph, func(path string, task *OpTask) {
+		if task.Type == "sink" {
+			h.sinks[task.Id] = &SinkHealth{Node: path, Op: task.Op}
+			h.order = append(h.order, task.Id)
+		}
+	})
+}
+
+// restarted records the number of restarts since the pipeline last ran successfully
+func (h *pipelineHealth) restarted(restarts int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.health.Restarts = restarts
+}
+
+// sourceExited records that the source stopped without being asked to
+func (h *pipelineHealth) sourceExited(reason string) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.health.SourceRunning = false
+	h.health.SourceError = reason
+}
+
+// sinkFlushed records the result of writing to the sink with sinkId
+func (h *pipelineHealth) sinkFlushed(sinkId uuid.UUID, err error) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	sink, ok := h.sinks[sinkId]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if err == nil {
+		sink.LastSuccess = &now
+		sink.FailingSince = nil
+		sink.LastError = ""
+		return
+	}
+
+	if sink.FailingSince == nil {
+		sink.FailingSince = &now
+	}
+	sink.LastError = err.Error()
+}
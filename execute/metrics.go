@@ -0,0 +1,328 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vaerohq/vaero/capsule"
+	"github.com/vaerohq/vaero/integrations/sinks"
+)
+
+// Flush triggers, used as the trigger label of vaero_sink_flushes_total
+const (
+	flushMaxBytes = "max_bytes"
+	flushMaxTime  = "max_time"
+	flushShutdown = "shutdown"
+)
+
+// Nodes are labeled by the path of the task in the task graph json, which is how the graph and tap commands
+// identify nodes. Task ids are regenerated every time a pipeline starts, so they would not make stable labels.
+var nodeLabels = []string{"pipeline", "node", "type", "op"}
+
+var (
+	nodeEventsIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vaero", Name: "node_events_in_total", Help: "Events received by a node",
+	}, nodeLabels)
+	nodeEventsOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vaero", Name: "node_events_out_total", Help: "Events sent on by a node",
+	}, nodeLabels)
+	nodeBytesIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vaero", Name: "node_bytes_in_total", Help: "Bytes of events received by a node",
+	}, nodeLabels)
+	nodeBytesOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vaero", Name: "node_bytes_out_total", Help: "Bytes of events sent on by a node",
+	}, nodeLabels)
+	nodeEventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vaero", Name: "node_events_dropped_total", Help: "Events dropped by a transform, such as a filter",
+	}, nodeLabels)
+
+	sinkBufferEvents = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "vaero", Name: "sink_buffer_events", Help: "Events waiting in the buffers of a sink",
+	}, []string{"pipeline", "node"})
+	sinkBufferBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "vaero", Name: "sink_buffer_bytes", Help: "Bytes waiting in the buffers of a sink",
+	}, []string{"pipeline", "node"})
+	sinkBuffers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "vaero", Name: "sink_buffers", Help: "Number of buffers of a sink, one per filename prefix",
+	}, []string{"pipeline", "node"})
+	sinkFlushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vaero", Name: "sink_flushes_total", Help: "Buffers flushed by a sink, by what triggered the flush",
+	}, []string{"pipeline", "node", "trigger"})
+	sinkFlushErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "vaero", Name: "sink_flush_errors_total", Help: "Flushes that failed to write to the sink",
+	}, []string{"pipeline", "node", "op"})
+	sinkFlushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "vaero", Name: "sink_flush_duration_seconds", Help: "Time taken to write a flushed buffer to the sink",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"pipeline", "node", "op"})
+)
+
+var metricsRegistry = prometheus.NewRegistry()
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		nodeEventsIn, nodeEventsOut, nodeBytesIn, nodeBytesOut, nodeEventsDropped,
+		sinkBufferEvents, sinkBufferBytes, sinkBuffers, sinkFlushes, sinkFlushErrors, sinkFlushDuration,
+		channels,
+	)
+}
+
+// MetricsHandler serves the pipeline metrics in the Prometheus exposition format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// pipelineMetrics records the metrics of a running pipeline. A nil *pipelineMetrics records nothing, so nodes run
+// outside a pipeline (e.g., by RunTransforms) need no checks.
+type pipelineMetrics struct {
+	pipeline string
+	nodes    map[uuid.UUID]*nodeMetrics
+}
+
+// nodeMetrics holds the metrics of a node with its labels already applied
+type nodeMetrics struct {
+	path      string
+	eventsIn  prometheus.Counter
+	eventsOut prometheus.Counter
+	bytesIn   prometheus.Counter
+	bytesOut  prometheus.Counter
+	dropped   prometheus.Counter // only used for transforms
+	routes    []*nodeMetrics     // only used for branches, one per route
+}
+
+// newPipelineMetrics creates the metrics of every node in the task graph of the pipeline with id
+func newPipelineMetrics(id int, taskGraph []OpTask) *pipelineMetrics {
+	m := &pipelineMetrics{pipeline: strconv.Itoa(id), nodes: map[uuid.UUID]*nodeMetrics{}}
+
+	m.addNodes(taskGraph, "")
+
+	return m
+}
+
+func (m *pipelineMetrics) addNodes(taskGraph []OpTask, prefix string) {
+	for idx := range taskGraph {
+		task := &taskGraph[idx]
+		path := taskPath(prefix, idx)
+
+		node := m.newNodeMetrics(path, task.Type, task.Op)
+		m.nodes[task.Id] = node
+
+		if task.Type == "branch" {
+			for branchIdx, branch := range task.Branches {
+				routePath := taskPath(path, branchIdx)
+				node.routes = append(node.routes, m.newNodeMetrics(routePath, "route", ""))
+				m.addNodes(branch, routePath)
+			}
+		}
+
+		for inputIdx, input := range task.Inputs {
+			m.addNodes(input, taskPath(path, inputIdx))
+		}
+	}
+}
+
+func (m *pipelineMetrics) newNodeMetrics(path string, nodeType string, op string) *nodeMetrics {
+	labels := prometheus.Labels{"pipeline": m.pipeline, "node": path, "type": nodeType, "op": op}
+
+	node := &nodeMetrics{
+		path:      path,
+		eventsIn:  nodeEventsIn.With(labels),
+		eventsOut: nodeEventsOut.With(labels),
+		bytesIn:   nodeBytesIn.With(labels),
+		bytesOut:  nodeBytesOut.With(labels),
+	}
+
+	// Only transforms drop events
+	if nodeType == "tn" {
+		node.dropped = nodeEventsDropped.With(labels)
+	}
+
+	return node
+}
+
+// node returns the metrics of the node with taskId, or nil if there are none
+func (m *pipelineMetrics) node(taskId uuid.UUID) *nodeMetrics {
+	if m == nil {
+		return nil
+	}
+	return m.nodes[taskId]
+}
+
+// in records the events received by a node
+func (n *nodeMetrics) in(eventList []string) {
+	if n == nil {
+		return
+	}
+	n.eventsIn.Add(float64(len(eventList)))
+	n.bytesIn.Add(float64(eventListBytes(eventList)))
+}
+
+// out records the events sent on by a node
+func (n *nodeMetrics) out(eventList []string) {
+	if n == nil {
+		return
+	}
+	n.eventsOut.Add(float64(len(eventList)))
+	n.bytesOut.Add(float64(eventListBytes(eventList)))
+}
+
+// transformed records the events received and sent on by a transform, and any events that it dropped
+func (n *nodeMetrics) transformed(before int, beforeBytes int, eventList []string) {
+	if n == nil {
+		return
+	}
+	n.eventsIn.Add(float64(before))
+	n.bytesIn.Add(float64(beforeBytes))
+	n.out(eventList)
+	if dropped := before - len(eventList); dropped > 0 && n.dropped != nil {
+		n.dropped.Add(float64(dropped))
+	}
+}
+
+// route returns the metrics of route idx of a branch
+func (n *nodeMetrics) route(idx int) *nodeMetrics {
+	if n == nil || idx >= len(n.routes) {
+		return nil
+	}
+	return n.routes[idx]
+}
+
+// sinkBuffered records the events waiting in the buffers of a sink
+func (m *pipelineMetrics) sinkBuffered(sinkConfig *sinks.SinkConfig) {
+	node := m.node(sinkConfig.Id)
+	if node == nil {
+		return
+	}
+
+	events, size := 0, 0
+	for _, buffer := range sinkConfig.Prefix {
+		events += len(buffer.BufferList)
+		size += buffer.Size
+	}
+
+	sinkBufferEvents.WithLabelValues(m.pipeline, node.path).Set(float64(events))
+	sinkBufferBytes.WithLabelValues(m.pipeline, node.path).Set(float64(size))
+	sinkBuffers.WithLabelValues(m.pipeline, node.path).Set(float64(len(sinkConfig.Prefix)))
+}
+
+// sinkFlushed records a flush of a sink buffer and what triggered it
+func (m *pipelineMetrics) sinkFlushed(sinkConfig *sinks.SinkConfig, trigger string) {
+	if node := m.node(sinkConfig.Id); node != nil {
+		sinkFlushes.WithLabelValues(m.pipeline, node.path, trigger).Inc()
+	}
+}
+
+// sinkWritten records the time taken to write a flushed buffer to a sink, and whether it failed
+func (m *pipelineMetrics) sinkWritten(sinkConfig *sinks.SinkConfig, seconds float64, err error) {
+	node := m.node(sinkConfig.Id)
+	if node == nil {
+		return
+	}
+
+	sinkFlushDuration.WithLabelValues(m.pipeline, node.path, sinkConfig.Type).Observe(seconds)
+	if err != nil {
+		sinkFlushErrors.WithLabelValues(m.pipeline, node.path, sinkConfig.Type).Inc()
+	}
+}
+
+// removeSink deletes the buffer gauges of a sink that has closed, so they do not report stale values
+func (m *pipelineMetrics) removeSink(sinkConfig *sinks.SinkConfig) {
+	node := m.node(sinkConfig.Id)
+	if node == nil {
+		return
+	}
+
+	sinkBufferEvents.DeleteLabelValues(m.pipeline, node.path)
+	sinkBufferBytes.DeleteLabelValues(m.pipeline, node.path)
+	sinkBuffers.DeleteLabelValues(m.pipeline, node.path)
+}
+
+// watchChannel reports the fill level of a channel of the pipeline until unwatchChannels is called
+func (m *pipelineMetrics) watchChannel(name string, node string, ch chan capsule.Capsule) {
+	if m == nil {
+		return
+	}
+	channels.watch(m.pipeline, name, node, ch)
+}
+
+// unwatchChannels stops reporting the channels of the pipeline
+func (m *pipelineMetrics) unwatchChannels() {
+	if m == nil {
+		return
+	}
+	channels.unwatch(m.pipeline)
+}
+
+func eventListBytes(eventList []string) int {
+	size := 0
+	for _, event := range eventList {
+		size += len(event)
+	}
+	return size
+}
+
+// channelCollector reports the length and capacity of the channels between nodes when metrics are scraped, so
+// the nodes do no extra work to report them
+type channelCollector struct {
+	mutex    sync.Mutex
+	watched  map[string][]watchedChannel // keyed by pipeline
+	length   *prometheus.Desc
+	capacity *prometheus.Desc
+}
+
+type watchedChannel struct {
+	name string
+	node string
+	ch   chan capsule.Capsule
+}
+
+var channels = &channelCollector{
+	watched: map[string][]watchedChannel{},
+	length: prometheus.NewDesc("vaero_channel_length", "Capsules waiting in a channel between nodes",
+		[]string{"pipeline", "channel", "node"}, nil),
+	capacity: prometheus.NewDesc("vaero_channel_capacity", "Capacity of a channel between nodes",
+		[]string{"pipeline", "channel", "node"}, nil),
+}
+
+func (cc *channelCollector) watch(pipeline string, name string, node string, ch chan capsule.Capsule) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	cc.watched[pipeline] = append(cc.watched[pipeline], watchedChannel{name: name, node: node, ch: ch})
+}
+
+func (cc *channelCollector) unwatch(pipeline string) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	delete(cc.watched, pipeline)
+}
+
+// Describe implements prometheus.Collector
+func (cc *channelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.length
+	ch <- cc.capacity
+}
+
+// Collect implements prometheus.Collector
+func (cc *channelCollector) Collect(ch chan<- prometheus.Metric) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+
+	for pipeline, list := range cc.watched {
+		for _, w := range list {
+			ch <- prometheus.MustNewConstMetric(cc.length, prometheus.GaugeValue, float64(len(w.ch)), pipeline, w.name, w.node)
+			ch <- prometheus.MustNewConstMetric(cc.capacity, prometheus.GaugeValue, float64(cap(w.ch)), pipeline, w.name, w.node)
+		}
+	}
+}
@@ -3,13 +3,94 @@ Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
 */
 package execute
 
-import "github.com/google/uuid"
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/vaerohq/vaero/transform"
+)
 
 type OpTask struct {
-	Id       uuid.UUID
-	Type     string                 `mapstructure:"type"` // source, sink, or tn
-	Op       string                 `mapstructure:"op"`   // identify the source, sink, or tn
-	Args     map[string]interface{} `mapstructure:"args"`
-	Branches [][]OpTask             // only used for branches
-	Secret   map[string]interface{} `mapstructure:"secret"` // only used with source and sink for retrieving secrets for params
+	Id        uuid.UUID
+	Type      string                 `mapstructure:"type"` // source, sink, tn, or union
+	Op        string                 `mapstructure:"op"`   // identify the source, sink, or tn
+	Args      map[string]interface{} `mapstructure:"args"`
+	Branches  [][]OpTask             // only used for branches
+	Inputs    [][]OpTask             `mapstructure:"-"`        // only used for unions, each input is a source and its transforms
+	Secret    map[string]interface{} `mapstructure:"secret"`   // only used with source and sink for retrieving secrets for params
+	Restart   map[string]interface{} `mapstructure:"restart"`  // only used with source, to set the restart policy of the pipeline
+	Schedule  map[string]interface{} `mapstructure:"schedule"` // only used with pull sources, to set when they read
+	Transform transform.Transform    // only used with tn, set by CompileTaskGraph
+
+	// SecretRefs is only set on copies of tasks whose secret references were resolved, to the args that reference
+	// secrets with ${secret:provider:path}, unresolved, so that they can be resolved again
+	SecretRefs map[string]interface{} `mapstructure:"-"`
+}
+
+// StringArg returns the named arg as a string, or "" if it is missing or not a string. Args are converted to their
+// declared types by ValidateTaskGraph, so after validation this only returns "" for empty values.
+func (task *OpTask) StringArg(name string) string {
+	val, _ := task.Args[name].(string)
+	return val
+}
+
+// IntArg returns the named arg as an int, or 0 if it is missing or not an int
+func (task *OpTask) IntArg(name string) int {
+	val, _ := task.Args[name].(int)
+	return val
+}
+
+// FloatArg returns the named arg as a float64, or 0 if it is missing or not a number
+func (task *OpTask) FloatArg(name string) float64 {
+	val, _ := task.Args[name].(float64)
+	return val
+}
+
+// BoolArg returns the named arg as a bool, or false if it is missing or not a bool
+func (task *OpTask) BoolArg(name string) bool {
+	val, _ := task.Args[name].(bool)
+	return val
+}
+
+// StringMapArg returns the named object arg with its values as strings, or an empty map if it is missing or not an
+// object
+func (task *OpTask) StringMapArg(name string) map[string]string {
+	result := map[string]string{}
+	val, _ := task.Args[name].(map[string]interface{})
+	for k, v := range val {
+		result[k] = fmt.Sprint(v)
+	}
+	return result
+}
+
+// SecretInt returns the named field of the secret block as an int, or 0 if it is missing or not an int
+func (task *OpTask) SecretInt(name string) int {
+	val, _ := task.Secret[name].(int)
+	return val
+}
+
+// WalkTaskGraph calls fn for every task in the task graph other than branches, in order, with the path of the task
+// in the task graph json. A union is visited before the tasks of its inputs.
+func WalkTaskGraph(taskGraph []OpTask, fn func(path string, task *OpTask)) {
+	walkTaskGraphHelper(taskGraph, "", fn)
+}
+
+func walkTaskGraphHelper(taskGraph []OpTask, prefix string, fn func(path string, task *OpTask)) {
+	for idx := range taskGraph {
+		task := &taskGraph[idx]
+		path := taskPath(prefix, idx)
+
+		if task.Type == "branch" {
+			for branchIdx, branch := range task.Branches {
+				walkTaskGraphHelper(branch, taskPath(path, branchIdx), fn)
+			}
+			continue
+		}
+
+		fn(path, task)
+
+		for inputIdx, input := range task.Inputs {
+			walkTaskGraphHelper(input, taskPath(path, inputIdx), fn)
+		}
+	}
 }
@@ -0,0 +1,142 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/vaerohq/vaero/schema"
+)
+
+// Redacted replaces the values of secret args in logs and output
+const Redacted = "[REDACTED]"
+
+// SecretArgs returns the args of a task that hold credentials: the args marked secret in the schema of the op, the
+// args named by its secret_args arg, and the args that the secret block of the task fills in
+func SecretArgs(taskType string, op string, args map[string]interface{}, secret map[string]interface{}) map[string]bool {
+	secretArgs := map[string]bool{}
+
+	if opSchema, ok := schema.Lookup(taskType, op); ok {
+		for _, arg := range opSchema.Args {
+			if arg.Secret {
+				secretArgs[arg.Name] = true
+			}
+		}
+	}
+
+	// Ops that pass args through, such as python sources, name their credentials in secret_args
+	names, _ := args["secret_args"].([]interface{})
+	for _, name := range names {
+		if arg, ok := name.(string); ok {
+			secretArgs[arg] = true
+		}
+	}
+
+	// The secrets of the block are a list of {secret name : target arg} maps
+	secrets, _ := secret["secrets"].([]interface{})
+	for _, s := range secrets {
+		targets, _ := s.(map[string]interface{})
+		for _, target := range targets {
+			if arg, ok := target.(string); ok {
+				secretArgs[arg] = true
+			}
+		}
+	}
+
+	return secretArgs
+}
+
+// RedactTaskGraph returns the task graph json with the values of secret args replaced by Redacted, so that it can
+// be logged or displayed. A task graph that is not valid json is returned unchanged.
+func RedactTaskGraph(taskGraphStr string) string {
+	var taskGraph interface{}
+	if err := json.Unmarshal([]byte(taskGraphStr), &taskGraph); err != nil {
+		return taskGraphStr
+	}
+
+	redactTasks(taskGraph)
+
+	redacted, err := json.Marshal(taskGraph)
+	if err != nil {
+		return taskGraphStr
+	}
+	return string(redacted)
+}
+
+// redactTasks redacts the secret args of every task in a decoded task graph, including the tasks of branch routes
+// and union inputs
+func redactTasks(node interface{}) {
+	switch val := node.(type) {
+	case []interface{}:
+		for _, item := range val {
+			redactTasks(item)
+		}
+	case map[string]interface{}:
+		RedactArgs(val)
+		redactTasks(val["inputs"])
+	}
+}
+
+// RedactArgs replaces the values of the secret args of a decoded task with Redacted
+func RedactArgs(task map[string]interface{}) {
+	args, _ := task["args"].(map[string]interface{})
+	if len(args) == 0 {
+		return
+	}
+
+	taskType, _ := task["type"].(string)
+	op, _ := task["op"].(string)
+	secret, _ := task["secret"].(map[string]interface{})
+
+	for arg := range SecretArgs(taskType, op, args, secret) {
+		if _, ok := args[arg]; ok {
+			args[arg] = Redacted
+		}
+	}
+}
+
+// RedactSpecSource returns the source of a pipeline specification with the values of the secret args of its task
+// graph replaced by Redacted. The source may be in any spec language, so the values are replaced wherever they appear.
+func RedactSpecSource(source string, taskGraphStr string) string {
+	var taskGraph interface{}
+	if source == "" || json.Unmarshal([]byte(taskGraphStr), &taskGraph) != nil {
+		return source
+	}
+
+	values := []string{}
+	collectSecretValues(taskGraph, &values)
+
+	// Replace longer values first, so that a value containing another is replaced whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, value := range values {
+		source = strings.ReplaceAll(source, value, Redacted)
+	}
+
+	return source
+}
+
+// collectSecretValues appends the non-empty string values of the secret args of every task in a decoded task graph
+func collectSecretValues(node interface{}, values *[]string) {
+	switch val := node.(type) {
+	case []interface{}:
+		for _, item := range val {
+			collectSecretValues(item, values)
+		}
+	case map[string]interface{}:
+		args, _ := val["args"].(map[string]interface{})
+		taskType, _ := val["type"].(string)
+		op, _ := val["op"].(string)
+		secret, _ := val["secret"].(map[string]interface{})
+
+		for arg := range SecretArgs(taskType, op, args, secret) {
+			if str, ok := args[arg].(string); ok && str != "" && str != Redacted {
+				*values = append(*values, str)
+			}
+		}
+
+		collectSecretValues(val["inputs"], values)
+	}
+}
@@ -0,0 +1,37 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactTaskGraph(t *testing.T) {
+	taskGraphStr := `[{"type":"source","op":"http_poll","args":{"url":"https://api.example.com","token":"tok-123"}},` +
+		`{"type":"sink","op":"splunk","args":{"token":"hec-456"}}]`
+
+	redacted := RedactTaskGraph(taskGraphStr)
+	for _, secret := range []string{"tok-123", "hec-456"} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("RedactTaskGraph kept secret %q: %s", secret, redacted)
+		}
+	}
+	if !strings.Contains(redacted, "https://api.example.com") {
+		t.Errorf("RedactTaskGraph redacted an arg that is not secret: %s", redacted)
+	}
+}
+
+func TestRedactSpecSource(t *testing.T) {
+	taskGraphStr := `[{"type":"source","op":"http_poll","args":{"url":"https://api.example.com","token":"tok-123"}},` +
+		`{"type":"sink","op":"splunk","args":{"token":"tok-123-long"}}]`
+	source := `Vaero().source("http_poll", url = "https://api.example.com", token = "tok-123")` +
+		`.sink("splunk", token = "tok-123-long")`
+
+	want := `Vaero().source("http_poll", url = "https://api.example.com", token = "[REDACTED]")` +
+		`.sink("splunk", token = "[REDACTED]")`
+	if got := RedactSpecSource(source, taskGraphStr); got != want {
+		t.Errorf("RedactSpecSource = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,375 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+//go:generate go test -run ^TestSchemaJSON$ . -update
+
+import (
+	"sort"
+	"time"
+
+	"github.com/vaerohq/vaero/capsule"
+	"github.com/vaerohq/vaero/integrations/sinks"
+	"github.com/vaerohq/vaero/integrations/sources"
+	"github.com/vaerohq/vaero/schema"
+	"github.com/vaerohq/vaero/transform"
+)
+
+// SourcePlugin is a source that pipelines can use, with its arg schema, constructor, and capabilities
+type SourcePlugin struct {
+	Op             string
+	Schema         *schema.OpSchema
+	Pull           bool // reads on an interval or schedule, rather than receiving events pushed to it
+	SecretsRefresh bool // is created again with the new args when its secrets are refreshed
+	Acks           bool // commits a checkpoint after each read, so that a restarted pipeline resumes after the last read
+	New            func(task *OpTask, srcOut chan capsule.Capsule) (sources.Source, error)
+}
+
+// SinkPlugin is a sink that pipelines can use, with its arg schema, constructor, and capabilities
+type SinkPlugin struct {
+	Op             string
+	Schema         *schema.OpSchema
+	SecretsRefresh bool // takes new args when its secrets are refreshed, without dropping buffered events
+	Acks           bool // Flush returns an error when the events were not written, so failures are counted
+	New            func() sinks.Sink
+}
+
+// TransformPlugin is a transform that pipelines can use, with its arg schema and constructor
+type TransformPlugin struct {
+	Op     string
+	Schema *schema.OpSchema
+	New    func(task *OpTask) (transform.Transform, error)
+}
+
+var sourcePlugins = map[string]*SourcePlugin{}
+var sinkPlugins = map[string]*SinkPlugin{}
+var transformPlugins = map[string]*TransformPlugin{}
+
+// registerSource adds a source, and registers its schema for the op
+func registerSource(p *SourcePlugin) {
+	p.Schema.Type, p.Schema.Op = "source", p.Op
+	schema.Register(p.Schema)
+	sourcePlugins[p.Op] = p
+}
+
+// registerSink adds a sink, and registers its schema for the op
+func registerSink(p *SinkPlugin) {
+	p.Schema.Type, p.Schema.Op = "sink", p.Op
+	schema.Register(p.Schema)
+	sinkPlugins[p.Op] = p
+}
+
+// registerTransform adds a transform, and registers its schema for the op
+func registerTransform(p *TransformPlugin) {
+	p.Schema.Type, p.Schema.Op = "tn", p.Op
+	schema.Register(p.Schema)
+	transformPlugins[p.Op] = p
+}
+
+// newPythonSourcePlugin creates the source of a task whose op is implemented by a Python connector
+func newPythonSourcePlugin(task *OpTask, _ chan capsule.Capsule) (sources.Source, error) {
+	return newPythonSource(task), nil
+}
+
+// lookupSchema returns the schema of a registered source, sink, or transform, so that only ops that can be run
+// pass validation
+func lookupSchema(opType string, op string) (*schema.OpSchema, bool) {
+	switch opType {
+	case "source":
+		if p, ok := sourcePlugins[op]; ok {
+			return p.Schema, true
+		}
+	case "sink":
+		if p, ok := sinkPlugins[op]; ok {
+			return p.Schema, true
+		}
+	case "tn":
+		if p, ok := transformPlugins[op]; ok {
+			return p.Schema, true
+		}
+	}
+	return nil, false
+}
+
+// takesSecrets reports whether the registered source or sink of an op takes new args when its secrets are refreshed
+func takesSecrets(opType string, op string) bool {
+	switch opType {
+	case "source":
+		p, ok := sourcePlugins[op]
+		return ok && p.SecretsRefresh
+	case "sink":
+		p, ok := sinkPlugins[op]
+		return ok && p.SecretsRefresh
+	}
+	return false
+}
+
+// SourcePlugins returns every registered source sorted by op
+func SourcePlugins() []*SourcePlugin {
+	all := make([]*SourcePlugin, 0, len(sourcePlugins))
+	for _, p := range sourcePlugins {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Op < all[j].Op })
+	return all
+}
+
+// SinkPlugins returns every registered sink sorted by op
+func SinkPlugins() []*SinkPlugin {
+	all := make([]*SinkPlugin, 0, len(sinkPlugins))
+	for _, p := range sinkPlugins {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Op < all[j].Op })
+	return all
+}
+
+// TransformPlugins returns every registered transform sorted by op
+func TransformPlugins() []*TransformPlugin {
+	all := make([]*TransformPlugin, 0, len(transformPlugins))
+	for _, p := range transformPlugins {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Op < all[j].Op })
+	return all
+}
+
+func init() {
+	// Sources
+	registerSource(&SourcePlugin{Op: "http_poll", Pull: true, SecretsRefresh: true, Acks: true,
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			schema.IntervalArg,
+			{Name: "url", Type: schema.String, Required: true, Description: "URL of the API endpoint to poll"},
+			{Name: "method", Type: schema.String, Default: "GET", Allowed: []interface{}{"GET", "POST"}, Description: "HTTP method of requests"},
+			{Name: "body", Type: schema.String, Default: "", Description: "Body of POST requests"},
+			{Name: "headers", Type: schema.Object, Default: map[string]interface{}{}, Description: "Headers of every request"},
+			{Name: "params", Type: schema.Object, Default: map[string]interface{}{}, Description: "Query params of every request"},
+			{Name: "name", Type: schema.String, Default: "", Description: "Name of the source"},
+			{Name: "auth", Type: schema.String, Default: "none", Allowed: []interface{}{"none", "basic", "bearer", "header", "query"},
+				Description: "How requests authenticate: basic with username and password, or token as a bearer token, in the auth_name header, or in the auth_name query param"},
+			{Name: "token", Type: schema.String, Default: "", Secret: true, Description: "API token of the bearer, header, and query auth"},
+			{Name: "username", Type: schema.String, Default: "", Description: "Username of basic auth"},
+			{Name: "password", Type: schema.String, Default: "", Secret: true, Description: "Password of basic auth"},
+			{Name: "auth_name", Type: schema.String, Default: "",
+				Description: "Header or query param of the token, for header and query auth. Defaults to Authorization and api_key"},
+			{Name: "auth_prefix", Type: schema.String, Default: "", Description: "Prepended to the token in header auth, such as \"SSWS \""},
+			{Name: "events_path", Type: schema.String, Default: "",
+				Description: "Path of the event array in the response body. If empty, the body is the array"},
+			{Name: "pagination", Type: schema.String, Default: "none", Allowed: []interface{}{"none", "link", "cursor", "offset", "page"},
+				Description: "How further pages are requested: the rel=next Link header, a cursor from the body, an offset, or a page number"},
+			{Name: "cursor_path", Type: schema.String, Default: "", Description: "Path of the next cursor in the response body, for cursor pagination"},
+			{Name: "page_param", Type: schema.String, Default: "",
+				Description: "Query param of the cursor, offset, or page number. Defaults to cursor, offset, and page"},
+			{Name: "limit_param", Type: schema.String, Default: "", Description: "Query param of the page size, if the API takes one"},
+			{Name: "page_size", Type: schema.Int, Default: 0, Min: schema.Minimum(0),
+				Description: "Events per page. For offset and page pagination, a shorter page is the last page"},
+			{Name: "max_pages", Type: schema.Int, Default: 100, Min: schema.Minimum(1), Description: "Most pages requested by one read"},
+			{Name: "time_field", Type: schema.String, Default: "",
+				Description: "Path of the event time. If set, the latest time read is checkpointed and later reads only return events not yet read"},
+			{Name: "id_field", Type: schema.String, Default: "",
+				Description: "Path of a unique event id, used to drop events at the checkpointed time that were already read. If empty, the whole event is compared"},
+			{Name: "time_param", Type: schema.String, Default: "", Description: "Query param that the checkpointed time is sent in, such as since"},
+			{Name: "time_format", Type: schema.String, Default: "RFC3339",
+				Description: "Format of time_field and time_param: RFC3339, unix, unix_ms, or a Go time layout"},
+			{Name: "lookback_seconds", Type: schema.Int, Default: 0, Min: schema.Minimum(0),
+				Description: "How far back the first read starts, with no checkpoint. If 0, time_param is not sent"},
+			{Name: "max_calls_per_period", Type: schema.Int, Default: 60, Min: schema.Minimum(1), Description: "Rate limit calls per period"},
+			{Name: "limit_period", Type: schema.Int, Default: 60, Min: schema.Minimum(1), Description: "Rate limit period in seconds"},
+			{Name: "max_retries", Type: schema.Int, Default: 6, Min: schema.Minimum(0),
+				Description: "Retries of a request that fails with 429, a 5xx status, or a network error"},
+			{Name: "backoff_seconds", Type: schema.Int, Default: 5, Min: schema.Minimum(1),
+				Description: "Delay before the first retry, doubled after each retry. A Retry-After header takes precedence"},
+			{Name: "max_backoff_seconds", Type: schema.Int, Default: 300, Min: schema.Minimum(1), Description: "Longest delay before a retry"},
+			{Name: "timeout_seconds", Type: schema.Int, Default: 30, Min: schema.Minimum(1), Description: "Seconds a request may take"},
+		}},
+		New: func(task *OpTask, _ chan capsule.Capsule) (sources.Source, error) {
+			return newHTTPPollSource(task), nil
+		}})
+	registerSource(&SourcePlugin{Op: "http_server",
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			{Name: "endpoint", Type: schema.String, Default: "/logevent", Description: "URL path to receive events on"},
+			{Name: "event_breaker", Type: schema.String, Default: "jsonarray", Allowed: []interface{}{"jsonarray"},
+				Description: "How to split a request body into events"},
+			{Name: "name", Type: schema.String, Default: "", Description: "Name of the source"},
+			{Name: "port", Type: schema.Int, Default: 8080, Min: schema.Minimum(1), Description: "Port to listen on"},
+		}},
+		New: func(task *OpTask, srcOut chan capsule.Capsule) (sources.Source, error) {
+			return &sources.HTTPServerSource{
+				Endpoint:     task.StringArg("endpoint"),
+				EventBreaker: task.StringArg("event_breaker"),
+				Name:         task.StringArg("name"),
+				Port:         task.IntArg("port"),
+				SrcOut:       srcOut,
+			}, nil
+		}})
+	registerSource(&SourcePlugin{Op: "okta", Pull: true, SecretsRefresh: true, Acks: true,
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			schema.IntervalArg,
+			{Name: "host", Type: schema.String, Required: true, Description: "Okta domain, such as https://example.okta.com/"},
+			{Name: "token", Type: schema.String, Required: true, Secret: true, Description: "Okta API token"},
+			{Name: "name", Type: schema.String, Default: "okta", Description: "Name of the source, used to store the cursor"},
+			{Name: "max_calls_per_period", Type: schema.Int, Default: 60, Min: schema.Minimum(1), Description: "Rate limit calls per period"},
+			{Name: "limit_period", Type: schema.Int, Default: 60, Min: schema.Minimum(1), Description: "Rate limit period in seconds"},
+			{Name: "max_retries", Type: schema.Int, Default: 6, Min: schema.Minimum(0), Description: "Retries before giving up on a request"},
+		}},
+		New: newPythonSourcePlugin})
+	registerSource(&SourcePlugin{Op: "python", Pull: true, SecretsRefresh: true, Acks: true,
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			schema.IntervalArg,
+			{Name: "module", Type: schema.String, Required: true,
+				Description: "Python module of the connector, such as integrations.python.source_google_workspace"},
+			{Name: "class", Type: schema.String, Default: "",
+				Description: "Connector class in the module. If empty, the module must define exactly one HTTPConnector subclass"},
+			{Name: "secret_args", Type: schema.List, Default: []interface{}{},
+				Description: "Names of args that hold credentials, which are redacted from logs and output"},
+		}},
+		New: newPythonSourcePlugin})
+	registerSource(&SourcePlugin{Op: "random", Pull: true,
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			schema.IntervalArg,
+			{Name: "name", Type: schema.String, Default: "", Description: "Name of the source"},
+		}},
+		New: func(task *OpTask, _ chan capsule.Capsule) (sources.Source, error) {
+			return &sources.RandomSource{Name: task.StringArg("name")}, nil
+		}})
+	registerSource(&SourcePlugin{Op: "s3", Pull: true, SecretsRefresh: true, Acks: true,
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			schema.IntervalArg,
+			{Name: "bucket", Type: schema.String, Required: true, Description: "Bucket to read from"},
+			{Name: "prefix", Type: schema.String, Default: "", Description: "Prefix of objects to read"},
+			{Name: "region", Type: schema.String, Default: "", Description: "Region of the bucket"},
+		}},
+		New: func(task *OpTask, _ chan capsule.Capsule) (sources.Source, error) {
+			return &sources.S3Source{
+				Bucket: task.StringArg("bucket"),
+				Prefix: task.StringArg("prefix"),
+				Region: task.StringArg("region"),
+			}, nil
+		}})
+	registerSource(&SourcePlugin{Op: "wasm", Pull: true, SecretsRefresh: true, Acks: true,
+		Schema: &schema.OpSchema{Args: append([]schema.Arg{schema.IntervalArg}, schema.PluginArgs...)},
+		New: func(task *OpTask, _ chan capsule.Capsule) (sources.Source, error) {
+			source, err := newWasmSource(task)
+			if err != nil {
+				return nil, err
+			}
+			return source, nil
+		}})
+
+	// Sinks. The datadog, elastic, and splunk sinks do not ack, as they print their events until their APIs are
+	// implemented, so a failed delivery cannot be reported.
+	registerSink(&SinkPlugin{Op: "datadog", SecretsRefresh: true,
+		Schema: &schema.OpSchema{Args: schema.SinkArgs(
+			schema.Arg{Name: "api_key", Type: schema.String, Default: "", Secret: true, Description: "Datadog API key"},
+		)},
+		New: func() sinks.Sink { return &sinks.DatadogSink{} }})
+	registerSink(&SinkPlugin{Op: "elastic", SecretsRefresh: true,
+		Schema: &schema.OpSchema{Args: schema.SinkArgs(
+			schema.Arg{Name: "api_key", Type: schema.String, Default: "", Secret: true, Description: "Elasticsearch API key. Set api_key, or username and password"},
+			schema.Arg{Name: "username", Type: schema.String, Default: "", Description: "Username of basic auth"},
+			schema.Arg{Name: "password", Type: schema.String, Default: "", Secret: true, Description: "Password of basic auth"},
+		)},
+		New: func() sinks.Sink { return &sinks.ElasticSink{} }})
+	registerSink(&SinkPlugin{Op: "s3", Acks: true,
+		Schema: &schema.OpSchema{Args: schema.SinkArgs(
+			schema.Arg{Name: "bucket", Type: schema.String, Required: true, Description: "Bucket to write to"},
+		)},
+		New: func() sinks.Sink { return &sinks.S3Sink{} }})
+	registerSink(&SinkPlugin{Op: "splunk", SecretsRefresh: true,
+		Schema: &schema.OpSchema{Args: schema.SinkArgs(
+			schema.Arg{Name: "token", Type: schema.String, Default: "", Secret: true, Description: "Splunk HTTP Event Collector token"},
+		)},
+		New: func() sinks.Sink { return &sinks.SplunkSink{} }})
+	registerSink(&SinkPlugin{Op: "stdout", Acks: true,
+		Schema: &schema.OpSchema{Args: schema.SinkArgs()},
+		New:    func() sinks.Sink { return &sinks.StdoutSink{} }})
+	registerSink(&SinkPlugin{Op: "wasm", Acks: true,
+		Schema: &schema.OpSchema{Args: schema.SinkArgs(schema.PluginArgs...)},
+		New:    func() sinks.Sink { return &sinks.WasmSink{} }})
+
+	// Transforms
+	registerTransform(&TransformPlugin{Op: "add",
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			schema.PathArg,
+			{Name: "value", Type: schema.Any, Required: true, Description: "Value to add"},
+		}},
+		New: func(task *OpTask) (transform.Transform, error) {
+			return transform.NewAdd(task.StringArg("path"), task.Args["value"])
+		}})
+	registerTransform(&TransformPlugin{Op: "delete",
+		Schema: &schema.OpSchema{Args: []schema.Arg{schema.PathArg}},
+		New: func(task *OpTask) (transform.Transform, error) {
+			return transform.NewDelete(task.StringArg("path"))
+		}})
+	registerTransform(&TransformPlugin{Op: "filter_regexp",
+		Schema: &schema.OpSchema{Args: []schema.Arg{schema.PathArg, schema.RegexArg}},
+		New: func(task *OpTask) (transform.Transform, error) {
+			return transform.NewFilterRegExp(task.StringArg("path"), task.StringArg("regex"))
+		}})
+	registerTransform(&TransformPlugin{Op: "mask",
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			schema.PathArg,
+			schema.RegexArg,
+			{Name: "replace_expr", Type: schema.String, Default: "", Description: "Replacement for the matched text; may reference groups as $1"},
+		}},
+		New: func(task *OpTask) (transform.Transform, error) {
+			return transform.NewMask(task.StringArg("path"), task.StringArg("regex"), task.StringArg("replace_expr"))
+		}})
+	registerTransform(&TransformPlugin{Op: "parse_regexp",
+		Schema: &schema.OpSchema{Args: []schema.Arg{schema.PathArg, schema.RegexArg}},
+		New: func(task *OpTask) (transform.Transform, error) {
+			return transform.NewParseRegExp(task.StringArg("path"), task.StringArg("regex"))
+		}})
+	registerTransform(&TransformPlugin{Op: "python",
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			{Name: "module", Type: schema.String, Required: true, Description: "Python module of the function, such as transforms.vendor"},
+			{Name: "func", Type: schema.String, Required: true,
+				Description: "Function that takes an event and returns an event, a list of events, or None to drop the event"},
+			{Name: "batch_size", Type: schema.Int, Default: 1000, Min: schema.Minimum(1), Description: "Most events sent to the Python worker at once"},
+			{Name: "timeout_seconds", Type: schema.Int, Default: 30, Min: schema.Minimum(1),
+				Description: "Seconds a batch may take before the worker is restarted"},
+			{Name: "on_error", Type: schema.String, Default: "pass", Allowed: []interface{}{"pass", "drop"},
+				Description: "Whether events that fail, or whose batch fails, are passed on unchanged or dropped"},
+		}},
+		New: func(task *OpTask) (transform.Transform, error) {
+			return transform.NewPython(task.StringArg("module"), task.StringArg("func"), task.IntArg("batch_size"),
+				time.Duration(task.IntArg("timeout_seconds"))*time.Second, task.StringArg("on_error"))
+		}})
+	registerTransform(&TransformPlugin{Op: "rename",
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			schema.PathArg,
+			{Name: "new_path", Type: schema.String, Required: true, Description: "New path of the field"},
+		}},
+		New: func(task *OpTask) (transform.Transform, error) {
+			return transform.NewRename(task.StringArg("path"), task.StringArg("new_path"))
+		}})
+	registerTransform(&TransformPlugin{Op: "select",
+		Schema: &schema.OpSchema{Args: []schema.Arg{schema.PathArg}},
+		New: func(task *OpTask) (transform.Transform, error) {
+			return transform.NewSelect(task.StringArg("path"))
+		}})
+	registerTransform(&TransformPlugin{Op: "starlark",
+		Schema: &schema.OpSchema{Args: []schema.Arg{
+			{Name: "script", Type: schema.String, Default: "", Description: "Starlark script that defines the function. Set script or file"},
+			{Name: "file", Type: schema.String, Default: "", Description: "Path of a Starlark script file that defines the function. Set script or file"},
+			{Name: "func", Type: schema.String, Default: "transform",
+				Description: "Function that takes an event as a dict and returns a dict, a list of dicts, or None to drop the event"},
+			{Name: "max_steps", Type: schema.Int, Default: 100_000, Min: schema.Minimum(1), Description: "Most execution steps per event"},
+			{Name: "on_error", Type: schema.String, Default: "pass", Allowed: []interface{}{"pass", "drop"},
+				Description: "Whether events for which the function fails are passed on unchanged or dropped"},
+		}},
+		New: func(task *OpTask) (transform.Transform, error) {
+			return transform.NewStarlark(task.StringArg("script"), task.StringArg("file"), task.StringArg("func"),
+				task.IntArg("max_steps"), task.StringArg("on_error"))
+		}})
+	registerTransform(&TransformPlugin{Op: "wasm",
+		Schema: &schema.OpSchema{Args: append(append([]schema.Arg{}, schema.PluginArgs...),
+			schema.Arg{Name: "on_error", Type: schema.String, Default: "pass", Allowed: []interface{}{"pass", "drop"},
+				Description: "Whether the events of a failed call are passed on unchanged or dropped"})},
+		New: func(task *OpTask) (transform.Transform, error) {
+			config, _ := task.Args["config"].(map[string]interface{})
+			return transform.NewWasm(task.StringArg("plugin"), config, time.Duration(task.IntArg("timeout_seconds"))*time.Second,
+				task.StringArg("on_error"))
+		}})
+}
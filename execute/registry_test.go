@@ -0,0 +1,60 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vaerohq/vaero/schema"
+)
+
+var updateSchema = flag.Bool("update", false, "write vaero/schema.json from the registered components")
+
+// schemaFile is read by the Python Vaero builder to validate pipelines before they are added
+var schemaFile = filepath.Join("..", "vaero", "schema.json")
+
+func TestSchemaJSON(t *testing.T) {
+	out, err := schema.JSON()
+	if err != nil {
+		t.Fatalf("JSON returned error: %v", err)
+	}
+	out = append(out, '\n')
+
+	if *updateSchema {
+		if err := os.WriteFile(schemaFile, out, 0644); err != nil {
+			t.Fatalf("could not write %s: %v", schemaFile, err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(schemaFile)
+	if err != nil {
+		t.Fatalf("could not read %s: %v", schemaFile, err)
+	}
+	if !bytes.Equal(out, want) {
+		t.Errorf("%s is out of date with the registered components, regenerate it with go generate ./execute", schemaFile)
+	}
+}
+
+func TestRegisteredSchemas(t *testing.T) {
+	for _, p := range SourcePlugins() {
+		if s, ok := schema.Lookup("source", p.Op); !ok || s != p.Schema || s.Type != "source" || s.Op != p.Op {
+			t.Errorf("source %s has schema %+v", p.Op, s)
+		}
+	}
+	for _, p := range SinkPlugins() {
+		if s, ok := schema.Lookup("sink", p.Op); !ok || s != p.Schema || s.Type != "sink" || s.Op != p.Op {
+			t.Errorf("sink %s has schema %+v", p.Op, s)
+		}
+	}
+	for _, p := range TransformPlugins() {
+		if s, ok := schema.Lookup("tn", p.Op); !ok || s != p.Schema || s.Type != "tn" || s.Op != p.Op {
+			t.Errorf("transform %s has schema %+v", p.Op, s)
+		}
+	}
+}
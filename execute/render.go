@@ -0,0 +1,237 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/vaerohq/vaero/schema"
+)
+
+// RenderTree renders the task graph as an ASCII tree. Each task is labeled with its path in the task graph json.
+func RenderTree(taskGraph []OpTask) string {
+	var b strings.Builder
+
+	renderTreeHelper(&b, taskGraph, "", "")
+
+	return b.String()
+}
+
+func renderTreeHelper(b *strings.Builder, taskGraph []OpTask, prefix string, indent string) {
+	for idx := range taskGraph {
+		task := &taskGraph[idx]
+		path := taskPath(prefix, idx)
+
+		if task.Type == "union" {
+			fmt.Fprintf(b, "%s%s union\n", indent, path)
+			for inputIdx, input := range task.Inputs {
+				connector, childIndent := "├── ", "│   "
+				if inputIdx == len(task.Inputs)-1 {
+					connector, childIndent = "└── ", "    "
+				}
+
+				fmt.Fprintf(b, "%s%sinput %d\n", indent, connector, inputIdx)
+				renderTreeHelper(b, input, taskPath(path, inputIdx), indent+childIndent)
+			}
+			continue
+		}
+
+		if task.Type != "branch" {
+			fmt.Fprintf(b, "%s%s\n", indent, nodeLabel(path, task, " "))
+			continue
+		}
+
+		fmt.Fprintf(b, "%s%s branch\n", indent, path)
+		for branchIdx, branch := range task.Branches {
+			connector, childIndent := "├── ", "│   "
+			if branchIdx == len(task.Branches)-1 {
+				connector, childIndent = "└── ", "    "
+			}
+
+			fmt.Fprintf(b, "%s%sroute %d\n", indent, connector, branchIdx)
+			renderTreeHelper(b, branch, taskPath(path, branchIdx), indent+childIndent)
+		}
+	}
+}
+
+// RenderDOT renders the task graph in the Graphviz DOT language
+func RenderDOT(taskGraph []OpTask) string {
+	var b strings.Builder
+
+	b.WriteString("digraph pipeline {\n")
+	b.WriteString("  rankdir=LR;\n")
+
+	nodes, edges := graphNodesAndEdges(taskGraph)
+	for _, n := range nodes {
+		shape := "box"
+		switch n.Task.Type {
+		case "source":
+			shape = "ellipse"
+		case "sink":
+			shape = "cylinder"
+		case "union":
+			shape = "invtrapezium"
+		}
+		label := dotEscaper.Replace(nodeLabel(n.Path, n.Task, "\n"))
+		fmt.Fprintf(&b, "  %q [shape=%s, label=\"%s\"];\n", n.Path, shape, label)
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %q -> %q;\n", e[0], e[1])
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// RenderMermaid renders the task graph as a Mermaid flowchart
+func RenderMermaid(taskGraph []OpTask) string {
+	var b strings.Builder
+
+	b.WriteString("flowchart LR\n")
+
+	nodes, edges := graphNodesAndEdges(taskGraph)
+	for _, n := range nodes {
+		left, right := "[", "]"
+		switch n.Task.Type {
+		case "source":
+			left, right = "([", "])"
+		case "sink":
+			left, right = "[(", ")]"
+		case "union":
+			left, right = "{{", "}}"
+		}
+		label := strings.ReplaceAll(nodeLabel(n.Path, n.Task, "<br/>"), `"`, "#quot;")
+		fmt.Fprintf(&b, "  %s%s\"%s\"%s\n", mermaidId(n.Path), left, label, right)
+	}
+	for _, e := range edges {
+		fmt.Fprintf(&b, "  %s --> %s\n", mermaidId(e[0]), mermaidId(e[1]))
+	}
+
+	return b.String()
+}
+
+// graphNode is a task with its path in the task graph json
+type graphNode struct {
+	Path string
+	Task *OpTask
+}
+
+// graphNodesAndEdges flattens the task graph into its non-branch tasks and the edges between them. Each route of a
+// branch is connected to the task before the branch, and the last task of each input of a union to the union.
+func graphNodesAndEdges(taskGraph []OpTask) ([]graphNode, [][2]string) {
+	var nodes []graphNode
+	var edges [][2]string
+
+	// walk returns the path of the last task it added, to connect it to a union
+	var walk func(taskGraph []OpTask, prefix string, from string) string
+	walk = func(taskGraph []OpTask, prefix string, from string) string {
+		for idx := range taskGraph {
+			task := &taskGraph[idx]
+			path := taskPath(prefix, idx)
+
+			if task.Type == "branch" {
+				for branchIdx, branch := range task.Branches {
+					walk(branch, taskPath(path, branchIdx), from)
+				}
+				continue
+			}
+
+			var inputs []string
+			for inputIdx, input := range task.Inputs {
+				if last := walk(input, taskPath(path, inputIdx), ""); last != "" {
+					inputs = append(inputs, last)
+				}
+			}
+
+			nodes = append(nodes, graphNode{Path: path, Task: task})
+			for _, last := range inputs {
+				edges = append(edges, [2]string{last, path})
+			}
+			if from != "" {
+				edges = append(edges, [2]string{from, path})
+			}
+			from = path
+		}
+		return from
+	}
+	walk(taskGraph, "", "")
+
+	return nodes, edges
+}
+
+// nodeLabel describes a task by its path, type, op, and any args that differ from their defaults
+func nodeLabel(path string, task *OpTask, sep string) string {
+	if task.Type == "union" {
+		return path + " union"
+	}
+
+	parts := []string{fmt.Sprintf("%s %s %s", path, task.Type, task.Op)}
+
+	parts = append(parts, describeArgs(task)...)
+
+	return strings.Join(parts, sep)
+}
+
+// describeArgs returns key=value strings for the args of a task that are not set to their defaults, in schema order.
+// Secret args are redacted.
+func describeArgs(task *OpTask) []string {
+	var described []string
+
+	secretArgs := SecretArgs(task.Type, task.Op, task.Args, task.Secret)
+	describe := func(name string, val interface{}) string {
+		if secretArgs[name] {
+			return name + "=" + Redacted
+		}
+		return formatArg(name, val)
+	}
+
+	opSchema, ok := schema.Lookup(task.Type, task.Op)
+	if !ok {
+		keys := make([]string, 0, len(task.Args))
+		for k := range task.Args {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			described = append(described, describe(k, task.Args[k]))
+		}
+		return described
+	}
+
+	for _, arg := range opSchema.Args {
+		val, ok := task.Args[arg.Name]
+		if !ok || reflect.DeepEqual(val, arg.Default) {
+			continue
+		}
+		described = append(described, describe(arg.Name, val))
+	}
+
+	return described
+}
+
+// formatArg formats an arg as name=value, with the value in json
+func formatArg(name string, val interface{}) string {
+	var b strings.Builder
+
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(val); err != nil {
+		return fmt.Sprintf("%s=%v", name, val)
+	}
+
+	return fmt.Sprintf("%s=%s", name, strings.TrimSuffix(b.String(), "\n"))
+}
+
+// dotEscaper escapes a label for use in a DOT quoted string
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// mermaidId converts a task path into a valid Mermaid node id
+func mermaidId(path string) string {
+	return "n" + strings.ReplaceAll(path, ".", "_")
+}
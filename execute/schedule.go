@@ -0,0 +1,374 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/schema"
+	"go.uber.org/zap"
+)
+
+// ScheduleStore records the reads of sources that have a schedule, so that reads missed while a pipeline was not
+// running can be caught up, and so that the next read can be displayed
+type ScheduleStore interface {
+	// LastScheduledRead returns the time of the last read of the source at path of the pipeline with id
+	LastScheduledRead(id int, path string) (time.Time, bool)
+	// RecordScheduledRead records a read of the source at path of the pipeline with id, and when the next read is
+	RecordScheduledRead(id int, path string, last time.Time, next time.Time)
+}
+
+// maxScheduleSteps bounds the search for the next read allowed by the window and days of a schedule
+const maxScheduleSteps = 10000
+
+// sourceSchedule decides when a pull source reads
+type sourceSchedule struct {
+	cron       cron.Schedule // nil to read every interval
+	interval   time.Duration
+	window     *timeWindow // nil to allow reads at any time of day
+	days       [7]bool     // indexed by time.Weekday
+	location   *time.Location
+	jitter     time.Duration
+	catchUp    string
+	maxCatchUp int
+}
+
+// timeWindow is a time of day range, in minutes after midnight. The window wraps past midnight if end <= start.
+type timeWindow struct {
+	start int
+	end   int
+}
+
+// parseSchedule parses the schedule block of a source. The block must already have been checked against
+// schema.Schedule, which fills in its defaults. The errors are keyed by the arg of the block that is invalid.
+func parseSchedule(block map[string]interface{}, interval time.Duration) (*sourceSchedule, []schema.ArgError) {
+	var errs []schema.ArgError
+
+	s := &sourceSchedule{interval: interval}
+
+	if expr, _ := block["cron"].(string); expr != "" {
+		sched, err := cron.ParseStandard(expr)
+		if err != nil {
+			errs = append(errs, schema.ArgError{Arg: "cron", Msg: err.Error()})
+		}
+		s.cron = sched
+	} else if interval <= 0 {
+		errs = append(errs, schema.ArgError{Arg: "cron", Msg: "must be set if the source has no interval"})
+	}
+
+	if window, _ := block["window"].(string); window != "" {
+		w, err := parseWindow(window)
+		if err != nil {
+			errs = append(errs, schema.ArgError{Arg: "window", Msg: err.Error()})
+		}
+		s.window = w
+	}
+
+	days, _ := block["days"].(string)
+	var err error
+	if s.days, err = parseDays(days); err != nil {
+		errs = append(errs, schema.ArgError{Arg: "days", Msg: err.Error()})
+	}
+
+	timezone, _ := block["timezone"].(string)
+	if s.location, err = time.LoadLocation(timezone); err != nil {
+		errs = append(errs, schema.ArgError{Arg: "timezone", Msg: err.Error()})
+	}
+
+	jitter, _ := block["jitter_seconds"].(int)
+	s.jitter = time.Duration(jitter) * time.Second
+
+	s.catchUp, _ = block["catch_up"].(string)
+	s.maxCatchUp, _ = block["max_catch_up"].(int)
+
+	return s, errs
+}
+
+// parseWindow parses a time of day range such as 09:00-17:00
+func parseWindow(window string) (*timeWindow, error) {
+	startStr, endStr, ok := strings.Cut(window, "-")
+	if !ok {
+		return nil, fmt.Errorf("must be a range of times such as 09:00-17:00")
+	}
+
+	start, err := parseTimeOfDay(startStr)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTimeOfDay(endStr)
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("start and end of the window must differ")
+	}
+
+	return &timeWindow{start: start, end: end}, nil
+}
+
+// parseTimeOfDay parses a time such as 09:30 into minutes after midnight. 24:00 is the end of the day.
+func parseTimeOfDay(str string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(str))
+	if err != nil {
+		if strings.TrimSpace(str) == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("%q is not a time such as 09:30", str)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// weekdays are the names of the days of the week, indexed by time.Weekday
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseDays parses a list of days or ranges of days, such as mon-fri or sat,sun. Every day is allowed if days is
+// empty.
+func parseDays(days string) ([7]bool, error) {
+	var allowed [7]bool
+
+	if strings.TrimSpace(days) == "" {
+		for idx := range allowed {
+			allowed[idx] = true
+		}
+		return allowed, nil
+	}
+
+	for _, part := range strings.Split(days, ",") {
+		firstStr, lastStr, isRange := strings.Cut(part, "-")
+		first, err := parseWeekday(firstStr)
+		if err != nil {
+			return allowed, err
+		}
+
+		last := first
+		if isRange {
+			if last, err = parseWeekday(lastStr); err != nil {
+				return allowed, err
+			}
+		}
+
+		// Ranges may wrap, such as fri-mon
+		for day := first; ; day = (day + 1) % 7 {
+			allowed[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	return allowed, nil
+}
+
+// parseWeekday parses the name of a day of the week, such as mon or Monday
+func parseWeekday(str string) (int, error) {
+	name := strings.ToLower(strings.TrimSpace(str))
+	if len(name) >= 3 {
+		for idx, day := range weekdays {
+			if strings.HasPrefix(name, day) {
+				return idx, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%q is not a day of the week such as mon", str)
+}
+
+// allowed reports whether the window and days of the schedule allow a read at t
+func (s *sourceSchedule) allowed(t time.Time) bool {
+	t = t.In(s.location)
+
+	if !s.days[t.Weekday()] {
+		return false
+	}
+	if s.window == nil {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if s.window.start < s.window.end {
+		return minute >= s.window.start && minute < s.window.end
+	}
+	return minute >= s.window.start || minute < s.window.end
+}
+
+// nextAllowed returns the first time at or after t that the window and days of the schedule allow a read
+func (s *sourceSchedule) nextAllowed(t time.Time) time.Time {
+	if s.allowed(t) {
+		return t
+	}
+
+	local := t.In(s.location)
+	for day := 0; day <= 7; day++ {
+		midnight := time.Date(local.Year(), local.Month(), local.Day()+day, 0, 0, 0, 0, s.location)
+
+		// A window that wraps past midnight is open at midnight, otherwise it opens at its start
+		candidates := []time.Time{midnight}
+		if s.window != nil {
+			candidates = append(candidates, midnight.Add(time.Duration(s.window.start)*time.Minute))
+		}
+
+		for _, candidate := range candidates {
+			if !candidate.Before(t) && s.allowed(candidate) {
+				return candidate
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// next returns the time of the first read after t, or the zero time if the schedule allows no more reads
+func (s *sourceSchedule) next(t time.Time) time.Time {
+	if s.cron == nil {
+		return s.nextAllowed(t.Add(s.interval))
+	}
+
+	next := s.cron.Next(t.In(s.location))
+	for step := 0; step < maxScheduleSteps && !next.IsZero(); step++ {
+		if s.allowed(next) {
+			return next
+		}
+
+		// Skip to the next time the window is open, and the first cron time from then
+		allowed := s.nextAllowed(next)
+		if allowed.IsZero() {
+			break
+		}
+		next = s.cron.Next(allowed.Add(-time.Second))
+	}
+
+	return time.Time{}
+}
+
+// missed returns the number of reads that were scheduled after last and at or before now, up to limit
+func (s *sourceSchedule) missed(last time.Time, now time.Time, limit int) int {
+	count := 0
+	for t := s.next(last); count < limit && !t.IsZero() && !t.After(now); t = s.next(t) {
+		count++
+	}
+	return count
+}
+
+// scheduler waits for the reads of a pull source with a schedule
+type scheduler struct {
+	schedule *sourceSchedule
+	store    ScheduleStore
+	id       int
+	path     string        // path of the source in the task graph
+	offset   time.Duration // random delay added to every read, up to the jitter of the schedule
+	next     time.Time     // time of the next scheduled read, without the offset
+	pending  int           // missed reads to make right away
+}
+
+// newScheduler starts the schedule of the source at path of the pipeline with id. Reads missed since the last
+// recorded read are caught up according to the catch_up policy of the schedule.
+func newScheduler(schedule *sourceSchedule, store ScheduleStore, id int, path string) *scheduler {
+	s := &scheduler{schedule: schedule, store: store, id: id, path: path}
+
+	if schedule.jitter > 0 {
+		s.offset = time.Duration(rand.Int63n(int64(schedule.jitter)))
+	}
+
+	now := time.Now()
+	s.next = schedule.next(now)
+
+	if store == nil {
+		return s
+	}
+
+	last, ok := store.LastScheduledRead(id, path)
+	if !ok {
+		store.RecordScheduledRead(id, path, time.Time{}, s.next)
+		return s
+	}
+
+	switch schedule.catchUp {
+	case "once":
+		s.pending = schedule.missed(last, now, 1)
+	case "all":
+		s.pending = schedule.missed(last, now, schedule.maxCatchUp)
+	}
+
+	if s.pending > 0 {
+		log.Logger.Info("Catching up missed scheduled reads", zap.Int("Id", id), zap.String("Source", path),
+			zap.Int("Reads", s.pending), zap.Time("LastRead", last))
+	}
+
+	store.RecordScheduledRead(id, path, last, s.next)
+
+	return s
+}
+
+// wait waits until the next scheduled read, and returns false if done is closed first
+func (s *scheduler) wait(done <-chan struct{}) bool {
+	if s.pending > 0 {
+		s.pending--
+		return true
+	}
+
+	if s.next.IsZero() {
+		log.Logger.Info("Schedule allows no more reads", zap.Int("Id", s.id), zap.String("Source", s.path))
+		<-done
+		return false
+	}
+
+	timer := time.NewTimer(time.Until(s.next) + s.offset)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// read records a read that has just been made, and schedules the next one
+func (s *scheduler) read() {
+	now := time.Now()
+
+	// A read that was caught up does not move the schedule. Scheduled reads that take longer than the time between
+	// them skip the reads they overran, rather than piling up.
+	if !s.next.After(now) {
+		s.next = s.schedule.next(s.next)
+		if !s.next.IsZero() && s.next.Before(now) {
+			s.next = s.schedule.next(now)
+		}
+	}
+
+	if s.store != nil {
+		s.store.RecordScheduledRead(s.id, s.path, now, s.next)
+	}
+}
+
+// DescribeSchedule describes the schedule block of a source, such as for the detail command
+func DescribeSchedule(block map[string]interface{}) string {
+	var parts []string
+
+	if expr, _ := block["cron"].(string); expr != "" {
+		parts = append(parts, fmt.Sprintf("cron %q", expr))
+	}
+	if window, _ := block["window"].(string); window != "" {
+		parts = append(parts, "between "+window)
+	}
+	if days, _ := block["days"].(string); days != "" {
+		parts = append(parts, "on "+days)
+	}
+	if timezone, _ := block["timezone"].(string); timezone != "" {
+		parts = append(parts, "("+timezone+")")
+	}
+	if jitter, _ := block["jitter_seconds"].(int); jitter > 0 {
+		parts = append(parts, "jitter "+strconv.Itoa(jitter)+"s")
+	}
+	if catchUp, _ := block["catch_up"].(string); catchUp != "" {
+		parts = append(parts, "catch up "+catchUp)
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,162 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vaerohq/vaero/schema"
+)
+
+// newTestSchedule parses the schedule block, with its defaults filled in as ValidateTaskGraph does
+func newTestSchedule(t *testing.T, block map[string]interface{}, interval time.Duration) *sourceSchedule {
+	t.Helper()
+
+	if errs := schema.Schedule().Apply(block, nil); len(errs) != 0 {
+		t.Fatalf("invalid schedule block %v: %v", block, errs)
+	}
+	s, errs := parseSchedule(block, interval)
+	if len(errs) != 0 {
+		t.Fatalf("parseSchedule(%v) returned errors: %v", block, errs)
+	}
+	return s
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   map[string]interface{}
+		wantArg string
+	}{
+		{name: "bad cron", block: map[string]interface{}{"cron": "61 * * * *"}, wantArg: "cron"},
+		{name: "no cron or interval", block: map[string]interface{}{}, wantArg: "cron"},
+		{name: "bad window", block: map[string]interface{}{"cron": "@hourly", "window": "09:00"}, wantArg: "window"},
+		{name: "empty window", block: map[string]interface{}{"cron": "@hourly", "window": "09:00-09:00"}, wantArg: "window"},
+		{name: "bad days", block: map[string]interface{}{"cron": "@hourly", "days": "mon-funday"}, wantArg: "days"},
+		{name: "bad timezone", block: map[string]interface{}{"cron": "@hourly", "timezone": "Mars/Olympus"}, wantArg: "timezone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema.Schedule().Apply(tt.block, nil)
+			_, errs := parseSchedule(tt.block, 0)
+			if len(errs) != 1 || errs[0].Arg != tt.wantArg {
+				t.Errorf("parseSchedule errors = %v, want one for %s", errs, tt.wantArg)
+			}
+		})
+	}
+}
+
+func TestSourceScheduleNext(t *testing.T) {
+	// 2023-05-01 is a Monday
+	at := func(day int, hour int, min int) time.Time {
+		return time.Date(2023, 5, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		block    map[string]interface{}
+		interval time.Duration
+		from     time.Time
+		want     time.Time
+	}{
+		{name: "interval", interval: 10 * time.Minute, from: at(1, 12, 0), want: at(1, 12, 10)},
+		{name: "cron", block: map[string]interface{}{"cron": "30 * * * *"}, from: at(1, 12, 0), want: at(1, 12, 30)},
+		{name: "cron in time zone", block: map[string]interface{}{"cron": "0 9 * * *", "timezone": "America/New_York"},
+			from: at(1, 12, 0), want: at(1, 13, 0)},
+		{name: "interval outside window", block: map[string]interface{}{"window": "09:00-17:00"},
+			interval: time.Hour, from: at(1, 16, 30), want: at(2, 9, 0)},
+		{name: "cron outside window", block: map[string]interface{}{"cron": "0 * * * *", "window": "09:00-17:00"},
+			from: at(1, 16, 30), want: at(2, 9, 0)},
+		{name: "window past midnight", block: map[string]interface{}{"cron": "0 * * * *", "window": "22:00-02:00"},
+			from: at(1, 2, 30), want: at(1, 22, 0)},
+		{name: "weekdays", block: map[string]interface{}{"cron": "0 9 * * *", "days": "mon-fri"},
+			from: at(5, 10, 0), want: at(8, 9, 0)},
+		{name: "days wrap", block: map[string]interface{}{"cron": "0 9 * * *", "days": "fri-mon"},
+			from: at(2, 10, 0), want: at(5, 9, 0)},
+		{name: "no allowed time", block: map[string]interface{}{"cron": "0 12 * * *", "window": "09:00-10:00"},
+			from: at(1, 0, 0), want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.block == nil {
+				tt.block = map[string]interface{}{}
+			}
+			s := newTestSchedule(t, tt.block, tt.interval)
+			if got := s.next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceScheduleMissed(t *testing.T) {
+	last := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		block map[string]interface{}
+		now   time.Time
+		limit int
+		want  int
+	}{
+		{name: "none missed", block: map[string]interface{}{"cron": "0 * * * *"}, now: last.Add(59 * time.Minute),
+			limit: 10, want: 0},
+		{name: "boundary is missed", block: map[string]interface{}{"cron": "0 * * * *"}, now: last.Add(time.Hour),
+			limit: 10, want: 1},
+		{name: "several missed", block: map[string]interface{}{"cron": "0 * * * *"}, now: last.Add(5*time.Hour + time.Minute),
+			limit: 10, want: 5},
+		{name: "up to limit", block: map[string]interface{}{"cron": "0 * * * *"}, now: last.Add(48 * time.Hour),
+			limit: 10, want: 10},
+		{name: "only in window", block: map[string]interface{}{"cron": "0 * * * *", "window": "13:00-15:00"},
+			now: last.Add(24 * time.Hour), limit: 100, want: 2}, // 13:00 and 14:00 of the first day
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSchedule(t, tt.block, 0)
+			if got := s.missed(last, tt.now, tt.limit); got != tt.want {
+				t.Errorf("missed(%v, %v, %d) = %d, want %d", last, tt.now, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+// memScheduleStore is a ScheduleStore in memory
+type memScheduleStore struct {
+	last map[string]time.Time
+}
+
+func (m *memScheduleStore) LastScheduledRead(id int, path string) (time.Time, bool) {
+	t, ok := m.last[path]
+	return t, ok
+}
+
+func (m *memScheduleStore) RecordScheduledRead(id int, path string, last time.Time, next time.Time) {
+	m.last[path] = last
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	tests := []struct {
+		catchUp string
+		want    int
+	}{
+		{"skip", 0},
+		{"once", 1},
+		{"all", 3}, // max_catch_up
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.catchUp, func(t *testing.T) {
+			s := newTestSchedule(t, map[string]interface{}{"catch_up": tt.catchUp, "max_catch_up": 3}, time.Minute)
+			store := &memScheduleStore{last: map[string]time.Time{"0": time.Now().Add(-time.Hour)}}
+
+			if got := newScheduler(s, store, 1, "0").pending; got != tt.want {
+				t.Errorf("pending = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"time"
+
+	"github.com/vaerohq/vaero/integrations/secrets"
+	"github.com/vaerohq/vaero/settings"
+)
+
+// secretRefArgs returns the args that reference secrets with ${secret:provider:path}, with their unresolved values
+func secretRefArgs(args map[string]interface{}) map[string]interface{} {
+	refs := map[string]interface{}{}
+	for k, v := range args {
+		if secrets.HasRefs(v) {
+			refs[k] = v
+		}
+	}
+	return refs
+}
+
+// resolveSecretRefs returns a copy of args with the args in refs set to their resolved values. Args are copied
+// rather than modified, as the args of a running pipeline may be read by other nodes.
+func resolveSecretRefs(args map[string]interface{}, refs map[string]interface{}) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(args))
+	for k, v := range args {
+		resolved[k] = v
+	}
+
+	for k, v := range refs {
+		value, err := secrets.Resolve(v)
+		if err != nil {
+			return nil, err
+		}
+		resolved[k] = value
+	}
+
+	return resolved, nil
+}
+
+// withResolvedRefs returns a copy of the task with the secret references in its args resolved, and SecretRefs set
+// to the args that reference secrets. A task that has already been resolved is resolved again from its SecretRefs.
+// A task without references is returned as is.
+func withResolvedRefs(task *OpTask) (*OpTask, error) {
+	refs := task.SecretRefs
+	if refs == nil {
+		refs = secretRefArgs(task.Args)
+	}
+	if len(refs) == 0 {
+		return task, nil
+	}
+
+	args, err := resolveSecretRefs(task.Args, refs)
+	if err != nil {
+		return task, err
+	}
+
+	resolved := *task
+	resolved.Args, resolved.SecretRefs = args, refs
+	return &resolved, nil
+}
+
+// secretRefsDue reports whether secret references last resolved at last are due to be resolved again, because
+// their cached values have expired
+func secretRefsDue(last time.Time) bool {
+	return time.Since(last) > time.Duration(settings.Config.SecretCacheSeconds)*time.Second
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateSecretRefs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    map[string]interface{}
+		wantArg string // empty if valid
+		wantMsg string
+	}{
+		{name: "string arg", args: map[string]interface{}{"name": "${secret:env:NAME}"}},
+		{name: "part of a string arg", args: map[string]interface{}{"name": "http-${secret:env:NAME}"}},
+		{name: "int arg", args: map[string]interface{}{"port": "${secret:env:PORT}"}, wantArg: "port",
+			wantMsg: "secret references may only be used in string args, and port is of type int"},
+		{name: "int arg without reference", args: map[string]interface{}{"port": "8080"}, wantArg: "port",
+			wantMsg: "must be of type int, got string"},
+		{name: "unknown provider", args: map[string]interface{}{"name": "${secret:keychain:NAME}"}, wantArg: "name",
+			wantMsg: `unknown secret provider "keychain"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskGraph := []OpTask{{Type: "source", Op: "http_server", Args: tt.args}, {Type: "sink", Op: "stdout"}}
+			err := ValidateTaskGraph(taskGraph)
+
+			if tt.wantArg == "" {
+				if err != nil {
+					t.Fatalf("ValidateTaskGraph returned error: %v", err)
+				}
+				return
+			}
+
+			var errs ValidationErrors
+			if !errors.As(err, &errs) || len(errs) != 1 {
+				t.Fatalf("ValidateTaskGraph = %v, want one error", err)
+			}
+			if errs[0].Arg != tt.wantArg || !strings.Contains(errs[0].Msg, tt.wantMsg) {
+				t.Errorf("error = %v, want arg %s: %s", errs[0], tt.wantArg, tt.wantMsg)
+			}
+		})
+	}
+}
@@ -4,7 +4,7 @@ Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
 package execute
 
 import (
-	"strings"
+	"reflect"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,19 +18,28 @@ import (
 )
 
 // initSinkNode performs initialize for the sink node
-func initSinkNode(snks map[uuid.UUID]*sinks.SinkConfig /*sinkTargets []uuid.UUID*/, taskGraph []OpTask, timeChan chan capsule.SinkTimerCapsule) {
+func initSinkNode(snks map[uuid.UUID]*sinks.SinkConfig /*sinkTargets []uuid.UUID*/, taskGraph []OpTask, timeChan chan capsule.SinkTimerCapsule, g *graphRun) {
 
-	initSinksFromTaskGraph(snks, taskGraph, timeChan)
+	initSinksFromTaskGraph(snks, taskGraph, timeChan, g)
 }
 
 // initSinks finds all the sinks in the task graph and initializes them
-func initSinksFromTaskGraph(snks map[uuid.UUID]*sinks.SinkConfig, taskGraph []OpTask, timeChan chan capsule.SinkTimerCapsule) {
+func initSinksFromTaskGraph(snks map[uuid.UUID]*sinks.SinkConfig, taskGraph []OpTask, timeChan chan capsule.SinkTimerCapsule, g *graphRun) {
 	for _, v := range taskGraph {
 		if v.Type == "sink" {
+			// Resolve the secrets referenced by the args, into a copy of the task so that the task graph keeps the
+			// references. A sink whose secrets cannot be resolved is not started, and fails the pipeline.
+			sinkTask, err := withResolvedRefs(&v)
+			if err != nil {
+				log.Logger.Error("Could not resolve secrets of sink", zap.String("Sink", v.Op), zap.String("Error", err.Error()))
+				g.run.fail(nodeFailure{node: "sinkNode", err: err.Error()})
+				continue
+			}
+
 			// Set timestamp format
 			var timestampFormat string
-			switch strings.ToLower(v.Args["timestamp_format"].(string)) {
-			case "rfc3339":
+			switch sinkTask.StringArg("timestamp_format") {
+			case "RFC3339":
 				timestampFormat = time.RFC3339
 			case "unix":
 				timestampFormat = time.UnixDate
@@ -41,29 +50,42 @@ func initSinksFromTaskGraph(snks map[uuid.UUID]*sinks.SinkConfig, taskGraph []Op
 			// Create configuration for a sink
 			snks[v.Id] = &sinks.SinkConfig{Id: v.Id, Type: v.Op, Prefix: make(map[string]*sinks.SinkBuffer),
 				FlushChan: make(chan capsule.Capsule, settings.Config.DefaultChanBufferLen), TimeChan: timeChan,
-				BatchMaxBytes: int(v.Args["batch_max_bytes"].(float64)), BatchMaxTime: int(v.Args["batch_max_time"].(float64)),
-				Bucket:         v.Args["bucket"].(string),
-				FilenamePrefix: v.Args["filename_prefix"].(string), FilenameFormat: v.Args["filename_format"].(string),
-				Region:       v.Args["region"].(string),
-				TimestampKey: v.Args["timestamp_key"].(string), TimestampFormat: timestampFormat}
+				BatchMaxBytes: sinkTask.IntArg("batch_max_bytes"), BatchMaxTime: sinkTask.IntArg("batch_max_time"),
+				Bucket:         sinkTask.StringArg("bucket"),
+				FilenamePrefix: sinkTask.StringArg("filename_prefix"), FilenameFormat: sinkTask.StringArg("filename_format"),
+				Region:       sinkTask.StringArg("region"),
+				TimestampKey: sinkTask.StringArg("timestamp_key"), TimestampFormat: timestampFormat,
+				Args: sinkTask.Args, Secret: v.Secret,
+				SecretsCacheTime: time.Duration(v.SecretInt("cache_time_seconds")) * time.Second,
+				SecretsTimeout:   time.Duration(v.SecretInt("timeout_seconds")) * time.Second,
+				SecretRefs:       sinkTask.SecretRefs, LastRefsRefresh: time.Now()}
 
 			//fmt.Printf("Sinkconfig %v\n", snks[v.Id])
 
+			if node := g.metrics.node(v.Id); node != nil {
+				g.metrics.watchChannel("flush", node.path, snks[v.Id].FlushChan)
+			}
+
 			// Create goroutine to flush to the sink
-			go flushNode(snks[v.Id])
+			g.add(1)
+			go flushNode(g, snks[v.Id])
 		} else if v.Type == "branch" {
 			for _, branch := range v.Branches {
-				initSinksFromTaskGraph(snks, branch, timeChan)
+				initSinksFromTaskGraph(snks, branch, timeChan, g)
 			}
 		}
 	}
 }
 
 // sinkBatch adds events to a sink buffer and flushes if needed
-func sinkBatch(c *capsule.Capsule, sinks map[uuid.UUID]*sinks.SinkConfig) {
+func sinkBatch(c *capsule.Capsule, sinks map[uuid.UUID]*sinks.SinkConfig, m *pipelineMetrics) {
 
-	// Identify sinkConfig
-	sinkConfig := sinks[c.SinkId]
+	// Identify sinkConfig. Sinks that were not started, because their secrets could not be resolved, drop events until
+	// the failed pipeline is stopped.
+	sinkConfig, ok := sinks[c.SinkId]
+	if !ok {
+		return
+	}
 	eventList := c.EventList
 
 	timeField := sinkConfig.TimestampKey
@@ -97,12 +119,14 @@ func sinkBatch(c *capsule.Capsule, sinks map[uuid.UUID]*sinks.SinkConfig) {
 		}
 
 		// Append to selected buffer
-		sinkAddToBuffer(sinkBuffer, sinkConfig, prefix, event)
+		sinkAddToBuffer(sinkBuffer, sinkConfig, prefix, event, m)
 	}
+
+	m.sinkBuffered(sinkConfig)
 }
 
 // sinkAddToBuffer adds an event to the buffer, and flushes if write out criteria is met
-func sinkAddToBuffer(sinkBuffer *sinks.SinkBuffer, sinkConfig *sinks.SinkConfig, prefix string, event string) {
+func sinkAddToBuffer(sinkBuffer *sinks.SinkBuffer, sinkConfig *sinks.SinkConfig, prefix string, event string, m *pipelineMetrics) {
 
 	// len gets the number of bytes in string, not the number of characters in the string
 	if len(event)+sinkBuffer.Size <= sinkConfig.BatchMaxBytes {
@@ -112,7 +136,7 @@ func sinkAddToBuffer(sinkBuffer *sinks.SinkBuffer, sinkConfig *sinks.SinkConfig,
 		log.Logger.Info("Flush: MaxBytes")
 
 		// Sends buffer to be flushed, and deletes buffer
-		flushSinkBuffer(sinkConfig, prefix, sinkBuffer)
+		flushSinkBuffer(sinkConfig, prefix, sinkBuffer, flushMaxBytes, m)
 
 		// Create new buffer
 		sinkBuffer = createSinkBuffer(sinkConfig, prefix)
@@ -130,31 +154,30 @@ func startSinkTimer(delay int, timeChan chan capsule.SinkTimerCapsule, tc capsul
 	timeChan <- tc
 }
 
-func flushNode(sinkConfig *sinks.SinkConfig) {
+func flushNode(g *graphRun, sinkConfig *sinks.SinkConfig) {
+	defer g.nodeDone()
+	defer g.run.recoverNode("flushNode", func() { go drainCapsules(sinkConfig.FlushChan) })
+
+	m, h := g.metrics, g.run.health
+
 	defer func() {
 		log.Logger.Info("Closing sinkFlusher", zap.String("id", sinkConfig.Id.String()), zap.String("Type", sinkConfig.Type))
 	}()
 
 	// Choose sink type based on taskGraph
-	var s sinks.Sink
-	switch sinkConfig.Type {
-	case "stdout":
-		s = &sinks.StdoutSink{}
-	case "s3":
-		s = &sinks.S3Sink{}
-	case "datadog":
-		s = &sinks.DatadogSink{}
-	case "elastic":
-		s = &sinks.ElasticSink{}
-	case "splunk":
-		s = &sinks.SplunkSink{}
-	default:
+	p, ok := sinkPlugins[sinkConfig.Type]
+	if !ok {
 		log.Logger.Error("Unknown sink", zap.String("sink", sinkConfig.Type))
 		return
 	}
+	s := p.New()
 
-	// Initialize sink
+	// Initialize sink, with the values of its secrets
+	refreshSinkSecrets(sinkConfig)
 	s.Init(sinkConfig)
+	if closer, ok := s.(sinks.Closer); ok {
+		defer closer.Close()
+	}
 
 	// Main loop
 	for {
@@ -165,29 +188,85 @@ func flushNode(sinkConfig *sinks.SinkConfig) {
 			return
 		}
 
+		// Refresh secrets if needed, replacing the credentials of the sink. Buffered events are held by the sink node,
+		// so none are dropped.
+		if refreshSinkSecrets(sinkConfig) {
+			if refresher, ok := s.(sinks.Refresher); ok {
+				refresher.Refresh(sinkConfig)
+			}
+		}
+
 		// Flush
 		if len(event.EventList) > 0 {
-			s.Flush(event.Filename, event.Prefix, event.EventList)
+			tapEvents(sinkConfig.Id, event.EventList)
+
+			start := time.Now()
+			err := s.Flush(event.Filename, event.Prefix, event.EventList)
+			m.sinkWritten(sinkConfig, time.Since(start).Seconds(), err)
+			h.sinkFlushed(sinkConfig.Id, err)
+
+			if err != nil {
+				log.Logger.Error("Flush failed", zap.String("Type", sinkConfig.Type), zap.String("Prefix", event.Prefix),
+					zap.String("Error", err.Error()))
+			} else {
+				m.node(sinkConfig.Id).out(event.EventList)
+			}
 		}
 	}
 }
 
+// refreshSinkSecrets runs the secret command of the sink, and resolves the secrets referenced by its args, if they
+// are due to be refreshed, and sets their values in the args of the sink. It returns whether the args were updated.
+func refreshSinkSecrets(sinkConfig *sinks.SinkConfig) bool {
+	refreshed := false
+
+	if len(sinkConfig.Secret) != 0 && time.Now().Sub(sinkConfig.LastSecretsRefresh) > sinkConfig.SecretsCacheTime {
+		sinkConfig.LastSecretsRefresh = time.Now()
+
+		newSecrets, err := getSecrets(sinkConfig.Secret, sinkConfig.SecretsTimeout)
+		if err != nil {
+			log.Logger.Error("Error refreshing secrets", zap.String("Sink", sinkConfig.Type), zap.String("Error", err.Error()))
+		} else {
+			sinkConfig.Args = withSecrets("sink", sinkConfig.Type, sinkConfig.Args, newSecrets)
+			log.Logger.Info("Refreshed secrets", zap.String("Sink", sinkConfig.Type))
+			refreshed = true
+		}
+	}
+
+	if len(sinkConfig.SecretRefs) != 0 && secretRefsDue(sinkConfig.LastRefsRefresh) {
+		sinkConfig.LastRefsRefresh = time.Now()
+
+		args, err := resolveSecretRefs(sinkConfig.Args, sinkConfig.SecretRefs)
+		if err != nil {
+			log.Logger.Error("Error refreshing secrets", zap.String("Sink", sinkConfig.Type), zap.String("Error", err.Error()))
+		} else if !reflect.DeepEqual(args, sinkConfig.Args) {
+			sinkConfig.Args = args
+			log.Logger.Info("Refreshed secrets", zap.String("Sink", sinkConfig.Type))
+			refreshed = true
+		}
+	}
+
+	return refreshed
+}
+
 // closeSinks closes all the sinks
-func closeSinks(snks map[uuid.UUID]*sinks.SinkConfig) {
+func closeSinks(snks map[uuid.UUID]*sinks.SinkConfig, m *pipelineMetrics) {
 	for _, sink := range snks {
-		flushSinkBuffers(sink)
+		flushSinkBuffers(sink, m)
 		close(sink.FlushChan)
+		m.removeSink(sink)
 	}
 }
 
 // flushSinkBuffers flushes all the buffers of a sink
-func flushSinkBuffers(sink *sinks.SinkConfig) {
+func flushSinkBuffers(sink *sinks.SinkConfig, m *pipelineMetrics) {
 	for prefix, v := range sink.Prefix {
-		flushSinkBuffer(sink, prefix, v)
+		flushSinkBuffer(sink, prefix, v, flushShutdown, m)
 	}
 }
 
-func flushSinkBuffer(sinkConfig *sinks.SinkConfig, prefix string, sinkBuffer *sinks.SinkBuffer) {
+// flushSinkBuffer sends the buffer to be flushed by the flushNode. trigger records why the buffer was flushed.
+func flushSinkBuffer(sinkConfig *sinks.SinkConfig, prefix string, sinkBuffer *sinks.SinkBuffer, trigger string, m *pipelineMetrics) {
 	// Generate filename
 	filenameFormatter, err := strftime.New(sinkConfig.FilenameFormat, strftime.WithUnixSeconds('s'))
 	if err != nil {
@@ -221,6 +300,8 @@ func flushSinkBuffer(sinkConfig *sinks.SinkConfig, prefix string, sinkBuffer *si
 	sinkBuffer = nil
 	delete(sinkConfig.Prefix, prefix)
 
+	m.sinkFlushed(sinkConfig, trigger)
+
 	//fmt.Printf("Delete sinkbuffer for %v\n", prefix)
 }
 
@@ -239,7 +320,7 @@ func createSinkBuffer(sinkConfig *sinks.SinkConfig, prefix string) *sinks.SinkBu
 	return sinkBuffer
 }
 
-func handleSinkTimer(tc capsule.SinkTimerCapsule, snks map[uuid.UUID]*sinks.SinkConfig) {
+func handleSinkTimer(tc capsule.SinkTimerCapsule, snks map[uuid.UUID]*sinks.SinkConfig, m *pipelineMetrics) {
 
 	sinkConfig := snks[tc.SinkId]
 	sinkBuffer, found := snks[tc.SinkId].Prefix[tc.Prefix]
@@ -249,6 +330,7 @@ func handleSinkTimer(tc capsule.SinkTimerCapsule, snks map[uuid.UUID]*sinks.Sink
 	if found && sinkBuffer.LastFlush == tc.LastFlush {
 		log.Logger.Info("Flush: MaxTime")
 		// Sends buffer to be flushed, and resets buffer
-		flushSinkBuffer(sinkConfig, tc.Prefix, sinkBuffer)
+		flushSinkBuffer(sinkConfig, tc.Prefix, sinkBuffer, flushMaxTime, m)
+		m.sinkBuffered(sinkConfig)
 	}
 }
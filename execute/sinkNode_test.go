@@ -0,0 +1,161 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vaerohq/vaero/capsule"
+	"github.com/vaerohq/vaero/integrations/sinks"
+)
+
+// refreshTestSink is a sink that records the token of every flush, and the tokens it is refreshed with
+type refreshTestSink struct {
+	mu        sync.Mutex
+	token     string
+	refreshes []string
+	flushed   chan refreshTestFlush
+}
+
+type refreshTestFlush struct {
+	token  string
+	events []string
+}
+
+func (s *refreshTestSink) Init(sinkConfig *sinks.SinkConfig) {
+	s.token = sinkConfig.StringArg("token")
+}
+
+func (s *refreshTestSink) Refresh(sinkConfig *sinks.SinkConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = sinkConfig.StringArg("token")
+	s.refreshes = append(s.refreshes, s.token)
+}
+
+func (s *refreshTestSink) Flush(filename string, prefix string, eventList []string) error {
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+	s.flushed <- refreshTestFlush{token: token, events: eventList}
+	return nil
+}
+
+// writeSecretScript writes a secret command that prints the token in the file token of dir, and records each run in
+// the file calls. It fails while the file fail exists.
+func writeSecretScript(t *testing.T, dir string) string {
+	t.Helper()
+
+	script := filepath.Join(dir, "secret.sh")
+	body := "#!/bin/sh\ncat > /dev/null\necho run >> " + filepath.Join(dir, "calls") + "\n" +
+		"if [ -e " + filepath.Join(dir, "fail") + " ]; then exit 1; fi\n" +
+		`printf '{"token": "%s"}' "$(cat ` + filepath.Join(dir, "token") + `)"` + "\n"
+	if err := os.WriteFile(script, []byte(body), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestFlushNodeRefreshesSecrets(t *testing.T) {
+	dir := t.TempDir()
+	script := writeSecretScript(t, dir)
+	setToken := func(token string) {
+		if err := os.WriteFile(filepath.Join(dir, "token"), []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	calls := func() int {
+		data, _ := os.ReadFile(filepath.Join(dir, "calls"))
+		return strings.Count(string(data), "run")
+	}
+	setToken("v1")
+
+	snk := &refreshTestSink{flushed: make(chan refreshTestFlush)}
+	sinkPlugins["refresh_test"] = &SinkPlugin{Op: "refresh_test", New: func() sinks.Sink { return snk }}
+	t.Cleanup(func() { delete(sinkPlugins, "refresh_test") })
+
+	cacheTime := 200 * time.Millisecond
+	sinkConfig := &sinks.SinkConfig{Id: uuid.New(), Type: "refresh_test", FlushChan: make(chan capsule.Capsule),
+		Args:             map[string]interface{}{"token": "", "batch_max_time": 1},
+		Secret:           map[string]interface{}{"command": script, "secrets": []interface{}{}},
+		SecretsCacheTime: cacheTime, SecretsTimeout: 5 * time.Second}
+
+	run := &pipelineRun{id: 1, failures: make(chan nodeFailure, 1)}
+	g := &graphRun{run: run}
+	g.add(1)
+	go flushNode(g, sinkConfig)
+
+	// flush sends a batch to the flush node, and returns the flush of the sink
+	flush := func(events ...string) refreshTestFlush {
+		t.Helper()
+		sinkConfig.FlushChan <- capsule.Capsule{Filename: "f", Prefix: "p", EventList: events}
+		select {
+		case f := <-snk.flushed:
+			return f
+		case <-time.After(5 * time.Second):
+			t.Fatal("batch was not flushed")
+			return refreshTestFlush{}
+		}
+	}
+
+	tests := []struct {
+		name      string
+		step      func()
+		events    []string
+		wantToken string
+		wantCalls int
+	}{
+		{name: "initial secrets", events: []string{"a"}, wantToken: "v1", wantCalls: 1},
+		{name: "cached", step: func() { setToken("v2") }, events: []string{"b"}, wantToken: "v1", wantCalls: 1},
+		{name: "refreshed after cache time", step: func() { time.Sleep(cacheTime + 50*time.Millisecond) },
+			events: []string{"c", "d"}, wantToken: "v2", wantCalls: 2},
+		{name: "failed refresh keeps credentials", step: func() {
+			setToken("v3")
+			os.WriteFile(filepath.Join(dir, "fail"), nil, 0600)
+			time.Sleep(cacheTime + 50*time.Millisecond)
+		}, events: []string{"e"}, wantToken: "v2", wantCalls: 3},
+		{name: "refreshed after failure", step: func() {
+			os.Remove(filepath.Join(dir, "fail"))
+			time.Sleep(cacheTime + 50*time.Millisecond)
+		}, events: []string{"f", "g", "h"}, wantToken: "v3", wantCalls: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.step != nil {
+				tt.step()
+			}
+
+			f := flush(tt.events...)
+			if f.token != tt.wantToken {
+				t.Errorf("flushed with token %q, want %q", f.token, tt.wantToken)
+			}
+			if !reflect.DeepEqual(f.events, tt.events) {
+				t.Errorf("flushed events %v, want %v", f.events, tt.events)
+			}
+			if got := calls(); got != tt.wantCalls {
+				t.Errorf("secret command ran %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+
+	close(sinkConfig.FlushChan)
+	g.wg.Wait()
+
+	snk.mu.Lock()
+	defer snk.mu.Unlock()
+	if want := []string{"v2", "v3"}; !reflect.DeepEqual(snk.refreshes, want) {
+		t.Errorf("sink was refreshed with %v, want %v", snk.refreshes, want)
+	}
+	if sinkConfig.Args["batch_max_time"] != 1 {
+		t.Errorf("refresh lost the other args of the sink: %v", sinkConfig.Args)
+	}
+}
@@ -4,8 +4,10 @@ Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
 package execute
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os/exec"
@@ -13,8 +15,11 @@ import (
 
 	"github.com/tidwall/gjson"
 	"github.com/vaerohq/vaero/capsule"
+	"github.com/vaerohq/vaero/integrations/httppoll"
 	"github.com/vaerohq/vaero/integrations/sources"
+	"github.com/vaerohq/vaero/integrations/wasm"
 	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/schema"
 	"go.uber.org/zap"
 )
 
@@ -23,27 +28,21 @@ type SourceConfig struct {
 	Interval           time.Duration
 	LastExecution      time.Time
 	LastSecretsRefresh time.Time
+	LastRefsRefresh    time.Time
 	SecretsCacheTime   time.Duration
 	SecretsTimeout     time.Duration
+	Schedule           *sourceSchedule // nil to read every Interval
 }
 
 func initSourceConfig(sourceTask *OpTask) SourceConfig {
 	sourceConfig := SourceConfig{SourceTask: sourceTask}
 
-	val, ok := sourceTask.Args["interval"]
+	sourceConfig.Interval = time.Duration(sourceTask.IntArg("interval")) * time.Second
+	sourceConfig.SecretsCacheTime = time.Duration(sourceTask.SecretInt("cache_time_seconds")) * time.Second
+	sourceConfig.SecretsTimeout = time.Duration(sourceTask.SecretInt("timeout_seconds")) * time.Second
 
-	if ok {
-		sourceConfig.Interval = time.Duration(val.(float64)) * time.Second
-	}
-
-	val, ok = sourceTask.Secret["cache_time_seconds"]
-	if ok {
-		sourceConfig.SecretsCacheTime = time.Duration(val.(float64)) * time.Second
-	}
-
-	val, ok = sourceTask.Secret["timeout_seconds"]
-	if ok {
-		sourceConfig.SecretsTimeout = time.Duration(val.(float64)) * time.Second
+	if len(sourceTask.Schedule) != 0 {
+		sourceConfig.Schedule, _ = parseSchedule(sourceTask.Schedule, sourceConfig.Interval) // validated with the task graph
 	}
 
 	//fmt.Printf("sourceConfig %v\n", sourceConfig)
@@ -51,79 +50,144 @@ func initSourceConfig(sourceTask *OpTask) SourceConfig {
 	return sourceConfig
 }
 
+// createSource creates the source of a source task with the registered source of its op
 func createSource(sourceTask *OpTask, srcOut chan capsule.Capsule) (sources.Source, error) {
-	var source sources.Source
-
-	switch sourceTask.Op {
-	case "http_server":
-		source = &sources.HTTPServerSource{
-			Endpoint:     sourceTask.Args["endpoint"].(string),
-			EventBreaker: sourceTask.Args["event_breaker"].(string),
-			Name:         sourceTask.Args["name"].(string),
-			Port:         int(sourceTask.Args["port"].(float64)),
-			SrcOut:       srcOut,
-		}
-	case "okta":
-		source = &sources.OktaSource{
-			Interval:             int(sourceTask.Args["interval"].(float64)),
-			Host:                 sourceTask.Args["host"].(string),
-			Token:                sourceTask.Args["token"].(string),
-			Name:                 sourceTask.Args["name"].(string),
-			Max_calls_per_period: int(sourceTask.Args["max_calls_per_period"].(float64)),
-			Limit_period:         int(sourceTask.Args["limit_period"].(float64)),
-			Max_retries:          int(sourceTask.Args["max_retries"].(float64)),
-		}
-	case "random":
-		source = &sources.RandomSource{
-			Name: sourceTask.Args["name"].(string),
-		}
-	case "s3":
-		source = &sources.S3Source{
-			Bucket: sourceTask.Args["bucket"].(string),
-			Prefix: sourceTask.Args["prefix"].(string),
-			Region: sourceTask.Args["region"].(string),
-		}
-	default:
+	p, ok := sourcePlugins[sourceTask.Op]
+	if !ok {
 		log.Logger.Error("Source not found", zap.String("Source", sourceTask.Op))
 		return nil, errors.New("Source not found")
 	}
 
-	return source, nil
+	return p.New(sourceTask, srcOut)
 }
 
-func updateSource(source sources.Source, task *OpTask) sources.Source {
-	var updatedSource sources.Source
-
-	switch task.Op {
-	case "okta":
-		updatedSource = &sources.OktaSource{
-			Interval:             int(task.Args["interval"].(float64)),
-			Host:                 task.Args["host"].(string),
-			Token:                task.Args["token"].(string),
-			Name:                 task.Args["name"].(string),
-			Max_calls_per_period: int(task.Args["max_calls_per_period"].(float64)),
-			Limit_period:         int(task.Args["limit_period"].(float64)),
-			Max_retries:          int(task.Args["max_retries"].(float64)),
+// newHTTPPollSource returns a source that polls the REST API configured by the args of the task
+func newHTTPPollSource(task *OpTask) *sources.HTTPPollSource {
+	return &sources.HTTPPollSource{
+		Name: task.StringArg("name"),
+		Poller: httppoll.NewPoller(httppoll.Config{
+			URL:     task.StringArg("url"),
+			Method:  task.StringArg("method"),
+			Body:    task.StringArg("body"),
+			Headers: task.StringMapArg("headers"),
+			Params:  task.StringMapArg("params"),
+			Auth: httppoll.Auth{
+				Scheme:   task.StringArg("auth"),
+				Token:    task.StringArg("token"),
+				Username: task.StringArg("username"),
+				Password: task.StringArg("password"),
+				Name:     task.StringArg("auth_name"),
+				Prefix:   task.StringArg("auth_prefix"),
+			},
+			RateLimit: httppoll.RateLimit{
+				MaxCallsPerPeriod: task.IntArg("max_calls_per_period"),
+				Period:            time.Duration(task.IntArg("limit_period")) * time.Second,
+				MaxRetries:        task.IntArg("max_retries"),
+				Backoff:           time.Duration(task.IntArg("backoff_seconds")) * time.Second,
+				MaxBackoff:        time.Duration(task.IntArg("max_backoff_seconds")) * time.Second,
+			},
+			Pagination: httppoll.Pagination{
+				Type:       task.StringArg("pagination"),
+				CursorPath: task.StringArg("cursor_path"),
+				Param:      task.StringArg("page_param"),
+				LimitParam: task.StringArg("limit_param"),
+				PageSize:   task.IntArg("page_size"),
+				MaxPages:   task.IntArg("max_pages"),
+			},
+			Incremental: httppoll.Incremental{
+				TimeField:  task.StringArg("time_field"),
+				IDField:    task.StringArg("id_field"),
+				TimeParam:  task.StringArg("time_param"),
+				TimeFormat: task.StringArg("time_format"),
+				Lookback:   time.Duration(task.IntArg("lookback_seconds")) * time.Second,
+			},
+			EventsPath: task.StringArg("events_path"),
+			Timeout:    time.Duration(task.IntArg("timeout_seconds")) * time.Second,
+		}),
+	}
+}
+
+// pythonConnectors are the sources with their own op that are implemented by a Python connector, as the module and
+// class of the connector
+var pythonConnectors = map[string][2]string{
+	"okta": {"integrations.python.source_okta", "OktaSource"},
+}
+
+// newPythonSource returns a source that runs the Python connector of the task. Every arg but module, class, and
+// secret_args is passed through to the connector. The values of secret args, and of args that reference secrets, are
+// redacted from the output of the connector.
+func newPythonSource(task *OpTask) *sources.PythonSource {
+	source := &sources.PythonSource{
+		Op:     task.Op,
+		Module: task.StringArg("module"),
+		Class:  task.StringArg("class"),
+		Args:   map[string]interface{}{},
+	}
+	if connector, ok := pythonConnectors[task.Op]; ok {
+		source.Module, source.Class = connector[0], connector[1]
+	}
+
+	secretArgs := SecretArgs(task.Type, task.Op, task.Args, task.Secret)
+	for k, v := range task.Args {
+		switch k {
+		case "module", "class", "secret_args":
+			continue
 		}
-	case "random":
-		// nothing to update
-	case "s3":
-		source = &sources.S3Source{
-			Bucket: task.Args["bucket"].(string),
-			Prefix: task.Args["prefix"].(string),
-			Region: task.Args["region"].(string),
+
+		source.Args[k] = v
+		_, ref := task.SecretRefs[k]
+		if str, ok := v.(string); ok && str != "" && (secretArgs[k] || ref) {
+			source.Secrets = append(source.Secrets, str)
 		}
-	default:
-		log.Logger.Error("Source not found", zap.String("Source", task.Op))
+	}
+
+	return source
+}
+
+// updateSource creates the source again with the args of the task, after its secrets are refreshed. Sources that do
+// not take secrets, or that cannot be created with the new args, are kept, so that reads continue.
+func updateSource(source sources.Source, task *OpTask) sources.Source {
+	p, ok := sourcePlugins[task.Op]
+	if !ok || !p.SecretsRefresh {
+		return source
+	}
+
+	updatedSource, err := p.New(task, nil)
+	if err != nil {
+		log.Logger.Error("Could not update source", zap.String("Source", task.Op), zap.String("Error", err.Error()))
+		return source
 	}
 
 	return updatedSource
 }
 
-// getSecrets runs the command to retrieve secrets and returns the json parsed output from the command as a map
-func getSecrets(secret map[string]interface{}) (map[string]interface{}, error) {
+// newWasmSource returns a source that reads with the plugin named by the task
+func newWasmSource(task *OpTask) (*sources.WasmSource, error) {
+	config, _ := task.Args["config"].(map[string]interface{})
+	plugin, err := wasm.Open(task.StringArg("plugin"), config, time.Duration(task.IntArg("timeout_seconds"))*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if !plugin.Supports(wasm.Read) {
+		plugin.Close()
+		return nil, fmt.Errorf("plugin %s does not implement read", plugin.Name)
+	}
+
+	return &sources.WasmSource{Plugin: plugin}, nil
+}
+
+// getSecrets runs the command to retrieve secrets and returns the json parsed output from the command as a map. The
+// command is killed if it runs for longer than timeout.
+func getSecrets(secret map[string]interface{}, timeout time.Duration) (map[string]interface{}, error) {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	// Generate command
-	cmd := exec.Command(secret["command"].(string))
+	command, _ := secret["command"].(string)
+	cmd := exec.CommandContext(ctx, command)
 
 	// Connect stdin and stdout
 	stdin, err := cmd.StdinPipe()
@@ -137,8 +201,6 @@ func getSecrets(secret map[string]interface{}) (map[string]interface{}, error) {
 		log.Logger.Error("Error opening stdout pipe", zap.String("Error", err.Error()))
 		return make(map[string]interface{}), errors.New("Error opening stdout pipe")
 	}
-	defer stdout.Close()
-
 	// Execute the command
 	if err := cmd.Start(); err != nil {
 		log.Logger.Error("Error executing secrets script", zap.String("Error", err.Error()))
@@ -154,31 +216,64 @@ func getSecrets(secret map[string]interface{}) (map[string]interface{}, error) {
 	io.WriteString(stdin, string(jsonSecrets))
 	stdin.Close()
 
-	// Read stdout of the command
-	buf, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		log.Logger.Error("Error reading stdout pipe", zap.String("Error", err.Error()))
-		return make(map[string]interface{}), errors.New("Error reading stdout pipe")
+	// Read stdout of the command, and wait for it to complete. Processes started by the command may keep stdout open
+	// after the command is killed, so the result is not waited for once the timeout has passed.
+	type result struct {
+		buf []byte
+		err error
 	}
+	done := make(chan result, 1)
+	go func() {
+		buf, err := ioutil.ReadAll(stdout)
+		if waitErr := cmd.Wait(); err == nil {
+			err = waitErr
+		}
+		done <- result{buf: buf, err: err}
+	}()
 
-	// Wait for the command to complete
-	if err := cmd.Wait(); err != nil {
-		log.Logger.Error("Error waiting for secrets script", zap.String("Error", err.Error()))
-		return make(map[string]interface{}), errors.New("Error waiting for secrets script")
+	var buf []byte
+	select {
+	case res := <-done:
+		if res.err != nil {
+			log.Logger.Error("Error waiting for secrets script", zap.String("Error", res.err.Error()))
+			return make(map[string]interface{}), errors.New("Error waiting for secrets script")
+		}
+		buf = res.buf
+	case <-ctx.Done():
+		return make(map[string]interface{}), fmt.Errorf("secrets script did not finish within %s", timeout)
 	}
 
-	//fmt.Printf("Retrieved secrets %v\n", string(buf))
-
-	// Parse in format {"arg1" : value1, "arg2" : value2}
-	secretsMap := gjson.Parse(string(buf)).Value().(map[string]interface{})
+	// Parse in format {"arg1" : value1, "arg2" : value2}. The output is not logged, as it holds the secrets.
+	secretsMap, ok := gjson.ParseBytes(buf).Value().(map[string]interface{})
+	if !gjson.ValidBytes(buf) || !ok {
+		return make(map[string]interface{}), errors.New("secrets script must print a json object of {arg : value}")
+	}
 
 	return secretsMap, nil
 }
 
 // applySecrets adds the new secrets as arguments to the source task
 func applySecrets(sourceTask *OpTask, newSecrets map[string]interface{}) {
+	sourceTask.Args = withSecrets(sourceTask.Type, sourceTask.Op, sourceTask.Args, newSecrets)
+}
+
+// withSecrets returns a copy of args with the new secrets set, converted to the types declared by the schema of the
+// op. Args are copied rather than modified, as the args of a running pipeline may be read by other nodes.
+func withSecrets(opType string, op string, args map[string]interface{}, newSecrets map[string]interface{}) map[string]interface{} {
+	updated := make(map[string]interface{}, len(args)+len(newSecrets))
+	for k, v := range args {
+		updated[k] = v
+	}
 	for k, v := range newSecrets {
-		sourceTask.Args[k] = v
-		//fmt.Printf("Assigned to source task[%v] = %v", k, v)
+		updated[k] = v
 	}
+
+	// Convert the new values to the types declared by the schema
+	if opSchema, ok := schema.Lookup(opType, op); ok {
+		for _, e := range opSchema.Apply(updated, nil) {
+			log.Logger.Error("Invalid secret value", zap.String("Arg", e.Arg), zap.String("Error", e.Msg))
+		}
+	}
+
+	return updated
 }
@@ -0,0 +1,348 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/vaerohq/vaero/capsule"
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/schema"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+)
+
+// stopTimeout is how long to wait for the nodes of a pipeline to finish after they are told to stop
+const stopTimeout = 30 * time.Second
+
+// PipelineFailure describes a pipeline that stopped without being asked to, such as when a node panics
+type PipelineFailure struct {
+	PipelineId   int
+	Time         time.Time
+	Node         string // the node that failed, such as transformNode
+	Error        string
+	Stack        string        // stack trace of a panic
+	Restarts     int           // restarts since the pipeline last ran successfully
+	Restarting   bool          // whether the pipeline will be restarted
+	RestartDelay time.Duration // delay before the restart
+}
+
+// restartPolicy decides whether a failed pipeline is restarted
+type restartPolicy struct {
+	Policy     string // always, on-failure, or never
+	MaxRetries int    // 0 for no limit
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// restartPolicyOf returns the restart policy set on the source or union task, or the default policy
+func restartPolicyOf(sourceTask *OpTask) restartPolicy {
+	block := map[string]interface{}{}
+	for k, v := range sourceTask.Restart {
+		block[k] = v
+	}
+	schema.Restart().Apply(block, nil) // validated when the task graph was generated, so only fills defaults
+
+	policy, _ := block["policy"].(string)
+	maxRetries, _ := block["max_retries"].(int)
+	backoff, _ := block["backoff_seconds"].(int)
+	maxBackoff, _ := block["max_backoff_seconds"].(int)
+
+	return restartPolicy{
+		Policy:     policy,
+		MaxRetries: maxRetries,
+		Backoff:    time.Duration(backoff) * time.Second,
+		MaxBackoff: time.Duration(maxBackoff) * time.Second,
+	}
+}
+
+// shouldRestart decides whether to restart after a failure, given the number of restarts since the pipeline last
+// ran successfully. A clean exit is a pipeline whose nodes all finished without an error.
+func (p restartPolicy) shouldRestart(cleanExit bool, restarts int) bool {
+	switch p.Policy {
+	case "always":
+		return true
+	case "on-failure":
+		return !cleanExit && (p.MaxRetries == 0 || restarts < p.MaxRetries)
+	default:
+		return false
+	}
+}
+
+// delay returns the exponential backoff before a restart
+func (p restartPolicy) delay(restarts int) time.Duration {
+	delay := p.Backoff
+	for i := 0; i < restarts && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// pipelineRun is a single run of the nodes of a pipeline. Each restart of a pipeline is a new run. The source of a
+// run keeps running when the pipeline is updated, but its transforms and sinks are replaced by a new graphRun.
+type pipelineRun struct {
+	id          int
+	done        chan struct{} // closed to tell the sources to stop
+	stopOnce    sync.Once
+	taskGraph   []OpTask // task graph of the current graphRun
+	sourceTask  OpTask   // the source or union task as it was when the run started, to compare with updates
+	metrics     *pipelineMetrics
+	health      *pipelineHealth
+	wg          sync.WaitGroup // counts running nodes
+	failures    chan nodeFailure
+	swaps       chan swapRequest // tells the switchNode to send the source output to a new graphRun
+	finished    chan struct{}    // closed when every node has finished
+	schedules   ScheduleStore    // may be nil
+	checkpoints CheckpointStore  // may be nil
+}
+
+// nodeFailure is a panic recovered from a node
+type nodeFailure struct {
+	node  string
+	err   string
+	stack string
+}
+
+// recoverNode recovers a panic in a node and reports it to the supervisor. onPanic is run after a panic, such as
+// to drain the input channel of the node so that the nodes feeding it do not block. It must be deferred.
+func (run *pipelineRun) recoverNode(node string, onPanic func()) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	failure := nodeFailure{node: node, err: fmt.Sprint(r), stack: string(debug.Stack())}
+	log.Logger.Error("Pipeline node panicked", zap.Int("Id", run.id), zap.String("Node", node), zap.String("Error", failure.err))
+
+	run.fail(failure)
+
+	if onPanic != nil {
+		onPanic()
+	}
+}
+
+// fail reports a failure of the run to the supervisor
+func (run *pipelineRun) fail(failure nodeFailure) {
+	select {
+	case run.failures <- failure:
+	default: // the supervisor only needs the first failure
+	}
+}
+
+// drainCapsules discards capsules until the channel is closed
+func drainCapsules(ch chan capsule.Capsule) {
+	for range ch {
+	}
+}
+
+// startPipelineRun starts the nodes of the pipeline
+func startPipelineRun(id int, taskGraph []OpTask, m *pipelineMetrics, restarts int, schedules ScheduleStore,
+	checkpoints CheckpointStore) *pipelineRun {
+	run := &pipelineRun{
+		id:          id,
+		done:        make(chan struct{}),
+		taskGraph:   taskGraph,
+		sourceTask:  copySourceTask(&taskGraph[0]),
+		metrics:     m,
+		health:      newPipelineHealth(id, taskGraph),
+		failures:    make(chan nodeFailure, 1),
+		swaps:       make(chan swapRequest),
+		finished:    make(chan struct{}),
+		schedules:   schedules,
+		checkpoints: checkpoints,
+	}
+	run.health.restarted(restarts)
+
+	var srcRaw chan capsule.Capsule = make(chan capsule.Capsule, settings.Config.DefaultChanBufferLen)
+
+	if taskGraph[0].Type == "union" {
+		run.startUnionSources(srcRaw, taskGraph[0].Inputs)
+	} else {
+		run.wg.Add(1)
+		go sourceNode(run, srcRaw, taskGraph, "0")
+	}
+
+	run.wg.Add(1)
+	go switchNode(run, srcRaw, run.startGraph(taskGraph, m))
+
+	go func() {
+		run.wg.Wait()
+		close(run.finished)
+	}()
+
+	return run
+}
+
+// runResult is how a run ended
+type runResult struct {
+	stopped   bool           // stopped by a signal on the done channel of the pipeline
+	update    *updateRequest // stopped to restart with an updated task graph that changes the source
+	failure   nodeFailure
+	cleanExit bool // whether the nodes all exited without an error
+}
+
+// wait waits until the run is stopped by a signal on controls.Done, fails, or has to be restarted for an update.
+// Updates that keep the same source are applied to the run without stopping it. Such an update is answered once the
+// old graph has drained, and later updates wait until then, while stops and failures are handled at once.
+func (run *pipelineRun) wait(controls ControlChannels) runResult {
+	updates := controls.update
+	var swapped *updateRequest  // an update applied by a swap, which is answered when the old graph has drained
+	var drained <-chan struct{} // closed when the old graph of the swap has drained
+	defer func() {
+		if swapped != nil {
+			swapped.result <- nil // the run already uses the updated task graph
+		}
+	}()
+
+	for {
+		select {
+		case <-controls.Done:
+			run.stop()
+			return runResult{stopped: true}
+
+		case req := <-updates:
+			if sameSource(&run.sourceTask, &req.taskGraph[0]) {
+				var err error
+				drained, err = run.swap(req.taskGraph)
+				if err != nil {
+					req.result <- err
+					continue
+				}
+				swapped, updates = &req, nil
+				continue
+			}
+
+			log.Logger.Info("Restarting pipeline for an update that changes its source", zap.Int("Id", run.id))
+			run.stop()
+			return runResult{update: &req}
+
+		case <-drained:
+			swapped.result <- nil
+			swapped, drained, updates = nil, nil, controls.update
+
+		case failure := <-run.failures:
+			run.stop()
+			return runResult{failure: failure}
+
+		case <-run.finished:
+			// Every node exited without being stopped
+			select {
+			case failure := <-run.failures:
+				return runResult{failure: failure}
+			default:
+			}
+
+			health := run.health.snapshot()
+			if !health.SourceRunning {
+				return runResult{failure: nodeFailure{node: "sourceNode", err: "source stopped: " + health.SourceError}}
+			}
+			return runResult{failure: nodeFailure{node: "sourceNode", err: "source finished"}, cleanExit: true}
+		}
+	}
+}
+
+// stop tells the sources to stop, which closes the channels between nodes so that every node finishes, and waits
+// for them to finish
+func (run *pipelineRun) stop() {
+	run.stopOnce.Do(func() { close(run.done) })
+
+	select {
+	case <-run.finished:
+	case <-time.After(stopTimeout):
+		log.Logger.Error("Timed out waiting for pipeline nodes to finish", zap.Int("Id", run.id))
+	}
+}
+
+// supervise runs the pipeline, restarting it when it fails according to its restart policy, until it is stopped
+// or the policy gives up
+func (executor *Executor) supervise(id int, taskGraph []OpTask, controls ControlChannels) {
+	defer close(controls.Stopped)
+	defer cleanUpJob(id, controls)
+
+	m := newPipelineMetrics(id, taskGraph)
+	restarts := 0
+	var update *updateRequest // an update that is waiting for the restart
+
+	for {
+		started := time.Now()
+		run := startPipelineRun(id, taskGraph, m, restarts, executor.ScheduleStore, executor.CheckpointStore)
+
+		if update != nil {
+			setRunningTaskGraph(id, taskGraph)
+			removePipelineTaps(id)
+			update.result <- nil
+			update = nil
+		}
+
+		result := run.wait(controls)
+		if result.stopped {
+			return
+		}
+
+		// Updates applied during the run change the task graph, and with it the restart policy
+		taskGraph, m = run.taskGraph, run.metrics
+
+		if result.update != nil {
+			update = result.update
+			taskGraph = update.taskGraph
+			m = newPipelineMetrics(id, taskGraph)
+			restarts = 0
+			continue
+		}
+
+		policy := restartPolicyOf(&taskGraph[0])
+
+		// A pipeline that ran for longer than the longest backoff was healthy, so its failures start over
+		if time.Since(started) > policy.MaxBackoff {
+			restarts = 0
+		}
+
+		nf := result.failure
+		failure := PipelineFailure{
+			PipelineId: id,
+			Time:       time.Now(),
+			Node:       nf.node,
+			Error:      nf.err,
+			Stack:      nf.stack,
+			Restarts:   restarts,
+			Restarting: policy.shouldRestart(result.cleanExit, restarts),
+		}
+		if failure.Restarting {
+			failure.RestartDelay = policy.delay(restarts)
+		}
+
+		log.Logger.Error("Pipeline failed", zap.Int("Id", id), zap.String("Node", failure.Node),
+			zap.String("Error", failure.Error), zap.Bool("Restarting", failure.Restarting),
+			zap.Duration("Delay", failure.RestartDelay))
+
+		if executor.FailureHandler != nil {
+			executor.FailureHandler(failure)
+		}
+
+		if !failure.Restarting {
+			return
+		}
+
+		select {
+		case <-controls.Done:
+			return
+		case req := <-controls.update:
+			// Restart now with the updated task graph, rather than after the delay
+			update = &req
+			taskGraph = update.taskGraph
+			m = newPipelineMetrics(id, taskGraph)
+			restarts = 0
+			continue
+		case <-time.After(failure.RestartDelay):
+		}
+
+		restarts++
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartPolicyOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		restart map[string]interface{}
+		want    restartPolicy
+	}{
+		{name: "default", restart: nil,
+			want: restartPolicy{Policy: "on-failure", MaxRetries: 5, Backoff: time.Second, MaxBackoff: 300 * time.Second}},
+		{name: "set", restart: map[string]interface{}{"policy": "always", "max_retries": 0, "backoff_seconds": 2,
+			"max_backoff_seconds": 60},
+			want: restartPolicy{Policy: "always", MaxRetries: 0, Backoff: 2 * time.Second, MaxBackoff: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restartPolicyOf(&OpTask{Type: "source", Restart: tt.restart}); got != tt.want {
+				t.Errorf("restartPolicyOf = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestartPolicyDelay(t *testing.T) {
+	p := restartPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	tests := []struct {
+		restarts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second}, // capped at the max backoff
+		{100, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := p.delay(tt.restarts); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.restarts, got, tt.want)
+		}
+	}
+}
+
+func TestRestartPolicyShouldRestart(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		maxRetries int
+		cleanExit  bool
+		restarts   int
+		want       bool
+	}{
+		{name: "always after a clean exit", policy: "always", maxRetries: 1, cleanExit: true, restarts: 5, want: true},
+		{name: "on-failure after a failure", policy: "on-failure", maxRetries: 3, restarts: 2, want: true},
+		{name: "on-failure at max retries", policy: "on-failure", maxRetries: 3, restarts: 3, want: false},
+		{name: "on-failure with no limit", policy: "on-failure", maxRetries: 0, restarts: 1000, want: true},
+		{name: "on-failure after a clean exit", policy: "on-failure", maxRetries: 3, cleanExit: true, want: false},
+		{name: "never", policy: "never", maxRetries: 3, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := restartPolicy{Policy: tt.policy, MaxRetries: tt.maxRetries}
+			if got := p.shouldRestart(tt.cleanExit, tt.restarts); got != tt.want {
+				t.Errorf("shouldRestart(%v, %d) = %v, want %v", tt.cleanExit, tt.restarts, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// tapBufferLen is the number of events buffered for a tap. Events are dropped when the buffer is full, so a slow
+// tap never slows down the pipeline.
+const tapBufferLen = 1000
+
+// Tap receives a copy of the events leaving a node of a running pipeline
+type Tap struct {
+	Events chan string   // events leaving the node
+	Done   chan struct{} // closed when the tap is removed or its limit is reached
+
+	pipelineId int
+	taskId     uuid.UUID
+	sample     float64
+	limit      int
+	sent       int
+	closeOnce  sync.Once
+}
+
+// activeTaps counts the taps attached to any node. Nodes check it before doing any other tap work, so tapping has
+// no overhead when nobody is tapping.
+var activeTaps int32
+
+var tapsMutex sync.Mutex
+var taps = map[uuid.UUID][]*Tap{}
+
+// AddTap attaches a tap to the node at nodePath in the task graph of the running pipeline with id. Only a sample
+// fraction (0 to 1] of events is sent to the tap. If limit is greater than 0, the tap is removed after limit events.
+func (executor *Executor) AddTap(id int, nodePath string, sample float64, limit int) (*Tap, error) {
+	if sample <= 0 || sample > 1 {
+		return nil, errors.New("sample must be greater than 0 and at most 1")
+	}
+
+	taskGraph, ok := runningTaskGraph(id)
+	if !ok {
+		return nil, fmt.Errorf("pipeline %d is not running", id)
+	}
+
+	var node *OpTask
+	WalkTaskGraph(taskGraph, func(path string, task *OpTask) {
+		if path == nodePath {
+			node = task
+		}
+	})
+	if node == nil {
+		return nil, fmt.Errorf("pipeline %d has no node %s. Find nodes using the graph command", id, nodePath)
+	}
+
+	tap := &Tap{
+		Events:     make(chan string, tapBufferLen),
+		Done:       make(chan struct{}),
+		pipelineId: id,
+		taskId:     node.Id,
+		sample:     sample,
+		limit:      limit,
+	}
+
+	tapsMutex.Lock()
+	taps[node.Id] = append(taps[node.Id], tap)
+	atomic.AddInt32(&activeTaps, 1)
+	tapsMutex.Unlock()
+
+	return tap, nil
+}
+
+// RemoveTap detaches the tap. It is safe to call more than once.
+func (executor *Executor) RemoveTap(tap *Tap) {
+	tapsMutex.Lock()
+	defer tapsMutex.Unlock()
+
+	removeTapLocked(tap)
+}
+
+// removeTapLocked detaches the tap. tapsMutex must be held.
+func removeTapLocked(tap *Tap) {
+	list := taps[tap.taskId]
+	for idx, t := range list {
+		if t == tap {
+			taps[tap.taskId] = append(list[:idx], list[idx+1:]...)
+			if len(taps[tap.taskId]) == 0 {
+				delete(taps, tap.taskId)
+			}
+			atomic.AddInt32(&activeTaps, -1)
+			break
+		}
+	}
+
+	tap.closeOnce.Do(func() { close(tap.Done) })
+}
+
+// removePipelineTaps detaches all taps on the pipeline with id, such as when it is stopped
+func removePipelineTaps(id int) {
+	tapsMutex.Lock()
+	defer tapsMutex.Unlock()
+
+	for _, list := range taps {
+		for _, tap := range append([]*Tap{}, list...) {
+			if tap.pipelineId == id {
+				removeTapLocked(tap)
+			}
+		}
+	}
+}
+
+// tapEvents sends a copy of the events leaving the node with taskId to any taps on that node
+func tapEvents(taskId uuid.UUID, eventList []string) {
+	if atomic.LoadInt32(&activeTaps) == 0 {
+		return
+	}
+
+	tapsMutex.Lock()
+	defer tapsMutex.Unlock()
+
+	for _, tap := range append([]*Tap{}, taps[taskId]...) {
+		for _, event := range eventList {
+			if tap.sample < 1 && rand.Float64() >= tap.sample {
+				continue
+			}
+
+			select {
+			case tap.Events <- event:
+				tap.sent++
+			default: // drop the event rather than block the pipeline
+			}
+
+			if tap.limit > 0 && tap.sent >= tap.limit {
+				removeTapLocked(tap)
+				break
+			}
+		}
+	}
+}
@@ -6,34 +6,35 @@ package execute
 import (
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/vaerohq/vaero/capsule"
 	"github.com/vaerohq/vaero/log"
-	"github.com/vaerohq/vaero/transform"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
 )
 
-func transformProcess(eventList []string, taskGraph []OpTask, tnOut chan capsule.Capsule) []string {
+func transformProcess(eventList []string, taskGraph []OpTask, tnOut chan capsule.Capsule, m *pipelineMetrics) []string {
 	for _, v := range taskGraph {
 		// task is a transform, so perform the task
 		if v.Type == "tn" {
-			switch v.Op {
-			case "add":
-				eventList = transform.AddAll(eventList, v.Args["path"].(string), v.Args["value"])
-			case "delete":
-				eventList = transform.DeleteAll(eventList, v.Args["path"].(string))
-			case "filter_regexp":
-				eventList = transform.FilterRegExpAll(eventList, v.Args["path"].(string), v.Args["regex"].(string))
-			case "mask":
-				eventList = transform.MaskAll(eventList, v.Args["path"].(string), v.Args["regex"].(string), v.Args["replace_expr"].(string))
-			case "parse_regexp":
-				eventList = transform.ParseRegExpAll(eventList, v.Args["path"].(string), v.Args["regex"].(string))
-			case "rename":
-				eventList = transform.RenameAll(eventList, v.Args["path"].(string), v.Args["new_path"].(string))
-			case "select":
-				eventList = transform.SelectAll(eventList, v.Args["path"].(string))
-			default:
-				log.Logger.Error("Unknown op")
+			if v.Transform == nil {
+				log.Logger.Error("Transform was not compiled", zap.String("Op", v.Op))
+				continue
 			}
+
+			node := m.node(v.Id)
+			before, beforeBytes := len(eventList), 0
+			if node != nil {
+				beforeBytes = eventListBytes(eventList)
+			}
+
+			eventList = v.Transform.Apply(eventList)
+
+			node.transformed(before, beforeBytes, eventList)
+			tapEvents(v.Id, eventList)
 		} else if v.Type == "branch" { // Branch
+			node := m.node(v.Id)
+			node.in(eventList)
 
 			// Iterate over all branches, the first branch receives the eventList. Each additional branch receives a copy
 			// of the eventList.
@@ -53,9 +54,14 @@ func transformProcess(eventList []string, taskGraph []OpTask, tnOut chan capsule
 
 			// Perform transforms
 			for idx, branch := range v.Branches {
-				transformProcess(copyList[idx], branch, tnOut)
+				node.out(copyList[idx])
+				route := node.route(idx)
+				route.in(copyList[idx])
+				route.out(copyList[idx])
+				transformProcess(copyList[idx], branch, tnOut, m)
 			}
 		} else if v.Type == "sink" { // When reach a sink, transmit to the sinkNode with the sinkId as a tag
+			m.node(v.Id).in(eventList)
 
 			tnOut <- capsule.Capsule{SinkId: v.Id, EventList: eventList}
 			// capsule and eventList unsafe to access after sending
@@ -77,3 +83,35 @@ func copyEventList(eventList []string) []string {
 
 	return copyList
 }
+
+// RunTransforms runs the event list through the transforms and routes of a compiled task graph using the same
+// transformProcess that runs in production. Instead of being sent to sinks, the events that reach each sink are
+// captured and returned, keyed by the sink task id. If the task graph starts with a union, the event list is sent
+// through every input of the union.
+func RunTransforms(eventList []string, taskGraph []OpTask) map[uuid.UUID][]string {
+	captured := make(map[uuid.UUID][]string)
+	tnOut := make(chan capsule.Capsule, settings.Config.DefaultChanBufferLen)
+	done := make(chan bool)
+
+	go func() {
+		for c := range tnOut {
+			captured[c.SinkId] = append(captured[c.SinkId], c.EventList...)
+		}
+		done <- true
+	}()
+
+	if len(taskGraph) > 0 && taskGraph[0].Type == "union" {
+		for idx := range taskGraph[0].Inputs {
+			copyList := make([]string, len(eventList))
+			copy(copyList, eventList)
+			transformProcess(unionProcess(copyList, idx, &taskGraph[0], tnOut, nil), taskGraph, tnOut, nil)
+		}
+	} else {
+		transformProcess(eventList, taskGraph, tnOut, nil)
+	}
+	close(tnOut)
+	<-done
+	CloseTransforms(taskGraph)
+
+	return captured
+}
@@ -0,0 +1,87 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/vaerohq/vaero/capsule"
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+)
+
+// startUnionSources starts a sourceNode for each input of a union, and an inputNode that merges its events into
+// srcRaw. srcRaw is closed when every inputNode has finished.
+func (run *pipelineRun) startUnionSources(srcRaw chan capsule.Capsule, inputs [][]OpTask) {
+	var merging sync.WaitGroup
+
+	for idx := range inputs {
+		var inRaw chan capsule.Capsule = make(chan capsule.Capsule, settings.Config.DefaultChanBufferLen)
+
+		merging.Add(1)
+		run.wg.Add(2)
+		go sourceNode(run, inRaw, inputs[idx], taskPath(taskPath("0", idx), 0))
+		go inputNode(run, idx, inRaw, srcRaw, &merging)
+	}
+
+	run.wg.Add(1)
+	go func() {
+		defer run.wg.Done()
+		merging.Wait()
+		close(srcRaw)
+	}()
+}
+
+// inputNode tags the events of the source of union input with the input, so that the transformNode applies the
+// transforms of the input, and sends them to srcRaw
+func inputNode(run *pipelineRun, input int, inRaw chan capsule.Capsule, srcRaw chan capsule.Capsule, merging *sync.WaitGroup) {
+	defer run.wg.Done()
+	defer merging.Done()
+	defer run.recoverNode("inputNode", func() { go drainCapsules(inRaw) })
+
+	for event := range inRaw {
+		event.Input = input
+		srcRaw <- event
+	}
+
+	log.Logger.Info("Closing inputNode", zap.Int("Input", input))
+
+	// The other sources of the union keep running, so the run is failed for the supervisor to stop or restart it
+	select {
+	case <-run.done:
+	default:
+		reason := "source finished"
+		if health := run.health.snapshot(); !health.SourceRunning {
+			reason = "source stopped: " + health.SourceError
+		}
+		run.fail(nodeFailure{node: "sourceNode", err: fmt.Sprintf("union input %d: %s", input, reason)})
+	}
+}
+
+// unionProcess counts the events leaving the source of the union input and performs the transforms of the input.
+// It returns the events leaving the union.
+func unionProcess(eventList []string, input int, union *OpTask, tnOut chan capsule.Capsule, m *pipelineMetrics) []string {
+	if input >= len(union.Inputs) {
+		log.Logger.Error("Events from unknown union input", zap.Int("Input", input))
+		return nil
+	}
+
+	tasks := union.Inputs[input]
+
+	source := m.node(tasks[0].Id)
+	source.in(eventList)
+	source.out(eventList)
+	tapEvents(tasks[0].Id, eventList)
+
+	eventList = transformProcess(eventList, tasks, tnOut, m)
+
+	node := m.node(union.Id)
+	node.in(eventList)
+	node.out(eventList)
+	tapEvents(union.Id, eventList)
+
+	return eventList
+}
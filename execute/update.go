@@ -0,0 +1,238 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/vaerohq/vaero/capsule"
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+)
+
+// errRunFinished is returned when an update arrives after the nodes of a run have finished
+var errRunFinished = errors.New("pipeline finished before the update could be applied")
+
+// graphRun is the transform and sink nodes of one task graph of a pipeline run. Updating a running pipeline replaces
+// its graphRun, while the source keeps running.
+type graphRun struct {
+	run     *pipelineRun
+	metrics *pipelineMetrics
+	srcOut  chan capsule.Capsule // closed to drain the graph
+	wg      sync.WaitGroup       // counts running nodes of this graph
+	drained chan struct{}        // closed when every node of this graph has finished
+}
+
+// updateRequest asks the supervisor of a pipeline to switch to a new task graph
+type updateRequest struct {
+	taskGraph []OpTask
+	result    chan error
+}
+
+// swapRequest asks the switchNode to send the source output to a new task graph
+type swapRequest struct {
+	taskGraph []OpTask
+	metrics   *pipelineMetrics
+	old       chan *graphRun // receives the replaced graphRun
+}
+
+// add counts n nodes of the graph, which are also nodes of the run
+func (g *graphRun) add(n int) {
+	g.wg.Add(n)
+	g.run.wg.Add(n)
+}
+
+// nodeDone marks a node of the graph finished. It must be deferred.
+func (g *graphRun) nodeDone() {
+	g.wg.Done()
+	g.run.wg.Done()
+}
+
+// startGraph starts the transform and sink nodes of the task graph. They run until their srcOut is closed.
+func (run *pipelineRun) startGraph(taskGraph []OpTask, m *pipelineMetrics) *graphRun {
+	g := &graphRun{
+		run:     run,
+		metrics: m,
+		srcOut:  make(chan capsule.Capsule, settings.Config.DefaultChanBufferLen),
+		drained: make(chan struct{}),
+	}
+
+	var tnOut chan capsule.Capsule = make(chan capsule.Capsule, settings.Config.DefaultChanBufferLen)
+
+	m.unwatchChannels()
+	m.watchChannel("src_out", "", g.srcOut)
+	m.watchChannel("tn_out", "", tnOut)
+
+	g.add(2)
+	go transformNode(g, g.srcOut, tnOut, taskGraph)
+	go sinkNode(g, tnOut, taskGraph)
+
+	// The sinkNode adds the flushNodes while it is running, so the count cannot reach zero early
+	go func() {
+		g.wg.Wait()
+		close(g.drained)
+	}()
+
+	return g
+}
+
+// switchNode sends the output of the source to the current graphRun, and switches to a new graphRun when the
+// pipeline is updated. The replaced graphRun drains and its nodes finish.
+func switchNode(run *pipelineRun, srcRaw chan capsule.Capsule, current *graphRun) {
+	defer run.wg.Done()
+	defer run.recoverNode("switchNode", func() { go drainCapsules(srcRaw) })
+
+	defer func() {
+		close(current.srcOut)
+		log.Logger.Info("Closing switchNode")
+	}()
+
+	for {
+		select {
+		case req := <-run.swaps:
+			next := run.startGraph(req.taskGraph, req.metrics)
+			close(current.srcOut)
+			req.old <- current
+			current = next
+
+		case event, ok := <-srcRaw:
+			// Kill goroutine when the channel is closed
+			if !ok {
+				return
+			}
+
+			current.srcOut <- event
+		}
+	}
+}
+
+// swap switches the run to the task graph, which has the same source. The old graph drains in the background, so
+// that the events in its sink buffers are flushed, and the returned channel is closed when it has drained or timed out.
+func (run *pipelineRun) swap(taskGraph []OpTask) (<-chan struct{}, error) {
+	m := newPipelineMetrics(run.id, taskGraph)
+	req := swapRequest{taskGraph: taskGraph, metrics: m, old: make(chan *graphRun, 1)}
+
+	// Record the new sinks first, so their first flushes are not missed
+	run.health.setSinks(taskGraph)
+
+	select {
+	case run.swaps <- req:
+	case <-run.finished:
+		CloseTransforms(taskGraph) // never started
+		return nil, errRunFinished
+	}
+
+	old := <-req.old
+	run.taskGraph, run.metrics = taskGraph, m
+	setRunningTaskGraph(run.id, taskGraph)
+	removePipelineTaps(run.id) // taps are on nodes of the old task graph
+
+	log.Logger.Info("Switched pipeline to updated task graph", zap.Int("Id", run.id))
+
+	return old.waitDrained(stopTimeout), nil
+}
+
+// waitDrained returns a channel that is closed when every node of the graph has finished, or after timeout
+func (g *graphRun) waitDrained(timeout time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		select {
+		case <-g.drained:
+			log.Logger.Info("Drained previous task graph", zap.Int("Id", g.run.id))
+		case <-time.After(timeout):
+			log.Logger.Error("Timed out draining previous task graph", zap.Int("Id", g.run.id))
+		}
+	}()
+
+	return done
+}
+
+// copySourceTask copies the source task, so that changes made while the source runs, such as applying secrets, do
+// not change the copy. For a union, the sources of its inputs are copied.
+func copySourceTask(sourceTask *OpTask) OpTask {
+	task := *sourceTask
+	task.Args = make(map[string]interface{}, len(sourceTask.Args))
+	for k, v := range sourceTask.Args {
+		task.Args[k] = v
+	}
+
+	if sourceTask.Inputs != nil {
+		task.Inputs = make([][]OpTask, len(sourceTask.Inputs))
+		for idx, input := range sourceTask.Inputs {
+			task.Inputs[idx] = []OpTask{copySourceTask(&input[0])}
+		}
+	}
+
+	return task
+}
+
+// sameSource reports whether two source tasks configure the same source, so that a pipeline can switch between
+// them without restarting its source. The restart policy may differ, but a change of schedule restarts the source.
+// Two unions are the same if the sources of their inputs are the same, in the same order, while the transforms of
+// the inputs may differ.
+func sameSource(a *OpTask, b *OpTask) bool {
+	if a.Type != b.Type || a.Op != b.Op || !reflect.DeepEqual(a.Args, b.Args) || !reflect.DeepEqual(a.Secret, b.Secret) ||
+		!reflect.DeepEqual(a.Schedule, b.Schedule) {
+		return false
+	}
+
+	if len(a.Inputs) != len(b.Inputs) {
+		return false
+	}
+	for idx := range a.Inputs {
+		if !sameSource(&a.Inputs[idx][0], &b.Inputs[idx][0]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setRunningTaskGraph records the task graph that the running job with id has switched to
+func setRunningTaskGraph(id int, taskGraph []OpTask) {
+	runningGraphsMutex.Lock()
+	runningGraphs[id] = taskGraph
+	runningGraphsMutex.Unlock()
+}
+
+// UpdateJob switches the running job with id to the taskGraph. If the source is unchanged, the source keeps running
+// and its events go to the new transforms and sinks as soon as they start, while the old ones drain. Otherwise,
+// the job is stopped and restarted with the new task graph. The compiled transforms of taskGraph belong to the job
+// from then on, and are closed if the update cannot be applied.
+func (executor *Executor) UpdateJob(id int, taskGraph []OpTask) error {
+	log.Logger.Info("Update Job", zap.Int("Id", id))
+
+	pipeControlsMutex.Lock()
+	controls, ok := pipeControls[id]
+	pipeControlsMutex.Unlock()
+
+	if !ok {
+		CloseTransforms(taskGraph)
+		return fmt.Errorf("pipeline %d is not running", id)
+	}
+
+	req := updateRequest{taskGraph: taskGraph, result: make(chan error, 1)}
+
+	select {
+	case controls.update <- req:
+	case <-controls.Stopped:
+		CloseTransforms(taskGraph) // never received, so never started
+		return fmt.Errorf("pipeline %d stopped before the update could be applied", id)
+	}
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-controls.Stopped:
+		return fmt.Errorf("pipeline %d stopped before the update could be applied", id)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSameSource(t *testing.T) {
+	source := func(args map[string]interface{}) OpTask {
+		return OpTask{Type: "source", Op: "random", Args: args}
+	}
+	union := func(inputs ...OpTask) OpTask {
+		task := OpTask{Type: "union", Op: "union"}
+		for _, input := range inputs {
+			task.Inputs = append(task.Inputs, []OpTask{input, {Type: "tn", Op: "add"}})
+		}
+		return task
+	}
+
+	tests := []struct {
+		name string
+		a    OpTask
+		b    OpTask
+		want bool
+	}{
+		{name: "same", a: source(map[string]interface{}{"interval": 3}), b: source(map[string]interface{}{"interval": 3}),
+			want: true},
+		{name: "args differ", a: source(map[string]interface{}{"interval": 3}), b: source(map[string]interface{}{"interval": 5})},
+		{name: "op differs", a: source(nil), b: OpTask{Type: "source", Op: "http_poll"}},
+		{name: "secret differs", a: source(nil), b: OpTask{Type: "source", Op: "random",
+			Secret: map[string]interface{}{"provider": "env"}}},
+		{name: "schedule differs", a: source(nil), b: OpTask{Type: "source", Op: "random",
+			Schedule: map[string]interface{}{"cron": "@hourly"}}},
+		{name: "restart differs", a: source(nil), b: OpTask{Type: "source", Op: "random",
+			Restart: map[string]interface{}{"policy": "always"}}, want: true},
+		{name: "same union", a: union(source(nil), source(map[string]interface{}{"interval": 1})),
+			b: union(source(nil), source(map[string]interface{}{"interval": 1})), want: true},
+		{name: "union input differs", a: union(source(nil), source(nil)),
+			b: union(source(nil), source(map[string]interface{}{"interval": 1}))},
+		{name: "union inputs reordered", a: union(source(nil), source(map[string]interface{}{"interval": 1})),
+			b: union(source(map[string]interface{}{"interval": 1}), source(nil))},
+		{name: "union input added", a: union(source(nil)), b: union(source(nil), source(nil))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameSource(&tt.a, &tt.b); got != tt.want {
+				t.Errorf("sameSource = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitDrainedTimeout(t *testing.T) {
+	g := &graphRun{run: &pipelineRun{id: 1}, drained: make(chan struct{})} // never drains
+
+	select {
+	case <-g.waitDrained(10 * time.Millisecond):
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitDrained did not time out")
+	}
+
+	drained := &graphRun{run: &pipelineRun{id: 1}, drained: make(chan struct{})}
+	close(drained.drained)
+	select {
+	case <-drained.waitDrained(time.Hour):
+	case <-time.After(5 * time.Second):
+		t.Fatal("waitDrained did not return when the graph drained")
+	}
+}
+
+// newSwapTestRun returns a run of the task graph without nodes, whose switchNode replaces the graph by old, and
+// which finishes when it is stopped
+func newSwapTestRun(t *testing.T, id int, taskGraph []OpTask, old *graphRun) *pipelineRun {
+	run := &pipelineRun{
+		id:         id,
+		done:       make(chan struct{}),
+		taskGraph:  taskGraph,
+		sourceTask: copySourceTask(&taskGraph[0]),
+		health:     newPipelineHealth(id, taskGraph),
+		failures:   make(chan nodeFailure, 1),
+		swaps:      make(chan swapRequest),
+		finished:   make(chan struct{}),
+	}
+	old.run = run
+
+	go func() {
+		req := <-run.swaps
+		req.old <- old
+		<-run.done
+		close(run.finished)
+	}()
+
+	t.Cleanup(func() {
+		healthMutex.Lock()
+		delete(pipelineHealths, id)
+		healthMutex.Unlock()
+		runningGraphsMutex.Lock()
+		delete(runningGraphs, id)
+		runningGraphsMutex.Unlock()
+	})
+
+	return run
+}
+
+func TestWaitDuringSwapDrain(t *testing.T) {
+	taskGraph := []OpTask{{Type: "source", Op: "random", Args: map[string]interface{}{"interval": 1}},
+		{Type: "sink", Op: "stdout"}}
+	updated := []OpTask{taskGraph[0], {Type: "sink", Op: "stdout", Args: map[string]interface{}{"batch_max_time": 1}}}
+
+	t.Run("answers the update when drained", func(t *testing.T) {
+		old := &graphRun{drained: make(chan struct{})}
+		run := newSwapTestRun(t, 9001, taskGraph, old)
+		controls := ControlChannels{Done: make(chan int), update: make(chan updateRequest)}
+
+		results := make(chan runResult, 1)
+		go func() { results <- run.wait(controls) }()
+
+		req := updateRequest{taskGraph: updated, result: make(chan error, 1)}
+		controls.update <- req
+
+		select {
+		case err := <-req.result:
+			t.Fatalf("update answered before the old graph drained, with %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(old.drained)
+		select {
+		case err := <-req.result:
+			if err != nil {
+				t.Errorf("update returned error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("update was not answered after the old graph drained")
+		}
+		if run.taskGraph[1].Args["batch_max_time"] != 1 {
+			t.Errorf("run did not switch to the updated task graph")
+		}
+
+		controls.Done <- 1
+		if result := <-results; !result.stopped {
+			t.Errorf("wait = %+v, want stopped", result)
+		}
+	})
+
+	t.Run("stops while draining", func(t *testing.T) {
+		old := &graphRun{drained: make(chan struct{})} // never drains
+		run := newSwapTestRun(t, 9002, taskGraph, old)
+		controls := ControlChannels{Done: make(chan int), update: make(chan updateRequest)}
+
+		results := make(chan runResult, 1)
+		go func() { results <- run.wait(controls) }()
+
+		req := updateRequest{taskGraph: updated, result: make(chan error, 1)}
+		controls.update <- req
+
+		select {
+		case controls.Done <- 1:
+		case <-time.After(5 * time.Second):
+			t.Fatal("stop was not handled while the old graph drained")
+		}
+
+		select {
+		case result := <-results:
+			if !result.stopped {
+				t.Errorf("wait = %+v, want stopped", result)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("wait did not return after the stop")
+		}
+
+		// The run switched to the update before it stopped, so the update succeeded
+		select {
+		case err := <-req.result:
+			if err != nil {
+				t.Errorf("update returned error: %v", err)
+			}
+		default:
+			t.Error("update was not answered when the run stopped")
+		}
+	})
+}
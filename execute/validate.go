@@ -0,0 +1,287 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package execute
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vaerohq/vaero/integrations/secrets"
+	"github.com/vaerohq/vaero/schema"
+)
+
+// ValidationError describes a problem with a single task in a task graph
+type ValidationError struct {
+	Path string // location of the task in the task graph json, such as 3.1.0
+	Type string
+	Op   string
+	Arg  string // empty if the problem is not with a specific arg
+	Msg  string
+}
+
+func (e ValidationError) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "task %s", e.Path)
+	if e.Op != "" {
+		fmt.Fprintf(&b, " (%s %s)", e.Type, e.Op)
+	}
+	if e.Arg != "" {
+		fmt.Fprintf(&b, ": arg %s", e.Arg)
+	}
+	fmt.Fprintf(&b, ": %s", e.Msg)
+
+	return b.String()
+}
+
+// ValidationErrors is the list of every problem found in a task graph
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for idx, e := range errs {
+		msgs[idx] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ValidateTaskGraph checks the structure of the task graph and the args of every task against the op schemas.
+// Missing args are set to their defaults and arg values are converted to their declared types. If any problems are
+// found, it returns ValidationErrors listing all of them.
+func ValidateTaskGraph(taskGraph []OpTask) error {
+	var errs ValidationErrors
+
+	if len(taskGraph) == 0 {
+		errs = append(errs, ValidationError{Path: "0", Msg: "task graph is empty"})
+		return errs
+	}
+
+	if taskGraph[0].Type != "source" && taskGraph[0].Type != "union" {
+		errs = append(errs, ValidationError{Path: "0", Type: taskGraph[0].Type, Op: taskGraph[0].Op,
+			Msg: "task graph must start with a source or a union"})
+	}
+
+	validateTaskGraphHelper(taskGraph, "", &errs)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateTaskGraphHelper(taskGraph []OpTask, prefix string, errs *ValidationErrors) {
+	for idx := range taskGraph {
+		task := &taskGraph[idx]
+		path := taskPath(prefix, idx)
+
+		switch task.Type {
+		case "branch":
+			if len(task.Branches) == 0 {
+				*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Msg: "branch has no routes"})
+			}
+			for branchIdx, branch := range task.Branches {
+				validateTaskGraphHelper(branch, taskPath(path, branchIdx), errs)
+			}
+			continue
+		case "union":
+			if prefix != "" || idx != 0 {
+				*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op,
+					Msg: "a union may only be the first task of the task graph"})
+			}
+			validateUnion(task, path, errs)
+			continue
+		case "source":
+			if prefix != "" || idx != 0 {
+				*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op,
+					Msg: "a source may only be the first task of the task graph"})
+			}
+		case "sink", "tn":
+		default:
+			*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op,
+				Msg: fmt.Sprintf("unknown task type %q", task.Type)})
+			continue
+		}
+
+		validateTask(task, path, errs)
+	}
+}
+
+// validateUnion checks that each input of the union is a source followed only by transforms, and validates the
+// tasks of the inputs. The restart policy of a pipeline with a union is set on the union.
+func validateUnion(task *OpTask, path string, errs *ValidationErrors) {
+	if task.Op != "union" {
+		*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Msg: "the op of a union must be union"})
+	}
+	if task.Args == nil {
+		task.Args = make(map[string]interface{})
+	}
+	if len(task.Args) != 0 || len(task.Secret) != 0 {
+		*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op,
+			Msg: "a union has no args or secret, set them on the sources of its inputs"})
+	}
+	if len(task.Inputs) < 2 {
+		*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Msg: "a union needs at least two inputs"})
+	}
+
+	if len(task.Restart) != 0 {
+		for _, e := range schema.Restart().Apply(task.Restart, nil) {
+			*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "restart." + e.Arg, Msg: e.Msg})
+		}
+	}
+
+	for inputIdx, input := range task.Inputs {
+		inputPath := taskPath(path, inputIdx)
+
+		if len(input) == 0 || input[0].Type != "source" {
+			*errs = append(*errs, ValidationError{Path: inputPath, Msg: "each input of a union must start with a source"})
+		}
+
+		for idx := range input {
+			inputTask := &input[idx]
+			taskPath := taskPath(inputPath, idx)
+
+			switch {
+			case inputTask.Type == "source" && idx == 0:
+				if len(inputTask.Restart) != 0 {
+					*errs = append(*errs, ValidationError{Path: taskPath, Type: inputTask.Type, Op: inputTask.Op, Arg: "restart",
+						Msg: "the restart policy of a pipeline with a union may only be set on the union"})
+					continue
+				}
+			case inputTask.Type != "tn":
+				*errs = append(*errs, ValidationError{Path: taskPath, Type: inputTask.Type, Op: inputTask.Op,
+					Msg: "an input of a union may only have a source followed by transforms"})
+				continue
+			}
+
+			validateTask(inputTask, taskPath, errs)
+		}
+	}
+}
+
+// validateTask validates the args, secret block, restart block, and schedule block of a single task
+func validateTask(task *OpTask, path string, errs *ValidationErrors) {
+	opSchema, ok := lookupSchema(task.Type, task.Op)
+	if !ok {
+		*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op,
+			Msg: fmt.Sprintf("unknown %s op %q", task.Type, task.Op)})
+		return
+	}
+
+	if task.Args == nil {
+		task.Args = make(map[string]interface{})
+	}
+
+	if len(task.Inputs) != 0 {
+		*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Msg: "only a union may have inputs"})
+	}
+
+	// Args that are set by a secret may be empty in the spec
+	secretTargets := map[string]bool{}
+
+	if len(task.Secret) != 0 {
+		if task.Type != "source" && task.Type != "sink" {
+			*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "secret",
+				Msg: "secrets may only be used with sources and sinks"})
+		} else if !takesSecrets(task.Type, task.Op) {
+			*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "secret",
+				Msg: fmt.Sprintf("%s %s does not take secrets", task.Type, task.Op)})
+		}
+
+		for _, e := range schema.Secret().Apply(task.Secret, nil) {
+			*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "secret." + e.Arg, Msg: e.Msg})
+		}
+
+		secretList, _ := task.Secret["secrets"].([]interface{})
+		for _, pair := range secretList {
+			pairMap, ok := pair.(map[string]interface{})
+			if !ok {
+				*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "secret.secrets",
+					Msg: "each secret must be a {secret name : target arg} map"})
+				continue
+			}
+			for _, target := range pairMap {
+				if targetName, ok := target.(string); ok {
+					secretTargets[targetName] = true
+				}
+			}
+		}
+	}
+
+	if len(task.Restart) != 0 {
+		if task.Type != "source" {
+			*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "restart",
+				Msg: "a restart policy may only be set on the source or union"})
+		}
+
+		for _, e := range schema.Restart().Apply(task.Restart, nil) {
+			*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "restart." + e.Arg, Msg: e.Msg})
+		}
+	}
+
+	// Secret references resolve to strings, so they may only be used in string args, or in strings nested in list
+	// and object args. A reference in a bool or number arg is reported as such, rather than as a type error.
+	refErrs := map[string]string{}
+	for name, value := range task.Args {
+		switch argType := opSchema.TypeOf(name); argType {
+		case schema.Bool, schema.Int, schema.Number:
+			if secrets.HasRefs(value) {
+				refErrs[name] = fmt.Sprintf("secret references may only be used in string args, and %s is of type %s",
+					name, argType)
+			}
+		}
+	}
+
+	for _, e := range opSchema.Apply(task.Args, secretTargets) {
+		if _, ok := refErrs[e.Arg]; ok {
+			continue
+		}
+		*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: e.Arg, Msg: e.Msg})
+	}
+
+	// Secret references are only checked to be well formed and to name a known provider here. They are looked up
+	// when the pipeline is started: when its transforms are compiled to run, and when its sources and sinks start.
+	names := make([]string, 0, len(task.Args))
+	for name := range task.Args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if msg, ok := refErrs[name]; ok {
+			*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: name, Msg: msg})
+		} else if err := secrets.Check(task.Args[name]); err != nil {
+			*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: name, Msg: err.Error()})
+		}
+	}
+
+	if len(task.Schedule) != 0 {
+		validateSchedule(task, path, errs)
+	}
+}
+
+// validateSchedule validates the schedule block of a task, which may only be set on pull sources. The args of the
+// task must already have been validated, so that the interval is an int.
+func validateSchedule(task *OpTask, path string, errs *ValidationErrors) {
+	if task.Type != "source" {
+		*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "schedule",
+			Msg: "a schedule may only be set on a source"})
+		return
+	}
+
+	if p, ok := sourcePlugins[task.Op]; ok && !p.Pull {
+		*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "schedule",
+			Msg: "a schedule may only be set on a source that pulls events, not one that receives them"})
+		return
+	}
+
+	scheduleErrs := schema.Schedule().Apply(task.Schedule, nil)
+	if len(scheduleErrs) == 0 {
+		_, scheduleErrs = parseSchedule(task.Schedule, time.Duration(task.IntArg("interval"))*time.Second)
+	}
+
+	for _, e := range scheduleErrs {
+		*errs = append(*errs, ValidationError{Path: path, Type: task.Type, Op: task.Op, Arg: "schedule." + e.Arg, Msg: e.Msg})
+	}
+}
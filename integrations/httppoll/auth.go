@@ -0,0 +1,52 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package httppoll
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Auth is how requests authenticate to the API
+type Auth struct {
+	Scheme   string // none, basic, bearer, header, or query
+	Token    string // token of the bearer, header, and query schemes
+	Username string // username of the basic scheme
+	Password string // password of the basic scheme
+	Name     string // header or query param that holds the token, for the header and query schemes
+	Prefix   string // prepended to the token in the header scheme, such as "SSWS "
+}
+
+func (a *Auth) setDefaults() {
+	if a.Scheme == "" {
+		a.Scheme = "none"
+	}
+	if a.Name == "" {
+		switch a.Scheme {
+		case "header":
+			a.Name = "Authorization"
+		case "query":
+			a.Name = "api_key"
+		}
+	}
+}
+
+// setHeader adds the auth header of the scheme to req
+func (a *Auth) setHeader(req *http.Request) {
+	switch a.Scheme {
+	case "basic":
+		req.SetBasicAuth(a.Username, a.Password)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+a.Token)
+	case "header":
+		req.Header.Set(a.Name, a.Prefix+a.Token)
+	}
+}
+
+// setQuery adds the token to the query, for the query scheme
+func (a *Auth) setQuery(query url.Values) {
+	if a.Scheme == "query" {
+		query.Set(a.Name, a.Token)
+	}
+}
@@ -0,0 +1,250 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package httppoll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// Config describes a REST API endpoint to poll. It is the Go equivalent of a vaero_cdk HTTPConnector, configured
+// with args rather than code.
+type Config struct {
+	URL     string
+	Method  string            // GET or POST
+	Body    string            // request body, for POST
+	Headers map[string]string // headers of every request
+	Params  map[string]string // query params of every request
+
+	Auth        Auth
+	RateLimit   RateLimit
+	Pagination  Pagination
+	Incremental Incremental
+
+	EventsPath string        // json path of the event array in a response body, or "" if the body is the array
+	Timeout    time.Duration // time a single request may take
+}
+
+// Poller reads the events of an API endpoint, one page at a time, until all pages are read
+type Poller struct {
+	Config Config
+	Client *http.Client
+
+	limiter *rateLimiter
+	since   time.Time       // time of the latest event read, for incremental sync
+	seen    map[string]bool // keys of the events read with the time since
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewPoller returns a Poller for config, with defaults set for any missing settings
+func NewPoller(config Config) *Poller {
+	if config.Method == "" {
+		config.Method = http.MethodGet
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	config.Auth.setDefaults()
+	config.RateLimit.setDefaults()
+	config.Pagination.setDefaults()
+	config.Incremental.setDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Poller{
+		Config:  config,
+		Client:  &http.Client{Timeout: config.Timeout},
+		limiter: newRateLimiter(config.RateLimit),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Poll reads every page of the endpoint from the cursor on, and returns the events not read by an earlier poll. The
+// cursor only advances if all pages are read, so a failed poll is retried from the same cursor.
+func (p *Poller) Poll() ([]string, error) {
+	if p.Config.URL == "" {
+		return nil, errors.New("url is not set")
+	}
+
+	start := p.Config.Incremental.start(p.since, time.Now())
+
+	eventList := []string{}
+	latest, latestSeen := p.since, map[string]bool{}
+	for key := range p.seen {
+		latestSeen[key] = true
+	}
+	page := p.Config.Pagination.first()
+
+	for count := 1; ; count++ {
+		req, err := p.newRequest(page, start)
+		if err != nil {
+			return nil, err
+		}
+
+		header, body, err := p.send(req)
+		if err != nil {
+			return nil, err
+		}
+
+		events, err := extractEvents(body, p.Config.EventsPath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range events {
+			if p.Config.Incremental.enabled() {
+				eventTime, ok := p.Config.Incremental.eventTime(event)
+				if ok {
+					key := p.Config.Incremental.eventKey(event)
+					if !p.since.IsZero() && (eventTime.Before(p.since) || eventTime.Equal(p.since) && p.seen[key]) {
+						continue // read by an earlier poll
+					}
+					if eventTime.After(latest) {
+						latest, latestSeen = eventTime, map[string]bool{}
+					}
+					if eventTime.Equal(latest) {
+						latestSeen[key] = true
+					}
+				}
+			}
+			eventList = append(eventList, event)
+		}
+
+		page = p.Config.Pagination.next(page, req.URL, header, body, len(events))
+		if page == nil {
+			break
+		}
+		if count >= p.Config.Pagination.MaxPages {
+			log.Logger.Info("Reached max pages of HTTP poll, continuing on the next read", zap.String("URL", p.Config.URL),
+				zap.Int("Pages", count))
+			break
+		}
+	}
+
+	p.since, p.seen = latest, latestSeen
+
+	return eventList, nil
+}
+
+// Since returns the time of the latest event read, or the zero time if incremental sync is off or nothing was read
+func (p *Poller) Since() time.Time {
+	return p.since
+}
+
+// SetSince sets the time of the latest event read, so that the next poll only returns events from then on. Events
+// at that time are forgotten, so they are read again unless SetSeen is also called.
+func (p *Poller) SetSince(since time.Time) {
+	p.since, p.seen = since, map[string]bool{}
+}
+
+// Seen returns the keys of the events read with the time of the latest event read, sorted
+func (p *Poller) Seen() []string {
+	keys := make([]string, 0, len(p.seen))
+	for key := range p.seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetSeen sets the keys of the events read with the time of the latest event read, so that the next poll drops them
+func (p *Poller) SetSeen(keys []string) {
+	p.seen = make(map[string]bool, len(keys))
+	for _, key := range keys {
+		p.seen[key] = true
+	}
+}
+
+// Close stops any poll in progress, such as one waiting to retry
+func (p *Poller) Close() {
+	p.cancel()
+}
+
+// newRequest returns the request for page, with the cursor of incremental sync, auth, headers, and params
+func (p *Poller) newRequest(page *pageState, start time.Time) (*http.Request, error) {
+	reqURL, err := url.Parse(p.Config.URL)
+	if page.url != "" {
+		reqURL, err = url.Parse(page.url) // the next link of the previous page
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+
+	// A next link already has the params of the page, so only the first request of link pagination gets them
+	query := reqURL.Query()
+	if page.url == "" {
+		for k, v := range p.Config.Params {
+			query.Set(k, v)
+		}
+		if !start.IsZero() && p.Config.Incremental.TimeParam != "" {
+			query.Set(p.Config.Incremental.TimeParam, p.Config.Incremental.format(start))
+		}
+		p.Config.Pagination.setParams(query, page)
+	}
+	p.Config.Auth.setQuery(query)
+	reqURL.RawQuery = query.Encode()
+
+	var body io.Reader
+	if p.Config.Body != "" {
+		body = strings.NewReader(p.Config.Body)
+	}
+
+	req, err := http.NewRequestWithContext(p.ctx, p.Config.Method, reqURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if p.Config.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range p.Config.Headers {
+		req.Header.Set(k, v)
+	}
+	p.Config.Auth.setHeader(req)
+
+	return req, nil
+}
+
+// extractEvents returns the events at path in a json response body. If the value at path is an object rather than
+// an array, it is a single event.
+func extractEvents(body []byte, path string) ([]string, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, errors.New("response is not valid json")
+	}
+
+	result := gjson.ParseBytes(body)
+	if path != "" {
+		result = result.Get(path)
+	}
+
+	switch {
+	case result.IsArray():
+		events := []string{}
+		for _, event := range result.Array() {
+			events = append(events, event.Raw)
+		}
+		return events, nil
+	case result.IsObject():
+		return []string{result.Raw}, nil
+	case !result.Exists() || result.Type == gjson.Null:
+		return []string{}, nil
+	default:
+		return nil, fmt.Errorf("value at events path %q is not an array or object", path)
+	}
+}
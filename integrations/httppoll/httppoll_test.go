@@ -0,0 +1,329 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package httppoll
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// newTestPoller returns a poller of server that is not slowed by the rate limit
+func newTestPoller(server *httptest.Server, config Config) *Poller {
+	config.URL = server.URL + "/logs"
+	config.RateLimit.MaxCallsPerPeriod = 1000
+	config.RateLimit.Period = time.Millisecond
+	return NewPoller(config)
+}
+
+func TestAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   Auth
+		header string // header that should hold want
+		query  string // query param that should hold want
+		want   string
+	}{
+		{name: "basic", auth: Auth{Scheme: "basic", Username: "user", Password: "pass"},
+			header: "Authorization", want: "Basic dXNlcjpwYXNz"},
+		{name: "bearer", auth: Auth{Scheme: "bearer", Token: "tok"}, header: "Authorization", want: "Bearer tok"},
+		{name: "header", auth: Auth{Scheme: "header", Token: "tok", Prefix: "SSWS "}, header: "Authorization", want: "SSWS tok"},
+		{name: "named header", auth: Auth{Scheme: "header", Token: "tok", Name: "X-Api-Key"}, header: "X-Api-Key", want: "tok"},
+		{name: "query", auth: Auth{Scheme: "query", Token: "tok"}, query: "api_key", want: "tok"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" {
+					got = r.Header.Get(tt.header)
+				} else {
+					got = r.URL.Query().Get(tt.query)
+				}
+				fmt.Fprint(w, `[]`)
+			}))
+			defer server.Close()
+
+			p := newTestPoller(server, Config{Auth: tt.auth})
+			defer p.Close()
+			if _, err := p.Poll(); err != nil {
+				t.Fatalf("Poll returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("auth = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPagination(t *testing.T) {
+	// Each handler serves the pages [1,2], [3,4], [5]
+	pages := []string{`[{"n":1},{"n":2}]`, `[{"n":3},{"n":4}]`, `[{"n":5}]`}
+
+	tests := []struct {
+		name       string
+		pagination Pagination
+		eventsPath string
+		handler    func(w http.ResponseWriter, r *http.Request) // writes the page requested by r
+	}{
+		{
+			name:       "link",
+			pagination: Pagination{Type: "link"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				idx, _ := strconv.Atoi(r.URL.Query().Get("after"))
+				if idx+1 < len(pages) {
+					w.Header().Set("Link", fmt.Sprintf(`</logs?after=%d>; rel="next"`, idx+1))
+				}
+				fmt.Fprint(w, pages[idx])
+			},
+		},
+		{
+			name:       "cursor",
+			pagination: Pagination{Type: "cursor", CursorPath: "next"},
+			eventsPath: "data",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				idx, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+				next := ""
+				if idx+1 < len(pages) {
+					next = strconv.Itoa(idx + 1)
+				}
+				fmt.Fprintf(w, `{"data":%s,"next":%q}`, pages[idx], next)
+			},
+		},
+		{
+			name:       "offset",
+			pagination: Pagination{Type: "offset", PageSize: 2, LimitParam: "limit"},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+				if r.URL.Query().Get("limit") != "2" {
+					http.Error(w, "missing limit", http.StatusBadRequest)
+					return
+				}
+				fmt.Fprint(w, pages[offset/2])
+			},
+		},
+		{
+			name:       "page",
+			pagination: Pagination{Type: "page", PageSize: 2},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				fmt.Fprint(w, pages[page-1])
+			},
+		},
+	}
+
+	want := []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`, `{"n":5}`}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(tt.handler))
+			defer server.Close()
+
+			p := newTestPoller(server, Config{Pagination: tt.pagination, EventsPath: tt.eventsPath})
+			defer p.Close()
+			got, err := p.Poll()
+			if err != nil {
+				t.Fatalf("Poll returned error: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Poll = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPaginationMaxPages(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `[{"n":1}]`)
+	}))
+	defer server.Close()
+
+	p := newTestPoller(server, Config{Pagination: Pagination{Type: "page", MaxPages: 3}})
+	defer p.Close()
+	got, err := p.Poll()
+	if err != nil {
+		t.Fatalf("Poll returned error: %v", err)
+	}
+	if len(got) != 3 || atomic.LoadInt32(&requests) != 3 {
+		t.Errorf("Poll read %d events in %d requests, want 3 in 3", len(got), requests)
+	}
+}
+
+func TestRateLimitBackoff(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int // status of each request, after which requests succeed
+		maxRetries   int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "retries 429", statuses: []int{429, 429}, maxRetries: 3, wantRequests: 3},
+		{name: "retries 5xx", statuses: []int{503}, maxRetries: 3, wantRequests: 2},
+		{name: "gives up after max retries", statuses: []int{429, 429, 429}, maxRetries: 2, wantErr: true, wantRequests: 3},
+		{name: "does not retry 4xx", statuses: []int{401}, maxRetries: 3, wantErr: true, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&requests, 1)
+				if int(n) <= len(tt.statuses) {
+					w.WriteHeader(tt.statuses[n-1])
+					return
+				}
+				fmt.Fprint(w, `[{"n":1}]`)
+			}))
+			defer server.Close()
+
+			p := newTestPoller(server, Config{RateLimit: RateLimit{MaxRetries: tt.maxRetries, Backoff: time.Millisecond,
+				MaxBackoff: 10 * time.Millisecond}})
+			defer p.Close()
+			_, err := p.Poll()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Poll error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	var requests int32
+	var first time.Time
+	var retryDelay time.Duration
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		retryDelay = time.Since(first)
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	// Retry-After takes precedence over the backoff, but is bounded by the max backoff
+	p := newTestPoller(server, Config{RateLimit: RateLimit{MaxRetries: 1, Backoff: time.Millisecond,
+		MaxBackoff: 200 * time.Millisecond}})
+	defer p.Close()
+	if _, err := p.Poll(); err != nil {
+		t.Fatalf("Poll returned error: %v", err)
+	}
+	if retryDelay < 200*time.Millisecond || retryDelay > time.Second {
+		t.Errorf("retry delay = %v, want the max backoff of 200ms", retryDelay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"Mon, 01 May 2023 12:01:00 GMT", time.Minute},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestIncrementalCheckpoint(t *testing.T) {
+	var body atomic.Value
+	var since atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		since.Store(r.URL.Query().Get("since"))
+		fmt.Fprint(w, body.Load().(string))
+	}))
+	defer server.Close()
+
+	config := Config{Incremental: Incremental{TimeField: "published", IDField: "id", TimeParam: "since"}}
+	p := newTestPoller(server, config)
+	defer p.Close()
+
+	body.Store(`[{"id":"a","published":"2023-05-01T12:00:00Z"},{"id":"b","published":"2023-05-01T12:00:01Z"}]`)
+	got, err := p.Poll()
+	if err != nil || len(got) != 2 {
+		t.Fatalf("first Poll = %v, %v, want 2 events", got, err)
+	}
+	if since.Load().(string) != "" {
+		t.Errorf("first Poll sent since = %q, want none", since.Load())
+	}
+	if want := time.Date(2023, 5, 1, 12, 0, 1, 0, time.UTC); !p.Since().Equal(want) {
+		t.Errorf("Since = %v, want %v", p.Since(), want)
+	}
+
+	// The API returns the events at the cursor again, along with one that arrived in the same second after the last
+	// poll. Only the unseen events are returned.
+	body.Store(`[{"id":"a","published":"2023-05-01T12:00:00Z"},{"id":"b","published":"2023-05-01T12:00:01Z"},` +
+		`{"id":"c","published":"2023-05-01T12:00:01Z"},{"id":"d","published":"2023-05-01T12:00:02Z"}]`)
+	got, err = p.Poll()
+	want := []string{`{"id":"c","published":"2023-05-01T12:00:01Z"}`, `{"id":"d","published":"2023-05-01T12:00:02Z"}`}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("second Poll = %v, %v, want %v", got, err, want)
+	}
+	if since.Load().(string) != "2023-05-01T12:00:01.000Z" {
+		t.Errorf("second Poll sent since = %q, want the cursor", since.Load())
+	}
+
+	// A poller restored from the checkpoint drops the same events
+	restored := newTestPoller(server, config)
+	defer restored.Close()
+	restored.SetSince(p.Since())
+	restored.SetSeen(p.Seen())
+
+	body.Store(`[{"id":"d","published":"2023-05-01T12:00:02Z"},{"id":"e","published":"2023-05-01T12:00:02Z"}]`)
+	got, err = restored.Poll()
+	want = []string{`{"id":"e","published":"2023-05-01T12:00:02Z"}`}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("restored Poll = %v, %v, want %v", got, err, want)
+	}
+	if !reflect.DeepEqual(restored.Seen(), []string{"d", "e"}) {
+		t.Errorf("Seen = %v, want [d e]", restored.Seen())
+	}
+}
+
+func TestIncrementalKeyWithoutID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"msg":"x","ts":1682942400},{"msg":"y","ts":1682942400}]`)
+	}))
+	defer server.Close()
+
+	p := newTestPoller(server, Config{Incremental: Incremental{TimeField: "ts", TimeFormat: "unix"}})
+	defer p.Close()
+	p.SetSince(time.Unix(1682942400, 0))
+	p.SetSeen([]string{p.Config.Incremental.eventKey(`{"msg":"x","ts":1682942400}`)})
+
+	got, err := p.Poll()
+	if want := []string{`{"msg":"y","ts":1682942400}`}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Poll = %v, %v, want %v", got, err, want)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package httppoll
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Pagination is how the pages of a response are requested. A poll ends at a page with no events, or when there is no
+// next page.
+//   - none: the response has one page
+//   - link: the next page is the rel="next" url of the Link header, as with GitHub and Okta
+//   - cursor: the next page is requested with Param set to the value at CursorPath in the response body
+//   - offset: the next page is requested with Param set to the number of events read so far
+//   - page: the next page is requested with Param set to the page number, starting at 1
+type Pagination struct {
+	Type       string
+	CursorPath string // json path of the next cursor in the response body, for cursor pagination
+	Param      string // query param of the cursor, offset, or page number
+	LimitParam string // query param of the page size, if the API takes one
+	PageSize   int    // events per page. For offset and page pagination, a shorter page is the last page.
+	MaxPages   int    // most pages read by one poll
+}
+
+func (p *Pagination) setDefaults() {
+	if p.Type == "" {
+		p.Type = "none"
+	}
+	if p.Param == "" {
+		switch p.Type {
+		case "cursor":
+			p.Param = "cursor"
+		case "offset":
+			p.Param = "offset"
+		case "page":
+			p.Param = "page"
+		}
+	}
+	if p.MaxPages <= 0 {
+		p.MaxPages = 100
+	}
+}
+
+// pageState identifies the page to request
+type pageState struct {
+	url    string // next link, for link pagination
+	cursor string
+	offset int
+	page   int
+}
+
+// first returns the state of the first page
+func (p *Pagination) first() *pageState {
+	return &pageState{page: 1}
+}
+
+// setParams adds the query params that request page
+func (p *Pagination) setParams(query url.Values, page *pageState) {
+	if p.LimitParam != "" && p.PageSize > 0 {
+		query.Set(p.LimitParam, strconv.Itoa(p.PageSize))
+	}
+
+	switch p.Type {
+	case "cursor":
+		if page.cursor != "" {
+			query.Set(p.Param, page.cursor)
+		}
+	case "offset":
+		query.Set(p.Param, strconv.Itoa(page.offset))
+	case "page":
+		query.Set(p.Param, strconv.Itoa(page.page))
+	}
+}
+
+// next returns the state of the page after page, given the response to page and its number of events, or nil if it
+// was the last page
+func (p *Pagination) next(page *pageState, reqURL *url.URL, header http.Header, body []byte, events int) *pageState {
+	if events == 0 {
+		return nil
+	}
+
+	switch p.Type {
+	case "link":
+		link := nextLink(header)
+		if link == "" {
+			return nil
+		}
+		nextURL, err := reqURL.Parse(link) // a link may be relative to the request
+		if err != nil {
+			return nil
+		}
+		return &pageState{url: nextURL.String()}
+	case "cursor":
+		cursor := gjson.GetBytes(body, p.CursorPath).String()
+		if cursor == "" || cursor == page.cursor {
+			return nil
+		}
+		return &pageState{cursor: cursor}
+	case "offset", "page":
+		if p.PageSize > 0 && events < p.PageSize {
+			return nil
+		}
+		return &pageState{offset: page.offset + events, page: page.page + 1}
+	default:
+		return nil
+	}
+}
+
+// nextLink returns the rel="next" url of the Link headers, such as <https://api.example.com/logs?after=x>; rel="next",
+// or "" if there is none
+func nextLink(header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+
+			for _, param := range parts[1:] {
+				name, val, found := strings.Cut(strings.TrimSpace(param), "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(val), `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return target[1 : len(target)-1]
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
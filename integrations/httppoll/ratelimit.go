@@ -0,0 +1,164 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package httppoll
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// maxErrorBody bounds the part of an error response that is included in the error
+const maxErrorBody = 512
+
+// RateLimit bounds the rate of requests, and sets how failed requests are retried
+type RateLimit struct {
+	MaxCallsPerPeriod int // requests spread evenly over Period
+	Period            time.Duration
+	MaxRetries        int           // retries of a request that fails with 429, 5xx, or a network error
+	Backoff           time.Duration // delay before the first retry, doubled after each retry
+	MaxBackoff        time.Duration // longest delay before a retry, including one set by a Retry-After header
+}
+
+func (r *RateLimit) setDefaults() {
+	if r.MaxCallsPerPeriod <= 0 {
+		r.MaxCallsPerPeriod = 60
+	}
+	if r.Period <= 0 {
+		r.Period = time.Minute
+	}
+	if r.Backoff <= 0 {
+		r.Backoff = 5 * time.Second
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = 5 * time.Minute
+	}
+}
+
+// rateLimiter spaces requests at a steady rate, like rate_limit in vaero_cdk
+type rateLimiter struct {
+	interval time.Duration
+	last     time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{interval: limit.Period / time.Duration(limit.MaxCallsPerPeriod)}
+}
+
+// delay returns how long to wait before the next request
+func (r *rateLimiter) delay(now time.Time) time.Duration {
+	return r.last.Add(r.interval).Sub(now)
+}
+
+// send sends req, waiting for the rate limit, and retries it with exponential backoff if it fails with 429, 5xx, or
+// a network error. Other 4xx responses fail at once, as a retry would fail the same way. It returns the headers and
+// body of the response.
+func (p *Poller) send(req *http.Request) (http.Header, []byte, error) {
+	limit := p.Config.RateLimit
+
+	for attempt := 0; ; attempt++ {
+		if err := p.sleep(p.limiter.delay(time.Now())); err != nil {
+			return nil, nil, err
+		}
+		p.limiter.last = time.Now()
+
+		retryReq := req.Clone(p.ctx)
+		if req.GetBody != nil {
+			retryReq.Body, _ = req.GetBody()
+		}
+
+		resp, err := p.Client.Do(retryReq)
+		var retryAfter time.Duration
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = fmt.Errorf("%s %s: %w", p.Config.Method, p.Config.URL, urlErr.Err) // the url may hold a token
+		} else if err == nil {
+			body, readErr := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			switch {
+			case readErr != nil:
+				err = fmt.Errorf("could not read response: %w", readErr)
+			case resp.StatusCode < 300:
+				return resp.Header, body, nil
+			case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+				err = p.statusError(resp, body)
+				retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			default:
+				return nil, nil, p.statusError(resp, body)
+			}
+		}
+
+		if p.ctx.Err() != nil {
+			return nil, nil, p.ctx.Err()
+		}
+		if attempt >= limit.MaxRetries {
+			return nil, nil, fmt.Errorf("giving up after %d retries: %w", attempt, err)
+		}
+
+		delay := limit.Backoff << attempt
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if delay > limit.MaxBackoff || delay <= 0 {
+			delay = limit.MaxBackoff
+		}
+
+		log.Logger.Warn("Retrying HTTP poll request", zap.String("URL", p.Config.URL), zap.Int("Attempt", attempt+1),
+			zap.Duration("Delay", delay), zap.String("Error", err.Error()))
+
+		if err := p.sleep(delay); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// sleep waits for d, or returns an error if the poller is closed first
+func (p *Poller) sleep(d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-p.ctx.Done():
+		return errors.New("poller closed")
+	}
+}
+
+// statusError describes a failed response, with the start of its body, which often explains the failure. The url
+// of the request is left out, as it may hold a token.
+func (p *Poller) statusError(resp *http.Response, body []byte) error {
+	text := strings.TrimSpace(string(body))
+	if len(text) > maxErrorBody {
+		text = text[:maxErrorBody] + "..."
+	}
+	return fmt.Errorf("%s %s returned %s: %s", p.Config.Method, p.Config.URL, resp.Status, text)
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, in seconds or as an HTTP date, or 0 if there is none
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,373 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package pyproc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+)
+
+// Message is one line of the NDJSON protocol between Vaero and a Python worker process. Vaero writes requests to
+// the stdin of the worker, and the worker writes its replies to stdout. Everything else the worker prints, such as
+// debug output of a connector, must go to stderr, which Vaero logs.
+//
+// Vaero sends:
+//   - config: the Config of the worker, such as the args of a source, sent once when the worker starts. Config is
+//     sent on stdin rather than as command line arguments, so that credentials do not show in the process list.
+//   - read: read from the source of the worker, resuming from Checkpoint
+//   - transform: run the transform function of the worker on Events
+//   - shutdown: exit once the current request is finished
+//
+// The worker sends:
+//   - ready: the worker has read its config and is waiting for requests
+//   - events: a batch of Events in reply to the request with Id. A request may have several batches.
+//   - checkpoint: the Checkpoint after the events of the request with Id
+//   - log: a log line, at Level info, warning, error, or debug
+//   - error: the request with Id failed with Error. This ends the request.
+//   - done: the request with Id is finished
+type Message struct {
+	Type       string                 `json:"type"`
+	Id         int                    `json:"id,omitempty"`
+	Config     map[string]interface{} `json:"config,omitempty"`
+	Events     []json.RawMessage      `json:"events,omitempty"`
+	Checkpoint json.RawMessage        `json:"checkpoint,omitempty"`
+	Level      string                 `json:"level,omitempty"`
+	Msg        string                 `json:"msg,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Redacted replaces secrets in the output of a worker
+const Redacted = "[REDACTED]"
+
+// maxMessageBytes bounds the length of a line written by a worker, to stdout or stderr
+const maxMessageBytes = 64 * 1024 * 1024
+
+// startTimeout is how long a worker may take to send ready
+const startTimeout = 30 * time.Second
+
+// shutdownTimeout is how long a worker may take to exit after shutdown before it is killed
+const shutdownTimeout = 5 * time.Second
+
+// maxRestartDelay bounds the delay before a worker that keeps exiting is started again
+const maxRestartDelay = time.Minute
+
+// Worker is a long-running Python process, started with python -m Module, that serves requests one at a time. It is
+// started on the first request, and started again on a later request if it exits or times out.
+type Worker struct {
+	Name    string // name for logs, such as the op of the source
+	Module  string
+	Config  map[string]interface{} // sent to the worker when it starts
+	Secrets []string               // values of Config, such as tokens, that are redacted from the output of the worker
+	Timeout time.Duration          // time a request may take before the worker is killed
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	messages  chan Message  // replies of the worker, closed when its stdout closes
+	exited    chan struct{} // closed when the process has exited
+	nextId    int
+	failures  int // consecutive failed starts and requests, to delay restarts
+	lastStart time.Time
+}
+
+// NewWorker returns a Worker for the Python module with config, which is started on the first request
+func NewWorker(name string, module string, config map[string]interface{}) *Worker {
+	timeout := time.Duration(settings.Config.PythonTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+
+	return &Worker{Name: name, Module: module, Config: config, Timeout: timeout}
+}
+
+// Request sends req to the worker, and calls handle with each events and checkpoint message of the reply, until the
+// worker sends done. It returns the error sent by the worker, or an error if the worker exits or times out.
+func (w *Worker) Request(req Message, handle func(Message)) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.ensureRunning(); err != nil {
+		w.failures++
+		return err
+	}
+
+	w.nextId++
+	req.Id = w.nextId
+
+	if err := w.send(req); err != nil {
+		w.failures++
+		w.kill()
+		return fmt.Errorf("could not send request to Python worker: %w", err)
+	}
+
+	timer := time.NewTimer(w.Timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case msg, ok := <-w.messages:
+			if !ok {
+				w.failures++
+				return errors.New("Python worker exited during request")
+			}
+			if msg.Id != req.Id {
+				continue // a reply to an earlier request
+			}
+
+			switch msg.Type {
+			case "events", "checkpoint":
+				handle(msg)
+			case "error":
+				return errors.New(w.redact(msg.Error))
+			case "done":
+				w.failures = 0
+				return nil
+			}
+		case <-timer.C:
+			w.failures++
+			w.kill()
+			return fmt.Errorf("Python worker timed out after %v", w.Timeout)
+		}
+	}
+}
+
+// Close asks the worker to shut down, and kills it if it does not exit in time
+func (w *Worker) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cmd == nil {
+		return
+	}
+
+	w.send(Message{Type: "shutdown"})
+	w.stdin.Close()
+
+	select {
+	case <-w.exited:
+	case <-time.After(shutdownTimeout):
+		log.Logger.Error("Python worker did not shut down", zap.String("Worker", w.Name))
+	}
+	w.kill()
+}
+
+// ensureRunning starts the worker if it is not running. A worker that keeps failing is started again only after a
+// delay that grows with each failure, up to maxRestartDelay.
+func (w *Worker) ensureRunning() error {
+	if w.cmd != nil {
+		select {
+		case <-w.exited:
+			log.Logger.Error("Python worker exited", zap.String("Worker", w.Name), zap.String("Error", w.exitError()))
+			w.kill()
+		default:
+			return nil
+		}
+	}
+
+	if w.failures > 0 {
+		delay := time.Duration(w.failures) * time.Second
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+		if wait := time.Until(w.lastStart.Add(delay)); wait > 0 {
+			return fmt.Errorf("Python worker failed, restarting in %v", wait.Round(time.Second))
+		}
+		log.Logger.Info("Restarting Python worker", zap.String("Worker", w.Name), zap.Int("Failures", w.failures))
+	}
+
+	return w.start()
+}
+
+// start starts the process, sends its config, and waits for it to send ready
+func (w *Worker) start() error {
+	w.lastStart = time.Now()
+
+	cmd := exec.Command("python", "-m", w.Module)
+
+	// Activate virtual environment if selected
+	if settings.Config.PythonPath != "" {
+		cmd.Path = filepath.Join(settings.Config.PythonPath, "python")
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start Python worker: %w", err)
+	}
+
+	w.cmd = cmd
+	w.stdin = stdin
+	w.messages = make(chan Message)
+	w.exited = make(chan struct{})
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go w.readMessages(stdout, w.messages, &output)
+	go w.readStderr(stderr, &output)
+
+	exited := w.exited
+	go func() {
+		output.Wait() // Wait must not be called before the pipes are read
+		cmd.Wait()
+		close(exited)
+	}()
+
+	if err := w.send(Message{Type: "config", Config: w.Config}); err != nil {
+		w.kill()
+		return fmt.Errorf("could not send config to Python worker: %w", err)
+	}
+
+	timer := time.NewTimer(startTimeout)
+	defer timer.Stop()
+
+	select {
+	case msg, ok := <-w.messages:
+		if ok && msg.Type == "ready" {
+			log.Logger.Info("Started Python worker", zap.String("Worker", w.Name), zap.Int("Pid", cmd.Process.Pid))
+			return nil
+		}
+		w.kill()
+		return errors.New("Python worker exited before it was ready")
+	case <-timer.C:
+		w.kill()
+		return fmt.Errorf("Python worker was not ready after %v", startTimeout)
+	}
+}
+
+// readMessages decodes the lines written by the worker to stdout. Log messages are logged here, so that they are
+// logged between requests too, and other messages are sent to messages.
+func (w *Worker) readMessages(stdout io.Reader, messages chan Message, output *sync.WaitGroup) {
+	defer output.Done()
+	defer close(messages)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageBytes)
+
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Logger.Error("Invalid message from Python worker", zap.String("Worker", w.Name), zap.String("Error", err.Error()))
+			continue
+		}
+
+		if msg.Type == "log" {
+			w.log(msg)
+			continue
+		}
+
+		messages <- msg
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Logger.Error("Could not read from Python worker", zap.String("Worker", w.Name), zap.String("Error", err.Error()))
+		io.Copy(io.Discard, stdout) // let the worker exit
+	}
+}
+
+// readStderr logs the lines written by the worker to stderr, such as debug output, tracebacks, and stray prints
+func (w *Worker) readStderr(stderr io.Reader, output *sync.WaitGroup) {
+	defer output.Done()
+
+	scanner := bufio.NewScanner(stderr)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageBytes)
+
+	for scanner.Scan() {
+		log.Logger.Debug("Python worker output", zap.String("Worker", w.Name), zap.String("Output", w.redact(scanner.Text())))
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Logger.Error("Could not read Python worker output", zap.String("Worker", w.Name), zap.String("Error", err.Error()))
+		io.Copy(io.Discard, stderr) // let the worker exit
+	}
+}
+
+// log logs a log message of the worker at its level
+func (w *Worker) log(msg Message) {
+	fields := []zap.Field{zap.String("Worker", w.Name)}
+	text := w.redact(msg.Msg)
+
+	switch strings.ToLower(msg.Level) {
+	case "debug":
+		log.Logger.Debug(text, fields...)
+	case "warning", "warn":
+		log.Logger.Warn(text, fields...)
+	case "error", "critical":
+		log.Logger.Error(text, fields...)
+	default:
+		log.Logger.Info(text, fields...)
+	}
+}
+
+// redact replaces the secrets of the worker in text, such as a traceback that includes a token
+func (w *Worker) redact(text string) string {
+	for _, secret := range w.Secrets {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, Redacted)
+		}
+	}
+	return text
+}
+
+// send writes msg to the stdin of the worker as one line
+func (w *Worker) send(msg Message) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.stdin.Write(append(line, '\n'))
+	return err
+}
+
+// kill kills the process, if any, and waits for its output to be read
+func (w *Worker) kill() {
+	if w.cmd == nil {
+		return
+	}
+
+	w.cmd.Process.Kill()
+	w.stdin.Close()
+
+	// Discard replies that were not read, so the reader can finish
+	go drainMessages(w.messages)
+	<-w.exited
+
+	w.cmd = nil
+}
+
+// exitError describes how the process exited
+func (w *Worker) exitError() string {
+	if w.cmd.ProcessState == nil {
+		return "unknown"
+	}
+	return w.cmd.ProcessState.String()
+}
+
+// drainMessages discards messages until the channel is closed
+func drainMessages(messages chan Message) {
+	for range messages {
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package pyproc
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestReadStderrLongLine(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	log.Logger = zap.New(core)
+
+	w := &Worker{Name: "test", Secrets: []string{"tok-123"}}
+	reader, writer := io.Pipe()
+
+	var output sync.WaitGroup
+	output.Add(1)
+	go w.readStderr(reader, &output)
+
+	// A line longer than the default buffer of a bufio.Scanner, such as a long traceback, must not stop the reads
+	written := make(chan error, 1)
+	go func() {
+		_, err := io.WriteString(writer, strings.Repeat("x", 100*1024)+"\nlast tok-123\n")
+		writer.Close()
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker blocked writing to stderr")
+	}
+	output.Wait()
+
+	entries := logs.FilterMessage("Python worker output").All()
+	if len(entries) != 2 {
+		t.Fatalf("logged %d lines, want 2", len(entries))
+	}
+	if got := entries[1].ContextMap()["Output"]; got != "last "+Redacted {
+		t.Errorf("last line = %q, want it redacted", got)
+	}
+}
@@ -0,0 +1,81 @@
+#
+# Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+#
+import json, sys, traceback
+from typing import Any, Iterable, Iterator, Mapping, Optional
+
+# Largest number of events sent in one events message
+BATCH_SIZE = 1000
+
+class Protocol:
+    """
+    NDJSON protocol between Vaero and a long-running Python worker. Vaero writes one json request per line to
+    stdin, and the worker writes one json message per line to stdout. Creating the protocol redirects sys.stdout
+    to stderr, so that stray prints, such as debug output of a connector, cannot corrupt the messages. Vaero logs
+    stderr at debug level.
+    """
+
+    def __init__(self):
+        self._out = sys.stdout
+        sys.stdout = sys.stderr
+
+    def send(self, message: Mapping[str, Any]):
+        self._out.write(json.dumps(message) + "\n")
+        self._out.flush()
+
+    def ready(self):
+        self.send({"type": "ready"})
+
+    def log(self, level: str, msg: str):
+        self.send({"type": "log", "level": level, "msg": msg})
+
+    def events(self, request_id: int, event_list: Iterable[Any]):
+        """
+        Send the events of the request, in batches of at most BATCH_SIZE
+        """
+        batch = []
+        for event in event_list:
+            batch.append(event)
+            if len(batch) >= BATCH_SIZE:
+                self.send({"type": "events", "id": request_id, "events": batch})
+                batch = []
+        if batch:
+            self.send({"type": "events", "id": request_id, "events": batch})
+
+    def checkpoint(self, request_id: int, checkpoint: Optional[Mapping[str, Any]]):
+        self.send({"type": "checkpoint", "id": request_id, "checkpoint": checkpoint})
+
+    def done(self, request_id: int):
+        self.send({"type": "done", "id": request_id})
+
+    def error(self, request_id: int, exc: BaseException):
+        """
+        Fail the request with the exception, and log its traceback
+        """
+        self.log("error", "".join(traceback.format_exception(type(exc), exc, exc.__traceback__)))
+        self.send({"type": "error", "id": request_id, "error": f"{type(exc).__name__}: {exc}"})
+
+    def config(self) -> Mapping[str, Any]:
+        """
+        Return the config that Vaero sends when the worker starts. It is sent on stdin rather than as command line
+        arguments, so that credentials do not show in the process list.
+        """
+        line = sys.stdin.readline()
+        if not line.strip():
+            return {}
+        message = json.loads(line)
+        if message.get("type") != "config":
+            raise ValueError(f"expected config message, got {message.get('type')}")
+        return message.get("config") or {}
+
+    def requests(self) -> Iterator[Mapping[str, Any]]:
+        """
+        Yield the requests from Vaero until shutdown or stdin is closed
+        """
+        for line in sys.stdin:
+            if not line.strip():
+                continue
+            request = json.loads(line)
+            if request.get("type") == "shutdown":
+                return
+            yield request
@@ -1,38 +1,71 @@
 #
 # Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
 #
-import getopt, json, sys
-from integrations.python.source_okta import OktaSource
-
-def main(argv):
-    opts, args = getopt.getopt(argv, "", ["op=", "host=", "token=",
-        "name=", "max_calls_per_period=", "limit_period=", "max_retries="])
-
-    source = None
-    flags = {}
-
-    # Set flags
-    for opt, arg in opts:
-        flags[opt] = arg
-    
-    interval = flags.get("--interval")
-    host = flags.get("--host")
-    token = flags.get("--token")
-    name = flags.get("--name")
-    max_calls_per_period = int(flags.get("--max_calls_per_period", "30"))
-    limit_period = int(flags.get("--limit_period", "60"))
-    max_retries = int(flags.get("--max_retries", "5"))
-
-    # Set source
-    if(flags["--op"] == "okta"):
-        source = OktaSource(interval, host, token, name, max_calls_per_period, limit_period, max_retries)
-
-    # Read from source and dump events as json
-    event_list = source.read()
-    json_list = json.dumps(event_list)
-    print("__Python Source Driver Output__")
-    print(f"{json_list}")
-    print("__End Python Source Driver Output__")
+import importlib, inspect, sys
+from integrations.python.protocol import Protocol
+from vaero_cdk.http_connector import HTTPConnector
+
+def find_connector(module, class_name):
+    """
+    Return the connector class of the module: the class named class_name, or else the only HTTPConnector subclass
+    defined in the module
+    """
+    if class_name:
+        connector = getattr(module, class_name, None)
+        if not inspect.isclass(connector):
+            raise ValueError(f"{module.__name__} has no class {class_name}")
+        return connector
+
+    connectors = [c for _, c in inspect.getmembers(module, inspect.isclass)
+                  if issubclass(c, HTTPConnector) and c.__module__ == module.__name__ and not inspect.isabstract(c)]
+    if len(connectors) != 1:
+        raise ValueError(f"{module.__name__} defines {len(connectors)} connectors, select one with class")
+    return connectors[0]
+
+def create_source(connector, args):
+    """
+    Create the connector with the args that its constructor takes, or all args if it takes **kwargs
+    """
+    params = inspect.signature(connector).parameters
+    if any(p.kind == inspect.Parameter.VAR_KEYWORD for p in params.values()):
+        return connector(**args)
+    return connector(**{k: v for k, v in args.items() if k in params})
+
+def main():
+    # Take over stdout before anything can print to it
+    protocol = Protocol()
+
+    # The module, class, and args of the source, including credentials, arrive on stdin
+    config = protocol.config()
+
+    try:
+        module = importlib.import_module(config.get("module", ""))
+        source = create_source(find_connector(module, config.get("class", "")), config.get("args") or {})
+    except Exception as exc:
+        protocol.log("error", f"Could not create Python source {config.get('module')}: {type(exc).__name__}: {exc}")
+        return 1
+
+    protocol.ready()
+
+    # Serve read requests until Vaero shuts the source down. Each read resumes from the checkpoint that Vaero
+    # committed after the previous read.
+    for request in protocol.requests():
+        request_id = request.get("id", 0)
+        if request.get("type") != "read":
+            protocol.error(request_id, ValueError(f"unknown request type {request.get('type')}"))
+            continue
+
+        try:
+            if hasattr(source, "checkpoint"):
+                source.checkpoint = request.get("checkpoint")
+            event_list = source.read()
+            protocol.events(request_id, event_list)
+            protocol.checkpoint(request_id, getattr(source, "checkpoint", None))
+            protocol.done(request_id)
+        except Exception as exc:
+            protocol.error(request_id, exc)
+
+    return 0
 
 if __name__ == "__main__":
-    main(sys.argv[1:])
\ No newline at end of file
+    sys.exit(main())
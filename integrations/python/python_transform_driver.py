@@ -0,0 +1,75 @@
+#
+# Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+#
+import importlib, sys, traceback
+from integrations.python.protocol import Protocol
+
+# Largest number of failed events logged with their traceback per request
+MAX_LOGGED_FAILURES = 5
+
+def apply(func, events, on_error, protocol):
+    """
+    Run func on each event. func returns an event, a list of events, or None to drop the event. An event for which
+    func raises is passed on unchanged, or dropped if on_error is drop.
+    """
+    result = []
+    failures = 0
+    for event in events:
+        try:
+            out = func(event)
+        except Exception as exc:
+            failures += 1
+            if failures <= MAX_LOGGED_FAILURES:
+                protocol.log("warning", "".join(traceback.format_exception(type(exc), exc, exc.__traceback__)))
+            if on_error != "drop":
+                result.append(event)
+            continue
+
+        if out is None:
+            continue
+        elif isinstance(out, list):
+            result.extend(out)
+        else:
+            result.append(out)
+
+    if failures > MAX_LOGGED_FAILURES:
+        protocol.log("warning", f"Transform function failed on {failures} events")
+
+    return result
+
+def main():
+    # Take over stdout before anything can print to it
+    protocol = Protocol()
+
+    # The module and function of the transform arrive on stdin
+    config = protocol.config()
+    on_error = config.get("on_error", "pass")
+
+    try:
+        module = importlib.import_module(config.get("module", ""))
+        func = getattr(module, config.get("func", ""))
+        if not callable(func):
+            raise TypeError(f"{config.get('func')} is not a function")
+    except Exception as exc:
+        protocol.log("error", f"Could not load Python transform {config.get('module')}.{config.get('func')}: {type(exc).__name__}: {exc}")
+        return 1
+
+    protocol.ready()
+
+    # Serve transform requests until Vaero shuts the transform down
+    for request in protocol.requests():
+        request_id = request.get("id", 0)
+        if request.get("type") != "transform":
+            protocol.error(request_id, ValueError(f"unknown request type {request.get('type')}"))
+            continue
+
+        try:
+            protocol.events(request_id, apply(func, request.get("events") or [], on_error, protocol))
+            protocol.done(request_id)
+        except Exception as exc:
+            protocol.error(request_id, exc)
+
+    return 0
+
+if __name__ == "__main__":
+    sys.exit(main())
@@ -68,7 +68,6 @@ class GoogleWorkspaceSource(HTTPConnector):
         super().__init__(**kwargs)
 
         # cursor:  { app_name, seconds since 1970 }
-        self._cursor_location = "google_workspace_cursor"
         self._secrets = api_token
 
         self._token = None
@@ -25,7 +25,6 @@ class OktaSource(HTTPConnector):
         self._url_base = host
         self._okta_token = token
         self._name = name
-        self._cursor_location = f"{name}_cursor"
 
     def authorize(self) -> bool:
         return True
@@ -0,0 +1,87 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package secrets
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/vaerohq/vaero/settings"
+)
+
+// awsSecretsManager reads secrets from AWS Secrets Manager, with the credentials of the AWS SDK's default external
+// configuration
+type awsSecretsManager struct {
+	mu     sync.Mutex
+	client *secretsmanager.Client
+}
+
+// Get returns the current value of the secret with the name or ARN path. Binary secrets are returned as is.
+func (p *awsSecretsManager) Get(ctx context.Context, path string) (string, error) {
+	p.mu.Lock()
+	if p.client == nil {
+		sdkConfig, err := loadAWSConfig(ctx)
+		if err != nil {
+			p.mu.Unlock()
+			return "", err
+		}
+		p.client = secretsmanager.NewFromConfig(sdkConfig)
+	}
+	client := p.client
+	p.mu.Unlock()
+
+	out, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(path)})
+	if err != nil {
+		return "", err
+	}
+
+	if out.SecretString != nil {
+		return *out.SecretString, nil
+	}
+	return string(out.SecretBinary), nil
+}
+
+// awsParameterStore reads parameters from AWS Systems Manager Parameter Store, decrypting SecureString parameters
+type awsParameterStore struct {
+	mu     sync.Mutex
+	client *ssm.Client
+}
+
+// Get returns the value of the parameter named path
+func (p *awsParameterStore) Get(ctx context.Context, path string) (string, error) {
+	p.mu.Lock()
+	if p.client == nil {
+		sdkConfig, err := loadAWSConfig(ctx)
+		if err != nil {
+			p.mu.Unlock()
+			return "", err
+		}
+		p.client = ssm.NewFromConfig(sdkConfig)
+	}
+	client := p.client
+	p.mu.Unlock()
+
+	out, err := client.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(path), WithDecryption: aws.Bool(true)})
+	if err != nil {
+		return "", err
+	}
+
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return "", errors.New("parameter has no value")
+	}
+	return *out.Parameter.Value, nil
+}
+
+// loadAWSConfig loads the AWS SDK's default external configuration, in the region AWSRegion if it is set
+func loadAWSConfig(ctx context.Context) (aws.Config, error) {
+	if settings.Config.AWSRegion != "" {
+		return config.LoadDefaultConfig(ctx, config.WithRegion(settings.Config.AWSRegion))
+	}
+	return config.LoadDefaultConfig(ctx)
+}
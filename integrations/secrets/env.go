@@ -0,0 +1,22 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// envProvider looks up secrets in the environment variables of Vaero
+type envProvider struct{}
+
+// Get returns the value of the environment variable named path
+func (envProvider) Get(_ context.Context, path string) (string, error) {
+	value, ok := os.LookupEnv(path)
+	if !ok {
+		return "", errors.New("environment variable is not set")
+	}
+	return value, nil
+}
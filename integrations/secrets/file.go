@@ -0,0 +1,38 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxFileBytes bounds the size of a secret file
+const maxFileBytes = 1 << 20
+
+// fileProvider reads secrets from files, such as the secrets that Kubernetes mounts into a pod
+type fileProvider struct{}
+
+// Get returns the contents of the file at path, without a trailing newline. Files are read again when their cached
+// value expires, so secrets that Kubernetes rotates in a mounted volume are picked up.
+func (fileProvider) Get(_ context.Context, path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("is a folder, not a file")
+	}
+	if info.Size() > maxFileBytes {
+		return "", fmt.Errorf("file is larger than %d bytes", maxFileBytes)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,244 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+
+// Package secrets resolves references to secrets in the args of a task. A reference is written
+// ${secret:provider:path}, and may be the whole value of an arg or part of it, such as "Bearer ${secret:env:TOKEN}".
+// The providers are
+//
+//	env    an environment variable, such as ${secret:env:SPLUNK_TOKEN}
+//	file   the contents of a file, such as a Kubernetes mounted secret ${secret:file:/var/run/secrets/splunk/token}
+//	vault  a HashiCorp Vault KV v2 secret, as mount/path, such as ${secret:vault:secret/splunk#token}
+//	aws    an AWS Secrets Manager secret, by name or ARN, such as ${secret:aws:prod/splunk#token}
+//	ssm    an AWS Systems Manager Parameter Store parameter, decrypted, such as ${secret:ssm:/prod/splunk/token}
+//
+// A path may end with #field to select a field of a secret that is a json object, such as the token field of the
+// vault and aws secrets above. Resolved values are cached for SecretCacheSeconds, and each lookup may take at most
+// SecretTimeoutSeconds.
+//
+// References resolve to strings, so they may only be used in string args, or in strings nested in list and object
+// args, and not in bool or number args such as a port.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/vaerohq/vaero/settings"
+)
+
+// Provider looks up secrets by path
+type Provider interface {
+	// Get returns the value of the secret at path, which does not include a #field
+	Get(ctx context.Context, path string) (string, error)
+}
+
+var providers = map[string]Provider{
+	"aws":   &awsSecretsManager{},
+	"env":   envProvider{},
+	"file":  fileProvider{},
+	"ssm":   &awsParameterStore{},
+	"vault": &vaultProvider{},
+}
+
+// refPrefix starts every reference
+const refPrefix = "${secret:"
+
+// refPattern matches a well formed reference, capturing its provider and path
+var refPattern = regexp.MustCompile(`\$\{secret:([a-z0-9_]+):([^}]+)\}`)
+
+// Providers returns the names of the providers, sorted
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasRefs reports whether a value, or any string nested in it, contains a reference
+func HasRefs(value interface{}) bool {
+	switch val := value.(type) {
+	case string:
+		return strings.Contains(val, refPrefix)
+	case map[string]interface{}:
+		for _, v := range val {
+			if HasRefs(v) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range val {
+			if HasRefs(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check checks that every reference in a value, or in any string nested in it, is well formed and names a known
+// provider, without looking up the secrets
+func Check(value interface{}) error {
+	switch val := value.(type) {
+	case string:
+		return checkString(val)
+	case map[string]interface{}:
+		for _, v := range val {
+			if err := Check(v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, v := range val {
+			if err := Check(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkString checks the references in a string
+func checkString(s string) error {
+	if strings.Count(s, refPrefix) != len(refPattern.FindAllStringIndex(s, -1)) {
+		return fmt.Errorf("invalid secret reference, must be written ${secret:provider:path}")
+	}
+
+	for _, match := range refPattern.FindAllStringSubmatch(s, -1) {
+		if _, ok := providers[match[1]]; !ok {
+			return fmt.Errorf("unknown secret provider %q, must be one of %s", match[1], strings.Join(Providers(), ", "))
+		}
+	}
+	return nil
+}
+
+// Resolve returns a copy of value with every reference replaced by the value of its secret. Maps and lists are
+// copied, so that value is not modified. It returns an error for the first secret that cannot be looked up.
+func Resolve(value interface{}) (interface{}, error) {
+	switch val := value.(type) {
+	case string:
+		return resolveString(val)
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			r, err := Resolve(v)
+			if err != nil {
+				return nil, err
+			}
+			resolved[k] = r
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(val))
+		for idx, v := range val {
+			r, err := Resolve(v)
+			if err != nil {
+				return nil, err
+			}
+			resolved[idx] = r
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+// resolveString replaces the references in a string
+func resolveString(s string) (string, error) {
+	if !strings.Contains(s, refPrefix) {
+		return s, nil
+	}
+	if err := checkString(s); err != nil {
+		return "", err
+	}
+
+	var firstErr error
+	resolved := refPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if firstErr != nil {
+			return ""
+		}
+		match := refPattern.FindStringSubmatch(ref)
+		value, err := lookup(match[1], match[2])
+		if err != nil {
+			firstErr = err
+		}
+		return value
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return resolved, nil
+}
+
+// cachedSecret is a looked up secret and when it was looked up
+type cachedSecret struct {
+	value   string
+	fetched time.Time
+}
+
+var cacheMutex sync.Mutex
+var cache = map[string]cachedSecret{} // by provider:path
+
+// lookup returns the value of a secret, from the cache if it was looked up less than SecretCacheSeconds ago.
+// Errors name the provider and path of the secret, but never include its value.
+func lookup(provider string, path string) (string, error) {
+	key := provider + ":" + path
+	ttl := time.Duration(settings.Config.SecretCacheSeconds) * time.Second
+
+	cacheMutex.Lock()
+	cached, ok := cache[key]
+	cacheMutex.Unlock()
+	if ok && time.Since(cached.fetched) < ttl {
+		return cached.value, nil
+	}
+
+	secretPath, field, hasField := strings.Cut(path, "#")
+
+	timeout := time.Duration(settings.Config.SecretTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	value, err := providers[provider].Get(ctx, secretPath)
+	if err != nil {
+		return "", fmt.Errorf("could not get secret %s:%s: %w", provider, secretPath, err)
+	}
+
+	if hasField {
+		if value, err = selectField(value, field); err != nil {
+			return "", fmt.Errorf("could not get secret %s:%s: %w", provider, path, err)
+		}
+	}
+
+	cacheMutex.Lock()
+	cache[key] = cachedSecret{value: value, fetched: time.Now()}
+	cacheMutex.Unlock()
+
+	return value, nil
+}
+
+// selectField returns a field of a secret that is a json object. Fields are gjson paths, so nested fields are
+// selected with dots.
+func selectField(value string, field string) (string, error) {
+	if !gjson.Valid(value) {
+		return "", fmt.Errorf("secret is not json, so it has no field %q", field)
+	}
+
+	result := gjson.Get(value, field)
+	if !result.Exists() {
+		return "", fmt.Errorf("secret has no field %q", field)
+	}
+	if result.Type == gjson.String {
+		return result.Str, nil
+	}
+	return result.Raw, nil
+}
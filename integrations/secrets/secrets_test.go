@@ -0,0 +1,370 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package secrets
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vaerohq/vaero/settings"
+)
+
+// fakeProvider returns the values of its secrets, counting the lookups of each, and blocks until the lookup times
+// out for the path "slow"
+type fakeProvider struct {
+	mu     sync.Mutex
+	values map[string]string
+	calls  map[string]int
+}
+
+func (p *fakeProvider) Get(ctx context.Context, path string) (string, error) {
+	p.mu.Lock()
+	p.calls[path]++
+	p.mu.Unlock()
+
+	if path == "slow" {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	value, ok := p.values[path]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return value, nil
+}
+
+// useFakeProvider registers a fake provider named fake, with an empty cache and the given settings, for the
+// duration of the test
+func useFakeProvider(t *testing.T, values map[string]string, cacheSeconds int, timeoutSeconds int) *fakeProvider {
+	t.Helper()
+
+	fake := &fakeProvider{values: values, calls: map[string]int{}}
+	providers["fake"] = fake
+
+	cacheMutex.Lock()
+	cache = map[string]cachedSecret{}
+	cacheMutex.Unlock()
+
+	oldCache, oldTimeout := settings.Config.SecretCacheSeconds, settings.Config.SecretTimeoutSeconds
+	settings.Config.SecretCacheSeconds, settings.Config.SecretTimeoutSeconds = cacheSeconds, timeoutSeconds
+
+	t.Cleanup(func() {
+		delete(providers, "fake")
+		settings.Config.SecretCacheSeconds, settings.Config.SecretTimeoutSeconds = oldCache, oldTimeout
+	})
+
+	return fake
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr string // empty if valid
+	}{
+		{name: "no reference", value: "plain"},
+		{name: "whole value", value: "${secret:env:TOKEN}"},
+		{name: "part of value", value: "Bearer ${secret:env:TOKEN}"},
+		{name: "several references", value: "${secret:env:USER}:${secret:file:/run/password}"},
+		{name: "nested in object and list", value: map[string]interface{}{
+			"headers": []interface{}{"${secret:vault:secret/api#key}", 3}}},
+		{name: "unclosed", value: "${secret:env:TOKEN", wantErr: "invalid secret reference"},
+		{name: "no path", value: "${secret:env:}", wantErr: "invalid secret reference"},
+		{name: "no provider", value: "${secret::TOKEN}", wantErr: "invalid secret reference"},
+		{name: "one of several malformed", value: "${secret:env:USER}:${secret:env", wantErr: "invalid secret reference"},
+		{name: "unknown provider", value: "${secret:keychain:TOKEN}", wantErr: `unknown secret provider "keychain"`},
+		{name: "unknown provider nested", value: []interface{}{"${secret:gcp:token}"},
+			wantErr: `unknown secret provider "gcp"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Check(%v) returned error: %v", tt.value, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Check(%v) = %v, want %q", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	useFakeProvider(t, map[string]string{
+		"user": "admin",
+		"pass": "hunter2",
+	}, 60, 10)
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    interface{}
+		wantErr string
+	}{
+		{name: "whole value", value: "${secret:fake:user}", want: "admin"},
+		{name: "several references", value: "${secret:fake:user}:${secret:fake:pass}", want: "admin:hunter2"},
+		{name: "nested", value: map[string]interface{}{"auth": []interface{}{"Basic ${secret:fake:user}", 1}},
+			want: map[string]interface{}{"auth": []interface{}{"Basic admin", 1}}},
+		{name: "not a string", value: 5, want: 5},
+		{name: "missing secret", value: "${secret:fake:nope}", wantErr: "could not get secret fake:nope: not found"},
+		{name: "malformed", value: "${secret:fake:user", wantErr: "invalid secret reference"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Resolve(%v) = %v, want error %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%v) returned error: %v", tt.value, err)
+			}
+			if !equalValues(got, tt.want) {
+				t.Errorf("Resolve(%v) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// equalValues compares resolved values, which are strings, ints, and maps and lists of them
+func equalValues(a interface{}, b interface{}) bool {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k := range av {
+			if !equalValues(av[k], bv[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for idx := range av {
+			if !equalValues(av[idx], bv[idx]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func TestResolveField(t *testing.T) {
+	useFakeProvider(t, map[string]string{
+		"json":  `{"token": "abc", "nested": {"key": "xyz"}, "port": 8443, "list": [1, 2]}`,
+		"plain": "not json",
+	}, 60, 10)
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{ref: "${secret:fake:json#token}", want: "abc"},
+		{ref: "${secret:fake:json#nested.key}", want: "xyz"},
+		{ref: "${secret:fake:json#port}", want: "8443"},
+		{ref: "${secret:fake:json#list}", want: "[1, 2]"},
+		{ref: "${secret:fake:json#nested}", want: `{"key": "xyz"}`},
+		{ref: "${secret:fake:json#missing}", wantErr: `could not get secret fake:json#missing: secret has no field "missing"`},
+		{ref: "${secret:fake:plain#token}", wantErr: `secret is not json, so it has no field "token"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := Resolve(tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Resolve(%s) = %v, want error %q", tt.ref, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Resolve(%s) = %v, %v, want %s", tt.ref, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	fake := useFakeProvider(t, map[string]string{"token": "v1", "json": `{"a": "1", "b": "2"}`}, 60, 10)
+
+	tests := []struct {
+		name      string
+		step      func()
+		path      string
+		want      string
+		wantCalls int
+	}{
+		{name: "first lookup", path: "token", want: "v1", wantCalls: 1},
+		{name: "cache hit", step: func() {
+			fake.mu.Lock()
+			fake.values["token"] = "v2"
+			fake.mu.Unlock()
+		}, path: "token", want: "v1", wantCalls: 1},
+		{name: "expired", step: func() {
+			cacheMutex.Lock()
+			cached := cache["fake:token"]
+			cached.fetched = time.Now().Add(-60 * time.Second)
+			cache["fake:token"] = cached
+			cacheMutex.Unlock()
+		}, path: "token", want: "v2", wantCalls: 2},
+		{name: "hit after refresh", path: "token", want: "v2", wantCalls: 2},
+		{name: "fields are cached separately", path: "json#a", want: "1", wantCalls: 1},
+		{name: "other field", path: "json#b", want: "2", wantCalls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.step != nil {
+				tt.step()
+			}
+
+			got, err := lookup("fake", tt.path)
+			if err != nil || got != tt.want {
+				t.Errorf("lookup(%s) = %v, %v, want %s", tt.path, got, err, tt.want)
+			}
+
+			secretPath, _, _ := strings.Cut(tt.path, "#")
+			fake.mu.Lock()
+			calls := fake.calls[secretPath]
+			fake.mu.Unlock()
+			if calls != tt.wantCalls {
+				t.Errorf("provider was called %d times for %s, want %d", calls, secretPath, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestLookupNotCachedWithoutCacheSeconds(t *testing.T) {
+	fake := useFakeProvider(t, map[string]string{"token": "v1"}, 0, 10)
+
+	for i := 0; i < 2; i++ {
+		if _, err := lookup("fake", "token"); err != nil {
+			t.Fatalf("lookup returned error: %v", err)
+		}
+	}
+	if fake.calls["token"] != 2 {
+		t.Errorf("provider was called %d times, want 2", fake.calls["token"])
+	}
+}
+
+func TestLookupTimeout(t *testing.T) {
+	useFakeProvider(t, nil, 60, 1)
+
+	start := time.Now()
+	_, err := lookup("fake", "slow")
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lookup = %v, want deadline exceeded", err)
+	}
+	if elapsed < time.Second || elapsed > 5*time.Second {
+		t.Errorf("lookup took %v, want about SecretTimeoutSeconds", elapsed)
+	}
+
+	cacheMutex.Lock()
+	_, cached := cache["fake:slow"]
+	cacheMutex.Unlock()
+	if cached {
+		t.Error("failed lookup was cached")
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("VAERO_TEST_SECRET", "s3cret")
+	t.Setenv("VAERO_TEST_EMPTY", "")
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "VAERO_TEST_SECRET", want: "s3cret"},
+		{path: "VAERO_TEST_EMPTY", want: ""},
+		{path: "VAERO_TEST_UNSET", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := envProvider{}.Get(context.Background(), tt.path)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Get(%s) = %q, %v, want %q", tt.path, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr string
+	}{
+		{name: "trailing newline", path: write("token", "s3cret\n"), want: "s3cret"},
+		{name: "windows newline", path: write("crlf", "s3cret\r\n"), want: "s3cret"},
+		{name: "inner newlines kept", path: write("pem", "line1\nline2\n"), want: "line1\nline2"},
+		{name: "missing", path: filepath.Join(dir, "missing"), wantErr: "no such file"},
+		{name: "folder", path: dir, wantErr: "is a folder"},
+		{name: "too large", path: write("large", strings.Repeat("x", maxFileBytes+1)), wantErr: "larger than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fileProvider{}.Get(context.Background(), tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Get(%s) = %v, want error %q", tt.path, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Get(%s) = %q, %v, want %q", tt.path, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveEnvAndFile(t *testing.T) {
+	useFakeProvider(t, nil, 60, 10)
+	t.Setenv("VAERO_TEST_USER", "admin")
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Resolve("${secret:env:VAERO_TEST_USER}:${secret:file:" + path + "}")
+	if err != nil || got != "admin:hunter2" {
+		t.Errorf("Resolve = %v, %v, want admin:hunter2", got, err)
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/vaerohq/vaero/settings"
+)
+
+// maxVaultResponseBytes bounds the body of a response from Vault
+const maxVaultResponseBytes = 1 << 20
+
+// errVaultForbidden is returned for a 403 response, which for an AppRole token usually means it has expired
+var errVaultForbidden = errors.New("permission denied by vault")
+
+// vaultProvider reads HashiCorp Vault KV v2 secrets. It authenticates with VaultToken, or logs in with the AppRole
+// VaultRoleId and VaultSecretId, keeping the token until its lease is nearly over.
+type vaultProvider struct {
+	mu           sync.Mutex
+	token        string // AppRole token
+	tokenExpires time.Time
+}
+
+// Get returns the data of the KV v2 secret at path as a json object. The path is the mount of the secrets engine
+// followed by the path of the secret, such as secret/splunk, and may also be given as the API path secret/data/splunk.
+func (v *vaultProvider) Get(ctx context.Context, path string) (string, error) {
+	addr := strings.TrimRight(settingOrEnv(settings.Config.VaultAddress, "VAULT_ADDR"), "/")
+	if addr == "" {
+		return "", errors.New("set VaultAddress in vaero.cfg, or the VAULT_ADDR environment variable")
+	}
+
+	mount, secretPath, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok || secretPath == "" {
+		return "", errors.New("path must be the mount followed by the path of the secret, such as secret/splunk")
+	}
+	secretPath = strings.TrimPrefix(secretPath, "data/")
+	url := fmt.Sprintf("%s/v1/%s/data/%s", addr, mount, secretPath)
+
+	body, err := v.read(ctx, addr, url)
+	if errors.Is(err, errVaultForbidden) && settings.Config.VaultRoleId != "" {
+		// The AppRole token may have been revoked before its lease was over, so log in again once
+		v.mu.Lock()
+		v.token = ""
+		v.mu.Unlock()
+		body, err = v.read(ctx, addr, url)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	data := gjson.GetBytes(body, "data.data")
+	if !data.IsObject() {
+		return "", errors.New("response has no secret data, check that the mount is a KV version 2 secrets engine")
+	}
+	return data.Raw, nil
+}
+
+// read sends an authenticated GET request to vault
+func (v *vaultProvider) read(ctx context.Context, addr string, url string) ([]byte, error) {
+	token, err := v.authToken(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	return sendVault(req)
+}
+
+// authToken returns VaultToken, or the token of the AppRole, logging in if there is none or its lease is nearly over
+func (v *vaultProvider) authToken(ctx context.Context, addr string) (string, error) {
+	if settings.Config.VaultRoleId == "" {
+		token := settingOrEnv(settings.Config.VaultToken, "VAULT_TOKEN")
+		if token == "" {
+			return "", errors.New("set VaultToken, or VaultRoleId and VaultSecretId, in vaero.cfg, or the VAULT_TOKEN " +
+				"environment variable")
+		}
+		return token, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.token != "" && time.Now().Before(v.tokenExpires) {
+		return v.token, nil
+	}
+
+	mount := settings.Config.VaultAuthMount
+	if mount == "" {
+		mount = "approle"
+	}
+
+	login, _ := json.Marshal(map[string]string{
+		"role_id":   settings.Config.VaultRoleId,
+		"secret_id": settingOrEnv(settings.Config.VaultSecretId, "VAULT_SECRET_ID"),
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/v1/auth/%s/login", addr, mount),
+		bytes.NewReader(login))
+	if err != nil {
+		return "", err
+	}
+
+	body, err := sendVault(req)
+	if err != nil {
+		return "", fmt.Errorf("approle login failed: %w", err)
+	}
+
+	token := gjson.GetBytes(body, "auth.client_token").String()
+	if token == "" {
+		return "", errors.New("approle login returned no token")
+	}
+
+	// Renew by logging in again once most of the lease has passed. A lease of 0 never expires.
+	lease := time.Duration(gjson.GetBytes(body, "auth.lease_duration").Int()) * time.Second
+	if lease <= 0 {
+		lease = 24 * time.Hour
+	}
+	v.token, v.tokenExpires = token, time.Now().Add(lease*9/10)
+
+	return v.token, nil
+}
+
+// sendVault sends a request to vault, and returns the body of a successful response, or an error with the errors
+// reported by vault
+func sendVault(req *http.Request) ([]byte, error) {
+	if namespace := settingOrEnv(settings.Config.VaultNamespace, "VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVaultResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return body, nil
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, errVaultForbidden
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errors.New("secret not found")
+	}
+
+	msgs := []string{}
+	for _, msg := range gjson.GetBytes(body, "errors").Array() {
+		msgs = append(msgs, msg.String())
+	}
+	if len(msgs) == 0 {
+		return nil, fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+	return nil, fmt.Errorf("vault returned status %d: %s", resp.StatusCode, strings.Join(msgs, "; "))
+}
+
+// settingOrEnv returns the setting, or the environment variable if the setting is empty
+func settingOrEnv(setting string, env string) string {
+	if setting != "" {
+		return setting
+	}
+	return os.Getenv(env)
+}
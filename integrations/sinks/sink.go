@@ -12,7 +12,19 @@ import (
 
 type Sink interface {
 	Init(*SinkConfig)
-	Flush(string, string, []string)
+	Flush(string, string, []string) error // returns an error if the events could not be written
+}
+
+// Closer is implemented by sinks that hold resources, such as a plugin, that must be released when the flush node of
+// the pipeline stops
+type Closer interface {
+	Close()
+}
+
+// Refresher is implemented by sinks that take credentials in their args, such as an API token, so that refreshed
+// secrets replace the credentials of the running sink. Events buffered for the sink are kept.
+type Refresher interface {
+	Refresh(*SinkConfig)
 }
 
 type SinkConfig struct {
@@ -29,6 +41,21 @@ type SinkConfig struct {
 	Region          string
 	TimestampKey    string
 	TimestampFormat string
+	Args            map[string]interface{} // all args of the sink task, for sinks with args beyond the fields above
+
+	Secret             map[string]interface{} // secret block of the sink task, whose secrets are set in Args
+	SecretsCacheTime   time.Duration
+	SecretsTimeout     time.Duration
+	LastSecretsRefresh time.Time
+
+	SecretRefs      map[string]interface{} // args that reference secrets with ${secret:provider:path}, unresolved
+	LastRefsRefresh time.Time
+}
+
+// StringArg returns the named arg of the sink as a string, or "" if it is missing or not a string
+func (sinkConfig *SinkConfig) StringArg(name string) string {
+	val, _ := sinkConfig.Args[name].(string)
+	return val
 }
 
 type SinkBuffer struct {
@@ -12,19 +12,27 @@ import (
 )
 
 type DatadogSink struct {
+	APIKey string
 }
 
 // Init initializes the sink
 func (s *DatadogSink) Init(sinkConfig *SinkConfig) {
+	s.Refresh(sinkConfig)
+}
 
+// Refresh sets the API key from the args of the sink
+func (s *DatadogSink) Refresh(sinkConfig *SinkConfig) {
+	s.APIKey = sinkConfig.StringArg("api_key")
 }
 
 // Flush writes data out to the sink immediately
-func (s *DatadogSink) Flush(filename string, prefix string, eventList []string) {
+func (s *DatadogSink) Flush(filename string, prefix string, eventList []string) error {
 	/*
 	 * Replace here
 	 */
 
 	log.Logger.Info("Flush to Datadog", zap.String("Prefix", prefix))
 	fmt.Printf("%v\n", strings.Join(eventList, "\n"))
+
+	return nil
 }
@@ -12,19 +12,31 @@ import (
 )
 
 type ElasticSink struct {
+	APIKey   string
+	Username string
+	Password string
 }
 
 // Init initializes the sink
 func (s *ElasticSink) Init(sinkConfig *SinkConfig) {
+	s.Refresh(sinkConfig)
+}
 
+// Refresh sets the credentials from the args of the sink
+func (s *ElasticSink) Refresh(sinkConfig *SinkConfig) {
+	s.APIKey = sinkConfig.StringArg("api_key")
+	s.Username = sinkConfig.StringArg("username")
+	s.Password = sinkConfig.StringArg("password")
 }
 
 // Flush writes data out to the sink immediately
-func (s *ElasticSink) Flush(filename string, prefix string, eventList []string) {
+func (s *ElasticSink) Flush(filename string, prefix string, eventList []string) error {
 	/*
 	 * Replace here
 	 */
 
 	log.Logger.Info("Flush to Elastic", zap.String("Prefix", prefix))
 	fmt.Printf("%v\n", strings.Join(eventList, "\n"))
+
+	return nil
 }
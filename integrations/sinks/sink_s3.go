@@ -5,14 +5,13 @@ package sinks
 
 import (
 	"context"
+	"fmt"
 	"path/filepath"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/vaerohq/vaero/log"
-	"go.uber.org/zap"
 )
 
 type S3Sink struct {
@@ -27,7 +26,7 @@ func (s *S3Sink) Init(sinkConfig *SinkConfig) {
 }
 
 // Flush writes data out to the sink immediately
-func (s *S3Sink) Flush(filename string, prefix string, eventList []string) {
+func (s *S3Sink) Flush(filename string, prefix string, eventList []string) error {
 
 	// Load AWS config using the AWS SDK's default external configurations
 	var sdkConfig aws.Config
@@ -39,8 +38,7 @@ func (s *S3Sink) Flush(filename string, prefix string, eventList []string) {
 		sdkConfig, err = config.LoadDefaultConfig(context.TODO())
 	}
 	if err != nil {
-		log.Logger.Error("Could not load AWS credentials", zap.String("Error", err.Error()))
-		return
+		return fmt.Errorf("could not load AWS credentials: %w", err)
 	}
 
 	s3Client := s3.NewFromConfig(sdkConfig)
@@ -58,6 +56,8 @@ func (s *S3Sink) Flush(filename string, prefix string, eventList []string) {
 		Body:   strings.NewReader(content),
 	})
 	if err != nil {
-		log.Logger.Error("Could not upload file to S3", zap.String("Error", err.Error()))
+		return fmt.Errorf("could not upload file to S3: %w", err)
 	}
+
+	return nil
 }
@@ -12,19 +12,27 @@ import (
 )
 
 type SplunkSink struct {
+	Token string
 }
 
 // Init initializes the sink
 func (s *SplunkSink) Init(sinkConfig *SinkConfig) {
+	s.Refresh(sinkConfig)
+}
 
+// Refresh sets the HTTP Event Collector token from the args of the sink
+func (s *SplunkSink) Refresh(sinkConfig *SinkConfig) {
+	s.Token = sinkConfig.StringArg("token")
 }
 
 // Flush writes data out to the sink immediately
-func (s *SplunkSink) Flush(filename string, prefix string, eventList []string) {
+func (s *SplunkSink) Flush(filename string, prefix string, eventList []string) error {
 	/*
 	 * Replace here
 	 */
 
 	log.Logger.Info("Flush to Splunk", zap.String("Prefix", prefix))
 	fmt.Printf("%v\n", strings.Join(eventList, "\n"))
+
+	return nil
 }
@@ -19,8 +19,11 @@ func (s *StdoutSink) Init(sinkConfig *SinkConfig) {
 
 }
 
-// Flush writes data out to the sink immediately
-func (s *StdoutSink) Flush(filename string, prefix string, eventList []string) {
+// Flush writes data out to the sink immediately, and returns an error if stdout could not be written, such as when
+// it is a closed pipe
+func (s *StdoutSink) Flush(filename string, prefix string, eventList []string) error {
 	log.Logger.Info("Flush to Stdout", zap.String("Prefix", prefix))
-	fmt.Printf("%v\n", strings.Join(eventList, "\n"))
+	_, err := fmt.Printf("%v\n", strings.Join(eventList, "\n"))
+
+	return err
 }
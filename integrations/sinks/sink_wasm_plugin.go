@@ -0,0 +1,50 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package sinks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vaerohq/vaero/integrations/wasm"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// WasmSink writes events with the vaero_flush function of a WebAssembly plugin
+type WasmSink struct {
+	plugin *wasm.Plugin
+	err    error // why the plugin could not be opened, returned by every flush
+}
+
+// Init opens the plugin named by the plugin arg of the sink
+func (s *WasmSink) Init(sinkConfig *SinkConfig) {
+	name, _ := sinkConfig.Args["plugin"].(string)
+	config, _ := sinkConfig.Args["config"].(map[string]interface{})
+	timeout, _ := sinkConfig.Args["timeout_seconds"].(int)
+
+	s.plugin, s.err = wasm.Open(name, config, time.Duration(timeout)*time.Second)
+	if s.err == nil && !s.plugin.Supports(wasm.Flush) {
+		s.plugin.Close()
+		s.plugin, s.err = nil, fmt.Errorf("plugin %s does not implement flush", name)
+	}
+	if s.err != nil {
+		log.Logger.Error("Could not open sink plugin", zap.String("Plugin", name), zap.String("Error", s.err.Error()))
+	}
+}
+
+// Flush writes data out to the sink immediately
+func (s *WasmSink) Flush(filename string, prefix string, eventList []string) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.plugin.Flush(filename, prefix, eventList)
+}
+
+// Close stops the plugin
+func (s *WasmSink) Close() {
+	if s.plugin != nil {
+		s.plugin.Close()
+	}
+}
@@ -8,3 +8,19 @@ type Source interface {
 	Read() []string
 	Type() string
 }
+
+// Failer is implemented by push sources that can fail after Read returns, such as a server that cannot listen on
+// its port. The channel receives an error if the source stops receiving events.
+type Failer interface {
+	Failed() <-chan error
+}
+
+// Checkpointer is implemented by sources that keep a cursor of what they have read, such as the last object read from
+// a bucket, so that a restarted source resumes where it left off instead of reading everything again. Cursors are
+// json, and their format is up to the source.
+type Checkpointer interface {
+	// SetCheckpoint sets the cursor committed by an earlier run of the source. An empty cursor reads from the start.
+	SetCheckpoint(cursor string)
+	// Checkpoint returns the cursor after the events returned by the last Read, or "" if there is none yet
+	Checkpoint() string
+}
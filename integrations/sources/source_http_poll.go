@@ -0,0 +1,75 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package sources
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/vaerohq/vaero/integrations/httppoll"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// HTTPPollSource polls a REST API, as configured by the args of the source rather than by a Python connector
+type HTTPPollSource struct {
+	Name   string
+	Poller *httppoll.Poller
+}
+
+// httpPollCursor is the checkpoint of an HTTPPollSource
+type httpPollCursor struct {
+	Since string   `json:"since"`          // RFC3339 time of the latest event read
+	Seen  []string `json:"seen,omitempty"` // keys of the events read at that time
+}
+
+// Read returns the events of the API that are newer than the checkpoint
+func (source *HTTPPollSource) Read() []string {
+	eventList, err := source.Poller.Poll()
+	if err != nil {
+		log.Logger.Error("Error polling HTTP API", zap.String("Source", source.Name), zap.String("Error", err.Error()))
+		return []string{}
+	}
+
+	return eventList
+}
+
+// Type returns either "pull" or "push"
+func (source *HTTPPollSource) Type() string {
+	return "pull"
+}
+
+// SetCheckpoint sets the time of the latest event read, and the events read at that time
+func (source *HTTPPollSource) SetCheckpoint(cursor string) {
+	var c httpPollCursor
+	var since time.Time
+
+	if cursor != "" {
+		err := json.Unmarshal([]byte(cursor), &c)
+		if err == nil && c.Since != "" {
+			since, err = time.Parse(time.RFC3339Nano, c.Since)
+		}
+		if err != nil {
+			log.Logger.Error("Invalid HTTP poll checkpoint", zap.String("Cursor", cursor), zap.String("Error", err.Error()))
+		}
+	}
+
+	source.Poller.SetSince(since)
+	source.Poller.SetSeen(c.Seen)
+}
+
+// Checkpoint returns the time of the latest event read, and the events read at that time
+func (source *HTTPPollSource) Checkpoint() string {
+	since := source.Poller.Since()
+	if since.IsZero() {
+		return ""
+	}
+	cursor, _ := json.Marshal(httpPollCursor{Since: since.UTC().Format(time.RFC3339Nano), Seen: source.Poller.Seen()})
+	return string(cursor)
+}
+
+// CleanUp stops a poll in progress
+func (source *HTTPPollSource) CleanUp() {
+	source.Poller.Close()
+}
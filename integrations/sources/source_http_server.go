@@ -24,20 +24,27 @@ type HTTPServerSource struct {
 	Port         int
 	SrcOut       chan capsule.Capsule
 	Srv          *http.Server
+	failed       chan error
 }
 
 // Read and transmit event list to srcOut when data is received
 func (source *HTTPServerSource) Read() []string {
 	port := fmt.Sprintf(":%d", source.Port)
-	source.Srv = &http.Server{Addr: port}
 
+	// Each source has its own mux, so that the endpoint can be registered again when the pipeline restarts
+	mux := http.NewServeMux()
 	endpoint := fmt.Sprintf("%s", source.Endpoint)
-	http.HandleFunc(endpoint, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc(endpoint, func(w http.ResponseWriter, r *http.Request) {
 		source.httpHandler(w, r)
 	})
 
+	source.Srv = &http.Server{Addr: port, Handler: mux}
+
+	source.failed = make(chan error, 1)
 	go func() {
-		source.Srv.ListenAndServe()
+		if err := source.Srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			source.failed <- err
+		}
 	}()
 
 	return []string{} // have to return something to match interface function signature
@@ -48,6 +55,11 @@ func (source *HTTPServerSource) Type() string {
 	return "push"
 }
 
+// Failed receives an error if the server stops listening
+func (source *HTTPServerSource) Failed() <-chan error {
+	return source.failed
+}
+
 func (source *HTTPServerSource) CleanUp() {
 	log.Logger.Info("Shut down http server")
 	source.Srv.Shutdown(context.TODO())
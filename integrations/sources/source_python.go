@@ -4,66 +4,107 @@ Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
 package sources
 
 import (
-	"os/exec"
-	"path/filepath"
+	"encoding/json"
 	"regexp"
-	"strconv"
 
+	"github.com/vaerohq/vaero/integrations/pyproc"
 	"github.com/vaerohq/vaero/log"
-	"github.com/vaerohq/vaero/settings"
 	"go.uber.org/zap"
 )
 
-// PythonSourceRead executes python_source_driver.py with the specified source as a parameter to
-// read events from that source
-func PythonSourceRead(sourceName string, interval int, host string, token string, name string,
-	max_calls_per_period int, limit_period int, max_retries int) []string {
+// PythonSource reads from a Python connector, such as a vaero_cdk HTTPConnector subclass, run by
+// python_source_driver.py in a long-running worker process
+type PythonSource struct {
+	Op      string // op of the source task, for logs
+	Module  string // module of the connector, such as integrations.python.source_okta
+	Class   string // class of the connector. If empty, the driver finds the HTTPConnector subclass in Module.
+	Args    map[string]interface{}
+	Secrets []string // values of Args that are credentials, which are redacted from the output of the worker
+
+	cursor string         // cursor of the connector, such as {"since": "2023-01-02T15:04:05+00:00"}
+	worker *pyproc.Worker // started on the first read
+}
 
-	moduleName := "integrations.python.python_source_driver"
+// Read returns an event list
+func (source *PythonSource) Read() []string {
+	if source.worker == nil {
+		source.worker = NewPythonSourceWorker(source.Op, source.Module, source.Class, source.Args)
+		source.worker.Secrets = source.Secrets
+	}
 
-	// Generate command
-	cmd := exec.Command("python", "-m", moduleName, "--op", sourceName,
-		"--host", host, "--token", token, "--name", name,
-		"--max_calls_per_period", strconv.Itoa(max_calls_per_period),
-		"--limit_period", strconv.Itoa(limit_period),
-		"--max_retries", strconv.Itoa(max_retries))
+	eventList, cursor := PythonSourceRead(source.worker, source.cursor)
+	source.cursor = cursor
 
-	// Activate virtual environment if selected
-	if settings.Config.PythonPath != "" {
-		cmd.Path = filepath.Join(settings.Config.PythonPath, "python")
-	}
+	return eventList
+}
 
-	// Run command
-	rawOut, err := cmd.Output()
+// Type returns either "pull" or "push"
+func (source *PythonSource) Type() string {
+	return "pull"
+}
 
-	if err != nil {
-		log.Logger.Error("Error executing python source driver", zap.String("Error", err.Error()))
-		return []string{}
-	}
+// SetCheckpoint sets the cursor of the connector
+func (source *PythonSource) SetCheckpoint(cursor string) {
+	source.cursor = cursor
+}
 
-	//fmt.Printf("RAW OUTPUT: %v", string(rawOut)) // DEBUG
+// Checkpoint returns the cursor of the connector
+func (source *PythonSource) Checkpoint() string {
+	return source.cursor
+}
 
-	// Trim output to just include event list
-	r1 := regexp.MustCompile(`(?s)^.*__Python Source Driver Output__`)
-	jsonList := r1.ReplaceAllString(string(rawOut), ``)
+// CleanUp stops the Python process of the source
+func (source *PythonSource) CleanUp() {
+	if source.worker != nil {
+		source.worker.Close()
+	}
+}
 
-	r2 := regexp.MustCompile(`(?s)__End Python Source Driver Output__.*$`)
-	jsonList = r2.ReplaceAllString(jsonList, ``)
+// NewPythonSourceWorker returns the worker that runs python_source_driver.py for the connector class in module. The
+// process is started on the first read and serves every later read of the source. The args of the connector, which
+// may hold credentials, are sent on stdin.
+func NewPythonSourceWorker(name string, module string, class string, args map[string]interface{}) *pyproc.Worker {
+	moduleName := "integrations.python.python_source_driver"
 
-	//fmt.Printf("STRING: %s\n", string(jsonList)) // DEBUG
+	return pyproc.NewWorker(name, moduleName, map[string]interface{}{
+		"module": module,
+		"class":  class,
+		"args":   args,
+	})
+}
 
-	// Break into events
-	eventList := EventBreakJSONArray(jsonList)
+// PythonSourceRead asks the worker of a Python source to read events from that source. The source resumes from
+// checkpoint, the cursor returned by the previous read, and the cursor after this read is returned with the events.
+// On error, no events are returned and the checkpoint is unchanged.
+func PythonSourceRead(worker *pyproc.Worker, checkpoint string) ([]string, string) {
+	req := pyproc.Message{Type: "read", Checkpoint: json.RawMessage("null")}
+	if checkpoint != "" && json.Valid([]byte(checkpoint)) {
+		req.Checkpoint = json.RawMessage(checkpoint)
+	}
 
-	// DEBUG
-	/*
-		fmt.Printf("ARRAY %v:\n", len(eventList))
-		for _, e := range eventList {
-			fmt.Printf("%v\n", e)
+	eventList := []string{}
+	newCheckpoint := checkpoint
+
+	err := worker.Request(req, func(msg pyproc.Message) {
+		switch msg.Type {
+		case "events":
+			for _, event := range msg.Events {
+				eventList = append(eventList, string(event))
+			}
+		case "checkpoint":
+			// Keep the previous checkpoint if the source did not return one
+			if len(msg.Checkpoint) > 0 && string(msg.Checkpoint) != "null" {
+				newCheckpoint = string(msg.Checkpoint)
+			}
 		}
-	*/
+	})
 
-	return eventList
+	if err != nil {
+		log.Logger.Error("Error reading from python source", zap.String("Source", worker.Name), zap.String("Error", err.Error()))
+		return []string{}, checkpoint
+	}
+
+	return eventList, newCheckpoint
 }
 
 // EventBreakJSONArray receives as input a json representation of an array and returns
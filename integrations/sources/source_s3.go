@@ -6,6 +6,7 @@ package sources
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -19,6 +20,13 @@ type S3Source struct {
 	Bucket string
 	Prefix string
 	Region string
+
+	startAfter string // key of the last object read. Objects are listed in key order, so later reads start after it.
+}
+
+// s3Cursor is the checkpoint of an S3Source
+type s3Cursor struct {
+	StartAfter string `json:"start_after"`
 }
 
 // Read returns an event list
@@ -45,8 +53,9 @@ func (source *S3Source) Read() []string {
 	//folderName := "2023/01/02"
 
 	result, err := s3Client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(source.Bucket),
-		Prefix: aws.String(source.Prefix),
+		Bucket:     aws.String(source.Bucket),
+		Prefix:     aws.String(source.Prefix),
+		StartAfter: aws.String(source.startAfter),
 	})
 
 	if err != nil {
@@ -54,7 +63,7 @@ func (source *S3Source) Read() []string {
 	} else {
 		contents := result.Contents
 
-		// Iterate over all objects in the Bucket with the Prefix
+		// Iterate over the objects in the Bucket with the Prefix that have not been read yet
 		for idx := range contents {
 			objectKey := *contents[idx].Key // key for an object
 
@@ -65,6 +74,7 @@ func (source *S3Source) Read() []string {
 			if err != nil {
 				log.Logger.Error("Couldn't get object", zap.String("Bucket", source.Bucket), zap.String("Key", objectKey),
 					zap.String("Error", err.Error()))
+				break // read the object again on the next read, rather than skipping it
 			}
 
 			buf := new(bytes.Buffer)
@@ -73,6 +83,7 @@ func (source *S3Source) Read() []string {
 
 			newEvents := strings.Split(fileContent, "\n")
 			eventList = append(eventList, newEvents...)
+			source.startAfter = objectKey
 		}
 	}
 
@@ -84,6 +95,26 @@ func (source *S3Source) Type() string {
 	return "pull"
 }
 
+// SetCheckpoint sets the key of the last object read
+func (source *S3Source) SetCheckpoint(cursor string) {
+	var c s3Cursor
+	if cursor != "" {
+		if err := json.Unmarshal([]byte(cursor), &c); err != nil {
+			log.Logger.Error("Invalid S3 checkpoint", zap.String("Cursor", cursor), zap.String("Error", err.Error()))
+		}
+	}
+	source.startAfter = c.StartAfter
+}
+
+// Checkpoint returns the key of the last object read
+func (source *S3Source) Checkpoint() string {
+	if source.startAfter == "" {
+		return ""
+	}
+	cursor, _ := json.Marshal(s3Cursor{StartAfter: source.startAfter})
+	return string(cursor)
+}
+
 func (source *S3Source) CleanUp() {
 
 }
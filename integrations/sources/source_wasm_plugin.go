@@ -0,0 +1,61 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package sources
+
+import (
+	"encoding/json"
+
+	"github.com/vaerohq/vaero/integrations/wasm"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// WasmSource reads events with the vaero_read function of a WebAssembly plugin. The checkpoint of the source is the
+// checkpoint returned by the plugin, which is passed back to it on the next read.
+type WasmSource struct {
+	Plugin *wasm.Plugin
+
+	checkpoint json.RawMessage
+}
+
+// Read returns the events read by the plugin since the checkpoint
+func (source *WasmSource) Read() []string {
+	eventList, checkpoint, err := source.Plugin.Read(source.checkpoint)
+	if err != nil {
+		log.Logger.Error("Error reading from plugin", zap.String("Plugin", source.Plugin.Name), zap.String("Error", err.Error()))
+		return []string{}
+	}
+
+	if checkpoint != nil {
+		source.checkpoint = checkpoint
+	}
+	if eventList == nil {
+		return []string{}
+	}
+	return eventList
+}
+
+// Type returns either "pull" or "push"
+func (source *WasmSource) Type() string {
+	return "pull"
+}
+
+// SetCheckpoint sets the checkpoint passed to the plugin
+func (source *WasmSource) SetCheckpoint(cursor string) {
+	if cursor != "" && !json.Valid([]byte(cursor)) {
+		log.Logger.Error("Invalid plugin checkpoint", zap.String("Cursor", cursor))
+		cursor = ""
+	}
+	source.checkpoint = json.RawMessage(cursor)
+}
+
+// Checkpoint returns the checkpoint returned by the plugin
+func (source *WasmSource) Checkpoint() string {
+	return string(source.checkpoint)
+}
+
+// CleanUp stops the plugin
+func (source *WasmSource) CleanUp() {
+	source.Plugin.Close()
+}
@@ -0,0 +1,3 @@
+module github.com/vaerohq/vaero/integrations/wasm/examples/redact
+
+go 1.24
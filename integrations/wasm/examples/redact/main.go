@@ -0,0 +1,106 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+
+// Redact is an example wasm transform plugin, which replaces the values of the configured fields of each event.
+//
+// Build it into the plugin folder with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o ../../../../plugins/redact.wasm .
+//
+// and use it with vs.wasm_transform("redact", config = {"fields" : ["password", "user.ssn"]}).
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unsafe"
+)
+
+// config is the config arg of the task
+type config struct {
+	Fields      []string `json:"fields"`      // top-level or dotted paths of the fields to redact
+	Replacement string   `json:"replacement"` // value written to redacted fields
+}
+
+var cfg = config{Replacement: "REDACTED"}
+
+// buffers holds the memory given to the host, so that it is not collected until the host frees it
+var buffers = map[uintptr][]byte{}
+
+func main() {}
+
+//go:wasmexport vaero_alloc
+func alloc(size uint32) uint32 {
+	if size == 0 {
+		size = 1
+	}
+	buf := make([]byte, size)
+	ptr := uintptr(unsafe.Pointer(&buf[0]))
+	buffers[ptr] = buf
+	return uint32(ptr)
+}
+
+//go:wasmexport vaero_free
+func free(ptr uint32, size uint32) {
+	delete(buffers, uintptr(ptr))
+}
+
+//go:wasmexport vaero_init
+func initPlugin(ptr uint32, size uint32) uint64 {
+	if err := json.Unmarshal(input(ptr, size), &cfg); err != nil {
+		return output(map[string]string{"error": "invalid config: " + err.Error()})
+	}
+	return 0
+}
+
+//go:wasmexport vaero_transform
+func transform(ptr uint32, size uint32) uint64 {
+	var req struct {
+		Events []string `json:"events"`
+	}
+	if err := json.Unmarshal(input(ptr, size), &req); err != nil {
+		return output(map[string]string{"error": err.Error()})
+	}
+
+	for idx, event := range req.Events {
+		var obj map[string]interface{}
+		if json.Unmarshal([]byte(event), &obj) != nil {
+			continue // not a json object, such as a plain log line
+		}
+		for _, field := range cfg.Fields {
+			redact(obj, strings.Split(field, "."))
+		}
+		if out, err := json.Marshal(obj); err == nil {
+			req.Events[idx] = string(out)
+		}
+	}
+
+	return output(req)
+}
+
+// redact replaces the value at path in obj, if it exists
+func redact(obj map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		if _, ok := obj[path[0]]; ok {
+			obj[path[0]] = cfg.Replacement
+		}
+		return
+	}
+	if child, ok := obj[path[0]].(map[string]interface{}); ok {
+		redact(child, path[1:])
+	}
+}
+
+// input returns the memory at ptr, which the host allocated with alloc to write its input to
+func input(ptr uint32, size uint32) []byte {
+	return buffers[uintptr(ptr)][:size]
+}
+
+// output writes result as json to memory from alloc, and returns its location as ptr << 32 | len
+func output(result interface{}) uint64 {
+	data, _ := json.Marshal(result)
+	ptr := alloc(uint32(len(data)))
+	copy(input(ptr, uint32(len(data))), data)
+	return uint64(ptr)<<32 | uint64(len(data))
+}
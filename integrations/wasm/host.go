@@ -0,0 +1,183 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// maxResponseBytes bounds the body of a response returned to a plugin by http_request
+const maxResponseBytes = 16 << 20
+
+// httpRequest is a request of a plugin, sent by http_request
+type httpRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// httpResponse is the result of http_request. Error is set if the request could not be sent, such as to a host not
+// in the manifest.
+type httpResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// instantiateHost adds WASI and the host module "vaero" to the runtime of the plugin
+func (p *Plugin) instantiateHost(ctx context.Context) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, p.runtime); err != nil {
+		return fmt.Errorf("could not add WASI for plugin %s: %w", p.Name, err)
+	}
+
+	i32, i64 := api.ValueTypeI32, api.ValueTypeI64
+
+	_, err := p.runtime.NewHostModuleBuilder("vaero").
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(p.hostLog), []api.ValueType{i32, i32, i32}, nil).
+		WithParameterNames("level", "ptr", "len").
+		Export("log").
+		NewFunctionBuilder().
+		WithGoModuleFunction(api.GoModuleFunc(p.hostHTTPRequest), []api.ValueType{i32, i32}, []api.ValueType{i64}).
+		WithParameterNames("ptr", "len").
+		Export("http_request").
+		Instantiate(ctx)
+	if err != nil {
+		return fmt.Errorf("could not add host functions for plugin %s: %w", p.Name, err)
+	}
+
+	return nil
+}
+
+// hostLog logs a message of the plugin
+func (p *Plugin) hostLog(_ context.Context, module api.Module, stack []uint64) {
+	level, ptr, size := api.DecodeI32(stack[0]), api.DecodeU32(stack[1]), api.DecodeU32(stack[2])
+
+	msg, ok := module.Memory().Read(ptr, size)
+	if !ok {
+		return
+	}
+
+	fields := []zap.Field{zap.String("Plugin", p.Name), zap.String("Message", string(msg))}
+	switch level {
+	case 0:
+		log.Logger.Debug("Plugin log", fields...)
+	case 1:
+		log.Logger.Info("Plugin log", fields...)
+	case 2:
+		log.Logger.Warn("Plugin log", fields...)
+	default:
+		log.Logger.Error("Plugin log", fields...)
+	}
+}
+
+// hostHTTPRequest sends a request of the plugin, and writes the response to its memory
+func (p *Plugin) hostHTTPRequest(ctx context.Context, module api.Module, stack []uint64) {
+	var res httpResponse
+
+	data, ok := module.Memory().Read(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok {
+		res.Error = "request is outside memory"
+	} else {
+		var req httpRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			res.Error = "invalid request: " + err.Error()
+		} else {
+			res = p.sendHTTP(ctx, &req)
+		}
+	}
+
+	out, _ := json.Marshal(res)
+	ptr, err := writeGuest(ctx, module, out)
+	if err != nil {
+		stack[0] = 0 // no result, which the plugin sees as a response of length 0
+		return
+	}
+	stack[0] = uint64(ptr)<<32 | uint64(len(out))
+}
+
+// sendHTTP sends req, if its host is allowed by the manifest. Redirects are followed only to allowed hosts.
+func (p *Plugin) sendHTTP(ctx context.Context, req *httpRequest) httpResponse {
+	reqURL, err := url.Parse(req.URL)
+	if err != nil || (reqURL.Scheme != "http" && reqURL.Scheme != "https") {
+		return httpResponse{Error: fmt.Sprintf("invalid url %q", req.URL)}
+	}
+	if !p.hostAllowed(reqURL.Hostname()) {
+		return httpResponse{Error: fmt.Sprintf("host %s is not in the http_hosts of the plugin manifest", reqURL.Hostname())}
+	}
+
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, reqURL.String(), bytes.NewBufferString(req.Body))
+	if err != nil {
+		return httpResponse{Error: err.Error()}
+	}
+	for name, value := range req.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			if !p.hostAllowed(next.URL.Hostname()) {
+				return fmt.Errorf("redirect to host %s is not allowed", next.URL.Hostname())
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return httpResponse{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return httpResponse{Error: err.Error()}
+	}
+
+	headers := make(map[string]string, len(resp.Header))
+	for name := range resp.Header {
+		headers[name] = resp.Header.Get(name)
+	}
+
+	return httpResponse{Status: resp.StatusCode, Headers: headers, Body: string(body)}
+}
+
+// hostAllowed reports whether host matches the http_hosts of the manifest, exactly or as a subdomain of *.domain
+func (p *Plugin) hostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range p.Manifest.HTTPHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
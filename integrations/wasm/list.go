@@ -0,0 +1,65 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package wasm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/vaerohq/vaero/settings"
+)
+
+// Info describes a plugin in the plugin folder
+type Info struct {
+	Name     string
+	Kinds    []string // transform, flush, and read, by the functions the module exports
+	Manifest Manifest
+	Err      error // why the plugin cannot be used, such as a missing vaero_alloc
+}
+
+// Available returns the plugins in the plugin folder, sorted by name. Modules are only decoded, with the interpreter,
+// so that listing them is fast and runs no plugin code.
+func Available() ([]Info, error) {
+	paths, err := filepath.Glob(filepath.Join(settings.Config.PluginDir, "*.wasm"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+	defer runtime.Close(ctx)
+
+	infos := []Info{}
+	for _, path := range paths {
+		info := Info{Name: strings.TrimSuffix(filepath.Base(path), ".wasm")}
+		info.Manifest, info.Err = readManifest(strings.TrimSuffix(path, ".wasm") + ".json")
+
+		if code, err := os.ReadFile(path); err != nil {
+			info.Err = err
+		} else if compiled, err := runtime.CompileModule(ctx, code); err != nil {
+			info.Err = err
+		} else {
+			exports := compiled.ExportedFunctions()
+			if _, ok := exports["vaero_alloc"]; !ok && info.Err == nil {
+				info.Err = errors.New("does not export vaero_alloc")
+			}
+			for _, kind := range []string{Transform, Flush, Read} {
+				if _, ok := exports["vaero_"+kind]; ok {
+					info.Kinds = append(info.Kinds, kind)
+				}
+			}
+			compiled.Close(ctx)
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
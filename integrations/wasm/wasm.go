@@ -0,0 +1,379 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+
+// Package wasm runs WebAssembly plugins, which implement transforms, sinks, and pull sources without changes to
+// Vaero. A plugin named name is the module plugins/name.wasm, in settings.Config.PluginDir, with an optional manifest
+// plugins/name.json that grants it capabilities.
+//
+// A plugin module exports its memory and:
+//   - vaero_alloc(size i32) i32: allocate size bytes, for Vaero to write input to
+//   - vaero_free(ptr i32, size i32), optional: free memory returned by vaero_alloc or by a function below, once Vaero
+//     has read it
+//   - vaero_init(ptr i32, len i32) i64, optional: called once after the module starts, with the config arg of the
+//     task as json
+//   - vaero_transform(ptr i32, len i32) i64: transform {"events": [...]}, and return {"events": [...]}
+//   - vaero_flush(ptr i32, len i32) i64: write {"filename": ..., "prefix": ..., "events": [...]} to the sink
+//   - vaero_read(ptr i32, len i32) i64: read from the source, given {"checkpoint": ...}, and return
+//     {"events": [...], "checkpoint": ...}
+//
+// Input is json at ptr in the memory of the module, of length len. Each function returns the location of its json
+// result as ptr << 32 | len, and a result may set "error" to fail the call. Events are json strings, which usually hold
+// json. A plugin implements one or more of transform, flush, and read.
+//
+// Plugins run with WASI, but with no filesystem, and import host functions from the module "vaero":
+//   - log(level i32, ptr i32, len i32): log the text at ptr, at level 0 debug, 1 info, 2 warn, or 3 error
+//   - http_request(ptr i32, len i32) i64: send the request {"method", "url", "headers", "body"}, and return
+//     {"status", "headers", "body", "error"} in memory from vaero_alloc. Only hosts listed in the http_hosts of the
+//     manifest may be requested.
+package wasm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+)
+
+// Kinds of plugin, by the function that implements them
+const (
+	Transform = "transform"
+	Flush     = "flush"
+	Read      = "read"
+)
+
+// memoryLimitPages bounds the memory of a plugin, in 64 KiB pages
+const memoryLimitPages = 4096
+
+// cache shares compiled modules between the plugins of all pipelines
+var cache = wazero.NewCompilationCache()
+
+// Manifest grants capabilities to a plugin, and describes it
+type Manifest struct {
+	Description string   `json:"description"`
+	HTTPHosts   []string `json:"http_hosts"` // hosts that http_request may send to, such as api.example.com or *.example.com
+}
+
+// Plugin is a loaded plugin module. Its module is started on the first call, and started again if a call times out.
+// Calls are serialized, as a module runs one call at a time.
+type Plugin struct {
+	Name     string
+	Path     string
+	Manifest Manifest
+	Kinds    []string               // transform, flush, and read, by the functions the module exports
+	Config   map[string]interface{} // passed to vaero_init
+	Timeout  time.Duration          // time a call may take before the module is stopped
+
+	mu       sync.Mutex
+	code     []byte
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	module   api.Module
+}
+
+// result is the json result of a plugin function
+type result struct {
+	Events     []string        `json:"events"`
+	Checkpoint json.RawMessage `json:"checkpoint"`
+	Error      string          `json:"error"`
+}
+
+// Open loads and compiles the plugin named name, with the config of the task. The module is not started until the
+// first call, so opening a plugin, such as to validate a pipeline, runs no plugin code.
+func Open(name string, config map[string]interface{}, timeout time.Duration) (*Plugin, error) {
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid plugin name %q", name)
+	}
+
+	p := &Plugin{
+		Name:    name,
+		Path:    filepath.Join(settings.Config.PluginDir, name+".wasm"),
+		Config:  config,
+		Timeout: timeout,
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = 30 * time.Second
+	}
+
+	manifest, err := readManifest(strings.TrimSuffix(p.Path, ".wasm") + ".json")
+	if err != nil {
+		return nil, err
+	}
+	p.Manifest = manifest
+
+	if p.code, err = os.ReadFile(p.Path); err != nil {
+		return nil, fmt.Errorf("could not read plugin: %w", err)
+	}
+
+	// Compile now, to report an invalid module when the pipeline is built
+	if err := p.newRuntime(context.Background()); err != nil {
+		return nil, err
+	}
+
+	exports := p.compiled.ExportedFunctions()
+	for _, kind := range []string{Transform, Flush, Read} {
+		if _, ok := exports["vaero_"+kind]; ok {
+			p.Kinds = append(p.Kinds, kind)
+		}
+	}
+
+	return p, nil
+}
+
+// newRuntime compiles the module in a new runtime, with the host functions. Compiled code is cached, so a plugin used
+// by several tasks, or started again, is compiled once.
+func (p *Plugin) newRuntime(ctx context.Context) error {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCompilationCache(cache).
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(memoryLimitPages))
+
+	compiled, err := runtime.CompileModule(ctx, p.code)
+	if err != nil {
+		runtime.Close(ctx)
+		return fmt.Errorf("could not compile plugin %s: %w", p.Name, err)
+	}
+
+	if _, ok := compiled.ExportedFunctions()["vaero_alloc"]; !ok {
+		runtime.Close(ctx)
+		return fmt.Errorf("plugin %s does not export vaero_alloc", p.Name)
+	}
+
+	p.runtime, p.compiled = runtime, compiled
+	if err := p.instantiateHost(ctx); err != nil {
+		runtime.Close(ctx)
+		p.runtime, p.compiled = nil, nil
+		return err
+	}
+
+	return nil
+}
+
+// Supports reports whether the plugin implements kind
+func (p *Plugin) Supports(kind string) bool {
+	for _, k := range p.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Transform runs vaero_transform on events
+func (p *Plugin) Transform(events []string) ([]string, error) {
+	res, err := p.call(Transform, map[string]interface{}{"events": events})
+	if err != nil {
+		return nil, err
+	}
+	return res.Events, nil
+}
+
+// Flush runs vaero_flush on events
+func (p *Plugin) Flush(filename string, prefix string, events []string) error {
+	_, err := p.call(Flush, map[string]interface{}{"filename": filename, "prefix": prefix, "events": events})
+	return err
+}
+
+// Read runs vaero_read from checkpoint, and returns the events and the new checkpoint, which is empty if the plugin
+// returned none
+func (p *Plugin) Read(checkpoint json.RawMessage) ([]string, json.RawMessage, error) {
+	if len(checkpoint) == 0 {
+		checkpoint = json.RawMessage("null")
+	}
+	res, err := p.call(Read, map[string]interface{}{"checkpoint": checkpoint})
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(res.Checkpoint) == "null" {
+		res.Checkpoint = nil
+	}
+	return res.Events, res.Checkpoint, nil
+}
+
+// Close stops the module and releases the runtime of the plugin. It is compiled and started again if the plugin is
+// called again, such as by a restarted pipeline.
+func (p *Plugin) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.runtime != nil {
+		p.runtime.Close(context.Background())
+	}
+	p.runtime, p.compiled, p.module = nil, nil, nil
+}
+
+// call calls vaero_kind with input as json, and returns its result
+func (p *Plugin) call(kind string, input interface{}) (*result, error) {
+	if !p.Supports(kind) {
+		return nil, fmt.Errorf("plugin %s does not implement %s", p.Name, kind)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	if err := p.ensureRunning(ctx); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var res result
+	if err := p.callJSON(ctx, "vaero_"+kind, payload, &res); err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", p.Name, res.Error)
+	}
+
+	return &res, nil
+}
+
+// ensureRunning starts the module, if it is not running, and calls vaero_init with the config
+func (p *Plugin) ensureRunning(ctx context.Context) error {
+	if p.module != nil && !p.module.IsClosed() {
+		return nil
+	}
+	if p.runtime == nil {
+		if err := p.newRuntime(ctx); err != nil {
+			return err
+		}
+	}
+
+	logWriter := &outputWriter{plugin: p.Name}
+
+	// There is no filesystem, and clocks and randomness are real, as language runtimes such as Go need them
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(logWriter).
+		WithStderr(logWriter).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+
+	module, err := p.runtime.InstantiateModule(ctx, p.compiled, config)
+	if err != nil {
+		return fmt.Errorf("could not start plugin %s: %w", p.Name, err)
+	}
+	p.module = module
+
+	if module.ExportedFunction("vaero_init") != nil {
+		payload, _ := json.Marshal(p.Config)
+		var res result
+		if err := p.callJSON(ctx, "vaero_init", payload, &res); err != nil {
+			module.Close(ctx)
+			return err
+		}
+		if res.Error != "" {
+			module.Close(ctx)
+			return fmt.Errorf("plugin %s failed to initialize: %s", p.Name, res.Error)
+		}
+	}
+
+	log.Logger.Info("Started plugin", zap.String("Plugin", p.Name))
+	return nil
+}
+
+// callJSON writes payload to the memory of the module, calls fn with its location, and decodes the json result
+func (p *Plugin) callJSON(ctx context.Context, fn string, payload []byte, res *result) error {
+	ptr, err := writeGuest(ctx, p.module, payload)
+	if err != nil {
+		return err
+	}
+	defer freeGuest(ctx, p.module, ptr, uint32(len(payload)))
+
+	out, err := p.module.ExportedFunction(fn).Call(ctx, uint64(ptr), uint64(len(payload)))
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("plugin %s timed out after %v", p.Name, p.Timeout)
+		}
+		return fmt.Errorf("plugin %s failed: %w", p.Name, err)
+	}
+
+	resPtr, resLen := uint32(out[0]>>32), uint32(out[0])
+	if resLen == 0 {
+		return nil // no result, which is no error
+	}
+
+	data, ok := p.module.Memory().Read(resPtr, resLen)
+	if !ok {
+		return fmt.Errorf("plugin %s returned a result outside its memory", p.Name)
+	}
+	err = json.Unmarshal(data, res)
+	freeGuest(ctx, p.module, resPtr, resLen)
+	if err != nil {
+		return fmt.Errorf("plugin %s returned an invalid result: %w", p.Name, err)
+	}
+
+	return nil
+}
+
+// writeGuest copies data into memory allocated by vaero_alloc, and returns its location
+func writeGuest(ctx context.Context, module api.Module, data []byte) (uint32, error) {
+	out, err := module.ExportedFunction("vaero_alloc").Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, fmt.Errorf("vaero_alloc failed: %w", err)
+	}
+
+	ptr := uint32(out[0])
+	if !module.Memory().Write(ptr, data) {
+		return 0, errors.New("vaero_alloc returned memory outside the module")
+	}
+	return ptr, nil
+}
+
+// freeGuest calls vaero_free, if the module exports it
+func freeGuest(ctx context.Context, module api.Module, ptr uint32, size uint32) {
+	if free := module.ExportedFunction("vaero_free"); free != nil && !module.IsClosed() {
+		free.Call(ctx, uint64(ptr), uint64(size))
+	}
+}
+
+// readManifest reads the manifest at path, or returns an empty manifest, which grants no capabilities, if there is
+// none
+func readManifest(path string) (Manifest, error) {
+	var manifest Manifest
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	} else if err != nil {
+		return manifest, fmt.Errorf("could not read plugin manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid plugin manifest %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// outputWriter logs what a plugin writes to stdout and stderr
+type outputWriter struct {
+	plugin string
+}
+
+func (w *outputWriter) Write(data []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		log.Logger.Debug("Plugin output", zap.String("Plugin", w.plugin), zap.String("Output", line))
+	}
+	return len(data), nil
+}
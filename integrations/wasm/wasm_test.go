@@ -0,0 +1,305 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vaerohq/vaero/log"
+	"github.com/vaerohq/vaero/settings"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// testModule is the binary of this module, which is too small to need a compiler:
+//
+//	(module
+//	  (import "vaero" "http_request" (func $http_request (param i32 i32) (result i64)))
+//	  (memory (export "memory") 16)
+//	  (global $next (mut i32) (i32.const 1024))
+//	  ;; a bump allocator, which never frees
+//	  (func (export "vaero_alloc") (param $size i32) (result i32)
+//	    global.get $next
+//	    (global.set $next (i32.add (global.get $next) (local.get $size))))
+//	  ;; returns its input, so {"events": [...]} is transformed to itself
+//	  (func (export "vaero_transform") (param $ptr i32) (param $len i32) (result i64)
+//	    (i64.or (i64.shl (i64.extend_i32_u (local.get $ptr)) (i64.const 32)) (i64.extend_i32_u (local.get $len))))
+//	  ;; sends its input as the request to http_request, and returns the response, whose error fails the call
+//	  (func (export "vaero_flush") (param $ptr i32) (param $len i32) (result i64)
+//	    (call $http_request (local.get $ptr) (local.get $len)))
+//	  ;; never returns
+//	  (func (export "vaero_read") (param i32 i32) (result i64)
+//	    (loop (br 0))
+//	    unreachable)
+//	  (func (export "grow") (param $pages i32) (result i32)
+//	    (memory.grow (local.get $pages))))
+func testModule() []byte {
+	// section returns a section of the module, with its id and size
+	section := func(id byte, contents ...byte) []byte {
+		return append([]byte{id, byte(len(contents))}, contents...)
+	}
+	// name returns a length prefixed name
+	name := func(s string) []byte {
+		return append([]byte{byte(len(s))}, s...)
+	}
+	// body returns a function body, without locals
+	body := func(code ...byte) []byte {
+		return append([]byte{byte(len(code) + 1), 0x00}, code...)
+	}
+	concat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	const i32, i64, funcType = 0x7f, 0x7e, 0x60
+	const funcKind, memoryKind = 0x00, 0x02
+
+	return concat(
+		[]byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}, // magic and version
+		section(0x01, 2, // types
+			funcType, 2, i32, i32, 1, i64, // 0: (i32, i32) -> i64
+			funcType, 1, i32, 1, i32), // 1: (i32) -> i32
+		section(0x02, concat([]byte{1}, name("vaero"), name("http_request"), []byte{funcKind, 0})...), // imports
+		section(0x03, 5, 1, 0, 0, 0, 1),                     // functions 1 to 5, by type
+		section(0x05, 1, 0x00, 16),                          // memory of 16 pages, with no maximum
+		section(0x06, 1, i32, 0x01, 0x41, 0x80, 0x08, 0x0b), // mutable global, i32.const 1024
+		section(0x07, concat([]byte{6}, // exports
+			name("memory"), []byte{memoryKind, 0},
+			name("vaero_alloc"), []byte{funcKind, 1},
+			name("vaero_transform"), []byte{funcKind, 2},
+			name("vaero_flush"), []byte{funcKind, 3},
+			name("vaero_read"), []byte{funcKind, 4},
+			name("grow"), []byte{funcKind, 5})...),
+		section(0x0a, concat([]byte{5}, // code
+			body(0x23, 0, 0x23, 0, 0x20, 0, 0x6a, 0x24, 0, 0x0b),           // vaero_alloc
+			body(0x20, 0, 0xad, 0x42, 32, 0x86, 0x20, 1, 0xad, 0x84, 0x0b), // vaero_transform
+			body(0x20, 0, 0x20, 1, 0x10, 0, 0x0b),                          // vaero_flush
+			body(0x03, 0x40, 0x0c, 0, 0x0b, 0x00, 0x0b),                    // vaero_read
+			body(0x20, 0, 0x40, 0, 0x0b))...),                              // grow
+	)
+}
+
+// openTestPlugin writes the test module, and its manifest if set, to a plugin folder, and opens it. It returns the
+// plugin and the log, to count the starts of the plugin.
+func openTestPlugin(t *testing.T, manifest string, timeout time.Duration) (*Plugin, *observer.ObservedLogs) {
+	t.Helper()
+
+	core, logs := observer.New(zap.InfoLevel)
+	oldLogger, oldDir := log.Logger, settings.Config.PluginDir
+	log.Logger, settings.Config.PluginDir = zap.New(core), t.TempDir()
+	t.Cleanup(func() { log.Logger, settings.Config.PluginDir = oldLogger, oldDir })
+
+	if err := os.WriteFile(filepath.Join(settings.Config.PluginDir, "test.wasm"), testModule(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if manifest != "" {
+		if err := os.WriteFile(filepath.Join(settings.Config.PluginDir, "test.json"), []byte(manifest), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := Open("test", nil, timeout)
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	t.Cleanup(p.Close)
+
+	return p, logs
+}
+
+// starts returns the number of times the plugin was started
+func starts(logs *observer.ObservedLogs) int {
+	return logs.FilterMessage("Started plugin").Len()
+}
+
+func TestOpen(t *testing.T) {
+	p, logs := openTestPlugin(t, "", time.Second)
+
+	if want := []string{Transform, Flush, Read}; !reflect.DeepEqual(p.Kinds, want) {
+		t.Errorf("Kinds = %v, want %v", p.Kinds, want)
+	}
+	if starts(logs) != 0 {
+		t.Error("Open started the plugin")
+	}
+
+	for _, name := range []string{"", "../test", ".hidden", "a/b"} {
+		if _, err := Open(name, nil, time.Second); err == nil || !strings.Contains(err.Error(), "invalid plugin name") {
+			t.Errorf("Open(%q) = %v, want invalid plugin name", name, err)
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	p, _ := openTestPlugin(t, "", time.Second)
+	if _, err := p.Transform(nil); err != nil {
+		t.Fatalf("Transform returned error: %v", err)
+	}
+
+	grow := p.module.ExportedFunction("grow")
+	tests := []struct {
+		pages uint64
+		want  uint32 // previous size in pages, or -1 if the memory could not grow
+	}{
+		{pages: memoryLimitPages + 1, want: 0xffffffff},
+		{pages: memoryLimitPages - 16 + 1, want: 0xffffffff},
+		{pages: 10, want: 16},
+		{pages: memoryLimitPages - 26, want: 26}, // up to the limit
+		{pages: 1, want: 0xffffffff},
+	}
+
+	for _, tt := range tests {
+		out, err := grow.Call(context.Background(), tt.pages)
+		if err != nil {
+			t.Fatalf("grow(%d) returned error: %v", tt.pages, err)
+		}
+		if got := uint32(out[0]); got != tt.want {
+			t.Errorf("grow(%d) = %d, want %d", tt.pages, int32(got), int32(tt.want))
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	p, _ := openTestPlugin(t, "", time.Second)
+
+	events := []string{`{"a":1}`, "plain text"}
+	got, err := p.Transform(events)
+	if err != nil || !reflect.DeepEqual(got, events) {
+		t.Errorf("Transform = %v, %v, want %v", got, err, events)
+	}
+}
+
+func TestRestartAfterClose(t *testing.T) {
+	p, logs := openTestPlugin(t, "", time.Second)
+	events := []string{`{"a":1}`}
+
+	for i := 1; i <= 2; i++ {
+		if _, err := p.Transform(events); err != nil {
+			t.Fatalf("Transform returned error: %v", err)
+		}
+		if _, err := p.Transform(events); err != nil {
+			t.Fatalf("Transform returned error: %v", err)
+		}
+		if starts(logs) != i {
+			t.Errorf("plugin started %d times, want %d", starts(logs), i)
+		}
+
+		p.Close()
+		if p.runtime != nil || p.module != nil {
+			t.Fatal("Close did not release the runtime")
+		}
+	}
+}
+
+func TestRestartAfterTimeout(t *testing.T) {
+	p, logs := openTestPlugin(t, "", 100*time.Millisecond)
+
+	start := time.Now()
+	_, _, err := p.Read(nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("Read = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Read took %v to time out", elapsed)
+	}
+	if !p.module.IsClosed() {
+		t.Error("module was not stopped after the timeout")
+	}
+
+	events := []string{`{"a":1}`}
+	if got, err := p.Transform(events); err != nil || !reflect.DeepEqual(got, events) {
+		t.Errorf("Transform after timeout = %v, %v, want %v", got, err, events)
+	}
+	if starts(logs) != 2 {
+		t.Errorf("plugin started %d times, want 2", starts(logs))
+	}
+}
+
+func TestHTTPHosts(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://localhost:"+r.URL.Query().Get("port")+"/ok", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	srvURL, _ := url.Parse(srv.URL)
+	port := srvURL.Port()
+
+	tests := []struct {
+		name         string
+		manifest     string
+		url          string
+		wantErr      string // empty if the request succeeds
+		wantRequests int32
+	}{
+		{name: "allowed host", manifest: `{"http_hosts": ["127.0.0.1"]}`, url: srv.URL + "/ok", wantRequests: 1},
+		{name: "host not in manifest", manifest: `{"http_hosts": ["127.0.0.1"]}`, url: "http://localhost:" + port + "/ok",
+			wantErr: "host localhost is not in the http_hosts of the plugin manifest"},
+		{name: "no manifest", url: srv.URL + "/ok", wantErr: "host 127.0.0.1 is not in the http_hosts of the plugin manifest"},
+		{name: "redirect to host not in manifest", manifest: `{"http_hosts": ["127.0.0.1"]}`,
+			url: srv.URL + "/redirect?port=" + port, wantErr: "redirect to host localhost is not allowed", wantRequests: 1},
+		{name: "redirect to host in manifest", manifest: `{"http_hosts": ["127.0.0.1", "localhost"]}`,
+			url: srv.URL + "/redirect?port=" + port, wantRequests: 2},
+		{name: "not http", manifest: `{"http_hosts": ["127.0.0.1"]}`, url: "file:///etc/passwd", wantErr: "invalid url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := openTestPlugin(t, tt.manifest, 5*time.Second)
+			atomic.StoreInt32(&requests, 0)
+
+			_, err := p.call(Flush, httpRequest{Method: "GET", URL: tt.url})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("http_request returned error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("http_request = %v, want error %q", err, tt.wantErr)
+			}
+
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("server received %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	p := &Plugin{Manifest: Manifest{HTTPHosts: []string{"api.example.com", "*.Logs.example.org"}}}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"api.example.com", true},
+		{"API.example.com", true},
+		{"example.com", false},
+		{"evil-api.example.com", false},
+		{"api.example.com.evil.net", false},
+		{"eu.logs.example.org", true},
+		{"a.b.logs.example.org", true},
+		{"logs.example.org", false},
+		{"evillogs.example.org", false},
+	}
+
+	for _, tt := range tests {
+		if got := p.hostAllowed(tt.host); got != tt.want {
+			t.Errorf("hostAllowed(%s) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
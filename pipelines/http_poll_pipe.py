@@ -0,0 +1,12 @@
+from vaero.stream import Vaero
+
+vs = Vaero()
+
+result = vs.http_poll("https://example.okta.com/api/v1/logs", interval = 60,
+                auth = "header", auth_prefix = "SSWS ",
+                pagination = "link", params = {"sortOrder" : "ASCENDING", "limit" : 1000},
+                time_field = "published", time_param = "since", lookback_seconds = 86400 * 90) \
+        .secret("./scripts/aws_secrets.py", [{"okta_token" : "token"}]) \
+        .sink("stdout")
+
+Vaero.start()
@@ -0,0 +1,10 @@
+from vaero.stream import Vaero
+
+vs = Vaero()
+
+result = vs.python_source("integrations.python.source_google_workspace", interval = 60,
+                secret_args = ["api_token"]) \
+        .secret("./scripts/aws_secrets.py", [{"google_workspace_key" : "api_token"}]) \
+        .sink("stdout")
+
+Vaero.start()
@@ -0,0 +1,10 @@
+from vaero.stream import Vaero
+
+vs = Vaero()
+
+result = vs.source("http_server", port = 8080, endpoint = "/log") \
+        .python_transform("pipelines.transforms.vendor", "normalize") \
+        .sink("stdout") \
+        .option("batch_max_time", 2)
+
+Vaero.start()
@@ -0,0 +1,11 @@
+from vaero.stream import Vaero
+
+# Read every 5 minutes during business hours in New York, reading once at startup if reads were missed while the
+# pipeline was not running
+result = Vaero().source("random") \
+        .schedule(cron = "*/5 * * * *", window = "09:00-17:00", days = "mon-fri", timezone = "America/New_York",
+                jitter_seconds = 30, catch_up = "once") \
+        .sink("stdout") \
+        .option("batch_max_time", 2)
+
+Vaero.start()
@@ -0,0 +1,10 @@
+from vaero.stream import Vaero
+
+vs = Vaero()
+
+result = vs.source("http_server", port = 8080, endpoint = "/log") \
+        .starlark(file = "pipelines/transforms/vendor.star") \
+        .sink("stdout") \
+        .option("batch_max_time", 2)
+
+Vaero.start()
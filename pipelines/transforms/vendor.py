@@ -0,0 +1,18 @@
+#
+# Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+#
+from typing import Any, List, Mapping, Optional, Union
+
+def normalize(event: Any) -> Optional[Union[Mapping[str, Any], List[Mapping[str, Any]]]]:
+    """
+    Example python transform. Splits a vendor event that batches several records into one event per record, and drops
+    heartbeats.
+    """
+    if not isinstance(event, dict) or event.get("type") == "heartbeat":
+        return None
+
+    records = event.pop("records", None)
+    if records is None:
+        return event
+
+    return [{**event, **record} for record in records]
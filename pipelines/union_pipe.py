@@ -0,0 +1,16 @@
+from vaero.stream import Vaero
+
+# Events from the random source and from an HTTP receiver share the same transforms and sink
+generated = Vaero().source("random", interval = 3) \
+        .add("origin", "random")
+
+received = Vaero().source("http_server", port = 8080, endpoint = "/log") \
+        .add("origin", "http")
+
+result = Vaero.union(generated, received) \
+        .restart("on-failure") \
+        .rename("hostname", "host") \
+        .sink("stdout") \
+        .option("batch_max_time", 2)
+
+Vaero.start()
@@ -0,0 +1,13 @@
+from vaero.stream import Vaero
+
+# Build the example plugin into plugins/redact.wasm first:
+# cd integrations/wasm/examples/redact && GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o ../../../../plugins/redact.wasm .
+
+vs = Vaero()
+
+result = vs.source("http_server", port = 8080, endpoint = "/log") \
+        .wasm_transform("redact", config = {"fields" : ["password", "user.ssn"]}) \
+        .sink("stdout") \
+        .option("batch_max_time", 2)
+
+Vaero.start()
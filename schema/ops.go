@@ -0,0 +1,108 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package schema
+
+// Minimum returns a pointer to a minimum value for use in an Arg
+func Minimum(val float64) *float64 {
+	return &val
+}
+
+// IntervalArg is shared by all pull sources
+var IntervalArg = Arg{Name: "interval", Type: Int, Default: 10, Min: Minimum(1),
+	Description: "Seconds between reads from the source"}
+
+// sinkArgs are shared by all sinks
+var sinkArgs = []Arg{
+	{Name: "timestamp_key", Type: String, Default: "timestamp", Description: "Path of the event timestamp"},
+	{Name: "timestamp_format", Type: String, Default: "RFC3339", Allowed: []interface{}{"RFC3339", "unix"},
+		Description: "Format of the event timestamp"},
+	{Name: "filename_prefix", Type: String, Default: "%Y/%m/%d", Description: "strftime pattern for the prefix of flushed files"},
+	{Name: "filename_format", Type: String, Default: "%s.log", Description: "strftime pattern for the name of flushed files"},
+	{Name: "batch_max_bytes", Type: Int, Default: 1_000_000, Min: Minimum(1), Description: "Flush a buffer when it would exceed this size"},
+	{Name: "batch_max_time", Type: Int, Default: 300, Min: Minimum(1), Description: "Flush a buffer after this many seconds"},
+	{Name: "bucket", Type: String, Default: "", Description: "Bucket to write to"},
+	{Name: "region", Type: String, Default: "", Description: "Region of the bucket"},
+}
+
+// SinkArgs returns the args of a sink: the shared args, replaced by any arg in args with the same name, followed by
+// the other args in args
+func SinkArgs(args ...Arg) []Arg {
+	all := []Arg{}
+	used := map[string]bool{}
+
+	for _, shared := range sinkArgs {
+		replaced := false
+		for _, arg := range args {
+			if arg.Name == shared.Name {
+				all = append(all, arg)
+				used[arg.Name] = true
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			all = append(all, shared)
+		}
+	}
+
+	for _, arg := range args {
+		if !used[arg.Name] {
+			all = append(all, arg)
+		}
+	}
+
+	return all
+}
+
+// PathArg and RegexArg are shared by transforms
+var PathArg = Arg{Name: "path", Type: String, Required: true, Description: "Path of the field"}
+var RegexArg = Arg{Name: "regex", Type: String, Required: true, Description: "Regular expression in Go RE2 syntax"}
+
+// PluginArgs are shared by the wasm source, sink, and transform
+var PluginArgs = []Arg{
+	{Name: "plugin", Type: String, Required: true, Description: "Name of the WebAssembly plugin, the module <name>.wasm in the plugin folder"},
+	{Name: "config", Type: Object, Default: map[string]interface{}{}, Description: "Config passed to the plugin when it starts"},
+	{Name: "timeout_seconds", Type: Int, Default: 30, Min: Minimum(1),
+		Description: "Seconds a call to the plugin may take before it is stopped and started again"},
+}
+
+var secretSchema = &OpSchema{Type: "secret", Op: "command", Args: []Arg{
+	{Name: "command", Type: String, Required: true, Description: "Command to run to retrieve secrets"},
+	{Name: "secrets", Type: List, Default: []interface{}{}, Description: "List of {secret name : target arg} maps passed to the command"},
+	{Name: "cache_time_seconds", Type: Int, Default: 86400 * 30, Min: Minimum(0), Description: "Seconds before secrets are refreshed"},
+	{Name: "timeout_seconds", Type: Int, Default: 30, Min: Minimum(1), Description: "Seconds before the command is stopped"},
+}}
+
+var restartSchema = &OpSchema{Type: "restart", Op: "policy", Args: []Arg{
+	{Name: "policy", Type: String, Default: "on-failure", Allowed: []interface{}{"always", "on-failure", "never"},
+		Description: "When to restart the pipeline after it exits without being stopped"},
+	{Name: "max_retries", Type: Int, Default: 5, Min: Minimum(0),
+		Description: "Restarts after consecutive failures before the pipeline is marked failed; 0 for no limit"},
+	{Name: "backoff_seconds", Type: Int, Default: 1, Min: Minimum(1), Description: "Delay before the first restart, doubled after each failure"},
+	{Name: "max_backoff_seconds", Type: Int, Default: 300, Min: Minimum(1),
+		Description: "Longest delay between restarts. A pipeline that runs this long resets its failure count"},
+}}
+
+var scheduleSchema = &OpSchema{Type: "schedule", Op: "cron", Args: []Arg{
+	{Name: "cron", Type: String, Default: "",
+		Description: "Cron expression (minute hour day-of-month month day-of-week) or descriptor such as @hourly for when to read. If empty, the source reads every interval seconds"},
+	{Name: "window", Type: String, Default: "",
+		Description: "Time of day in which reads are allowed, such as 09:00-17:00. A window may wrap past midnight. If empty, reads are allowed at any time"},
+	{Name: "days", Type: String, Default: "",
+		Description: "Days of the week on which reads are allowed, such as mon-fri or sat,sun. If empty, reads are allowed every day"},
+	{Name: "timezone", Type: String, Default: "UTC", Description: "IANA time zone of the cron expression, window, and days"},
+	{Name: "jitter_seconds", Type: Int, Default: 0, Min: Minimum(0),
+		Description: "Longest random delay added to each scheduled read, to spread out pipelines with the same schedule"},
+	{Name: "catch_up", Type: String, Default: "skip", Allowed: []interface{}{"skip", "once", "all"},
+		Description: "Reads missed while the pipeline was not running are skipped, made once, or each made, up to max_catch_up"},
+	{Name: "max_catch_up", Type: Int, Default: 10, Min: Minimum(1), Description: "Most missed reads made when catch_up is all"},
+}}
+
+// opSchemas are the schemas of the sources, sinks, and transforms, which register them along with their components
+var opSchemas = map[string]*OpSchema{}
+
+// Register adds the schema of an op, so that it can be looked up and is included in JSON
+func Register(s *OpSchema) {
+	opSchemas[s.Type+"/"+s.Op] = s
+}
@@ -0,0 +1,284 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ArgType is the type of an op argument as it appears in the task graph json
+type ArgType string
+
+const (
+	Any    ArgType = "any"
+	Bool   ArgType = "bool"
+	Int    ArgType = "int"
+	List   ArgType = "list"
+	Number ArgType = "number"
+	Object ArgType = "object"
+	String ArgType = "string"
+)
+
+// Arg describes a single argument of an op
+type Arg struct {
+	Name        string        `json:"name"`
+	Type        ArgType       `json:"type"`
+	Required    bool          `json:"required,omitempty"` // must be present, and non-empty if a string
+	Default     interface{}   `json:"default"`            // used when the arg is missing and not required
+	Allowed     []interface{} `json:"allowed,omitempty"`  // if set, the value must be one of these (strings compare case-insensitively, and are stored as spelled here)
+	Min         *float64      `json:"min,omitempty"`      // if set, the minimum value of an int or number
+	Secret      bool          `json:"secret,omitempty"`   // the value is a credential, which is redacted from logs and output
+	Description string        `json:"description,omitempty"`
+}
+
+// MarshalJSON marshals the arg with its default, even if the default is a zero value such as 0, "", or false. Args
+// without a default, such as required args, have no default key.
+func (a Arg) MarshalJSON() ([]byte, error) {
+	type arg Arg // without this method
+	if a.Default != nil {
+		return json.Marshal(arg(a))
+	}
+	return json.Marshal(struct {
+		arg
+		Default interface{} `json:"default,omitempty"` // hides the default of arg
+	}{arg: arg(a)})
+}
+
+// OpSchema describes the arguments of an op. Args not listed in the schema are passed through unchanged.
+type OpSchema struct {
+	Type string `json:"type"` // source, sink, or tn
+	Op   string `json:"op"`
+	Args []Arg  `json:"args"`
+}
+
+// SecretArg reports whether the arg named name is a credential
+func (s *OpSchema) SecretArg(name string) bool {
+	for _, arg := range s.Args {
+		if arg.Name == name {
+			return arg.Secret
+		}
+	}
+	return false
+}
+
+// TypeOf returns the declared type of the arg named name, or Any if the arg is not in the schema, as such args are
+// passed through unchanged
+func (s *OpSchema) TypeOf(name string) ArgType {
+	for _, arg := range s.Args {
+		if arg.Name == name {
+			return arg.Type
+		}
+	}
+	return Any
+}
+
+// ArgError describes a problem with a single argument
+type ArgError struct {
+	Arg string
+	Msg string
+}
+
+// Lookup returns the schema for an op of the given type
+func Lookup(opType string, op string) (*OpSchema, bool) {
+	s, ok := opSchemas[opType+"/"+op]
+	return s, ok
+}
+
+// All returns every registered op schema sorted by type and op
+func All() []*OpSchema {
+	all := make([]*OpSchema, 0, len(opSchemas))
+	for _, s := range opSchemas {
+		all = append(all, s)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Type != all[j].Type {
+			return all[i].Type < all[j].Type
+		}
+		return all[i].Op < all[j].Op
+	})
+
+	return all
+}
+
+// Secret returns the schema of the secret block that can be attached to sources and sinks
+func Secret() *OpSchema {
+	return secretSchema
+}
+
+// Restart returns the schema of the restart block that can be attached to the source
+func Restart() *OpSchema {
+	return restartSchema
+}
+
+// Schedule returns the schema of the schedule block that can be attached to pull sources
+func Schedule() *OpSchema {
+	return scheduleSchema
+}
+
+// JSON returns all op schemas, plus the secret, restart, and schedule block schemas, as indented json
+func JSON() ([]byte, error) {
+	return json.MarshalIndent(struct {
+		Ops      []*OpSchema `json:"ops"`
+		Secret   *OpSchema   `json:"secret"`
+		Restart  *OpSchema   `json:"restart"`
+		Schedule *OpSchema   `json:"schedule"`
+	}{Ops: All(), Secret: secretSchema, Restart: restartSchema, Schedule: scheduleSchema}, "", "  ")
+}
+
+// Apply validates args against the schema, fills in defaults for missing args, and converts values to their
+// declared types, so that ints are stored as int rather than the float64 produced by json decoding. Required
+// string args named in optional may be empty, because their values are supplied later (e.g., by a secret).
+// It returns an error for every invalid arg, ordered as in the schema.
+func (s *OpSchema) Apply(args map[string]interface{}, optional map[string]bool) []ArgError {
+	var errs []ArgError
+
+	for _, arg := range s.Args {
+		val, ok := args[arg.Name]
+
+		if !ok || val == nil {
+			if arg.Required && !optional[arg.Name] {
+				errs = append(errs, ArgError{Arg: arg.Name, Msg: "required arg is missing"})
+			} else if arg.Default != nil {
+				args[arg.Name] = arg.Default
+			}
+			continue
+		}
+
+		converted, err := convert(arg.Type, val)
+		if err != nil {
+			errs = append(errs, ArgError{Arg: arg.Name, Msg: err.Error()})
+			continue
+		}
+
+		if str, isStr := converted.(string); isStr && str == "" && arg.Required && !optional[arg.Name] {
+			errs = append(errs, ArgError{Arg: arg.Name, Msg: "required arg must not be empty"})
+			continue
+		}
+
+		if arg.Min != nil {
+			if num, isNum := toFloat(converted); isNum && num < *arg.Min {
+				errs = append(errs, ArgError{Arg: arg.Name, Msg: fmt.Sprintf("must be at least %v, got %v", *arg.Min, converted)})
+				continue
+			}
+		}
+
+		if len(arg.Allowed) > 0 {
+			canonical, ok := allowedValue(converted, arg.Allowed)
+			if !ok {
+				errs = append(errs, ArgError{Arg: arg.Name, Msg: fmt.Sprintf("must be one of %s, got %v", formatAllowed(arg.Allowed), converted)})
+				continue
+			}
+			converted = canonical
+		}
+
+		args[arg.Name] = converted
+	}
+
+	return errs
+}
+
+// convert checks that val has type t and returns it in its canonical Go type
+func convert(t ArgType, val interface{}) (interface{}, error) {
+	switch t {
+	case Any:
+		return val, nil
+	case Bool:
+		if b, ok := val.(bool); ok {
+			return b, nil
+		}
+	case Int:
+		switch v := val.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int(v), nil
+			}
+			return nil, fmt.Errorf("must be an integer, got %v", v)
+		}
+	case Number:
+		switch v := val.(type) {
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case String:
+		if str, ok := val.(string); ok {
+			return str, nil
+		}
+	case List:
+		if list, ok := val.([]interface{}); ok {
+			return list, nil
+		}
+	case Object:
+		if obj, ok := val.(map[string]interface{}); ok {
+			return obj, nil
+		}
+	}
+
+	return nil, fmt.Errorf("must be of type %s, got %s", t, typeName(val))
+}
+
+// typeName returns the name of the schema type that best describes val
+func typeName(val interface{}) string {
+	switch val.(type) {
+	case bool:
+		return string(Bool)
+	case int, int64:
+		return string(Int)
+	case float64:
+		return string(Number)
+	case string:
+		return string(String)
+	case []interface{}:
+		return string(List)
+	case map[string]interface{}:
+		return string(Object)
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// allowedValue returns the allowed value that val matches, and whether there is one. Strings match case-insensitively,
+// and the value is returned as it is spelled in allowed, so that consumers of args compare against a single spelling.
+func allowedValue(val interface{}, allowed []interface{}) (interface{}, bool) {
+	for _, a := range allowed {
+		if str, ok := val.(string); ok {
+			if aStr, ok := a.(string); ok && strings.EqualFold(str, aStr) {
+				return aStr, true
+			}
+		} else if val == a {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+func formatAllowed(allowed []interface{}) string {
+	strs := make([]string, len(allowed))
+	for idx, a := range allowed {
+		strs[idx] = fmt.Sprintf("%v", a)
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
+}
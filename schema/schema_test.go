@@ -0,0 +1,64 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestApplyCanonicalAllowed(t *testing.T) {
+	s := &OpSchema{Type: "sink", Op: "test", Args: []Arg{
+		{Name: "format", Type: String, Default: "RFC3339", Allowed: []interface{}{"RFC3339", "unix"}},
+		{Name: "level", Type: Int, Allowed: []interface{}{1, 2}},
+	}}
+
+	tests := []struct {
+		name    string
+		args    map[string]interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "exact", args: map[string]interface{}{"format": "unix"}, want: "unix"},
+		{name: "lower case", args: map[string]interface{}{"format": "rfc3339"}, want: "RFC3339"},
+		{name: "upper case", args: map[string]interface{}{"format": "UNIX"}, want: "unix"},
+		{name: "default", args: map[string]interface{}{}, want: "RFC3339"},
+		{name: "not allowed", args: map[string]interface{}{"format": "iso"}, wantErr: true},
+		{name: "not allowed int", args: map[string]interface{}{"level": float64(3)}, want: "RFC3339", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := s.Apply(tt.args, nil)
+			if (len(errs) > 0) != tt.wantErr {
+				t.Fatalf("Apply errors = %v, want error %v", errs, tt.wantErr)
+			}
+			if !tt.wantErr && tt.args["format"] != tt.want {
+				t.Errorf("format = %v, want %v", tt.args["format"], tt.want)
+			}
+		})
+	}
+}
+
+func TestArgMarshalDefault(t *testing.T) {
+	tests := []struct {
+		arg  Arg
+		want string
+	}{
+		{Arg{Name: "a", Type: Int, Default: 0}, `{"name":"a","type":"int","default":0}`},
+		{Arg{Name: "a", Type: String, Default: ""}, `{"name":"a","type":"string","default":""}`},
+		{Arg{Name: "a", Type: Bool, Default: false}, `{"name":"a","type":"bool","default":false}`},
+		{Arg{Name: "a", Type: List, Default: []interface{}{}}, `{"name":"a","type":"list","default":[]}`},
+		{Arg{Name: "a", Type: String, Required: true}, `{"name":"a","type":"string","required":true}`},
+		{Arg{Name: "a", Type: Int, Default: 5, Min: Minimum(1), Description: "d"},
+			`{"name":"a","type":"int","default":5,"min":1,"description":"d"}`},
+	}
+
+	for _, tt := range tests {
+		got, err := json.Marshal(tt.arg)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Marshal(%+v) = %s, %v, want %s", tt.arg, got, err, tt.want)
+		}
+	}
+}
@@ -4,6 +4,25 @@ Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
 package settings
 
 type GlobalConfig struct {
+	// AWSRegion is the region of AWS Secrets Manager and Parameter Store, for the aws and ssm secret providers. If
+	// empty, the region of the AWS SDK's default external configuration is used
+	AWSRegion string
+
+	// ApiAddress is the host:port that the management API and the /metrics endpoint listen on. An empty address
+	// disables both
+	ApiAddress string
+
+	// ApiToken is the bearer token that clients of the management API must send. If empty, a token is generated
+	// and stored in ApiTokenFile
+	ApiToken string
+
+	// ApiTokenFile is the path of the file holding the generated API token, readable only by the owner
+	ApiTokenFile string
+
+	// ControlSocket is the path of the Unix socket that a running Vaero listens on for local control commands,
+	// such as tap
+	ControlSocket string
+
 	// DefaultChanBufferLen defines the default length of channel buffers. Each message on a channel
 	// is a slice of events, so this is effectively the number of slices, not of individual events
 	DefaultChanBufferLen int
@@ -11,12 +30,52 @@ type GlobalConfig struct {
 	// Level for logging
 	LogLevel string
 
+	// PluginDir is the folder holding WebAssembly plugins, each a module name.wasm with an optional manifest
+	// name.json
+	PluginDir string
+
 	// PollPipelineChangesFreq is the number of seconds between polls of the admin routine to check for changes
 	// in the jobs table
 	PollPipelineChangesFreq int
 
 	// Path to the folder containing the version of Python to use
 	PythonPath string
+
+	// PythonTimeout is the number of seconds a Python worker, such as the process of a Python source, may take to
+	// answer a request before it is killed and restarted
+	PythonTimeout int
+
+	// SecretCacheSeconds is the number of seconds that a secret referenced as ${secret:provider:path} is cached
+	// before it is looked up again. Sources and sinks take the new value of a secret that has changed
+	SecretCacheSeconds int
+
+	// SecretTimeoutSeconds is the number of seconds that looking up a secret referenced as ${secret:provider:path}
+	// may take
+	SecretTimeoutSeconds int
+
+	// SinkFailureThreshold is the number of seconds a sink may fail continuously before Vaero reports that it is
+	// not ready
+	SinkFailureThreshold int
+
+	// VaultAddress is the address of HashiCorp Vault for the vault secret provider, such as
+	// https://vault.example.com:8200. If empty, the VAULT_ADDR environment variable is used
+	VaultAddress string
+
+	// VaultAuthMount is the mount of the AppRole auth method in Vault
+	VaultAuthMount string
+
+	// VaultNamespace is the Vault Enterprise namespace of the secrets. If empty, the VAULT_NAMESPACE environment
+	// variable is used
+	VaultNamespace string
+
+	// VaultRoleId and VaultSecretId log in to Vault with AppRole. If VaultSecretId is empty, the VAULT_SECRET_ID
+	// environment variable is used
+	VaultRoleId   string
+	VaultSecretId string
+
+	// VaultToken is the token used to read secrets from Vault if VaultRoleId is not set. If empty, the VAULT_TOKEN
+	// environment variable is used
+	VaultToken string
 }
 
 var Config GlobalConfig
@@ -0,0 +1,409 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// nodeFields are the fields allowed in a node of a declarative spec
+var nodeFields = map[string]bool{
+	"type": true, "op": true, "args": true, "options": true, "option_file": true, "secret": true, "restart": true,
+	"schedule": true,
+}
+
+// unionFields are the fields allowed in a union node, either as written in a spec or as in a generated task graph
+var unionFields = map[string]bool{"union": true, "restart": true, "type": true, "op": true, "args": true, "inputs": true}
+
+// nodeTypes are the types of node in a task graph, other than branches and unions
+var nodeTypes = map[string]bool{"source": true, "sink": true, "tn": true}
+
+// IsDeclarative reports whether the spec file is a declarative spec, based on its extension
+func IsDeclarative(specName string) bool {
+	switch strings.ToLower(filepath.Ext(specName)) {
+	case ".yaml", ".yml", ".toml", ".json":
+		return true
+	default:
+		return false
+	}
+}
+
+// Build reads the declarative spec file and returns the task graph json that it describes, as an alternative to
+// Python specs that needs no Python interpreter. The task graph is not validated against the op schemas.
+//
+// A declarative spec is a list of nodes under the pipeline key:
+//
+//	pipeline:
+//	  - type: source
+//	    op: random
+//	    args: {interval: 3}
+//	  - type: tn
+//	    op: rename
+//	    args: {path: hostname, new_path: host}
+//	  - branch:
+//	      - - type: sink
+//	          op: stdout
+//	      - pipeline:
+//	          - type: sink
+//	            op: s3
+//	            option_file: pipelines/config/s3.toml
+//
+// A node may set args, options (applied after args, like the Python option method), option_file (a TOML file of
+// options, applied before options), secret, restart, and schedule. A branch must be the last node of a list, and
+// each of its routes is either a list of nodes or a table with a pipeline key. A JSON spec may also be a task graph
+// as generated by a Python spec.
+//
+// To merge several sources into one pipeline, the first node is a union, with a list of inputs in the same form as
+// the routes of a branch. Each input is a source followed by any transforms, and a union may set restart:
+//
+//	pipeline:
+//	  - union:
+//	      - - type: source
+//	          op: okta
+//	      - - type: source
+//	          op: http_server
+//	    restart: {policy: always}
+//	  - type: sink
+//	    op: stdout
+func Build(specName string) (string, error) {
+	data, err := os.ReadFile(specName)
+	if err != nil {
+		return "", fmt.Errorf("could not read pipeline specification: %w", err)
+	}
+
+	var doc interface{}
+	switch strings.ToLower(filepath.Ext(specName)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		var table map[string]interface{}
+		err = toml.Unmarshal(data, &table)
+		doc = table
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return "", fmt.Errorf("%s is not a YAML, TOML, or JSON pipeline specification", specName)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not parse pipeline specification %s: %w", specName, err)
+	}
+
+	nodes, err := pipelineNodes(doc, "")
+	if err != nil {
+		return "", fmt.Errorf("invalid pipeline specification %s: %w", specName, err)
+	}
+
+	taskGraph, err := buildTasks(nodes, "")
+	if err != nil {
+		return "", fmt.Errorf("invalid pipeline specification %s: %w", specName, err)
+	}
+
+	taskGraphJSON, err := json.Marshal(taskGraph)
+	if err != nil {
+		return "", fmt.Errorf("invalid pipeline specification %s: %w", specName, err)
+	}
+
+	return string(taskGraphJSON), nil
+}
+
+// pipelineNodes returns the nodes of a spec or branch route, which is either a list of nodes or a table with a
+// pipeline key
+func pipelineNodes(doc interface{}, path string) ([]interface{}, error) {
+	if nodes, err := nodeList(doc); err == nil {
+		return nodes, nil
+	}
+
+	switch val := doc.(type) {
+	case map[string]interface{}:
+		for k := range val {
+			if k != "pipeline" {
+				return nil, fmt.Errorf("%sunknown field %q, expected pipeline", location(path), k)
+			}
+		}
+		if _, ok := val["pipeline"]; !ok {
+			return nil, fmt.Errorf("%smissing pipeline", location(path))
+		}
+		return pipelineNodes(val["pipeline"], path)
+	default:
+		return nil, fmt.Errorf("%spipeline must be a list of nodes", location(path))
+	}
+}
+
+// buildTasks converts the nodes of a spec into the tasks of a task graph. prefix is the path of the list of nodes in
+// the task graph, such as 3.1 for the second route of a branch at 3.
+func buildTasks(nodes []interface{}, prefix string) ([]interface{}, error) {
+	tasks := make([]interface{}, 0, len(nodes))
+
+	for idx, node := range nodes {
+		path := taskPath(prefix, idx)
+		last := idx == len(nodes)-1
+
+		inputs, isUnion, err := unionInputs(node, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if isUnion {
+			if prefix != "" || idx != 0 {
+				return nil, fmt.Errorf("%sa union must be the first node of the pipeline", location(path))
+			}
+
+			task, err := buildUnion(node.(map[string]interface{}), inputs, path)
+			if err != nil {
+				return nil, err
+			}
+			tasks = append(tasks, task)
+			continue
+		}
+
+		routes, isBranch, err := branchRoutes(node, path)
+		if err != nil {
+			return nil, err
+		}
+
+		if isBranch {
+			if !last {
+				return nil, fmt.Errorf("%sa branch must be the last node", location(path))
+			}
+
+			branch := make([]interface{}, len(routes))
+			for routeIdx, route := range routes {
+				routePath := taskPath(path, routeIdx)
+
+				routeNodes, err := pipelineNodes(route, routePath)
+				if err != nil {
+					return nil, err
+				}
+
+				if branch[routeIdx], err = buildTasks(routeNodes, routePath); err != nil {
+					return nil, err
+				}
+			}
+
+			tasks = append(tasks, branch)
+			continue
+		}
+
+		task, err := buildTask(node, path)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+// branchRoutes returns the routes of a node if it is a branch. A branch is either a table with only a branch key, or
+// a list of routes as in a generated task graph.
+func branchRoutes(node interface{}, path string) ([]interface{}, bool, error) {
+	switch val := node.(type) {
+	case []interface{}:
+		return val, true, nil
+	case map[string]interface{}:
+		branch, ok := val["branch"]
+		if !ok {
+			return nil, false, nil
+		}
+		if len(val) != 1 {
+			return nil, true, fmt.Errorf("%sa branch node may only have the branch field", location(path))
+		}
+
+		routes, err := nodeList(branch)
+		if err != nil {
+			return nil, true, fmt.Errorf("%sbranch must be a list of routes", location(path))
+		}
+		return routes, true, nil
+	default:
+		return nil, false, fmt.Errorf("%snode must be a table", location(path))
+	}
+}
+
+// unionInputs returns the inputs of a node if it is a union. A union is either a table with a union key, or a task
+// of type union with an inputs list as in a generated task graph.
+func unionInputs(node interface{}, path string) ([]interface{}, bool, error) {
+	fields, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+
+	inputs, ok := fields["union"]
+	if !ok {
+		if fields["type"] != "union" {
+			return nil, false, nil
+		}
+		inputs = fields["inputs"]
+	}
+
+	for k := range fields {
+		if !unionFields[k] {
+			return nil, true, fmt.Errorf("%sunknown field %q, a union may only have the union and restart fields", location(path), k)
+		}
+	}
+
+	list, err := nodeList(inputs)
+	if err != nil {
+		return nil, true, fmt.Errorf("%sunion must be a list of inputs", location(path))
+	}
+
+	return list, true, nil
+}
+
+// buildUnion converts a union node of a spec into a union task. Each input is a list of nodes, starting with a
+// source, or a table with a pipeline key.
+func buildUnion(fields map[string]interface{}, inputs []interface{}, path string) (map[string]interface{}, error) {
+	taskInputs := make([]interface{}, len(inputs))
+	for inputIdx, input := range inputs {
+		inputPath := taskPath(path, inputIdx)
+
+		inputNodes, err := pipelineNodes(input, inputPath)
+		if err != nil {
+			return nil, err
+		}
+
+		if taskInputs[inputIdx], err = buildTasks(inputNodes, inputPath); err != nil {
+			return nil, err
+		}
+	}
+
+	task := map[string]interface{}{"type": "union", "op": "union", "args": map[string]interface{}{}, "inputs": taskInputs}
+
+	if val, ok := fields["restart"]; ok {
+		table := map[string]interface{}{}
+		if err := mergeTable(table, val, "restart", path); err != nil {
+			return nil, err
+		}
+		task["restart"] = table
+	}
+
+	return task, nil
+}
+
+// nodeList returns a list of nodes, routes, or inputs, including TOML arrays of tables
+func nodeList(val interface{}) ([]interface{}, error) {
+	switch list := val.(type) {
+	case []interface{}:
+		return list, nil
+	case []map[string]interface{}:
+		nodes := make([]interface{}, len(list))
+		for idx, node := range list {
+			nodes[idx] = node
+		}
+		return nodes, nil
+	}
+	return nil, fmt.Errorf("not a list")
+}
+
+// buildTask converts a node of a spec into a task
+func buildTask(node interface{}, path string) (map[string]interface{}, error) {
+	fields := node.(map[string]interface{})
+
+	var unknown []string
+	for k := range fields {
+		if !nodeFields[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%sunknown field %s", location(path), strings.Join(unknown, ", "))
+	}
+
+	nodeType, _ := fields["type"].(string)
+	if !nodeTypes[nodeType] {
+		return nil, fmt.Errorf("%stype must be source, sink, or tn", location(path))
+	}
+
+	op, _ := fields["op"].(string)
+	if op == "" {
+		return nil, fmt.Errorf("%sop must be set", location(path))
+	}
+
+	args := map[string]interface{}{}
+	if err := mergeTable(args, fields["args"], "args", path); err != nil {
+		return nil, err
+	}
+
+	if optionFile, ok := fields["option_file"]; ok {
+		fileName, ok := optionFile.(string)
+		if !ok {
+			return nil, fmt.Errorf("%soption_file must be a file name", location(path))
+		}
+
+		// Relative to the working directory, as in Python specs
+		var options map[string]interface{}
+		if _, err := toml.DecodeFile(fileName, &options); err != nil {
+			return nil, fmt.Errorf("%scould not read option_file: %w", location(path), err)
+		}
+		if err := mergeTable(args, options, "option_file", path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mergeTable(args, fields["options"], "options", path); err != nil {
+		return nil, err
+	}
+
+	// Endpoints are URL paths, as in Python specs
+	if endpoint, ok := args["endpoint"].(string); ok && nodeType == "source" && !strings.HasPrefix(endpoint, "/") {
+		args["endpoint"] = "/" + endpoint
+	}
+
+	task := map[string]interface{}{"type": nodeType, "op": op, "args": args}
+
+	for _, block := range []string{"secret", "restart", "schedule"} {
+		if val, ok := fields[block]; ok {
+			table := map[string]interface{}{}
+			if err := mergeTable(table, val, block, path); err != nil {
+				return nil, err
+			}
+			task[block] = table
+		}
+	}
+
+	return task, nil
+}
+
+// mergeTable copies the keys of a table in a spec into dst
+func mergeTable(dst map[string]interface{}, val interface{}, field string, path string) error {
+	if val == nil {
+		return nil
+	}
+
+	table, ok := val.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s%s must be a table", location(path), field)
+	}
+
+	for k, v := range table {
+		dst[k] = v
+	}
+
+	return nil
+}
+
+// taskPath returns the path of node idx of the list of nodes at prefix
+func taskPath(prefix string, idx int) string {
+	if prefix == "" {
+		return strconv.Itoa(idx)
+	}
+	return prefix + "." + strconv.Itoa(idx)
+}
+
+// location describes where in the task graph an error is, for error messages
+func location(path string) string {
+	if path == "" {
+		return ""
+	}
+	return "node " + path + ": "
+}
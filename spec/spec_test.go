@@ -0,0 +1,259 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package spec
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/vaerohq/vaero/execute"
+)
+
+// writeSpec writes a spec file named name to a temporary folder and returns its path
+func writeSpec(t *testing.T, name string, data string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuild(t *testing.T) {
+	optionFile := writeSpec(t, "s3.toml", "bucket = \"logs\"\nregion = \"us-east-1\"\n")
+
+	tests := []struct {
+		name string
+		file string
+		data string
+		want string // task graph json
+	}{
+		{name: "yaml", file: "spec.yaml", data: `
+pipeline:
+  - type: source
+    op: random
+    args: {interval: 3}
+  - type: tn
+    op: rename
+    args: {path: hostname, new_path: host}
+  - type: sink
+    op: stdout
+`, want: `[{"type": "source", "op": "random", "args": {"interval": 3}},
+			{"type": "tn", "op": "rename", "args": {"path": "hostname", "new_path": "host"}},
+			{"type": "sink", "op": "stdout", "args": {}}]`},
+		{name: "yaml list without pipeline key", file: "spec.yml", data: `
+- type: source
+  op: random
+- type: sink
+  op: stdout
+`, want: `[{"type": "source", "op": "random", "args": {}}, {"type": "sink", "op": "stdout", "args": {}}]`},
+		{name: "yaml options and blocks", file: "spec.yaml", data: `
+pipeline:
+  - type: source
+    op: http_server
+    args: {endpoint: events, port: 8080}
+    options: {port: 9000}
+    restart: {policy: always}
+  - type: sink
+    op: s3
+    option_file: ` + optionFile + `
+    options: {region: eu-west-1}
+    secret: {command: ./secret.sh}
+`, want: `[{"type": "source", "op": "http_server", "args": {"endpoint": "/events", "port": 9000},
+				"restart": {"policy": "always"}},
+			{"type": "sink", "op": "s3", "args": {"bucket": "logs", "region": "eu-west-1"},
+				"secret": {"command": "./secret.sh"}}]`},
+		{name: "yaml branch", file: "spec.yaml", data: `
+pipeline:
+  - type: source
+    op: random
+  - branch:
+      - - type: sink
+          op: stdout
+      - pipeline:
+          - type: tn
+            op: delete
+            args: {path: host}
+          - type: sink
+            op: stdout
+`, want: `[{"type": "source", "op": "random", "args": {}},
+			[[{"type": "sink", "op": "stdout", "args": {}}],
+			 [{"type": "tn", "op": "delete", "args": {"path": "host"}}, {"type": "sink", "op": "stdout", "args": {}}]]]`},
+		{name: "yaml union", file: "spec.yaml", data: `
+pipeline:
+  - union:
+      - - type: source
+          op: random
+      - - type: source
+          op: http_server
+        - type: tn
+          op: add
+          args: {path: via, value: http}
+    restart: {policy: never}
+  - type: sink
+    op: stdout
+`, want: `[{"type": "union", "op": "union", "args": {}, "restart": {"policy": "never"}, "inputs": [
+				[{"type": "source", "op": "random", "args": {}}],
+				[{"type": "source", "op": "http_server", "args": {}},
+				 {"type": "tn", "op": "add", "args": {"path": "via", "value": "http"}}]]},
+			{"type": "sink", "op": "stdout", "args": {}}]`},
+		{name: "toml", file: "spec.toml", data: `
+[[pipeline]]
+type = "source"
+op = "random"
+args = {interval = 3}
+schedule = {cron = "@hourly"}
+
+[[pipeline]]
+type = "sink"
+op = "stdout"
+`, want: `[{"type": "source", "op": "random", "args": {"interval": 3}, "schedule": {"cron": "@hourly"}},
+			{"type": "sink", "op": "stdout", "args": {}}]`},
+		{name: "toml branch", file: "spec.toml", data: `
+[[pipeline]]
+type = "source"
+op = "random"
+
+[[pipeline]]
+branch = [
+  [{type = "sink", op = "stdout"}],
+  {pipeline = [{type = "sink", op = "s3", args = {bucket = "logs"}}]},
+]
+`, want: `[{"type": "source", "op": "random", "args": {}},
+			[[{"type": "sink", "op": "stdout", "args": {}}], [{"type": "sink", "op": "s3", "args": {"bucket": "logs"}}]]]`},
+		{name: "json", file: "spec.json", data: `{"pipeline": [
+			{"type": "source", "op": "random", "args": {"interval": 3}},
+			{"type": "sink", "op": "stdout"}]}`,
+			want: `[{"type": "source", "op": "random", "args": {"interval": 3}}, {"type": "sink", "op": "stdout", "args": {}}]`},
+		{name: "json generated task graph", file: "spec.json", data: `[
+			{"type": "source", "op": "random", "args": {"interval": 3}},
+			[[{"type": "sink", "op": "stdout", "args": {}}], [{"type": "sink", "op": "stdout", "args": {}}]]]`,
+			want: `[{"type": "source", "op": "random", "args": {"interval": 3}},
+			[[{"type": "sink", "op": "stdout", "args": {}}], [{"type": "sink", "op": "stdout", "args": {}}]]]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Build(writeSpec(t, tt.file, tt.data))
+			if err != nil {
+				t.Fatalf("Build returned error: %v", err)
+			}
+
+			var gotGraph, wantGraph interface{}
+			if err := json.Unmarshal([]byte(got), &gotGraph); err != nil {
+				t.Fatalf("Build returned invalid json %s: %v", got, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &wantGraph); err != nil {
+				t.Fatalf("invalid want: %v", err)
+			}
+			if !reflect.DeepEqual(gotGraph, wantGraph) {
+				t.Errorf("Build = %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		wantErr string
+	}{
+		{name: "unknown format", file: "spec.txt", data: "pipeline: []",
+			wantErr: "is not a YAML, TOML, or JSON pipeline specification"},
+		{name: "bad yaml", file: "spec.yaml", data: "pipeline:\n  - type: source\n   op: random\n",
+			wantErr: "could not parse pipeline specification"},
+		{name: "bad toml", file: "spec.toml", data: "[[pipeline]]\ntype = source\n",
+			wantErr: "could not parse pipeline specification"},
+		{name: "bad json", file: "spec.json", data: `{"pipeline": [`, wantErr: "could not parse pipeline specification"},
+		{name: "pipeline not a list", file: "spec.yaml", data: "pipeline: random\n", wantErr: "pipeline must be a list of nodes"},
+		{name: "unknown top level field", file: "spec.yaml", data: "pipelines: []\n",
+			wantErr: `unknown field "pipelines", expected pipeline`},
+		{name: "unknown node field", file: "spec.yaml", data: "- {type: source, op: random, arg: {interval: 3}}\n",
+			wantErr: "node 0: unknown field arg"},
+		{name: "bad type", file: "spec.yaml", data: "- {type: transform, op: add}\n",
+			wantErr: "node 0: type must be source, sink, or tn"},
+		{name: "missing op", file: "spec.yaml", data: "- {type: source}\n", wantErr: "node 0: op must be set"},
+		{name: "args not a table", file: "spec.yaml", data: "- {type: source, op: random, args: [3]}\n",
+			wantErr: "node 0: args must be a table"},
+		{name: "branch not last", file: "spec.yaml",
+			data:    "- {type: source, op: random}\n- branch: [[{type: sink, op: stdout}]]\n- {type: sink, op: stdout}\n",
+			wantErr: "node 1: a branch must be the last node"},
+		{name: "error in branch route", file: "spec.yaml",
+			data:    "- {type: source, op: random}\n- branch: [[{type: sink, op: stdout}], [{type: sink}]]\n",
+			wantErr: "node 1.1.0: op must be set"},
+		{name: "union not first", file: "spec.yaml",
+			data:    "- {type: source, op: random}\n- union: [[{type: source, op: random}]]\n",
+			wantErr: "node 1: a union must be the first node of the pipeline"},
+		{name: "missing option_file", file: "spec.yaml",
+			data:    "- {type: sink, op: s3, option_file: /nonexistent/s3.toml}\n",
+			wantErr: "node 0: could not read option_file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Build(writeSpec(t, tt.file, tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Build = %v, want error %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := Build(filepath.Join(t.TempDir(), "missing.yaml")); err == nil ||
+		!strings.Contains(err.Error(), "could not read pipeline specification") {
+		t.Errorf("Build of a missing file = %v, want a read error", err)
+	}
+}
+
+// TestBuildValidate checks that specs of unknown ops and missing args build, as Build does not check ops, but are
+// rejected when their task graph is validated
+func TestBuildValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "valid", data: "- {type: source, op: random}\n- {type: sink, op: stdout}\n"},
+		{name: "unknown op", data: "- {type: source, op: random}\n- {type: sink, op: nosuch}\n",
+			wantErr: `task 1 (sink nosuch): unknown sink op "nosuch"`},
+		{name: "missing required arg", data: "- {type: source, op: random}\n- {type: tn, op: rename, args: {path: a}}\n" +
+			"- {type: sink, op: stdout}\n", wantErr: "task 1 (tn rename): arg new_path: required arg is missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskGraphStr, err := Build(writeSpec(t, "spec.yaml", tt.data))
+			if err != nil {
+				t.Fatalf("Build returned error: %v", err)
+			}
+
+			var tasks []map[string]interface{}
+			if err := json.Unmarshal([]byte(taskGraphStr), &tasks); err != nil {
+				t.Fatal(err)
+			}
+			taskGraph := make([]execute.OpTask, len(tasks))
+			for idx, task := range tasks {
+				if err := mapstructure.Decode(task, &taskGraph[idx]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = execute.ValidateTaskGraph(taskGraph)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateTaskGraph returned error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateTaskGraph = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
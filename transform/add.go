@@ -27,3 +27,23 @@ func AddAll(eventList []string, path string, val interface{}) []string {
 	}
 	return eventList
 }
+
+// AddTransform is a prepared add transform
+type AddTransform struct {
+	Path  string
+	Value interface{}
+}
+
+// NewAdd prepares an add transform
+func NewAdd(path string, val interface{}) (*AddTransform, error) {
+	if err := checkSimplePath("path", path); err != nil {
+		return nil, err
+	}
+
+	return &AddTransform{Path: path, Value: val}, nil
+}
+
+// Apply adds the value to each event in eventList
+func (t *AddTransform) Apply(eventList []string) []string {
+	return AddAll(eventList, t.Path, t.Value)
+}
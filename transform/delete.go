@@ -27,3 +27,22 @@ func DeleteAll(eventList []string, path string) []string {
 	}
 	return eventList
 }
+
+// DeleteTransform is a prepared delete transform
+type DeleteTransform struct {
+	Path string
+}
+
+// NewDelete prepares a delete transform
+func NewDelete(path string) (*DeleteTransform, error) {
+	if err := checkSimplePath("path", path); err != nil {
+		return nil, err
+	}
+
+	return &DeleteTransform{Path: path}, nil
+}
+
+// Apply deletes the path from each event in eventList
+func (t *DeleteTransform) Apply(eventList []string) []string {
+	return DeleteAll(eventList, t.Path)
+}
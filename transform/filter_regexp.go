@@ -10,22 +10,45 @@ import (
 )
 
 // FilterRegExp returns true if the value in path matches the regular expression
-func FilterRegExp(json string, path string, regex string) bool {
+func FilterRegExp(json string, path string, re *regexp.Regexp) bool {
 	value := gjson.Get(json, path)
 
-	match, _ := regexp.MatchString(regex, value.String())
-
-	return match
+	return re.MatchString(value.String())
 }
 
 // FilterRegExpAll filters all logs in eventList based on matching a regular expression on a field
-func FilterRegExpAll(eventList []string, path string, regex string) []string {
+func FilterRegExpAll(eventList []string, path string, re *regexp.Regexp) []string {
 	var newEventList []string
 
 	for idx := range eventList {
-		if FilterRegExp(eventList[idx], path, regex) {
+		if FilterRegExp(eventList[idx], path, re) {
 			newEventList = append(newEventList, eventList[idx])
 		}
 	}
 	return newEventList
 }
+
+// FilterRegExpTransform is a prepared filter_regexp transform
+type FilterRegExpTransform struct {
+	Path  string
+	Regex *regexp.Regexp
+}
+
+// NewFilterRegExp prepares a filter_regexp transform by compiling its regular expression
+func NewFilterRegExp(path string, regex string) (*FilterRegExpTransform, error) {
+	if err := checkPath("path", path); err != nil {
+		return nil, err
+	}
+
+	re, err := compileRegExp("regex", regex)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FilterRegExpTransform{Path: path, Regex: re}, nil
+}
+
+// Apply filters eventList
+func (t *FilterRegExpTransform) Apply(eventList []string) []string {
+	return FilterRegExpAll(eventList, t.Path, t.Regex)
+}
@@ -0,0 +1,17 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package transform
+
+import (
+	"os"
+	"testing"
+
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	log.Logger = zap.NewNop()
+	os.Exit(m.Run())
+}
@@ -13,19 +13,12 @@ import (
 )
 
 // Mask masks the portion of the value matching the regex with the replace expression
-func Mask(json string, path string, regex string, replaceExpr string) string {
+func Mask(json string, path string, re *regexp.Regexp, replaceExpr string) string {
 	value := gjson.Get(json, path)
 
-	re, err := regexp.Compile(regex)
-
-	if err != nil {
-		log.Logger.Error("Mask regular expression failed to compile", zap.String("Error", err.Error()))
-	}
-
 	maskedString := re.ReplaceAllString(value.String(), replaceExpr)
 
-	var result string
-	result, err = sjson.Set(json, path, maskedString)
+	result, err := sjson.Set(json, path, maskedString)
 
 	if err != nil {
 		log.Logger.Error("Mask transform failed to set value", zap.String("Error", err.Error()))
@@ -35,9 +28,35 @@ func Mask(json string, path string, regex string, replaceExpr string) string {
 }
 
 // MaskAll masks all the log events in the event list
-func MaskAll(eventList []string, path string, regex string, replaceExpr string) []string {
+func MaskAll(eventList []string, path string, re *regexp.Regexp, replaceExpr string) []string {
 	for idx := range eventList {
-		eventList[idx] = Mask(eventList[idx], path, regex, replaceExpr)
+		eventList[idx] = Mask(eventList[idx], path, re, replaceExpr)
 	}
 	return eventList
 }
+
+// MaskTransform is a prepared mask transform
+type MaskTransform struct {
+	Path        string
+	Regex       *regexp.Regexp
+	ReplaceExpr string
+}
+
+// NewMask prepares a mask transform by compiling its regular expression
+func NewMask(path string, regex string, replaceExpr string) (*MaskTransform, error) {
+	if err := checkSimplePath("path", path); err != nil {
+		return nil, err
+	}
+
+	re, err := compileRegExp("regex", regex)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MaskTransform{Path: path, Regex: re, ReplaceExpr: replaceExpr}, nil
+}
+
+// Apply masks each event in eventList
+func (t *MaskTransform) Apply(eventList []string) []string {
+	return MaskAll(eventList, t.Path, t.Regex, t.ReplaceExpr)
+}
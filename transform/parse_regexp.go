@@ -13,20 +13,20 @@ import (
 )
 
 // ParseRegExp adds a new field for each capture group in the regex, with the matching content as the value
-func ParseRegExp(json string, path string, regex string) string {
+func ParseRegExp(json string, path string, re *regexp.Regexp) string {
 	value := gjson.Get(json, path)
 
-	re, err := regexp.Compile(regex)
-
-	if err != nil {
-		log.Logger.Error("Mask regular expression failed to compile", zap.String("Error", err.Error()))
-	}
-
 	match := re.FindStringSubmatch(value.String())
 	result := json
 
+	// Leave the event unchanged if the regex does not match
+	if match == nil {
+		return result
+	}
+
 	for idx, name := range re.SubexpNames() {
 		if idx != 0 && name != "" {
+			var err error
 			result, err = sjson.Set(result, name, match[idx])
 
 			if err != nil {
@@ -38,10 +38,35 @@ func ParseRegExp(json string, path string, regex string) string {
 	return result
 }
 
-// FilterRegExpAll filters all logs in eventList based on matching a regular expression on a field
-func ParseRegExpAll(eventList []string, path string, regex string) []string {
+// ParseRegExpAll parses all logs in eventList with the regular expression
+func ParseRegExpAll(eventList []string, path string, re *regexp.Regexp) []string {
 	for idx := range eventList {
-		eventList[idx] = ParseRegExp(eventList[idx], path, regex)
+		eventList[idx] = ParseRegExp(eventList[idx], path, re)
 	}
 	return eventList
 }
+
+// ParseRegExpTransform is a prepared parse_regexp transform
+type ParseRegExpTransform struct {
+	Path  string
+	Regex *regexp.Regexp
+}
+
+// NewParseRegExp prepares a parse_regexp transform by compiling its regular expression
+func NewParseRegExp(path string, regex string) (*ParseRegExpTransform, error) {
+	if err := checkPath("path", path); err != nil {
+		return nil, err
+	}
+
+	re, err := compileRegExp("regex", regex)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParseRegExpTransform{Path: path, Regex: re}, nil
+}
+
+// Apply parses each event in eventList
+func (t *ParseRegExpTransform) Apply(eventList []string) []string {
+	return ParseRegExpAll(eventList, t.Path, t.Regex)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vaerohq/vaero/integrations/pyproc"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// pythonTransformModule is the worker that runs the function of a python transform
+const pythonTransformModule = "integrations.python.python_transform_driver"
+
+// PythonTransform is a prepared python transform, which runs a user-defined Python function on each event in a
+// long-running worker process. The function takes an event and returns an event, a list of events, or None to drop
+// the event.
+type PythonTransform struct {
+	Module    string
+	Func      string
+	BatchSize int  // most events sent to the worker in one request
+	Drop      bool // drop the events of a failed batch or function call, rather than passing them on unchanged
+
+	worker *pyproc.Worker
+}
+
+// NewPython prepares a python transform. The worker is started on the first event list, so preparing the transform,
+// such as to validate a pipeline, does not start Python.
+func NewPython(module string, fn string, batchSize int, timeout time.Duration, onError string) (*PythonTransform, error) {
+	if module == "" {
+		return nil, errors.New("module must not be empty")
+	}
+	if fn == "" {
+		return nil, errors.New("func must not be empty")
+	}
+	if batchSize <= 0 {
+		return nil, errors.New("batch_size must be positive")
+	}
+	if onError != "pass" && onError != "drop" {
+		return nil, fmt.Errorf("on_error %q must be pass or drop", onError)
+	}
+
+	worker := pyproc.NewWorker(module+"."+fn, pythonTransformModule,
+		map[string]interface{}{"module": module, "func": fn, "on_error": onError})
+	if timeout > 0 {
+		worker.Timeout = timeout
+	}
+
+	return &PythonTransform{Module: module, Func: fn, BatchSize: batchSize, Drop: onError == "drop", worker: worker}, nil
+}
+
+// Apply sends eventList to the worker in batches, and returns the events that the function returns
+func (t *PythonTransform) Apply(eventList []string) []string {
+	result := make([]string, 0, len(eventList))
+
+	for start := 0; start < len(eventList); start += t.BatchSize {
+		end := start + t.BatchSize
+		if end > len(eventList) {
+			end = len(eventList)
+		}
+		batch := eventList[start:end]
+
+		out, err := t.applyBatch(batch)
+		if err != nil {
+			log.Logger.Error("Python transform failed", zap.String("Module", t.Module), zap.String("Func", t.Func),
+				zap.Int("Events", len(batch)), zap.Bool("Dropped", t.Drop), zap.String("Error", err.Error()))
+			if !t.Drop {
+				result = append(result, batch...)
+			}
+			continue
+		}
+		result = append(result, out...)
+	}
+
+	return result
+}
+
+// applyBatch runs the function on one batch of events in the worker
+func (t *PythonTransform) applyBatch(batch []string) ([]string, error) {
+	req := pyproc.Message{Type: "transform", Events: make([]json.RawMessage, len(batch))}
+	for idx, event := range batch {
+		req.Events[idx] = encodeEvent(event)
+	}
+
+	out := []string{}
+	err := t.worker.Request(req, func(msg pyproc.Message) {
+		for _, event := range msg.Events {
+			out = append(out, decodeEvent(event))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Close stops the worker. It is started again if the transform is applied again, such as by a restarted pipeline.
+func (t *PythonTransform) Close() {
+	t.worker.Close()
+}
+
+// encodeEvent returns an event as json. Events that are not json, such as plain log lines, are sent as json strings.
+func encodeEvent(event string) json.RawMessage {
+	if json.Valid([]byte(event)) {
+		return json.RawMessage(event)
+	}
+	encoded, _ := json.Marshal(event)
+	return encoded
+}
+
+// decodeEvent returns an event returned by the worker. A json string is returned as plain text, the reverse of
+// encodeEvent.
+func decodeEvent(event json.RawMessage) string {
+	var text string
+	if len(event) > 0 && event[0] == '"' && json.Unmarshal(event, &text) == nil {
+		return text
+	}
+	return string(event)
+}
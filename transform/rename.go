@@ -39,3 +39,27 @@ func RenameAll(eventList []string, path string, newPath string) []string {
 	}
 	return eventList
 }
+
+// RenameTransform is a prepared rename transform
+type RenameTransform struct {
+	Path    string
+	NewPath string
+}
+
+// NewRename prepares a rename transform
+func NewRename(path string, newPath string) (*RenameTransform, error) {
+	if err := checkSimplePath("path", path); err != nil {
+		return nil, err
+	}
+
+	if err := checkSimplePath("new_path", newPath); err != nil {
+		return nil, err
+	}
+
+	return &RenameTransform{Path: path, NewPath: newPath}, nil
+}
+
+// Apply renames the field in each event in eventList
+func (t *RenameTransform) Apply(eventList []string) []string {
+	return RenameAll(eventList, t.Path, t.NewPath)
+}
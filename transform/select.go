@@ -21,3 +21,22 @@ func SelectAll(eventList []string, path string) []string {
 	}
 	return eventList
 }
+
+// SelectTransform is a prepared select transform
+type SelectTransform struct {
+	Path string
+}
+
+// NewSelect prepares a select transform
+func NewSelect(path string) (*SelectTransform, error) {
+	if err := checkPath("path", path); err != nil {
+		return nil, err
+	}
+
+	return &SelectTransform{Path: path}, nil
+}
+
+// Apply selects the field from each event in eventList
+func (t *SelectTransform) Apply(eventList []string) []string {
+	return SelectAll(eventList, t.Path)
+}
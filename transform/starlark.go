@@ -0,0 +1,269 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+	"github.com/vaerohq/vaero/log"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.uber.org/zap"
+)
+
+// StarlarkTransform is a prepared starlark transform, which runs a function of a Starlark script on each event, in
+// process. The function takes the event as a dict and returns a dict, a list of dicts, or None to drop the event.
+// Scripts have no access to the filesystem or network: Starlark has no builtins for them, and load is not allowed.
+type StarlarkTransform struct {
+	Name     string // file of the script, or "script" for an inline script
+	MaxSteps uint64 // most execution steps per event, after which the call fails
+	Drop     bool   // drop events for which the function fails, rather than passing them on unchanged
+
+	fn *starlark.Function
+}
+
+// NewStarlark prepares a starlark transform by running the script, given inline or as the path of a file, and
+// looking up its function fn. Global values of the script are frozen, so that calls cannot share state.
+func NewStarlark(script string, file string, fn string, maxSteps int, onError string) (*StarlarkTransform, error) {
+	if (script == "") == (file == "") {
+		return nil, errors.New("exactly one of script and file must be set")
+	}
+	if fn == "" {
+		return nil, errors.New("func must not be empty")
+	}
+	if maxSteps <= 0 {
+		return nil, errors.New("max_steps must be positive")
+	}
+	if onError != "pass" && onError != "drop" {
+		return nil, fmt.Errorf("on_error %q must be pass or drop", onError)
+	}
+
+	name := "script"
+	if file != "" {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read file: %s", err.Error())
+		}
+		name, script = file, string(src)
+	}
+
+	t := &StarlarkTransform{Name: name, MaxSteps: uint64(maxSteps), Drop: onError == "drop"}
+
+	globals, err := starlark.ExecFile(t.newThread(), name, script, starlark.StringDict{"json": starlarkjson.Module})
+	if err != nil {
+		return nil, fmt.Errorf("script failed: %s", starlarkError(err))
+	}
+	globals.Freeze()
+
+	function, ok := globals[fn].(*starlark.Function)
+	if !ok {
+		return nil, fmt.Errorf("script does not define function %s", fn)
+	}
+	if function.NumParams() < 1 {
+		return nil, fmt.Errorf("function %s must take the event as its argument", fn)
+	}
+	t.fn = function
+
+	return t, nil
+}
+
+// Apply runs the function on each event in eventList
+func (t *StarlarkTransform) Apply(eventList []string) []string {
+	result := make([]string, 0, len(eventList))
+	failures := 0
+	var firstErr error
+
+	for _, event := range eventList {
+		out, err := t.applyEvent(event)
+		if err != nil {
+			failures++
+			if firstErr == nil {
+				firstErr = err
+			}
+			if !t.Drop {
+				result = append(result, event)
+			}
+			continue
+		}
+		result = append(result, out...)
+	}
+
+	if failures > 0 {
+		log.Logger.Error("Starlark transform failed", zap.String("Script", t.Name), zap.Int("Events", failures),
+			zap.Bool("Dropped", t.Drop), zap.String("Error", firstErr.Error()))
+	}
+
+	return result
+}
+
+// applyEvent runs the function on one event, with a fresh thread so that each event has its own step limit
+func (t *StarlarkTransform) applyEvent(event string) ([]string, error) {
+	out, err := starlark.Call(t.newThread(), t.fn, starlark.Tuple{eventToStarlark(event)}, nil)
+	if err != nil {
+		return nil, errors.New(starlarkError(err))
+	}
+
+	switch out := out.(type) {
+	case starlark.NoneType:
+		return []string{}, nil
+	case *starlark.List:
+		events := make([]string, 0, out.Len())
+		for idx := 0; idx < out.Len(); idx++ {
+			e, err := starlarkToEvent(out.Index(idx))
+			if err != nil {
+				return nil, fmt.Errorf("event %d returned by %s: %s", idx, t.fn.Name(), err.Error())
+			}
+			events = append(events, e)
+		}
+		return events, nil
+	default:
+		e, err := starlarkToEvent(out)
+		if err != nil {
+			return nil, fmt.Errorf("event returned by %s: %s", t.fn.Name(), err.Error())
+		}
+		return []string{e}, nil
+	}
+}
+
+// newThread returns a thread with the step limit of the transform, which cannot load modules
+func (t *StarlarkTransform) newThread() *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: t.Name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Logger.Debug("Starlark print", zap.String("Script", t.Name), zap.String("Output", msg))
+		},
+		Load: func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+			return nil, errors.New("load is not allowed")
+		},
+	}
+	thread.SetMaxExecutionSteps(t.MaxSteps)
+	return thread
+}
+
+// starlarkError describes err, with the Starlark stack if it is an evaluation error
+func starlarkError(err error) string {
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		return evalErr.Backtrace()
+	}
+	return err.Error()
+}
+
+// eventToStarlark converts an event to a Starlark value. A json object becomes a dict, with its keys in order, and
+// an event that is not json, such as a plain log line, becomes a string.
+func eventToStarlark(event string) starlark.Value {
+	if !gjson.Valid(event) {
+		return starlark.String(event)
+	}
+	return jsonToStarlark(gjson.Parse(event))
+}
+
+func jsonToStarlark(value gjson.Result) starlark.Value {
+	switch {
+	case value.IsObject():
+		dict := starlark.NewDict(0)
+		value.ForEach(func(key, val gjson.Result) bool {
+			dict.SetKey(starlark.String(key.String()), jsonToStarlark(val))
+			return true
+		})
+		return dict
+	case value.IsArray():
+		elems := []starlark.Value{}
+		value.ForEach(func(_, val gjson.Result) bool {
+			elems = append(elems, jsonToStarlark(val))
+			return true
+		})
+		return starlark.NewList(elems)
+	}
+
+	switch value.Type {
+	case gjson.String:
+		return starlark.String(value.String())
+	case gjson.True:
+		return starlark.True
+	case gjson.False:
+		return starlark.False
+	case gjson.Number:
+		if i, ok := new(big.Int).SetString(value.Raw, 10); ok {
+			return starlark.MakeBigInt(i)
+		}
+		return starlark.Float(value.Float())
+	default:
+		return starlark.None
+	}
+}
+
+// starlarkToEvent converts a value returned by a script to an event. A string is returned as plain text, the
+// reverse of eventToStarlark.
+func starlarkToEvent(value starlark.Value) (string, error) {
+	if str, ok := value.(starlark.String); ok {
+		return string(str), nil
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, value); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// writeJSON writes value as json, keeping the keys of dicts in order
+func writeJSON(buf *bytes.Buffer, value starlark.Value) error {
+	switch value := value.(type) {
+	case starlark.NoneType:
+		buf.WriteString("null")
+	case starlark.Bool:
+		buf.WriteString(strconv.FormatBool(bool(value)))
+	case starlark.Int:
+		buf.WriteString(value.String())
+	case starlark.Float:
+		if math.IsInf(float64(value), 0) || math.IsNaN(float64(value)) {
+			return fmt.Errorf("cannot encode %v as json", value)
+		}
+		buf.WriteString(strconv.FormatFloat(float64(value), 'g', -1, 64))
+	case starlark.String:
+		encoded, _ := json.Marshal(string(value))
+		buf.Write(encoded)
+	case *starlark.Dict:
+		buf.WriteByte('{')
+		for idx, item := range value.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return fmt.Errorf("dict key %s is not a string", item[0])
+			}
+			if idx > 0 {
+				buf.WriteByte(',')
+			}
+			encoded, _ := json.Marshal(string(key))
+			buf.Write(encoded)
+			buf.WriteByte(':')
+			if err := writeJSON(buf, item[1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case starlark.Indexable: // list or tuple
+		buf.WriteByte('[')
+		for idx := 0; idx < value.Len(); idx++ {
+			if idx > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, value.Index(idx)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		return fmt.Errorf("cannot encode %s as json", value.Type())
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package transform
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spinScript defines a transform that loops for far more steps than any limit
+const spinScript = `
+def transform(event):
+    for i in range(1 << 40):
+        pass
+    return event
+`
+
+func TestStarlarkStepLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		onError string
+		events  []string
+		want    []string
+	}{
+		{name: "infinite loop passes the event", script: spinScript, onError: "pass",
+			events: []string{`{"a":1}`}, want: []string{`{"a":1}`}},
+		{name: "infinite loop drops the event", script: spinScript, onError: "drop",
+			events: []string{`{"a":1}`}, want: []string{}},
+		{name: "limit is per event", onError: "drop", script: `
+def transform(event):
+    for i in range(100):
+        pass
+    event["done"] = True
+    return event
+`, events: []string{`{"a":1}`, `{"a":2}`, `{"a":3}`}, // about 500 steps each
+			want: []string{`{"a":1,"done":true}`, `{"a":2,"done":true}`, `{"a":3,"done":true}`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tn, err := NewStarlark(tt.script, "", "transform", 1000, tt.onError)
+			if err != nil {
+				t.Fatalf("NewStarlark returned error: %v", err)
+			}
+
+			done := make(chan []string, 1)
+			go func() { done <- tn.Apply(tt.events) }()
+
+			var got []string
+			select {
+			case got = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Apply was not stopped by the step limit")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Apply = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := tn(spinScript); err == nil {
+		t.Error("applyEvent of an infinite loop returned no error")
+	} else if !strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("applyEvent = %v, want too many steps", err)
+	}
+
+	// The limit also applies to the script when it is prepared
+	if _, err := NewStarlark(spinScript+"transform({})\n", "", "transform", 1000, "pass"); err == nil ||
+		!strings.Contains(err.Error(), "too many steps") {
+		t.Errorf("NewStarlark of a script that loops = %v, want too many steps", err)
+	}
+}
+
+// tn prepares the script and applies it to one event
+func tn(script string) ([]string, error) {
+	t, err := NewStarlark(script, "", "transform", 1000, "pass")
+	if err != nil {
+		return nil, err
+	}
+	return t.applyEvent(`{"a":1}`)
+}
+
+func TestStarlarkSandbox(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{name: "load", script: `load("os.star", "system")` + "\ndef transform(event):\n    return event\n",
+			wantErr: "load is not allowed"},
+		{name: "open", script: "def transform(event):\n    return open('/etc/passwd').read()\n",
+			wantErr: "undefined: open"},
+		{name: "os module", script: "def transform(event):\n    return os.getenv('HOME')\n", wantErr: "undefined: os"},
+		{name: "exec", script: "def transform(event):\n    return exec('1')\n", wantErr: "undefined: exec"},
+		{name: "import", script: "import os\ndef transform(event):\n    return event\n", wantErr: "illegal token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStarlark(tt.script, "", "transform", 1000, "pass")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewStarlark = %v, want error %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// json is the only module, and print is logged rather than written to stdout
+	got, err := tn("def transform(event):\n    print('hi')\n    return json.decode(json.encode(event))\n")
+	if err != nil || !reflect.DeepEqual(got, []string{`{"a":1}`}) {
+		t.Errorf("json and print = %v, %v, want the event", got, err)
+	}
+
+	// Globals are frozen, so that calls cannot share state
+	got, err = tn("seen = []\ndef transform(event):\n    seen.append(event)\n    return event\n")
+	if err == nil || !strings.Contains(err.Error(), "frozen") {
+		t.Errorf("append to a global = %v, %v, want a frozen error", got, err)
+	}
+}
+
+func TestStarlarkFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "enrich.star")
+	script := `
+TEAMS = {"web": "frontend"}
+
+def enrich(event):
+    if event.get("drop"):
+        return None
+    event["team"] = TEAMS.get(event["service"], "unknown")
+    return [event, {"copy": event["service"]}]
+`
+	if err := os.WriteFile(file, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tn, err := NewStarlark("", file, "enrich", 1000, "pass")
+	if err != nil {
+		t.Fatalf("NewStarlark returned error: %v", err)
+	}
+	if tn.Name != file {
+		t.Errorf("Name = %s, want %s", tn.Name, file)
+	}
+
+	got := tn.Apply([]string{`{"service":"web"}`, `{"service":"db","drop":true}`})
+	want := []string{`{"service":"web","team":"frontend"}`, `{"copy":"web"}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply = %v, want %v", got, want)
+	}
+
+	errTests := []struct {
+		name    string
+		script  string
+		file    string
+		fn      string
+		wantErr string
+	}{
+		{name: "missing file", file: filepath.Join(dir, "missing.star"), fn: "enrich", wantErr: "could not read file"},
+		{name: "script and file", script: script, file: file, fn: "enrich", wantErr: "exactly one of script and file"},
+		{name: "neither", fn: "enrich", wantErr: "exactly one of script and file"},
+		{name: "missing function", file: file, fn: "transform", wantErr: "does not define function transform"},
+		{name: "not a function", file: file, fn: "TEAMS", wantErr: "does not define function TEAMS"},
+	}
+
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStarlark(tt.script, tt.file, tt.fn, 1000, "pass")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("NewStarlark = %v, want error %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package transform
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/tidwall/sjson"
+)
+
+// Transform is a prepared transform. All arguments are parsed and validated once when the transform is created,
+// so Apply can be called on every event list without repeating that work.
+type Transform interface {
+	Apply(eventList []string) []string
+}
+
+// Closer is implemented by transforms that hold resources, such as a worker process, that must be released when the
+// transform node of the pipeline stops
+type Closer interface {
+	Close()
+}
+
+// checkPath checks that path can be used to read a value from an event
+func checkPath(name string, path string) error {
+	if path == "" {
+		return fmt.Errorf("%s must not be empty", name)
+	}
+
+	return nil
+}
+
+// checkSimplePath checks that path can be used to modify an event. sjson cannot modify values at complex paths,
+// such as paths containing wildcards, queries, or modifiers.
+func checkSimplePath(name string, path string) error {
+	if err := checkPath(name, path); err != nil {
+		return err
+	}
+
+	if _, err := sjson.Delete("{}", path); err != nil {
+		return fmt.Errorf("%s %q is not a simple path: %s", name, path, err.Error())
+	}
+
+	return nil
+}
+
+// compileRegExp compiles a regular expression and wraps any error with the name of the argument
+func compileRegExp(name string, regex string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q failed to compile: %s", name, regex, err.Error())
+	}
+
+	return re, nil
+}
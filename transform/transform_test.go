@@ -0,0 +1,67 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package transform
+
+import "testing"
+
+func TestNewTransformPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		new     func() (Transform, error)
+		wantErr bool
+	}{
+		{name: "add", new: func() (Transform, error) { return NewAdd("a.b", 1) }},
+		{name: "add to wildcard path", new: func() (Transform, error) { return NewAdd("a.*", 1) }, wantErr: true},
+		{name: "add to query path", new: func() (Transform, error) { return NewAdd("a.#(b==1)", 1) }, wantErr: true},
+		{name: "rename", new: func() (Transform, error) { return NewRename("a", "b.c") }},
+		{name: "rename to array path", new: func() (Transform, error) { return NewRename("a", "b.#.c") }, wantErr: true},
+		{name: "rename to modifier", new: func() (Transform, error) { return NewRename("a", "@reverse") }, wantErr: true},
+		{name: "rename from empty path", new: func() (Transform, error) { return NewRename("", "b") }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.new(); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewTransformRegExps(t *testing.T) {
+	tests := []struct {
+		name    string
+		new     func() (Transform, error)
+		wantErr bool
+	}{
+		{name: "parse with named group", new: func() (Transform, error) { return NewParseRegExp("msg", `(?P<user>\w+)`) }},
+		{name: "parse without named group", new: func() (Transform, error) { return NewParseRegExp("msg", `(\w+)`) }},
+		{name: "parse invalid", new: func() (Transform, error) { return NewParseRegExp("msg", `(\w+`) }, wantErr: true},
+		{name: "filter empty", new: func() (Transform, error) { return NewFilterRegExp("msg", "") }},
+		{name: "mask invalid", new: func() (Transform, error) { return NewMask("msg", `[`, "") }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.new(); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseRegExpUnnamedGroups(t *testing.T) {
+	p, err := NewParseRegExp("msg", `(\w+) (?P<user>\w+)`)
+	if err != nil {
+		t.Fatalf("NewParseRegExp returned error: %v", err)
+	}
+
+	got := p.Apply([]string{`{"msg":"login alice"}`, `{"msg":"-"}`})
+	want := []string{`{"msg":"login alice","user":"alice"}`, `{"msg":"-"}`}
+	for idx := range want {
+		if got[idx] != want[idx] {
+			t.Errorf("Apply = %s, want %s", got[idx], want[idx])
+		}
+	}
+}
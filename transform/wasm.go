@@ -0,0 +1,62 @@
+/*
+Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+*/
+package transform
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vaerohq/vaero/integrations/wasm"
+	"github.com/vaerohq/vaero/log"
+	"go.uber.org/zap"
+)
+
+// WasmTransform is a prepared wasm transform, which runs the vaero_transform function of a WebAssembly plugin on
+// each event list, in process
+type WasmTransform struct {
+	Drop bool // drop the events of a failed call, rather than passing them on unchanged
+
+	plugin *wasm.Plugin
+}
+
+// NewWasm prepares a wasm transform by compiling the plugin. The plugin is started on the first event list.
+func NewWasm(plugin string, config map[string]interface{}, timeout time.Duration, onError string) (*WasmTransform, error) {
+	if onError != "pass" && onError != "drop" {
+		return nil, fmt.Errorf("on_error %q must be pass or drop", onError)
+	}
+
+	p, err := wasm.Open(plugin, config, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if !p.Supports(wasm.Transform) {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s does not implement transform", plugin)
+	}
+
+	return &WasmTransform{Drop: onError == "drop", plugin: p}, nil
+}
+
+// Apply runs the plugin on eventList
+func (t *WasmTransform) Apply(eventList []string) []string {
+	out, err := t.plugin.Transform(eventList)
+	if err != nil {
+		log.Logger.Error("Wasm transform failed", zap.String("Plugin", t.plugin.Name), zap.Int("Events", len(eventList)),
+			zap.Bool("Dropped", t.Drop), zap.String("Error", err.Error()))
+		if t.Drop {
+			return []string{}
+		}
+		return eventList
+	}
+	if out == nil {
+		return []string{}
+	}
+
+	return out
+}
+
+// Close stops the plugin
+func (t *WasmTransform) Close() {
+	t.plugin.Close()
+}
@@ -0,0 +1,181 @@
+#
+# Copyright © 2023 Vaero Inc. (https://www.vaero.co/)
+#
+import json
+import os
+from typing import Any, List, Mapping, Optional
+
+# schema.json is generated from the registered components by running: go generate ./execute
+SCHEMA_FILE = os.path.join(os.path.dirname(__file__), "schema.json")
+
+def load_schema(file_name: str = SCHEMA_FILE) -> Optional[Mapping[str, Any]]:
+    """
+    Load the op schemas exported by Vaero. Returns None if the schema file does not exist.
+    """
+    try:
+        with open(file_name, "r") as schema_file:
+            return json.load(schema_file)
+    except OSError:
+        return None
+
+def validate_task_graph(task_graph: List[Any], schema: Mapping[str, Any]) -> List[str]:
+    """
+    Validate a task graph against the op schemas, mirroring the checks that Vaero runs when a pipeline is added.
+
+    :return: A list of error messages, which is empty if the task graph is valid
+    """
+    ops = {(s["type"], s["op"]) : s for s in schema.get("ops", [])}
+    errors = []
+
+    if len(task_graph) == 0:
+        return ["task 0: task graph is empty"]
+
+    if not isinstance(task_graph[0], dict) or task_graph[0].get("type") not in ("source", "union"):
+        errors.append("task 0: task graph must start with a source or a union")
+
+    _validate_helper(task_graph, "", ops, schema.get("secret"), schema.get("restart"), errors,
+                    schedule_schema = schema.get("schedule"))
+
+    return errors
+
+def _validate_helper(task_graph: List[Any], prefix: str, ops: Mapping[Any, Any], secret_schema: Mapping[str, Any],
+                    restart_schema: Mapping[str, Any], errors: List[str], union_input: bool = False,
+                    schedule_schema: Optional[Mapping[str, Any]] = None):
+    for idx, task in enumerate(task_graph):
+        path = f"{prefix}.{idx}" if prefix else f"{idx}"
+
+        # Arrays represent branching, where each element is a route
+        if isinstance(task, list):
+            if len(task) == 0:
+                errors.append(f"task {path}: branch has no routes")
+            for branch_idx, branch in enumerate(task):
+                _validate_helper(branch, f"{path}.{branch_idx}", ops, secret_schema, restart_schema, errors,
+                                schedule_schema = schedule_schema)
+            continue
+
+        task_type, op = task.get("type"), task.get("op")
+        label = f"task {path} ({task_type} {op})"
+
+        if task_type == "union":
+            if path != "0":
+                errors.append(f"{label}: a union may only be the first task of the task graph")
+            _validate_union(task, path, ops, secret_schema, restart_schema, errors, schedule_schema)
+            continue
+
+        if task_type == "source" and path != "0" and not (union_input and idx == 0):
+            errors.append(f"{label}: a source may only be the first task of the task graph")
+
+        op_schema = ops.get((task_type, op))
+        if op_schema is None:
+            errors.append(f"{label}: unknown {task_type} op \"{op}\"")
+            continue
+
+        # Args that are set by a secret may be empty in the spec
+        secret_targets = set()
+        secret = task.get("secret")
+        if secret and secret_schema:
+            for msg in _validate_args(secret, secret_schema, set()):
+                errors.append(f"{label}: arg secret.{msg}")
+            for pair in secret.get("secrets", []):
+                secret_targets.update(pair.values())
+
+        restart = task.get("restart")
+        if restart:
+            if task_type != "source":
+                errors.append(f"{label}: arg restart: a restart policy may only be set on the source or union")
+            elif path != "0":
+                errors.append(f"{label}: arg restart: the restart policy of a pipeline with a union may only be set on the union")
+            if restart_schema:
+                for msg in _validate_args(restart, restart_schema, set()):
+                    errors.append(f"{label}: arg restart.{msg}")
+
+        for msg in _validate_args(task.get("args", {}), op_schema, secret_targets):
+            errors.append(f"{label}: arg {msg}")
+
+        schedule = task.get("schedule")
+        if schedule:
+            if task_type != "source":
+                errors.append(f"{label}: arg schedule: a schedule may only be set on a source")
+            if schedule_schema:
+                for msg in _validate_args(schedule, schedule_schema, set()):
+                    errors.append(f"{label}: arg schedule.{msg}")
+
+def _validate_union(task: Mapping[str, Any], path: str, ops: Mapping[Any, Any], secret_schema: Mapping[str, Any],
+                    restart_schema: Mapping[str, Any], errors: List[str], schedule_schema: Optional[Mapping[str, Any]]):
+    label = f"task {path} (union union)"
+    inputs = task.get("inputs", [])
+
+    if len(inputs) < 2:
+        errors.append(f"{label}: a union needs at least two inputs")
+
+    restart = task.get("restart")
+    if restart and restart_schema:
+        for msg in _validate_args(restart, restart_schema, set()):
+            errors.append(f"{label}: arg restart.{msg}")
+
+    # Each input is a source followed only by transforms
+    for input_idx, input_tasks in enumerate(inputs):
+        input_path = f"{path}.{input_idx}"
+        for idx, input_task in enumerate(input_tasks):
+            input_type = input_task.get("type") if isinstance(input_task, dict) else None
+            if (idx == 0 and input_type != "source") or (idx > 0 and input_type != "tn"):
+                errors.append(f"task {input_path}: an input of a union may only have a source followed by transforms")
+                break
+        else:
+            if len(input_tasks) == 0:
+                errors.append(f"task {input_path}: each input of a union must start with a source")
+                continue
+            _validate_helper(input_tasks, input_path, ops, secret_schema, restart_schema, errors, union_input = True,
+                            schedule_schema = schedule_schema)
+
+def _validate_args(args: Mapping[str, Any], op_schema: Mapping[str, Any], optional: set) -> List[str]:
+    errors = []
+
+    for arg in op_schema.get("args", []):
+        name = arg["name"]
+        val = args.get(name)
+
+        if val is None:
+            if arg.get("required") and name not in optional:
+                errors.append(f"{name}: required arg is missing")
+            continue
+
+        if not _type_matches(arg["type"], val):
+            errors.append(f"{name}: must be of type {arg['type']}, got {type(val).__name__}")
+            continue
+
+        if isinstance(val, str) and val == "" and arg.get("required") and name not in optional:
+            errors.append(f"{name}: required arg must not be empty")
+            continue
+
+        if arg.get("min") is not None and val < arg["min"]:
+            errors.append(f"{name}: must be at least {arg['min']}, got {val}")
+            continue
+
+        allowed = arg.get("allowed")
+        if allowed and not any(_equal(val, a) for a in allowed):
+            errors.append(f"{name}: must be one of {allowed}, got {val}")
+
+    return errors
+
+def _type_matches(arg_type: str, val: Any) -> bool:
+    if arg_type == "any":
+        return True
+    elif arg_type == "bool":
+        return isinstance(val, bool)
+    elif arg_type == "int":
+        return (isinstance(val, int) and not isinstance(val, bool)) or (isinstance(val, float) and val.is_integer())
+    elif arg_type == "number":
+        return isinstance(val, (int, float)) and not isinstance(val, bool)
+    elif arg_type == "string":
+        return isinstance(val, str)
+    elif arg_type == "list":
+        return isinstance(val, list)
+    elif arg_type == "object":
+        return isinstance(val, dict)
+    return False
+
+def _equal(val: Any, allowed: Any) -> bool:
+    if isinstance(val, str) and isinstance(allowed, str):
+        return val.lower() == allowed.lower()
+    return val == allowed
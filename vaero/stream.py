@@ -3,8 +3,10 @@
 #
 from __future__ import annotations # enable using class type in the class
 import json
+import sys
 import tomli
 from typing import Any, List, Mapping, Optional
+from vaero.schema import load_schema, validate_task_graph
 
 class Vaero():
     """"
@@ -13,8 +15,9 @@ class Vaero():
 
     tg_start = None # pointer to first node of global task graph
 
-    def __init__(self, ptr: Mapping[str, Any] = None):
+    def __init__(self, ptr: Mapping[str, Any] = None, root: Mapping[str, Any] = None):
         self._ptr = ptr # self._ptr is a pointer to the node at the current place of this instance
+        self._root = root # self._root is a pointer to the first node of the chain of this instance
 
     def source(self, source_type: str, interval: int = 10, host: str = "",
                 token: str = "", name: str = "", max_calls_per_period: int = 60, limit_period : int = 60,
@@ -32,15 +35,58 @@ class Vaero():
 
         return self._addToTaskGraph(node)
     
+    # Args of a particular sink, such as the token of splunk, are passed as keyword args.
+    # Usage: vs.sink("splunk", token = "${secret:env:SPLUNK_TOKEN}")
     def sink(self, sink_type: str, timestamp_key : str = "timestamp", timestamp_format : str = "RFC3339",
                 filename_prefix : str = '%Y/%m/%d', filename_format : str = '%s.log',
                 batch_max_bytes : int = 1_000_000, batch_max_time: int = 60 * 5,
-                bucket : str = "", region : str = "") -> Vaero:
+                bucket : str = "", region : str = "", **args: Any) -> Vaero:
         node = {"type" : "sink", "op" : sink_type,
                 "args" : {"timestamp_key" : timestamp_key, "timestamp_format" : timestamp_format,
                 "filename_prefix" : filename_prefix, "filename_format" : filename_format,
                 "batch_max_bytes" : batch_max_bytes, "batch_max_time" : batch_max_time,
-                "bucket" : bucket, "region" : region}}
+                "bucket" : bucket, "region" : region, **args}}
+
+        return self._addToTaskGraph(node)
+
+    # Write with the vaero_flush function of a WebAssembly plugin. Events are batched as with other sinks, and args
+    # are the args of sink, such as batch_max_time.
+    # Usage: vs.wasm_sink("my_siem", config = {"url" : "https://siem.example.com/ingest"})
+    def wasm_sink(self, plugin: str, config: Mapping[str, Any] = {}, timeout_seconds: int = 30, **args: Any) -> Vaero:
+        result = self.sink("wasm", **args)
+        result._ptr["args"].update({"plugin" : plugin, "config" : config, "timeout_seconds" : timeout_seconds})
+
+        return result
+
+    # Poll a REST API without Python, configured by args such as auth, pagination, events_path, and time_field. See
+    # the http_poll op in vaero/schema.json for all args.
+    # Usage: vs.http_poll("https://example.okta.com/api/v1/logs", auth = "header", auth_prefix = "SSWS ", token = "...",
+    #                     pagination = "link", time_field = "published", time_param = "since")
+    def http_poll(self, url: str, interval: int = 10, **args: Any) -> Vaero:
+        node = {"type" : "source", "op" : "http_poll", "args" : {"interval" : interval, "url" : url, **args}}
+
+        return self._addToTaskGraph(node)
+
+    # Read from any vaero_cdk connector, run in a Python worker. module is the Python module of the connector, such
+    # as "integrations.python.source_google_workspace", and cls its class, if the module defines more than one
+    # connector. The other args are passed to the constructor of the connector. secret_args names the args that
+    # hold credentials, which are redacted from logs and output.
+    # Usage: vs.python_source("my_connectors.github", token = "...", secret_args = ["token"])
+    def python_source(self, module: str, cls: str = "", interval: int = 10, secret_args: List[str] = [],
+                **args: Any) -> Vaero:
+        node = {"type" : "source", "op" : "python",
+                "args" : {"interval" : interval, "module" : module, "class" : cls, "secret_args" : secret_args,
+                **args}}
+
+        return self._addToTaskGraph(node)
+
+    # Read with the vaero_read function of a WebAssembly plugin, the module <plugin>.wasm in the plugin folder. config
+    # is passed to the plugin when it starts, and the checkpoint it returns is passed back on the next read.
+    # Usage: vs.wasm_source("github_audit", config = {"org" : "example"})
+    def wasm_source(self, plugin: str, config: Mapping[str, Any] = {}, interval: int = 10,
+                timeout_seconds: int = 30) -> Vaero:
+        node = {"type" : "source", "op" : "wasm", "args" : {"interval" : interval, "plugin" : plugin,
+                "config" : config, "timeout_seconds" : timeout_seconds}}
 
         return self._addToTaskGraph(node)
 
@@ -69,6 +115,40 @@ class Vaero():
 
         return self._addToTaskGraph(node)
 
+    # Run a Python function on each event, in a long-running Python worker. func takes an event and returns an event,
+    # a list of events, or None to drop the event. Events are sent to the worker in batches of batch_size. A batch that
+    # takes longer than timeout_seconds restarts the worker. Events that fail are passed on unchanged, or dropped if
+    # on_error is "drop".
+    # Usage: vs.python_transform("transforms.vendor", "normalize")
+    def python_transform(self, module: str, func: str, batch_size: int = 1000, timeout_seconds: int = 30,
+                on_error: str = "pass") -> Vaero:
+        node = {"type" : "tn", "op" : "python", "args" : {"module" : module, "func" : func, "batch_size" : batch_size,
+                "timeout_seconds" : timeout_seconds, "on_error" : on_error}}
+
+        return self._addToTaskGraph(node)
+
+    # Run a function of a Starlark script on each event, in process. The script is given inline or as the path of a
+    # file. func takes the event as a dict and returns a dict, a list of dicts, or None to drop the event. Each call
+    # may take at most max_steps execution steps, and scripts cannot access the filesystem or network.
+    # Usage: vs.starlark(script = "def transform(event):\n    event['seen'] = True\n    return event")
+    def starlark(self, script: str = "", file: str = "", func: str = "transform", max_steps: int = 100_000,
+                on_error: str = "pass") -> Vaero:
+        node = {"type" : "tn", "op" : "starlark", "args" : {"script" : script, "file" : file, "func" : func,
+                "max_steps" : max_steps, "on_error" : on_error}}
+
+        return self._addToTaskGraph(node)
+
+    # Run the vaero_transform function of a WebAssembly plugin on each event list, in process. A call that takes
+    # longer than timeout_seconds restarts the plugin. The events of a failed call are passed on unchanged, or dropped
+    # if on_error is "drop".
+    # Usage: vs.wasm_transform("redact", config = {"fields" : ["password"]})
+    def wasm_transform(self, plugin: str, config: Mapping[str, Any] = {}, timeout_seconds: int = 30,
+                on_error: str = "pass") -> Vaero:
+        node = {"type" : "tn", "op" : "wasm", "args" : {"plugin" : plugin, "config" : config,
+                "timeout_seconds" : timeout_seconds, "on_error" : on_error}}
+
+        return self._addToTaskGraph(node)
+
     def rename(self, path: str, new_path: str) -> Vaero:
         node = {"type" : "tn", "op" : "rename", "args" : {"path" : path, "new_path" : new_path}}
 
@@ -98,6 +178,10 @@ class Vaero():
     # command will be run
     # secrets is an array of maps of {secret_name : target_argument} that will be passed on stdin to the command
     # The output of command should generate a map in format {"arg_name1" : value1, "arg_name2" : value2}
+    # Call on a source or a sink, such as .sink("splunk").secret(...). The command is run again every
+    # cache_time_seconds, and the new values replace the credentials of the running source or sink.
+    # To read a secret from a provider instead of a command, set the arg to a reference such as
+    # "${secret:env:SPLUNK_TOKEN}" or "${secret:vault:secret/splunk#token}"
     def secret(self, command : str = "", secrets : List[str] = [], cache_time_seconds : int = 86400 * 30, timeout_seconds : int = 30) -> Vaero:
         self._ptr["secret"] = {
             "command" : command,
@@ -108,6 +192,57 @@ class Vaero():
 
         return self
 
+    # Set the restart policy of the pipeline, which applies when the pipeline exits without being stopped, such as when
+    # a node panics. Call on the source.
+    # policy is "always", "on-failure", or "never"
+    # max_retries is the number of restarts after consecutive failures before the pipeline is marked failed (0 for no limit)
+    # The delay before a restart starts at backoff_seconds and doubles after each failure, up to max_backoff_seconds
+    def restart(self, policy : str = "on-failure", max_retries : int = 5, backoff_seconds : int = 1,
+                max_backoff_seconds : int = 300) -> Vaero:
+        self._ptr["restart"] = {
+            "policy" : policy,
+            "max_retries" : max_retries,
+            "backoff_seconds" : backoff_seconds,
+            "max_backoff_seconds" : max_backoff_seconds
+        }
+
+        return self
+
+    # Merge the events of several streams into one, which continues with the nodes added to the union. Each stream
+    # is a source followed by any transforms, such as Vaero().source("okta").rename("host", "hostname"). The union
+    # must start the pipeline, and a restart policy for the pipeline is set on the union.
+    # Usage: Vaero.union(okta, http).sink("stdout")
+    @staticmethod
+    def union(*streams : Vaero) -> Vaero:
+        node = {"type" : "union", "op" : "union", "args" : {}, "inputs" : [stream._root for stream in streams],
+                "next" : []}
+        Vaero.tg_start = node
+
+        return Vaero(node, node)
+
+    merge = union
+
+    # Set when a pull source reads, instead of every interval seconds. Call on the source.
+    # cron is a cron expression (minute hour day-of-month month day-of-week) or descriptor such as "@hourly"
+    # window limits reads to a time of day, such as "09:00-17:00", and days to days of the week, such as "mon-fri"
+    # timezone is the IANA time zone of cron, window, and days
+    # jitter_seconds is the longest random delay added to each read, to spread out pipelines with the same schedule
+    # catch_up is what to do with reads missed while the pipeline was not running: "skip", "once", or "all" (up to
+    # max_catch_up reads)
+    def schedule(self, cron : str = "", window : str = "", days : str = "", timezone : str = "UTC",
+                jitter_seconds : int = 0, catch_up : str = "skip", max_catch_up : int = 10) -> Vaero:
+        self._ptr["schedule"] = {
+            "cron" : cron,
+            "window" : window,
+            "days" : days,
+            "timezone" : timezone,
+            "jitter_seconds" : jitter_seconds,
+            "catch_up" : catch_up,
+            "max_catch_up" : max_catch_up
+        }
+
+        return self
+
     def _addToTaskGraph(self, node : Mapping[str, Any]) -> Vaero:
         node["next"] = []
 
@@ -116,18 +251,28 @@ class Vaero():
 
         # first node
         if self._ptr == None:
-            self._ptr = Vaero.tg_start = node
-            return Vaero(node)
+            self._ptr = self._root = Vaero.tg_start = node
+            return Vaero(node, node)
         # add to list at ptr location
         else:
             self._ptr["next"].append(node)
-            return Vaero(node)
+            return Vaero(node, self._root)
 
     # Convert the task graph into json and print to stdout
+    # The task graph is validated against the op schemas first, if vaero/schema.json is available
     @classmethod
     def start(cls):
         task_graph = Vaero.linkedListToArr(Vaero.tg_start)
 
+        schema = load_schema()
+        if schema is not None:
+            errors = validate_task_graph(task_graph, schema)
+            if errors:
+                print("Invalid pipeline specification:", file = sys.stderr)
+                for error in errors:
+                    print(f"  {error}", file = sys.stderr)
+                sys.exit(1)
+
         json_graph = json.dumps(task_graph)
         print(f"{json_graph}")
 
@@ -147,6 +292,10 @@ class Vaero():
             result.append(node)
             next_list = node.pop("next", None) # remove the next field (the array keeps the order)
 
+            # each input of a union is a separate linked list
+            if "inputs" in node:
+                node["inputs"] = [Vaero.linkedListToArr(input_node) for input_node in node["inputs"]]
+
             if len(next_list) == 0:
                 break
             elif len(next_list) == 1:
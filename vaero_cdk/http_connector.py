@@ -21,7 +21,7 @@ class HTTPConnector(ABC):
 
         self._incremental_sync = True
         self._cursor = APICursor() # cursor for incremental sync
-        self._cursor_location = "temp"
+        self._cursor_location = None # file to persist the cursor to, if not run by Vaero, which stores checkpoints
 
         self._max_calls_per_period = max_calls_per_period # max calls per period
         self._limit_period = limit_period # period in seconds
@@ -32,6 +32,19 @@ class HTTPConnector(ABC):
         print(f"Rate limit steady = {self._rate_limit_steady}")
 
 
+    @property
+    def checkpoint(self) -> Optional[Mapping[str, Any]]:
+        """
+        The cursor for incremental sync, which Vaero stores between reads as the checkpoint of the source
+
+        :return: The cursor, or None if there is none yet
+        """
+        return self._cursor.cursor or None
+
+    @checkpoint.setter
+    def checkpoint(self, value: Optional[Mapping[str, Any]]):
+        self._cursor.cursor = value or {}
+
     def authorize(self) -> bool:
         """
         authorize the configured connector
@@ -225,7 +238,8 @@ class HTTPConnector(ABC):
         """
         
         if self._incremental_sync:
-            self._cursor.load_cursor(self._cursor_location)
+            if self._cursor_location:
+                self._cursor.load_cursor(self._cursor_location)
             print(f"Cursor: {self._cursor.cursor}") # debug
 
         event_list = []
@@ -234,6 +248,7 @@ class HTTPConnector(ABC):
         
         if self._incremental_sync:
             self._update_cursor(event_list)
-            self._cursor.store_cursor(self._cursor_location)
+            if self._cursor_location:
+                self._cursor.store_cursor(self._cursor_location)
 
         return event_list